@@ -0,0 +1,71 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ANSI colors
+const (
+	reset = "\x1b[0m"
+	bold  = "\x1b[1m"
+	red   = "\x1b[31m"
+	green = "\x1b[32m"
+)
+
+// separator is the line of dashes under a table header, one group per column
+var separator = regexp.MustCompile(`^\s*-+(\s+-+)+\s*$`)
+
+// painter colors output, or leaves it as it is when colors are off
+type painter struct {
+	on bool
+}
+
+func (p painter) paint(color, s string) string {
+	if !p.on || color == "" || s == "" {
+		return s
+	}
+	return color + s + reset
+}
+
+// tables bolds table headers and colors the rows of watch lists and portfolios green or red by
+// the sign of their last column. Two column tables are key/value listings and aren't colored.
+func (p painter) tables(text string) string {
+	if !p.on {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+	rows := false
+	for i, line := range lines {
+		switch {
+		case i+1 < len(lines) && separator.MatchString(lines[i+1]):
+			lines[i] = p.paint(bold, line)
+			rows = len(strings.Fields(lines[i+1])) > 2
+		case separator.MatchString(line):
+		case strings.TrimSpace(line) == "":
+			rows = false
+		case rows:
+			lines[i] = p.paint(sign(line), line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// sign returns the color for a row by the sign of its last column
+func sign(row string) string {
+	fields := strings.Fields(row)
+	v, err := strconv.ParseFloat(strings.TrimSuffix(fields[len(fields)-1], "%"), 64)
+	switch {
+	case err != nil:
+		return ""
+	case v < 0:
+		return red
+	case v > 0:
+		return green
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTables(t *testing.T) {
+	text := "Ticker Price Change\n------ ----- ------\nAMD    1.00  +0.50\nINTC   2.00  -0.25\nT      3.00  0.00\n\nBalance: $10.00"
+
+	require.Equal(t, text, painter{}.tables(text))
+	require.Equal(t,
+		bold+"Ticker Price Change"+reset+"\n------ ----- ------\n"+
+			green+"AMD    1.00  +0.50"+reset+"\n"+
+			red+"INTC   2.00  -0.25"+reset+"\n"+
+			"T      3.00  0.00\n\nBalance: $10.00",
+		painter{on: true}.tables(text))
+
+	// Key value listings only have their header bolded
+	require.Equal(t, bold+"Key Value"+reset+"\n--- -----\nPE  -1.5", painter{on: true}.tables("Key Value\n--- -----\nPE  -1.5"))
+}
+
+func TestPlain(t *testing.T) {
+	require.Equal(t, "AMD 1.00\n\nChart: http://finviz.com", plain("```AMD 1.00\n```\n<http://finviz.com|Chart>"))
+	require.Equal(t, "buy [ticker] [shares]\npurchase shares", plain("*buy [ticker] [shares]*\npurchase shares"))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Control keys
+const (
+	ctrlA     = 0x01
+	ctrlC     = 0x03
+	ctrlD     = 0x04
+	ctrlE     = 0x05
+	ctrlH     = 0x08
+	ctrlK     = 0x0b
+	ctrlU     = 0x15
+	ctrlW     = 0x17
+	esc       = 0x1b
+	backspace = 0x7f
+)
+
+// maxHistory is the number of lines kept in the history
+const maxHistory = 1000
+
+// editor reads lines from a terminal in raw mode, with line editing and a history browsed with
+// the arrow keys
+type editor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	prompt  string
+	history []string
+}
+
+func newEditor(in io.Reader, out io.Writer, prompt string, history []string) *editor {
+	return &editor{in: bufio.NewReader(in), out: out, prompt: prompt, history: history}
+}
+
+// readLine reads a line, io.EOF is returned when ctrl-d is pressed on an empty line
+func (e *editor) readLine() (string, error) {
+	var (
+		line, draft []rune
+		pos         int
+		hist        = len(e.history) // The line being edited is after the history
+	)
+
+	e.redraw(line, pos)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			s := string(line)
+			e.remember(s)
+			return s, nil
+		case ctrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			line, pos, hist = nil, 0, len(e.history)
+		case ctrlD:
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case backspace, ctrlH:
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case ctrlA:
+			pos = 0
+		case ctrlE:
+			pos = len(line)
+		case ctrlU:
+			line, pos = line[pos:], 0
+		case ctrlK:
+			line = line[:pos]
+		case ctrlW:
+			start := pos
+			for start > 0 && unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			line, pos = append(line[:start], line[pos:]...), start
+		case esc:
+			switch e.escape() {
+			case 'A': // Up
+				if hist > 0 {
+					if hist == len(e.history) {
+						draft = line
+					}
+					hist--
+					line = []rune(e.history[hist])
+					pos = len(line)
+				}
+			case 'B': // Down
+				if hist < len(e.history) {
+					hist++
+					line = draft
+					if hist < len(e.history) {
+						line = []rune(e.history[hist])
+					}
+					pos = len(line)
+				}
+			case 'C': // Right
+				if pos < len(line) {
+					pos++
+				}
+			case 'D': // Left
+				if pos > 0 {
+					pos--
+				}
+			case 'H', '1': // Home
+				pos = 0
+			case 'F', '4': // End
+				pos = len(line)
+			case '3': // Delete
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+				pos++
+			}
+		}
+
+		e.redraw(line, pos)
+	}
+}
+
+// escape reads the rest of an escape sequence, returning the final letter of an arrow or
+// home/end key, or the number of a key like delete that ends with ~
+func (e *editor) escape() rune {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return 0
+	}
+
+	key, _, err := e.in.ReadRune()
+	if err != nil {
+		return 0
+	}
+	if unicode.IsDigit(key) {
+		for r != '~' {
+			if r, _, err = e.in.ReadRune(); err != nil {
+				return 0
+			}
+		}
+	}
+	return key
+}
+
+// redraw rewrites the line and moves the cursor to pos
+func (e *editor) redraw(line []rune, pos int) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.prompt, string(line))
+	if back := len(line) - pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+// remember adds a line to the history, skipping blank lines and repeats
+func (e *editor) remember(line string) {
+	if strings.TrimSpace(line) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// last returns the most recent line of the history
+func (e *editor) last() string {
+	if len(e.history) == 0 {
+		return ""
+	}
+	return e.history[len(e.history)-1]
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditor(t *testing.T) {
+	tests := []struct {
+		name  string
+		keys  string
+		lines []string
+	}{
+		{name: "plain", keys: "quote aapl\r", lines: []string{"quote aapl"}},
+		{name: "backspace", keys: "quotx\x7fe\r", lines: []string{"quote"}},
+		{name: "cursor", keys: "uote\x1b[D\x1b[D\x1b[D\x1b[Dq\x1b[F s\r", lines: []string{"quote s"}},
+		{name: "home and delete", keys: "xlist\x01\x1b[3~\r", lines: []string{"list"}},
+		{name: "kill word", keys: "watch amd intc\x17\r", lines: []string{"watch amd "}},
+		{name: "kill to end", keys: "buy amd 2\x01\x1b[C\x1b[C\x1b[C\x0b\x05 x\r", lines: []string{"buy x"}},
+		{name: "kill to start", keys: "gone\x15kept\r", lines: []string{"kept"}},
+		{name: "ctrl-c", keys: "oops\x03list\r", lines: []string{"list"}},
+		{name: "history", keys: "list\rnews amd\r\x1b[A\x1b[A\r\x1b[A\x1b[B\r", lines: []string{"list", "news amd", "list", ""}},
+		{name: "draft", keys: "list\rne\x1b[A\x1b[Bws\r", lines: []string{"list", "news"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := newEditor(strings.NewReader(test.keys), ioutil.Discard, "> ", nil)
+			for _, want := range test.lines {
+				line, err := e.readLine()
+				require.NoError(t, err)
+				require.Equal(t, want, line)
+			}
+			_, err := e.readLine()
+			require.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestEditorHistory(t *testing.T) {
+	e := newEditor(strings.NewReader("list\rlist\r \r\x04"), ioutil.Discard, "> ", []string{"help"})
+	for i := 0; i < 3; i++ {
+		_, err := e.readLine()
+		require.NoError(t, err)
+	}
+
+	// ctrl-d on an empty line ends the session
+	_, err := e.readLine()
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, []string{"help", "list"}, e.history)
+	require.Equal(t, "list", e.last())
+}
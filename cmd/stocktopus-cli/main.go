@@ -0,0 +1,285 @@
+// stocktopus-cli runs stocktopus commands from a terminal, against local storage or a remote
+// stocktopus server
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thorfour/iex/pkg/endpoint"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/thorfour/stocktopus/pkg/engine"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+const usage = `Usage: stocktopus-cli [flags] [command]
+
+Runs a single command, e.g. stocktopus-cli quote aapl, or starts an interactive session when
+no command is given. Commands are read line by line when stdin isn't a terminal. Type help
+for the list of commands and exit or ctrl-d to leave.
+
+Flags:
+`
+
+const prompt = "stocktopus> "
+
+var (
+	storageType = flag.String("storage", "bolt", "storage backend to use: bolt, redis or memory")
+	dataDir     = flag.String("data", defaultDataDir(), "directory for the bolt database")
+	provider    = flag.String("provider", "iex", "market data provider: iex or alphavantage, tokens are read from IEX_API_TOKEN and ALPHAVANTAGE_API_KEY")
+	remoteURL   = flag.String("remote", "", "URL of a stocktopus server to send commands to instead of using local storage")
+	platform    = flag.String("platform", engine.Slack, "platform the team and user belong to")
+	team        = flag.String("team", "cli", "team to run commands as")
+	user        = flag.String("user", os.Getenv("USER"), "user to run commands as")
+	token       = flag.String("token", "", "token sent to the remote server")
+	history     = flag.String("history", defaultHistory(), "file the session history is kept in, empty to keep none")
+	noColor     = flag.Bool("no-color", os.Getenv("NO_COLOR") != "", "print without colors")
+	verbose     = flag.Bool("v", false, "print the logs of failed commands")
+
+	redisConfig = storage.DefaultRedisConfig()
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	redisConfig.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	redisConfig.LoadEnv()
+	if !*verbose {
+		logrus.SetOutput(ioutil.Discard) // Errors are printed as the user would see them
+	}
+
+	r, closer, err := newRunner()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closer()
+
+	ctx := context.Background()
+	p := painter{on: !*noColor && terminal.IsTerminal(int(os.Stdout.Fd()))}
+
+	if flag.NArg() > 0 {
+		if !execute(ctx, r, p, strings.Join(flag.Args(), " ")) {
+			closer()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if !session(ctx, r, p, scanner.Text()) {
+				return
+			}
+		}
+		return
+	}
+
+	if err := repl(ctx, r, p); err != nil {
+		closer()
+		log.Fatal(err)
+	}
+}
+
+// newRunner runs commands against the remote server when one is set, or local storage
+func newRunner() (runner, func(), error) {
+	if *remoteURL != "" {
+		return newRemote(*remoteURL, *team, *user, *token), func() {}, nil
+	}
+
+	store, err := newStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	lookup, err := newProvider()
+	if err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+
+	return &local{
+		e:        engine.New(store, lookup),
+		platform: *platform,
+		team:     *team,
+		user:     *user,
+	}, func() { store.Close() }, nil
+}
+
+// newStore opens the storage backend selected by the storage flag
+func newStore() (storage.Store, error) {
+	switch *storageType {
+	case "redis":
+		s, err := storage.ConnectRedis(context.Background(), &redisConfig)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "bolt":
+		if err := os.MkdirAll(*dataDir, 0700); err != nil {
+			return nil, err
+		}
+		// Bolt only allows one process to open the database
+		s, err := storage.OpenBolt(*dataDir)
+		if err != nil {
+			return nil, fmt.Errorf("%w (stop the server or use -remote)", err)
+		}
+		return s, nil
+	case "memory":
+		return storage.NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", *storageType)
+	}
+}
+
+// newProvider returns the market data provider selected by the provider flag
+func newProvider() (stock.Lookup, error) {
+	switch *provider {
+	case "alphavantage":
+		return stock.NewAlphaWrapper(os.Getenv("ALPHAVANTAGE_API_KEY")), nil
+	case "iex":
+		if t := os.Getenv("IEX_API_TOKEN"); t != "" {
+			endpoint.Token = t
+		}
+		return &stock.IexWrapper{}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", *provider)
+	}
+}
+
+// repl reads commands from the terminal until exit or ctrl-d
+func repl(ctx context.Context, r runner, p painter) error {
+	ed := newEditor(os.Stdin, os.Stdout, prompt, loadHistory(*history))
+	for {
+		last := ed.last()
+		line, err := readLine(ed)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if ed.last() != last {
+			saveHistory(*history, ed.history) // Only when the editor remembered the line
+		}
+		if !session(ctx, r, p, line) {
+			return nil
+		}
+	}
+}
+
+// readLine reads a line with the terminal in raw mode. The terminal is only raw while editing
+// so command output prints normally.
+func readLine(ed *editor) (string, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return "", fmt.Errorf("raw mode failed: %w", err)
+	}
+	defer terminal.Restore(fd, state)
+
+	return ed.readLine()
+}
+
+// session runs a line of an interactive session, returning false when the session should end
+func session(ctx context.Context, r runner, p painter, line string) bool {
+	switch strings.TrimSpace(line) {
+	case "":
+		return true
+	case "exit", "quit":
+		return false
+	}
+	execute(ctx, r, p, line)
+	return true
+}
+
+// execute runs a command and prints its output, returning false if it failed
+func execute(ctx context.Context, r runner, p painter, text string) bool {
+	out, err := r.run(ctx, text)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, p.paint(red, stocktopus.UserMessage(err)))
+		return false
+	}
+	fmt.Println(p.tables(out))
+	return true
+}
+
+// loadHistory reads the history file, a missing file is an empty history
+func loadHistory(path string) []string {
+	if path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil || len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+	return lines
+}
+
+// saveHistory rewrites the history file with the last maxHistory lines. The history is a
+// convenience, failures to write it are ignored.
+func saveHistory(path string, lines []string) {
+	if path == "" {
+		return
+	}
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		fmt.Fprintln(w, strings.ReplaceAll(line, "\n", " "))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return
+	}
+	if err := f.Close(); err != nil {
+		return
+	}
+	os.Rename(f.Name(), path)
+}
+
+func defaultDataDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "stocktopus"
+	}
+	return filepath.Join(dir, "stocktopus")
+}
+
+func defaultHistory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".stocktopus_history")
+}
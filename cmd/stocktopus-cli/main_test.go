@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history")
+
+	lines := make([]string, 0, maxHistory+5)
+	for i := 0; i < maxHistory+5; i++ {
+		lines = append(lines, "quote "+strconv.Itoa(i))
+	}
+	saveHistory(path, lines[:1])
+	saveHistory(path, lines)
+
+	// The file is rewritten with only the most recent lines
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSpace(string(b)), "\n"), maxHistory)
+	require.Equal(t, lines[5:], loadHistory(path))
+
+	// No temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/thorfour/stocktopus/pkg/engine"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+)
+
+// runner runs a command and returns what to print. Failures the user should see are returned
+// as errors.
+type runner interface {
+	run(ctx context.Context, text string) (string, error)
+}
+
+// local runs commands with an engine on local storage
+type local struct {
+	e        *engine.Engine
+	platform string
+	team     string
+	user     string
+}
+
+func (l *local) run(ctx context.Context, text string) (string, error) {
+	result, err := l.e.Run(ctx, &engine.Request{
+		Platform: l.platform,
+		Team:     l.team,
+		User:     l.user,
+		Text:     text,
+	})
+	if err != nil {
+		return "", err
+	}
+	return render(result), nil
+}
+
+// remote sends commands to the slash command endpoint of a stocktopus server
+type remote struct {
+	url    string
+	form   url.Values
+	client *http.Client
+}
+
+func newRemote(server, team, user, token string) *remote {
+	return &remote{
+		url:    strings.TrimSuffix(server, "/") + "/v1",
+		form:   url.Values{"team_id": {team}, "user_id": {user}, "token": {token}},
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (r *remote) run(ctx context.Context, text string) (string, error) {
+	form := url.Values{"text": {text}}
+	for k, v := range r.form {
+		form[k] = v
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("request failed: %s %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	reply := struct {
+		Text string `json:"text"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return "", fmt.Errorf("invalid response: %w", err)
+	}
+
+	return plain(reply.Text), nil
+}
+
+var (
+	slackLink = regexp.MustCompile(`<([^|>]+)\|([^>]+)>`)
+	slackBold = regexp.MustCompile(`(?m)^\*([^*\n]+)\*`)
+)
+
+// plain turns Slack formatting into plain text
+func plain(text string) string {
+	text = strings.ReplaceAll(text, "```", "")
+	text = slackLink.ReplaceAllString(text, "$2: $1")
+	text = slackBold.ReplaceAllString(text, "$1")
+	return strings.Trim(text, "\n")
+}
+
+// render formats a command result for the terminal
+func render(result engine.Result) string {
+	switch r := result.(type) {
+	case *engine.Message:
+		return r.Text
+	case *engine.Quotes:
+		text := r.List.String() + stocktopus.MissingText(r.Missing, r.Unavailable, r.RetryAfter)
+		if r.Chart != "" {
+			text = fmt.Sprintf("%s\n%s\nChart: %s", text, stocktopus.Details(r.List[0]), r.Chart)
+		}
+		return text
+	case *engine.Balance:
+		return fmt.Sprintf("New Balance: %v", r.Balance)
+	case *engine.Portfolio:
+		return r.Account.String()
+	case *engine.Company:
+		return strings.Join([]string{r.CompanyName, r.Industry, r.Website, r.CEO, r.Description}, "\n")
+	case *engine.News:
+		return strings.Join(r.Headlines, "\n\n")
+	case *engine.Stats:
+		return stocktopus.Stats(r.Stats)
+	case *engine.History:
+		return r.Performance.String()
+	case *engine.Search:
+		return r.Results.String()
+	case *engine.Export:
+		return fmt.Sprintf("Download your data: %s (link expires in %v)", r.URL, r.Expires)
+	case *engine.ImportPreview:
+		return fmt.Sprintf("%s\nRun `import confirm` to apply or `import cancel` to discard", r.Import)
+	case *engine.Imported:
+		return fmt.Sprintf("Imported %v tickers and %v positions", len(r.Import.Tickers), len(r.Import.Positions))
+	case *engine.Help:
+		return help(r)
+	default:
+		return stocktopus.InternalErrorMessage
+	}
+}
+
+// missing lists symbols that couldn't be quoted
+// help lists every command, or shows how to use a single command
+func help(h *engine.Help) string {
+	if h.Command != nil {
+		lines := []string{h.Command.Usage(), "  " + h.Command.Summary}
+		for _, f := range h.Command.Flags {
+			lines = append(lines, fmt.Sprintf("  --%s %s", f.Name, f.Usage))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	lines := make([]string, 0, len(h.Specs))
+	for _, spec := range h.Specs {
+		lines = append(lines, fmt.Sprintf("%-45s %s", spec.Usage(), spec.Summary))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -1,84 +1,392 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"golang.org/x/crypto/acme/autocert"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/thorfour/iex/pkg/endpoint"
+	"github.com/thorfour/stocktopus/pkg/admin"
+	"github.com/thorfour/stocktopus/pkg/api"
 	"github.com/thorfour/stocktopus/pkg/auth"
+	"github.com/thorfour/stocktopus/pkg/config"
+	"github.com/thorfour/stocktopus/pkg/discord"
+	"github.com/thorfour/stocktopus/pkg/engine"
+	"github.com/thorfour/stocktopus/pkg/health"
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/mattermost"
 	"github.com/thorfour/stocktopus/pkg/slack"
 	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/storage"
+	"github.com/thorfour/stocktopus/pkg/telegram"
+	"github.com/thorfour/stocktopus/pkg/tracing"
 )
 
 var (
-	port         = flag.Int("p", 443, "port to serve on")
-	notls        = flag.Bool("n", false, "turn off TLS")
-	debug        = flag.Bool("d", false, "turn on debugging. Disable TLS")
-	certCache    = flag.String("c", "/cert", "location to store certs")
-	allowedHost  = flag.String("host", "api.stocktopus.io", "ACME allowed FQDN")
-	supportEmail = flag.String("email", "support@stocktopus.io", "ACME support email")
-
-	redisPW      string
-	redisAddr    string
-	clientID     string
-	clientSecret string
+	migrateKeys = flag.Bool("migrate-keys", false, "migrate legacy storage keys to the current key scheme and exit")
+	dryRun      = flag.Bool("dry-run", false, "report what -migrate-keys would do without changing anything")
+	tokenTeams  = flag.String("token-teams", "", "comma separated token=team_id pairs used to migrate legacy personal lists and accounts")
+	printConfig = flag.Bool("print-config", false, "print the configuration with secrets redacted and exit")
 )
 
-func init() {
-	flag.Parse()
-	redisPW = os.Getenv("REDISPW")
-	redisAddr = os.Getenv("REDISADDR")
-	clientID = os.Getenv("CLIENTID")
-	clientSecret = os.Getenv("CLIENTSECRET")
-}
-
 func main() {
-	log.Printf("Starting server on port %v", *port)
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
 
-	tlsOff := *debug || *notls
-	if !tlsOff {
-		log.Printf("Serving TLS for host %s", *allowedHost)
-		log.Printf("Storing certs in %s", *certCache)
+	shutdownTracing, err := tracing.Setup(context.Background(), &cfg.Tracing)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	raw, err := newStore(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer raw.Close()
+
+	if *migrateKeys {
+		if err := migrate(raw); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Background work stops when ctx is cancelled on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	workers := &sync.WaitGroup{}
+	background := func(run func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(ctx)
+		}()
+	}
+
+	// Stateful commands fail fast while storage is down, everything else keeps working
+	storageHealth := storage.NewHealth(raw)
+	if err := storageHealth.Check(ctx); err != nil {
+		log.Printf("Storage unavailable, starting degraded: %v", err)
+	}
+	background(func(ctx context.Context) { storageHealth.Run(ctx, cfg.Storage.HealthInterval) })
+	store := storage.Instrument(storageHealth.Guard(), cfg.Storage.Backend)
+
+	provider := newProvider(cfg)
+
+	// Only a local database makes this instance unfit for traffic, shared services being down
+	// affects every instance so they keep serving what they can
+	checker := health.New()
+	checker.Add("storage", 0, cfg.Storage.Backend == config.BackendBolt, func(context.Context) error {
+		return storageHealth.Err()
+	})
+	checker.Add("provider", cfg.Health.ProviderTTL, false, func(context.Context) error {
+		_, err := provider.Price(cfg.Health.ProviderTicker)
+
+		// Provider request URLs can carry the API token, keep it out of the report
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = strings.SplitN(urlErr.URL, "?", 2)[0]
+		}
+		return err
+	})
+
+	log.Printf("Starting server on port %v", cfg.Server.Port)
+
+	opts := []engine.Option{engine.WithSymbolTTL(cfg.Cache.SymbolDirectoryTTL)}
+	if cfg.Features.ResolveNames {
+		opts = append(opts, engine.WithNameResolution())
+	}
+	if cfg.Exports.Secret != "" {
+		opts = append(opts, engine.WithExports(cfg.ExportURL(), []byte(cfg.Exports.Secret)))
 	}
 
-	s := slack.New(redis.NewClient(&redis.Options{
-		Addr:     redisAddr,
-		Password: redisPW,
-	}),
-		&stock.IexWrapper{},
-	)
+	e := engine.New(store, provider, opts...)
+	s := slack.New(e)
 
+	// Probes and scrapes aren't traced, everything else goes through app
 	router := mux.NewRouter()
-	router.HandleFunc("/v1", s.Handler)
-	router.HandleFunc("/auth", auth.Dummy(clientID, clientSecret))
 	router.Handle("/metrics", promhttp.Handler()) // start prometheus endpoint
+	router.HandleFunc("/healthz", checker.Live)
+	router.HandleFunc("/readyz", checker.Ready)
+
+	app := mux.NewRouter()
+	app.Use(tracing.Middleware)
+	router.PathPrefix("/").Handler(app)
+
+	app.Handle("/v1", slack.Recover(http.HandlerFunc(s.Handler)))
+	app.HandleFunc("/auth", auth.Dummy(cfg.Slack.ClientID, cfg.Slack.ClientSecret))
+	if cfg.Exports.Secret != "" {
+		app.HandleFunc("/export", e.ExportHandler)
+	}
+	if b, ok := raw.(storage.Backuper); ok {
+		app.HandleFunc("/admin/backup", storage.BackupHandler(b, cfg.Admin.Token))
+	}
+
+	if cfg.Mattermost.Tokens != "" {
+		tokens, err := cfg.MattermostTokens()
+		if err != nil {
+			log.Fatal(err)
+		}
+		m := mattermost.New(e, tokens)
+		app.Handle("/mattermost", slack.Recover(http.HandlerFunc(m.Handler)))
+	}
+
+	if cfg.Telegram.Token != "" {
+		bot := telegram.New(e, cfg.Telegram.Token, telegram.WithAPIURL(cfg.Telegram.APIURL), telegram.WithSecretToken(cfg.Telegram.SecretToken))
+		switch cfg.Telegram.Mode {
+		case config.TelegramPoll:
+			background(bot.Poll)
+		default:
+			app.HandleFunc("/telegram", bot.Handler)
+			background(func(ctx context.Context) {
+				if err := bot.SetWebhook(ctx, cfg.TelegramWebhookURL()); err != nil {
+					log.Printf("Setting the Telegram webhook failed: %v", err)
+				}
+			})
+		}
+	}
+
+	var d *discord.Server
+	if cfg.Discord.PublicKey != "" {
+		d, err = discord.New(e, cfg.Discord.PublicKey, discord.WithAPIURL(cfg.Discord.APIURL))
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.HandleFunc("/discord/interactions", d.Handler)
+	}
+	if cfg.Discord.BotToken != "" {
+		background(func(ctx context.Context) {
+			if err := discord.Register(ctx, cfg.Discord.APIURL, cfg.Discord.ApplicationID, cfg.Discord.GuildID, cfg.Discord.BotToken); err != nil {
+				log.Printf("Registering Discord commands failed: %v", err)
+			}
+		})
+	}
+
+	a := admin.New(store)
+	background(func(ctx context.Context) { a.ReportActivity(ctx, cfg.Metrics.ActivityInterval) })
+	app.PathPrefix("/admin/teams/").Handler(a.Handler(cfg.Admin.Token))
+
+	if cfg.API.Keys != "" {
+		apiKeys, err := cfg.APIKeys()
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.PathPrefix(api.Prefix + "/").Handler(api.New(e.Stocktopus(), a, apiKeys).Handler())
+	}
+	if cfg.Slack.SigningSecret != "" {
+		app.HandleFunc("/slack/events", slack.EventsHandler(cfg.Slack.SigningSecret, a.PurgeTeam, a.Purge))
+	}
+
+	if cfg.Retention.Inactive > 0 {
+		r := &admin.Retention{
+			Admin:    a,
+			Inactive: cfg.Retention.Inactive,
+			Warning:  cfg.Retention.Warning,
+			Notifier: admin.LogNotifier{},
+		}
+		if cfg.Slack.BotToken != "" {
+			// Only Slack users can be messaged directly, teams of other platforms are prefixed
+			r.Notifier = &admin.PlatformNotifier{
+				Platforms: map[string]admin.Notifier{
+					engine.Discord + "-":    admin.LogNotifier{},
+					engine.Mattermost + "-": admin.LogNotifier{},
+					engine.Telegram + "-":   admin.LogNotifier{},
+				},
+				Default: slack.NewNotifier(cfg.Slack.BotToken),
+			}
+		}
+		background(func(ctx context.Context) { r.Run(ctx, time.Hour) })
+	}
 
-	switch tlsOff {
-	case true:
+	servers := serve(cfg, router)
 
-		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", *port), router))
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-servers.errs:
+		log.Fatal(err)
+	case sig := <-stop:
+		log.Printf("Received %v, shutting down", sig)
+	}
+
+	// Fail readiness and keep serving until load balancers have stopped sending requests
+	checker.Drain()
+	time.Sleep(cfg.Server.ShutdownDelay)
+
+	shutdown, done := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer done()
+	for _, srv := range servers.all {
+		if err := srv.Shutdown(shutdown); err != nil {
+			log.Printf("Shutdown incomplete: %v", err)
+		}
+	}
+
+	if d != nil {
+		if err := d.Wait(shutdown); err != nil {
+			log.Printf("Deferred Discord responses didn't finish: %v", err)
+		}
+	}
+
+	cancel()
+	if err := wait(shutdown, workers); err != nil {
+		log.Printf("Background work didn't finish: %v", err)
+	}
+	if err := shutdownTracing(shutdown); err != nil {
+		log.Printf("Flushing traces failed: %v", err)
+	}
+	log.Print("Shutdown complete")
+}
+
+// servers are the running HTTP servers
+type servers struct {
+	all  []*http.Server
+	errs chan error
+}
+
+// serve starts serving the router, with ACME certificates unless TLS is off
+func serve(cfg *config.Config, router http.Handler) *servers {
+	srv := &http.Server{
+		Handler: router,
+		Addr:    fmt.Sprintf(":%v", cfg.Server.Port),
+	}
+	s := &servers{all: []*http.Server{srv}, errs: make(chan error, 2)}
+
+	if !cfg.TLS() {
+		go func() { s.errs <- listen(srv.ListenAndServe) }()
+		return s
+	}
+
+	log.Printf("Serving TLS for host %s", cfg.Server.Host)
+	log.Printf("Storing certs in %s", cfg.Server.CertCache)
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.Server.Host),
+		Cache:      autocert.DirCache(cfg.Server.CertCache),
+		Email:      cfg.Server.Email,
+	}
+	srv.TLSConfig = m.TLSConfig()
+	challenges := &http.Server{Addr: ":80", Handler: m.HTTPHandler(nil)}
+	s.all = append(s.all, challenges)
+
+	go func() { s.errs <- listen(challenges.ListenAndServe) }()
+	go func() { s.errs <- listen(func() error { return srv.ListenAndServeTLS("", "") }) }()
+	return s
+}
 
+// listen runs a server until it fails, ignoring the error from a graceful shutdown
+func listen(run func() error) error {
+	if err := run(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// wait waits for the workers to finish or ctx to expire
+func wait(ctx context.Context, workers *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newProvider returns the configured market data provider, limited to its quota
+func newProvider(cfg *config.Config) stock.Lookup {
+	var limiter *stock.Limiter
+	if q := cfg.ProviderQuota(); q.Rate > 0 {
+		limiter = stock.NewLimiter(cfg.Provider.Name, q)
+	}
+
+	switch cfg.Provider.Name {
+	case config.ProviderAlphaVantage:
+		return stock.Instrument(&stock.AlphaWrapper{APIKey: cfg.Provider.AlphaVantageKey, Limiter: limiter}, cfg.Provider.Name)
 	default:
-		m := &autocert.Manager{
-			Prompt:     autocert.AcceptTOS,
-			HostPolicy: autocert.HostWhitelist(*allowedHost),
-			Cache:      autocert.DirCache(*certCache),
-			Email:      *supportEmail,
+		if cfg.Provider.IEXToken != "" {
+			endpoint.Token = cfg.Provider.IEXToken
 		}
+		return stock.Instrument(&stock.IexWrapper{Limiter: limiter}, cfg.Provider.Name)
+	}
+}
 
-		srv := &http.Server{
-			Handler:   router,
-			Addr:      fmt.Sprintf(":%v", *port),
-			TLSConfig: m.TLSConfig(),
+// newStore opens the configured storage backend
+func newStore(cfg *config.Config) (storage.Store, error) {
+	switch cfg.Storage.Backend {
+	case config.BackendRedis:
+		s, err := storage.ConnectRedis(context.Background(), &cfg.Storage.Redis)
+		if errors.Is(err, storage.ErrUnreachable) {
+			// Start anyway so quotes keep working, storage is used once it's reachable
+			log.Print(err)
+			return storage.OpenRedis(&cfg.Storage.Redis)
+		}
+		if err != nil {
+			return nil, err
 		}
-		go http.ListenAndServe(":80", m.HTTPHandler(nil))
-		log.Fatal(srv.ListenAndServeTLS("", ""))
+		return s, nil
+	case config.BackendBolt:
+		log.Printf("Storing data in %s", cfg.Storage.DataDir)
+		return storage.OpenBolt(cfg.Storage.DataDir)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
+
+// migrate rewrites legacy keys to the current key scheme
+func migrate(store storage.Store) error {
+	teams, err := keys.ParseTokenTeams(*tokenTeams)
+	if err != nil {
+		return err
+	}
+
+	m := &keys.Migrator{
+		Store:    store,
+		Teams:    teams,
+		DryRun:   *dryRun,
+		Progress: keys.PrintProgress(os.Stdout),
 	}
+
+	report, err := m.Run(context.Background())
+	if report != nil {
+		fmt.Println(report)
+	}
+	if err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%v keys failed to migrate, run again to retry", report.Failed)
+	}
+
+	return nil
 }
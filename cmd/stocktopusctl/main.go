@@ -0,0 +1,351 @@
+// stocktopusctl inspects and repairs the data stored by stocktopus
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/thorfour/stocktopus/pkg/admin"
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+const usage = `Usage: stocktopusctl [flags] <command> [args]
+
+Commands:
+  teams                                  list every team with stored data
+  users TEAM                             list the users and #lists of a team
+  account get TEAM USER                  print an account as JSON
+  account set TEAM USER [FILE]           replace an account with JSON from FILE or stdin
+  account edit TEAM USER                 edit an account with $EDITOR
+  list get TEAM USER|#LIST               print a watch list
+  list add TEAM USER|#LIST TICKER...     add tickers to a watch list
+  list remove TEAM USER|#LIST TICKER...  remove tickers from a watch list
+  recompute TEAM USER                    set an account balance to its opening balance plus its transactions
+  purge TEAM [USER]                      delete everything stored for a team or user
+  migrate-keys                           migrate legacy keys to the current key scheme
+  validate                               check that every stored account parses
+  backup FILE                            write a copy of a bolt database to FILE
+
+Flags:
+`
+
+var (
+	storageType = flag.String("storage", "redis", "storage backend to use: redis or bolt")
+	dataDir     = flag.String("data", "/data", "directory for the bolt database")
+	dryRun      = flag.Bool("dry-run", false, "report what recompute or migrate-keys would do without changing anything")
+	tokenTeams  = flag.String("token-teams", "", "comma separated token=team_id pairs used by migrate-keys")
+
+	redisConfig = storage.DefaultRedisConfig()
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	redisConfig.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	redisConfig.LoadEnv()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	store, err := newStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := run(context.Background(), admin.New(store), flag.Args()); err != nil {
+		store.Close()
+		log.Fatal(err)
+	}
+}
+
+// newStore opens the storage backend selected by the storage flag
+func newStore() (storage.Store, error) {
+	switch *storageType {
+	case "redis":
+		s, err := storage.ConnectRedis(context.Background(), &redisConfig)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "bolt":
+		// Bolt only allows one process to open the database
+		s, err := storage.OpenBolt(*dataDir)
+		if err != nil {
+			return nil, fmt.Errorf("%w (stop the server or work on a backup)", err)
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", *storageType)
+	}
+}
+
+// run executes a single command
+func run(ctx context.Context, a *admin.Admin, args []string) error {
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "teams":
+		if len(args) != 0 {
+			return errUsage
+		}
+		teams, err := a.Teams(ctx)
+		if err != nil {
+			return err
+		}
+		printLines(teams)
+
+	case "users":
+		if len(args) != 1 {
+			return errUsage
+		}
+		users, err := a.Users(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		printLines(users)
+
+	case "account":
+		return account(ctx, a, args)
+
+	case "list":
+		return list(ctx, a, args)
+
+	case "recompute":
+		if len(args) != 2 {
+			return errUsage
+		}
+		before, after, err := a.RecomputeBalance(ctx, args[0], args[1], *dryRun)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("balance %0.2f -> %0.2f\n", before, after)
+
+	case "purge":
+		var n int
+		var err error
+		switch len(args) {
+		case 1:
+			n, err = a.PurgeTeam(ctx, args[0])
+		case 2:
+			n, err = a.Purge(ctx, args[0], args[1])
+		default:
+			return errUsage
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%v keys deleted\n", n)
+
+	case "migrate-keys":
+		return migrate(ctx, a.Store)
+
+	case "backup":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return backup(a.Store, args[0])
+
+	case "validate":
+		total, problems, err := a.Validate(ctx)
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		fmt.Printf("%v accounts checked, %v invalid\n", total, len(problems))
+		if len(problems) > 0 {
+			return errors.New("invalid accounts found")
+		}
+
+	default:
+		return errUsage
+	}
+
+	return nil
+}
+
+var errUsage = errors.New("invalid command, see -help")
+
+func account(ctx context.Context, a *admin.Admin, args []string) error {
+	if len(args) < 3 {
+		return errUsage
+	}
+	sub, team, user := args[0], args[1], args[2]
+
+	switch sub {
+	case "get":
+		acct, err := a.Account(ctx, team, user)
+		if err != nil {
+			return err
+		}
+		b, err := json.MarshalIndent(acct, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+
+	case "set":
+		var b []byte
+		var err error
+		if len(args) > 3 && args[3] != "-" {
+			b, err = ioutil.ReadFile(args[3])
+		} else {
+			b, err = ioutil.ReadAll(os.Stdin)
+		}
+		if err != nil {
+			return err
+		}
+		return a.SetAccount(ctx, team, user, b)
+
+	case "edit":
+		return edit(ctx, a, team, user)
+
+	default:
+		return errUsage
+	}
+
+	return nil
+}
+
+// edit opens the account in $EDITOR and saves it once it's valid
+func edit(ctx context.Context, a *admin.Admin, team, user string) error {
+	acct, err := a.Account(ctx, team, user)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(acct, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile("", "account-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+	f.Close()
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command(editor, f.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor failed: %w", err)
+	}
+
+	edited, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+
+	return a.SetAccount(ctx, team, user, edited)
+}
+
+func list(ctx context.Context, a *admin.Admin, args []string) error {
+	if len(args) < 3 {
+		return errUsage
+	}
+	sub, key, tickers := args[0], admin.ListKey(args[1], args[2]), args[3:]
+	for i := range tickers {
+		tickers[i] = strings.ToUpper(tickers[i])
+	}
+
+	switch {
+	case sub == "get" && len(tickers) == 0:
+		members, err := a.Store.Members(ctx, key)
+		if err != nil {
+			return err
+		}
+		printLines(members)
+		return nil
+	case sub == "add" && len(tickers) > 0:
+		return a.Store.AddMembers(ctx, key, tickers...)
+	case sub == "remove" && len(tickers) > 0:
+		return a.Store.RemoveMembers(ctx, key, tickers...)
+	default:
+		return errUsage
+	}
+}
+
+// migrate rewrites legacy keys to the current key scheme
+func migrate(ctx context.Context, store storage.Store) error {
+	teams, err := keys.ParseTokenTeams(*tokenTeams)
+	if err != nil {
+		return err
+	}
+
+	m := &keys.Migrator{
+		Store:    store,
+		Teams:    teams,
+		DryRun:   *dryRun,
+		Progress: keys.PrintProgress(os.Stdout),
+	}
+
+	report, err := m.Run(ctx)
+	if report != nil {
+		fmt.Println(report)
+	}
+	if err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%v keys failed to migrate, run again to retry", report.Failed)
+	}
+
+	return nil
+}
+
+// backup writes a copy of the store to path. The copy is only moved into place once it's complete.
+func backup(store storage.Store, path string) error {
+	b, ok := store.(storage.Backuper)
+	if !ok {
+		return fmt.Errorf("%s storage can't be backed up, use its own tools", *storageType)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := b.Backup(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("backup failed: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	fmt.Printf("%v bytes written to %s\n", n, path)
+	return nil
+}
+
+func printLines(lines []string) {
+	for _, l := range lines {
+		fmt.Println(l)
+	}
+}
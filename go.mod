@@ -1,27 +1,33 @@
 module github.com/thorfour/stocktopus
 
-go 1.14
+// OpenTelemetry v1 supports Go 1.15 and later
+go 1.15
 
 require (
-	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
-	github.com/alicebob/miniredis v2.5.0+incompatible
+	github.com/alicebob/miniredis/v2 v2.17.0
 	github.com/beorn7/perks v1.0.0 // indirect
 	github.com/bndr/gotabulate v1.1.3-0.20170315142410-bc555436bfd5
 	github.com/cmckee-dev/go-alpha-vantage v0.0.0-20190315022515-f8ae72eef75c
-	github.com/go-redis/redis/v8 v8.0.0-beta.4
-	github.com/gomodule/redigo v1.8.1 // indirect
+	github.com/go-redis/redis/v8 v8.11.4 // v8.0.0-beta.4 imports the pre-1.0 OpenTelemetry API that v1 removed
 	github.com/gorilla/mux v1.7.1
 	github.com/konsorten/go-windows-terminal-sequences v1.0.2 // indirect
+	github.com/kr/pretty v0.1.0 // indirect
 	github.com/leekchan/accounting v0.0.0-20161211142212-a35854c07593
 	github.com/mattn/go-runewidth v0.0.4 // indirect
-	github.com/onsi/ginkgo v1.12.3 // indirect
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/prometheus/client_golang v0.9.2
 	github.com/prometheus/common v0.3.0 // indirect
 	github.com/prometheus/procfs v0.0.0-20190416084830-8368d24ba045 // indirect
 	github.com/sirupsen/logrus v1.4.2
-	github.com/stretchr/testify v1.5.1
+	github.com/stretchr/testify v1.7.0 // required by OpenTelemetry v1
 	github.com/thorfour/iex v0.0.0-20190617161349-acf25f2ae6bd
-	github.com/yuin/gopher-lua v0.0.0-20200603152657-dc2b0ca8b37e // indirect
-	golang.org/x/crypto v0.0.0-20190422183909-d864b10871cd
+	go.etcd.io/bbolt v1.3.6
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9 // required by the golang.org/x/net of go-redis and OpenTelemetry
+	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // required by go-redis v8.11
 )
@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+var (
+	activeUsers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "active_users",
+		Help: "Number of users who ran a command within the window (1d, 7d, 30d)",
+	},
+		[]string{"window"},
+	)
+
+	activeTeams = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "active_teams",
+		Help: "Number of teams with a user who ran a command within the window (1d, 7d, 30d)",
+	},
+		[]string{"window"},
+	)
+)
+
+// ActivityWindows are the periods active users and teams are counted over, by label
+var ActivityWindows = map[string]time.Duration{
+	"1d":  24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// ActiveCount is the number of active users and teams in each of the ActivityWindows
+type ActiveCount struct {
+	Users map[string]int
+	Teams map[string]int
+}
+
+// CountActive counts the users and teams active within each of the ActivityWindows before now
+func (a *Admin) CountActive(ctx context.Context, now time.Time) (*ActiveCount, error) {
+	all, err := a.Store.Keys(ctx, keys.Version+":")
+	if err != nil {
+		return nil, fmt.Errorf("list keys failed: %w", err)
+	}
+
+	count := &ActiveCount{Users: map[string]int{}, Teams: map[string]int{}}
+	teams := map[string]map[string]bool{}
+	for window := range ActivityWindows {
+		count.Users[window] = 0
+		teams[window] = map[string]bool{}
+	}
+
+	for _, key := range all {
+		k, ok := keys.Parse(key)
+		if !ok || k.Kind != keys.KindActivity {
+			continue
+		}
+
+		b, err := a.Store.Get(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue // Deleted since the keys were listed
+		}
+		if err != nil {
+			return nil, err
+		}
+		act := &Activity{}
+		if err := json.Unmarshal(b, act); err != nil {
+			return nil, fmt.Errorf("parse %s failed: %w", key, err)
+		}
+
+		for window, d := range ActivityWindows {
+			if now.Sub(act.Last) <= d {
+				count.Users[window]++
+				teams[window][k.Team] = true
+			}
+		}
+	}
+	for window, t := range teams {
+		count.Teams[window] = len(t)
+	}
+
+	return count, nil
+}
+
+// ReportActivity updates the active user and team gauges every interval until ctx is cancelled
+func (a *Admin) ReportActivity(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		count, err := a.CountActive(ctx, time.Now())
+		if err != nil {
+			logrus.WithField("msg", "counting active users failed").Error(err)
+		} else {
+			for window, n := range count.Users {
+				activeUsers.WithLabelValues(window).Set(float64(n))
+			}
+			for window, n := range count.Teams {
+				activeTeams.WithLabelValues(window).Set(float64(n))
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package admin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+func TestCountActive(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemory()
+	now := time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	require.NoError(t, Touch(ctx, s, "T1", "today", now.Add(-time.Hour)))
+	require.NoError(t, Touch(ctx, s, "T1", "week", now.Add(-3*day)))
+	require.NoError(t, Touch(ctx, s, "T2", "month", now.Add(-20*day)))
+	require.NoError(t, Touch(ctx, s, "T3", "gone", now.Add(-60*day)))
+	require.NoError(t, s.AddMembers(ctx, keys.List("T4", "nobody"), "AMD"))
+
+	count, err := New(s).CountActive(ctx, now)
+	require.NoError(t, err)
+	require.Equal(t, &ActiveCount{
+		Users: map[string]int{"1d": 1, "7d": 2, "30d": 3},
+		Teams: map[string]int{"1d": 1, "7d": 1, "30d": 2},
+	}, count)
+}
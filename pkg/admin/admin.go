@@ -0,0 +1,333 @@
+// Package admin inspects and repairs the data stocktopus stores
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+// ErrIncompleteHistory is returned when recomputing the balance of an account whose history
+// doesn't start from a known balance
+var ErrIncompleteHistory = errors.New("account history doesn't start from a known balance")
+
+// Admin operates directly on a store
+type Admin struct {
+	Store storage.Store
+}
+
+// New returns an Admin for the store
+func New(s storage.Store) *Admin {
+	return &Admin{Store: s}
+}
+
+// Teams returns every team with stored data
+func (a *Admin) Teams(ctx context.Context) ([]string, error) {
+	all, err := a.Store.Keys(ctx, keys.Version+":")
+	if err != nil {
+		return nil, fmt.Errorf("list keys failed: %w", err)
+	}
+
+	seen := map[string]bool{}
+	var teams []string
+	for _, key := range all {
+		k, ok := keys.Parse(key)
+		if !ok || seen[k.Team] {
+			continue
+		}
+		seen[k.Team] = true
+		teams = append(teams, k.Team)
+	}
+	sort.Strings(teams)
+
+	return teams, nil
+}
+
+// Users returns every user in a team with stored data, and the team's lists prefixed with #
+func (a *Admin) Users(ctx context.Context, team string) ([]string, error) {
+	all, err := a.Store.Keys(ctx, keys.Team(team))
+	if err != nil {
+		return nil, fmt.Errorf("list keys failed: %w", err)
+	}
+
+	seen := map[string]bool{}
+	var users []string
+	for _, key := range all {
+		k, ok := keys.Parse(key)
+		if !ok || k.Team != team {
+			continue
+		}
+
+		name := k.User
+		if k.Kind == keys.KindTeamList {
+			name = "#" + k.Name
+		}
+		if !seen[name] {
+			seen[name] = true
+			users = append(users, name)
+		}
+	}
+	sort.Strings(users)
+
+	return users, nil
+}
+
+// Lists returns the names of a team's lists
+func (a *Admin) Lists(ctx context.Context, team string) ([]string, error) {
+	return a.names(ctx, team, keys.KindTeamList)
+}
+
+// Accounts returns the users in a team with an account
+func (a *Admin) Accounts(ctx context.Context, team string) ([]string, error) {
+	return a.names(ctx, team, keys.KindAccount)
+}
+
+// names returns the sorted list names or users of a team's keys of kind
+func (a *Admin) names(ctx context.Context, team string, kind keys.Kind) ([]string, error) {
+	all, err := a.Store.Keys(ctx, keys.Team(team))
+	if err != nil {
+		return nil, fmt.Errorf("list keys failed: %w", err)
+	}
+
+	var names []string
+	for _, key := range all {
+		k, ok := keys.Parse(key)
+		switch {
+		case !ok || k.Team != team || k.Kind != kind:
+		case kind == keys.KindTeamList:
+			names = append(names, k.Name)
+		default:
+			names = append(names, k.User)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// ListKey returns the key of a user's personal list, or of a team list if who starts with #
+func ListKey(team, who string) string {
+	if strings.HasPrefix(who, "#") {
+		return keys.TeamList(team, who[1:])
+	}
+	return keys.List(team, who)
+}
+
+// Account returns a user's account
+func (a *Admin) Account(ctx context.Context, team, user string) (*stocktopus.Account, error) {
+	b, err := a.Store.Get(ctx, keys.Account(team, user))
+	if err != nil {
+		return nil, fmt.Errorf("load account failed: %w", err)
+	}
+
+	return stocktopus.ParseAccount(b)
+}
+
+// SetAccount validates and replaces a user's account with serialized
+func (a *Admin) SetAccount(ctx context.Context, team, user string, serialized []byte) error {
+	acct, err := Parse(serialized)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(acct)
+	if err != nil {
+		return fmt.Errorf("serialize account failed: %w", err)
+	}
+
+	return a.Store.Put(ctx, keys.Account(team, user), b)
+}
+
+// Parse strictly parses an account, rejecting unknown fields and impossible values
+func Parse(serialized []byte) (*stocktopus.Account, error) {
+	acct := &stocktopus.Account{}
+	dec := json.NewDecoder(bytes.NewReader(serialized))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(acct); err != nil {
+		return nil, fmt.Errorf("parse account failed: %w", err)
+	}
+
+	for ticker, h := range acct.Holdings {
+		if ticker == "" || ticker != strings.ToUpper(ticker) {
+			return nil, fmt.Errorf("holding %q: tickers must be upper case", ticker)
+		}
+		if h.Shares == 0 {
+			return nil, fmt.Errorf("holding %s: no shares", ticker)
+		}
+		if h.Strike < 0 {
+			return nil, fmt.Errorf("holding %s: negative strike", ticker)
+		}
+	}
+	if acct.Holdings == nil {
+		acct.Holdings = map[string]stocktopus.Holding{}
+	}
+
+	return acct, nil
+}
+
+// RecomputeBalance sets the balance of an account to its opening balance plus the sum of its
+// transactions, returning the old and new balance. Nothing is written if dryRun is set.
+func (a *Admin) RecomputeBalance(ctx context.Context, team, user string, dryRun bool) (float64, float64, error) {
+	var before, after float64
+	recompute := func(acct *stocktopus.Account) error {
+		if acct.Opening == nil {
+			return ErrIncompleteHistory
+		}
+
+		before, after = acct.Balance, *acct.Opening
+		for _, tx := range acct.Transactions {
+			after += tx.Amount
+		}
+		acct.Balance = after
+		return nil
+	}
+
+	if dryRun {
+		acct, err := a.Account(ctx, team, user)
+		if err != nil {
+			return 0, 0, err
+		}
+		err = recompute(acct)
+		return before, after, err
+	}
+
+	err := a.Store.Update(ctx, keys.Account(team, user), func(old []byte) ([]byte, error) {
+		if old == nil {
+			return nil, storage.ErrNotFound
+		}
+
+		acct, err := stocktopus.ParseAccount(old)
+		if err != nil {
+			return nil, err
+		}
+		if err := recompute(acct); err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(acct)
+	})
+
+	return before, after, err
+}
+
+// Purge deletes everything stored for a user, including data still under legacy keys, and
+// returns the number of keys deleted
+func (a *Admin) Purge(ctx context.Context, team, user string) (int, error) {
+	return a.purge(ctx, keys.User(team, user), func(l *keys.Legacy) bool {
+		return l.Kind != keys.KindTeamList && l.User == user
+	})
+}
+
+// PurgeTeam deletes everything stored for a team and returns the number of keys deleted. Legacy
+// personal lists and accounts don't record their team, so they're deleted for the team's users
+// and for the token of the team's legacy lists.
+func (a *Admin) PurgeTeam(ctx context.Context, team string) (int, error) {
+	current, err := a.Store.Keys(ctx, keys.Team(team))
+	if err != nil {
+		return 0, fmt.Errorf("purge failed: %w", err)
+	}
+	users := map[string]bool{}
+	for _, key := range current {
+		if k, ok := keys.Parse(key); ok && k.User != "" {
+			users[k.User] = true
+		}
+	}
+
+	lists, err := keys.FindLegacy(ctx, a.Store, func(l *keys.Legacy) bool {
+		return l.Kind == keys.KindTeamList && l.Team == team
+	})
+	if err != nil {
+		return 0, fmt.Errorf("purge failed: %w", err)
+	}
+	tokens := map[string]bool{}
+	for _, l := range lists {
+		tokens[l.Token] = true
+	}
+
+	return a.purge(ctx, keys.Team(team), func(l *keys.Legacy) bool {
+		if l.Kind == keys.KindTeamList {
+			return l.Team == team
+		}
+		return users[l.User] || tokens[l.Token]
+	})
+}
+
+// purge deletes every key starting with prefix and every legacy key matched by legacy
+func (a *Admin) purge(ctx context.Context, prefix string, legacy func(*keys.Legacy) bool) (int, error) {
+	found, err := a.Store.Keys(ctx, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("purge failed: %w", err)
+	}
+
+	for _, p := range keys.LegacyPrefixes {
+		old, err := a.Store.Keys(ctx, p)
+		if err != nil {
+			return 0, fmt.Errorf("purge failed: %w", err)
+		}
+		for _, key := range old {
+			if l, ok := keys.ParseLegacy(key); ok && legacy(l) {
+				found = append(found, key)
+			}
+		}
+	}
+
+	if len(found) == 0 {
+		return 0, nil
+	}
+
+	if err := a.Store.Delete(ctx, found...); err != nil {
+		return 0, fmt.Errorf("purge failed: %w", err)
+	}
+
+	return len(found), nil
+}
+
+// Problem is a stored account that can't be used
+type Problem struct {
+	Key string
+	Err error
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %v", p.Key, p.Err)
+}
+
+// Validate checks that every stored account, including legacy accounts, parses
+func (a *Admin) Validate(ctx context.Context) (int, []Problem, error) {
+	var accounts []string
+	for _, prefix := range []string{keys.Version + ":", "ACCT["} {
+		found, err := a.Store.Keys(ctx, prefix)
+		if err != nil {
+			return 0, nil, fmt.Errorf("list keys failed: %w", err)
+		}
+
+		for _, key := range found {
+			k, ok := keys.Parse(key)
+			l, legacy := keys.ParseLegacy(key)
+			if (ok && k.Kind == keys.KindAccount) || (legacy && l.Kind == keys.KindAccount) {
+				accounts = append(accounts, key)
+			}
+		}
+	}
+
+	var problems []Problem
+	for _, key := range accounts {
+		b, err := a.Store.Get(ctx, key)
+		if err == nil {
+			_, err = Parse(b)
+		}
+		if err != nil {
+			problems = append(problems, Problem{Key: key, Err: err})
+		}
+	}
+
+	return len(accounts), problems, nil
+}
@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemory()
+	a := New(s)
+
+	require.NoError(t, s.AddMembers(ctx, keys.List("T1", "U1"), "AMD"))
+	require.NoError(t, s.AddMembers(ctx, keys.TeamList("T1", "fun"), "TSLA"))
+	require.NoError(t, s.Put(ctx, keys.Account("T1", "U2"), []byte(`{"Balance":5,"Opening":0,"Transactions":[{"Type":"DEPOSIT","Amount":100},{"Type":"BUY","Ticker":"AMD","Shares":1,"Price":10,"Amount":-10}]}`)))
+	require.NoError(t, s.Put(ctx, keys.Account("T2", "U3"), []byte(`{"Balance":1}`)))
+	require.NoError(t, s.Put(ctx, keys.Account("T2", "U4"), []byte(`{"Balance":50,"Transactions":[{"Type":"DEPOSIT","Amount":10}]}`)))
+
+	teams, err := a.Teams(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"T1", "T2"}, teams)
+
+	users, err := a.Users(ctx, "T1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"#fun", "U1", "U2"}, users)
+
+	lists, err := a.Lists(ctx, "T1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"fun"}, lists)
+	accounts, err := a.Accounts(ctx, "T1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"U2"}, accounts)
+
+	require.Equal(t, keys.TeamList("T1", "fun"), ListKey("T1", "#fun"))
+	require.Equal(t, keys.List("T1", "U1"), ListKey("T1", "U1"))
+
+	// Dry runs don't write anything
+	before, after, err := a.RecomputeBalance(ctx, "T1", "U2", true)
+	require.NoError(t, err)
+	require.Equal(t, []float64{5, 90}, []float64{before, after})
+	acct, err := a.Account(ctx, "T1", "U2")
+	require.NoError(t, err)
+	require.Equal(t, float64(5), acct.Balance)
+
+	_, _, err = a.RecomputeBalance(ctx, "T1", "U2", false)
+	require.NoError(t, err)
+	acct, err = a.Account(ctx, "T1", "U2")
+	require.NoError(t, err)
+	require.Equal(t, float64(90), acct.Balance)
+
+	// History recorded without an opening balance can't be summed
+	for _, user := range []string{"U3", "U4"} {
+		_, _, err = a.RecomputeBalance(ctx, "T2", user, false)
+		require.True(t, errors.Is(err, ErrIncompleteHistory))
+	}
+
+	require.NoError(t, s.Put(ctx, keys.LegacyAccount("tok", "U1"), []byte(`{}`)))
+	require.NoError(t, s.AddMembers(ctx, keys.LegacyTeamList("tok", "T1", "old"), "GOOG"))
+
+	n, err := a.Purge(ctx, "T1", "U1")
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	users, err = a.Users(ctx, "T1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"#fun", "U2"}, users)
+
+	// Legacy personal data is found by the team's users and legacy token
+	require.NoError(t, s.AddMembers(ctx, keys.LegacyList("tok", "U9"), "AMD"))
+	require.NoError(t, s.Put(ctx, keys.LegacyAccount("old", "U2"), []byte(`{}`)))
+	require.NoError(t, s.Put(ctx, keys.LegacyAccount("other", "U3"), []byte(`{}`)))
+
+	n, err = a.PurgeTeam(ctx, "T1")
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	all, err := s.Keys(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{keys.LegacyAccount("other", "U3"), keys.Account("T2", "U3"), keys.Account("T2", "U4")}, all)
+}
+
+func TestSetAccount(t *testing.T) {
+	ctx := context.Background()
+	a := New(storage.NewMemory())
+
+	for _, bad := range []string{
+		`{"Balance":"lots"}`,
+		`{"Balanse":1}`,
+		`{"Holdings":{"amd":{"Shares":1}}}`,
+		`{"Holdings":{"AMD":{"Shares":0}}}`,
+	} {
+		require.Error(t, a.SetAccount(ctx, "T1", "U1", []byte(bad)), bad)
+	}
+
+	require.NoError(t, a.SetAccount(ctx, "T1", "U1", []byte(`{"Balance":1,"Holdings":{"AMD":{"Strike":1,"Shares":2}}}`)))
+	acct, err := a.Account(ctx, "T1", "U1")
+	require.NoError(t, err)
+	require.Equal(t, stocktopus.Holding{Strike: 1, Shares: 2}, acct.Holdings["AMD"])
+}
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemory()
+	a := New(s)
+
+	require.NoError(t, s.Put(ctx, keys.Account("T1", "U1"), []byte(`{"Balance":1}`)))
+	require.NoError(t, s.Put(ctx, keys.Account("T1", "U2"), []byte(`not json`)))
+	require.NoError(t, s.AddMembers(ctx, keys.Account("T1", "U3"), "AMD"))
+	require.NoError(t, s.Put(ctx, keys.LegacyAccount("tok", "U4"), []byte(`{}`)))
+	require.NoError(t, s.AddMembers(ctx, keys.List("T1", "U1"), "AMD"))
+
+	total, problems, err := a.Validate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 4, total)
+	require.Len(t, problems, 2)
+	require.Equal(t, keys.Account("T1", "U2"), problems[0].Key)
+	require.True(t, errors.Is(problems[1].Err, storage.ErrWrongType))
+}
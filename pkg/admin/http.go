@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// Handler serves the admin API for deleting data. Requests must carry token as a bearer token,
+// an empty token disables the API.
+//
+//	DELETE /admin/teams/{team}                 delete everything stored for a team
+//	DELETE /admin/teams/{team}/users/{user}    delete everything stored for a user
+func (a *Admin) Handler(token string) http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/admin/teams/{team}", func(resp http.ResponseWriter, req *http.Request) {
+		n, err := a.PurgeTeam(req.Context(), mux.Vars(req)["team"])
+		deleted(resp, n, err)
+	}).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/teams/{team}/users/{user}", func(resp http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		n, err := a.Purge(req.Context(), vars["team"], vars["user"])
+		deleted(resp, n, err)
+	}).Methods(http.MethodDelete)
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		given := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(resp, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		router.ServeHTTP(resp, req)
+	})
+}
+
+// deleted responds with the number of keys deleted
+func deleted(resp http.ResponseWriter, n int, err error) {
+	if err != nil {
+		logrus.WithField("msg", "admin purge failed").Error(err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(map[string]int{"deleted": n})
+}
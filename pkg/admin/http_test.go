@@ -0,0 +1,44 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemory()
+	require.NoError(t, s.AddMembers(ctx, keys.List("T1", "U1"), "AMD"))
+	require.NoError(t, s.AddMembers(ctx, keys.List("T1", "U2"), "AMD"))
+	require.NoError(t, s.AddMembers(ctx, keys.List("T2", "U3"), "AMD"))
+
+	h := New(s).Handler("secret")
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		return resp
+	}
+
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodDelete, "/admin/teams/T1", "wrong").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, do(http.MethodGet, "/admin/teams/T1", "secret").Code)
+
+	resp := do(http.MethodDelete, "/admin/teams/T1/users/U1", "secret")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"deleted":1}`, resp.Body.String())
+
+	resp = do(http.MethodDelete, "/admin/teams/T1", "secret")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"deleted":1}`, resp.Body.String())
+
+	all, err := s.Keys(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{keys.List("T2", "U3")}, all)
+}
@@ -0,0 +1,230 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+// Activity records when a user was last active and when they were warned their data would be
+// deleted
+type Activity struct {
+	Last   time.Time
+	Warned time.Time `json:",omitempty"`
+}
+
+// Touch records that a user is active, cancelling any pending deletion
+func Touch(ctx context.Context, s storage.Store, team, user string, now time.Time) error {
+	b, err := json.Marshal(&Activity{Last: now.UTC()})
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, keys.Activity(team, user), b)
+}
+
+// Notifier warns a user that their data is going to be deleted
+type Notifier interface {
+	Warn(ctx context.Context, team, user string, deleteAt time.Time) error
+}
+
+// LogNotifier logs warnings, for deployments that can't message users directly
+type LogNotifier struct{}
+
+// Warn logs the pending deletion
+func (LogNotifier) Warn(_ context.Context, team, user string, deleteAt time.Time) error {
+	logrus.WithField("team", team).WithField("user", user).WithField("delete_at", deleteAt).Info("inactive user will be deleted")
+	return nil
+}
+
+// PlatformNotifier warns users with the notifier of the platform their team is on. Teams are
+// matched on the platform prefix of their id, e.g. "discord-", and get Default otherwise.
+type PlatformNotifier struct {
+	Platforms map[string]Notifier
+	Default   Notifier
+}
+
+// Warn sends the warning with the team's platform notifier
+func (p *PlatformNotifier) Warn(ctx context.Context, team, user string, deleteAt time.Time) error {
+	for prefix, n := range p.Platforms {
+		if strings.HasPrefix(team, prefix) {
+			return n.Warn(ctx, team, user, deleteAt)
+		}
+	}
+	return p.Default.Warn(ctx, team, user, deleteAt)
+}
+
+// SweepReport counts what a retention sweep did
+type SweepReport struct {
+	Users  int
+	Warned int
+	Purged int
+}
+
+// Retention deletes the data of users who haven't used stocktopus for a while. Users are warned
+// before anything is deleted, and are never deleted less than Warning after being warned.
+type Retention struct {
+	Admin *Admin
+	// Inactive is how long a user can go without running a command before their data is deleted
+	Inactive time.Duration
+	// Warning is how long before deletion users are warned
+	Warning  time.Duration
+	Notifier Notifier
+
+	now func() time.Time
+}
+
+// Run sweeps every interval until ctx is cancelled
+func (r *Retention) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := r.Sweep(ctx)
+		if err != nil {
+			logrus.WithField("msg", "retention sweep failed").Error(err)
+		} else {
+			logrus.WithField("users", report.Users).WithField("warned", report.Warned).WithField("purged", report.Purged).Info("retention sweep complete")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type user struct {
+	team, id string
+}
+
+// Sweep warns and deletes inactive users once
+func (r *Retention) Sweep(ctx context.Context) (*SweepReport, error) {
+	now := time.Now()
+	if r.now != nil {
+		now = r.now()
+	}
+
+	all, err := r.Admin.Store.Keys(ctx, keys.Version+":")
+	if err != nil {
+		return nil, fmt.Errorf("list keys failed: %w", err)
+	}
+
+	legacy, err := keys.FindLegacy(ctx, r.Admin.Store, func(*keys.Legacy) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+
+	// Legacy personal keys only record the team's token, legacy team lists map it to the team
+	teams := map[string]string{}
+	for _, l := range legacy {
+		if l.Kind == keys.KindTeamList {
+			teams[l.Token] = l.Team
+		}
+	}
+
+	// Users with nothing but an activity record have no data to warn them about
+	hasData := map[user]bool{}
+	legacyUsers := map[string]bool{}
+	for _, l := range legacy {
+		if l.Kind == keys.KindTeamList {
+			continue
+		}
+		legacyUsers[l.User] = true
+		if team, ok := teams[l.Token]; ok {
+			hasData[user{team: team, id: l.User}] = true
+		}
+	}
+	for _, key := range all {
+		k, ok := keys.Parse(key)
+		if !ok || k.Kind == keys.KindTeamList {
+			continue
+		}
+		u := user{team: k.Team, id: k.User}
+		hasData[u] = hasData[u] || k.Kind != keys.KindActivity || legacyUsers[k.User]
+	}
+
+	report := &SweepReport{Users: len(hasData)}
+	for u, data := range hasData {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		warned, purged, err := r.sweep(ctx, u, data, now)
+		if err != nil {
+			logrus.WithField("team", u.team).WithField("user", u.id).WithField("msg", "retention failed").Error(err)
+			continue
+		}
+		if warned {
+			report.Warned++
+		}
+		if purged {
+			report.Purged++
+		}
+	}
+
+	return report, nil
+}
+
+// sweep applies retention to a single user
+func (r *Retention) sweep(ctx context.Context, u user, hasData bool, now time.Time) (bool, bool, error) {
+	a := &Activity{}
+	b, err := r.Admin.Store.Get(ctx, keys.Activity(u.team, u.id))
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		// Data from before retention was enabled starts the clock now
+		return false, false, Touch(ctx, r.Admin.Store, u.team, u.id, now)
+	case err != nil:
+		return false, false, err
+	}
+	if err := json.Unmarshal(b, a); err != nil {
+		return false, false, err
+	}
+
+	deleteAt := a.Last.Add(r.Inactive)
+	if !hasData {
+		if now.After(deleteAt) {
+			return false, false, r.Admin.Store.Delete(ctx, keys.Activity(u.team, u.id))
+		}
+		return false, false, nil
+	}
+
+	if a.Warned.IsZero() {
+		if now.Before(deleteAt.Add(-r.Warning)) {
+			return false, false, nil
+		}
+
+		// Always give the full warning period, even if retention was just shortened
+		if deleteAt.Before(now.Add(r.Warning)) {
+			deleteAt = now.Add(r.Warning)
+		}
+		if err := r.Notifier.Warn(ctx, u.team, u.id, deleteAt); err != nil {
+			return false, false, fmt.Errorf("warning failed: %w", err)
+		}
+
+		a.Warned = now.UTC()
+		b, err := json.Marshal(a)
+		if err != nil {
+			return false, false, err
+		}
+		return true, false, r.Admin.Store.Put(ctx, keys.Activity(u.team, u.id), b)
+	}
+
+	if warnedUntil := a.Warned.Add(r.Warning); deleteAt.Before(warnedUntil) {
+		deleteAt = warnedUntil
+	}
+	if now.Before(deleteAt) {
+		return false, false, nil
+	}
+
+	_, err = r.Admin.Purge(ctx, u.team, u.id)
+	return false, err == nil, err
+}
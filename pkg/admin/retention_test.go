@@ -0,0 +1,175 @@
+package admin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+type fakeNotifier struct {
+	warned map[string]time.Time
+}
+
+func (f *fakeNotifier) Warn(_ context.Context, team, user string, deleteAt time.Time) error {
+	f.warned[user] = deleteAt
+	return nil
+}
+
+func TestPlatformNotifier(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemory()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	slack := &fakeNotifier{warned: map[string]time.Time{}}
+	other := &fakeNotifier{warned: map[string]time.Time{}}
+	r := &Retention{
+		Admin:    New(s),
+		Inactive: 24 * time.Hour,
+		Warning:  48 * time.Hour,
+		Notifier: &PlatformNotifier{
+			Platforms: map[string]Notifier{"discord-": other, "telegram-": other},
+			Default:   slack,
+		},
+		now: func() time.Time { return now },
+	}
+
+	for team, user := range map[string]string{"T1": "U1", "discord-1": "D1", "telegram-users": "42"} {
+		require.NoError(t, s.AddMembers(ctx, keys.List(team, user), "AMD"))
+		require.NoError(t, Touch(ctx, s, team, user, now.Add(-90*24*time.Hour)))
+	}
+
+	report, err := r.Sweep(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, report.Warned)
+	require.Equal(t, map[string]time.Time{"U1": now.Add(48 * time.Hour)}, slack.warned)
+	require.Equal(t, map[string]time.Time{"D1": now.Add(48 * time.Hour), "42": now.Add(48 * time.Hour)}, other.warned)
+}
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemory()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	day := 24 * time.Hour
+
+	n := &fakeNotifier{warned: map[string]time.Time{}}
+	r := &Retention{
+		Admin:    New(s),
+		Inactive: 30 * day,
+		Warning:  7 * day,
+		Notifier: n,
+		now:      func() time.Time { return now },
+	}
+
+	require.NoError(t, s.AddMembers(ctx, keys.List("T1", "active"), "AMD"))
+	require.NoError(t, s.AddMembers(ctx, keys.List("T1", "idle"), "AMD"))
+	require.NoError(t, s.AddMembers(ctx, keys.TeamList("T1", "fun"), "AMD"))
+	require.NoError(t, Touch(ctx, s, "T1", "nodata", now))
+
+	// Users without an activity record start the clock
+	report, err := r.Sweep(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &SweepReport{Users: 3}, report)
+
+	// Warned a week before deletion
+	now = start.Add(23 * day)
+	require.NoError(t, Touch(ctx, s, "T1", "active", now))
+	report, err = r.Sweep(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &SweepReport{Users: 3, Warned: 1}, report)
+	require.Equal(t, map[string]time.Time{"idle": start.Add(30 * day)}, n.warned)
+
+	// Nothing is deleted before the warning period is over
+	now = start.Add(29 * day)
+	report, err = r.Sweep(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &SweepReport{Users: 3}, report)
+
+	now = start.Add(31 * day)
+	report, err = r.Sweep(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &SweepReport{Users: 3, Purged: 1}, report)
+
+	all, err := s.Keys(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		keys.TeamList("T1", "fun"),
+		keys.List("T1", "active"),
+		keys.Activity("T1", "active"),
+	}, all)
+}
+
+func TestRetentionFullWarning(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemory()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	n := &fakeNotifier{warned: map[string]time.Time{}}
+	r := &Retention{
+		Admin:    New(s),
+		Inactive: 24 * time.Hour,
+		Warning:  48 * time.Hour,
+		Notifier: n,
+		now:      func() time.Time { return now },
+	}
+
+	// Already past the retention period when it's turned on
+	require.NoError(t, s.AddMembers(ctx, keys.List("T1", "U1"), "AMD"))
+	require.NoError(t, Touch(ctx, s, "T1", "U1", now.Add(-90*24*time.Hour)))
+
+	report, err := r.Sweep(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Warned)
+	require.Equal(t, now.Add(48*time.Hour), n.warned["U1"])
+
+	now = now.Add(24 * time.Hour)
+	report, err = r.Sweep(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, report.Purged)
+
+	now = now.Add(25 * time.Hour)
+	report, err = r.Sweep(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Purged)
+}
+
+func TestRetentionLegacy(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemory()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	n := &fakeNotifier{warned: map[string]time.Time{}}
+	r := &Retention{
+		Admin:    New(s),
+		Inactive: 24 * time.Hour,
+		Warning:  48 * time.Hour,
+		Notifier: n,
+		now:      func() time.Time { return now },
+	}
+
+	// U1 only has an activity record next to its legacy list, U2's team is known from a legacy team list
+	require.NoError(t, s.AddMembers(ctx, keys.LegacyList("tok", "U1"), "AMD"))
+	require.NoError(t, Touch(ctx, s, "T1", "U1", now.Add(-90*24*time.Hour)))
+	require.NoError(t, s.Put(ctx, keys.LegacyAccount("tok", "U2"), []byte(`{}`)))
+	require.NoError(t, s.AddMembers(ctx, keys.LegacyTeamList("tok", "T1", "fun"), "AMD"))
+
+	report, err := r.Sweep(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &SweepReport{Users: 2, Warned: 1}, report)
+	require.Equal(t, map[string]time.Time{"U1": now.Add(48 * time.Hour)}, n.warned)
+
+	// U2's clock started with the first sweep
+	now = now.Add(49 * time.Hour)
+	report, err = r.Sweep(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &SweepReport{Users: 2, Warned: 1, Purged: 1}, report)
+	require.Contains(t, n.warned, "U2")
+
+	all, err := s.Keys(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{keys.LegacyAccount("tok", "U2"), keys.LegacyTeamList("tok", "T1", "fun"), keys.Activity("T1", "U2")}, all)
+}
@@ -0,0 +1,737 @@
+// Package api serves a versioned JSON API for dashboards and scripts. Every API key belongs to a
+// team and only reaches that team's watch lists and accounts.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/thorfour/stocktopus/pkg/admin"
+	"github.com/thorfour/stocktopus/pkg/engine"
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/storage"
+	"github.com/thorfour/stocktopus/pkg/tracing"
+)
+
+// Prefix is the path every API route starts with
+const Prefix = "/api/v2"
+
+const (
+	// maxBody limits the size of a request body
+	maxBody = 1 << 16
+
+	// maxSymbols is the most symbols quoted or added to a list at once
+	maxSymbols = 100
+)
+
+var (
+	// Symbols, list names and account ids become part of storage keys and responses
+	symbolPattern = regexp.MustCompile(`^[A-Za-z0-9^][A-Za-z0-9.=^-]{0,15}$`)
+	namePattern   = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	idPattern     = regexp.MustCompile(`^[A-Za-z0-9_.@-]{1,64}$`)
+
+	errNoAccount = &stocktopus.UserError{Message: "No account"}
+
+	// errNotMigrated is returned for accounts still under legacy keys. Their keys hold the Slack
+	// token rather than the team, so the API can't tell which team they belong to.
+	errNotMigrated = &stocktopus.UserError{Message: "This account is available once its data is migrated to the new storage keys"}
+)
+
+// sorts are the orders quotes can be sorted in
+var sorts = []string{stocktopus.SortChange, stocktopus.SortTicker, stocktopus.SortPrice}
+
+type teamKey struct{}
+
+// Server serves the API
+type Server struct {
+	s     *stocktopus.Stocktopus
+	admin *admin.Admin
+	keys  map[string]string
+}
+
+// New returns an API that serves quotes, lists and accounts with s and lists data with a. keys
+// maps each API key to the team it can reach.
+func New(s *stocktopus.Stocktopus, a *admin.Admin, keys map[string]string) *Server {
+	return &Server{s: s, admin: a, keys: keys}
+}
+
+// Handler serves every route under Prefix. The OpenAPI document is the only route that doesn't
+// need a key.
+func (s *Server) Handler() http.Handler {
+	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		writeError(resp, http.StatusNotFound, "Not found")
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		writeError(resp, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+	router.Use(recovery)
+	router.HandleFunc(Prefix+"/openapi.json", OpenAPI).Methods(http.MethodGet)
+
+	api := router.PathPrefix(Prefix).Subrouter()
+	api.Use(s.authenticate)
+	api.HandleFunc("/quotes", s.quotes).Methods(http.MethodGet)
+	api.HandleFunc("/companies/{symbol}", s.company).Methods(http.MethodGet)
+	api.HandleFunc("/companies/{symbol}/stats", s.stats).Methods(http.MethodGet)
+	api.HandleFunc("/watchlists", s.watchlists).Methods(http.MethodGet)
+	api.HandleFunc("/watchlists/{name}", s.watchlist).Methods(http.MethodGet)
+	api.HandleFunc("/watchlists/{name}", s.clearWatchlist).Methods(http.MethodDelete)
+	api.HandleFunc("/watchlists/{name}/symbols", s.watch).Methods(http.MethodPost)
+	api.HandleFunc("/watchlists/{name}/symbols/{symbol}", s.unwatch).Methods(http.MethodDelete)
+	api.HandleFunc("/accounts", s.accounts).Methods(http.MethodGet)
+	api.HandleFunc("/accounts/{id}", s.account).Methods(http.MethodGet)
+	api.HandleFunc("/accounts/{id}/orders", s.orders).Methods(http.MethodGet)
+	api.HandleFunc("/accounts/{id}/orders", s.order).Methods(http.MethodPost)
+	api.HandleFunc("/accounts/{id}/deposits", s.deposit).Methods(http.MethodPost)
+
+	return router
+}
+
+// authenticate requires a bearer API key and adds its team to the request context
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		given := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+
+		team := ""
+		for key, t := range s.keys {
+			if subtle.ConstantTimeCompare([]byte(given), []byte(key)) == 1 {
+				team = t
+			}
+		}
+		if team == "" {
+			resp.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(resp, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+
+		next.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), teamKey{}, team)))
+	})
+}
+
+// recovery responds with an internal error when a handler panics
+func recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if r == http.ErrAbortHandler {
+				panic(r) // Deliberately aborted, let net/http handle it
+			}
+
+			tracing.Log(req.Context()).WithField("msg", "panic").WithField("stack", string(debug.Stack())).Error(r)
+			writeError(resp, http.StatusInternalServerError, stocktopus.InternalErrorMessage)
+		}()
+
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// quotes serves GET /quotes?symbols=AMD,INTC&sort=change
+func (s *Server) quotes(resp http.ResponseWriter, req *http.Request) {
+	var symbols []string
+	for _, v := range req.URL.Query()["symbols"] {
+		symbols = append(symbols, strings.Split(v, ",")...)
+	}
+	if err := validSymbols(symbols); err != nil {
+		fail(resp, req, err)
+		return
+	}
+	sortBy, err := sortParam(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	wl, err := s.s.GetQuotes(req.Context(), upper(symbols))
+	if err != nil && !stocktopus.IsPartial(err) {
+		fail(resp, req, err)
+		return
+	}
+	wl.SortBy(sortBy)
+
+	q := &Quotes{Data: newQuotes(wl)}
+	q.Missing, q.Unavailable, q.RetryAfter = missing(err)
+	writeJSON(resp, http.StatusOK, q)
+}
+
+// company serves GET /companies/{symbol}
+func (s *Server) company(resp http.ResponseWriter, req *http.Request) {
+	symbol, err := symbolVar(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	c, err := s.s.Info(req.Context(), symbol)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	writeJSON(resp, http.StatusOK, newCompany(c))
+}
+
+// stats serves GET /companies/{symbol}/stats
+func (s *Server) stats(resp http.ResponseWriter, req *http.Request) {
+	symbol, err := symbolVar(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	st, err := s.s.Stats(req.Context(), symbol)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	writeJSON(resp, http.StatusOK, newStats(symbol, st))
+}
+
+// watchlists serves GET /watchlists, a page of the team's lists ordered by name
+func (s *Server) watchlists(resp http.ResponseWriter, req *http.Request) {
+	p, err := newPager(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	ctx, team := req.Context(), teamOf(req)
+	names, err := s.admin.Lists(ctx, team)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+	legacy, err := s.legacyLists(ctx, team, "")
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+	for _, l := range legacy {
+		names = append(names, l.Name)
+	}
+	names = unique(names)
+
+	start, end, more := p.window(len(names), func(i int) bool { return names[i] > p.after })
+	lists := make([]*Watchlist, 0, end-start)
+	for _, name := range names[start:end] {
+		key, err := s.listKey(ctx, team, name)
+		if err != nil {
+			fail(resp, req, err)
+			return
+		}
+		symbols, err := s.admin.Store.Members(ctx, key)
+		if err != nil {
+			fail(resp, req, err)
+			return
+		}
+		sort.Strings(symbols)
+		lists = append(lists, &Watchlist{Name: name, Symbols: symbols})
+	}
+
+	page := &Page{Data: lists}
+	if more {
+		page.NextCursor = cursor(names[end-1])
+	}
+	writeJSON(resp, http.StatusOK, page)
+}
+
+// watchlist serves GET /watchlists/{name}?sort=change with the latest quotes
+func (s *Server) watchlist(resp http.ResponseWriter, req *http.Request) {
+	name, err := nameVar(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+	sortBy, err := sortParam(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	key, err := s.listKey(req.Context(), teamOf(req), name)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+	wl, err := s.s.Print(req.Context(), key)
+	if err != nil && !stocktopus.IsPartial(err) {
+		fail(resp, req, err)
+		return
+	}
+	wl.SortBy(sortBy)
+
+	list := &Watchlist{Name: name, Quotes: newQuotes(wl)}
+	list.Missing, list.Unavailable, list.RetryAfter = missing(err)
+	for _, quote := range wl {
+		list.Symbols = append(list.Symbols, quote.Ticker)
+	}
+	list.Symbols = append(list.Symbols, list.Missing...)
+	list.Symbols = append(list.Symbols, list.Unavailable...)
+	sort.Strings(list.Symbols)
+
+	writeJSON(resp, http.StatusOK, list)
+}
+
+// clearWatchlist serves DELETE /watchlists/{name}
+func (s *Server) clearWatchlist(resp http.ResponseWriter, req *http.Request) {
+	name, err := nameVar(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	key, err := s.listKey(req.Context(), teamOf(req), name)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+	if err := s.s.Clear(req.Context(), key); err != nil {
+		fail(resp, req, err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// watch serves POST /watchlists/{name}/symbols, adding symbols to the list
+func (s *Server) watch(resp http.ResponseWriter, req *http.Request) {
+	name, err := nameVar(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+	body := &SymbolsRequest{}
+	if err := decode(resp, req, body); err != nil {
+		fail(resp, req, err)
+		return
+	}
+	if err := validSymbols(body.Symbols); err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	key, err := s.listKey(req.Context(), teamOf(req), name)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+	if err := s.s.Add(req.Context(), upper(body.Symbols), key); err != nil {
+		fail(resp, req, err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// unwatch serves DELETE /watchlists/{name}/symbols/{symbol}
+func (s *Server) unwatch(resp http.ResponseWriter, req *http.Request) {
+	name, err := nameVar(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+	symbol, err := symbolVar(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	key, err := s.listKey(req.Context(), teamOf(req), name)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+	if err := s.s.Remove(req.Context(), []string{symbol}, key); err != nil {
+		fail(resp, req, err)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// accounts serves GET /accounts, a page of the team's accounts ordered by id
+func (s *Server) accounts(resp http.ResponseWriter, req *http.Request) {
+	p, err := newPager(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	ctx, team := req.Context(), teamOf(req)
+	ids, err := s.admin.Accounts(ctx, team)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	start, end, more := p.window(len(ids), func(i int) bool { return ids[i] > p.after })
+	accounts := make([]*AccountSummary, 0, end-start)
+	for _, id := range ids[start:end] {
+		a, err := s.admin.Account(ctx, team, id)
+		if err != nil {
+			fail(resp, req, err)
+			return
+		}
+		accounts = append(accounts, &AccountSummary{ID: id, Balance: a.Balance})
+	}
+
+	page := &Page{Data: accounts}
+	if more {
+		page.NextCursor = cursor(ids[end-1])
+	}
+	writeJSON(resp, http.StatusOK, page)
+}
+
+// account serves GET /accounts/{id} with the latest prices of its holdings
+func (s *Server) account(resp http.ResponseWriter, req *http.Request) {
+	id, a, err := s.existing(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	a, err = s.s.Latest(req.Context(), a)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	writeJSON(resp, http.StatusOK, newAccount(id, a))
+}
+
+// orders serves GET /accounts/{id}/orders, a page of buys and sells newest first
+func (s *Server) orders(resp http.ResponseWriter, req *http.Request) {
+	p, err := newPager(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+	after := 0
+	if p.after != "" {
+		if after, err = strconv.Atoi(p.after); err != nil {
+			fail(resp, req, stocktopus.InvalidInput("Invalid cursor", err))
+			return
+		}
+	}
+	_, a, err := s.existing(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	// Orders are keyed by their position in the whole account history, counting transactions
+	// dropped from its start, so cursors stay put as it's trimmed
+	var (
+		orders    []*Order
+		positions []int
+	)
+	for i := len(a.Transactions) - 1; i >= 0; i-- {
+		if o, ok := newOrder(&a.Transactions[i]); ok {
+			orders, positions = append(orders, o), append(positions, a.Dropped+i)
+		}
+	}
+
+	start, end, more := p.window(len(orders), func(i int) bool { return positions[i] < after })
+	page := &Page{Data: append([]*Order{}, orders[start:end]...)}
+	if more {
+		page.NextCursor = cursor(strconv.Itoa(positions[end-1]))
+	}
+	writeJSON(resp, http.StatusOK, page)
+}
+
+// order serves POST /accounts/{id}/orders, buying or selling shares at the latest price
+func (s *Server) order(resp http.ResponseWriter, req *http.Request) {
+	id, err := idVar(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+	body := &OrderRequest{}
+	if err := decode(resp, req, body); err != nil {
+		fail(resp, req, err)
+		return
+	}
+	switch {
+	case body.Side != sideBuy && body.Side != sideSell:
+		err = stocktopus.InvalidInput(fmt.Sprintf("Side must be %s or %s", sideBuy, sideSell), nil)
+	case !symbolPattern.MatchString(body.Symbol):
+		err = stocktopus.InvalidInput(fmt.Sprintf("%q is not a symbol", body.Symbol), nil)
+	case body.Shares == 0:
+		err = stocktopus.InvalidInput("Shares must be positive", nil)
+	}
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	key, err := s.acctKey(req.Context(), teamOf(req), id)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+	trade := s.s.Buy
+	if body.Side == sideSell {
+		trade = s.s.Sell
+	}
+	a, err := trade(req.Context(), strings.ToUpper(body.Symbol), body.Shares, key)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	// The order is the last transaction of the account it was made in
+	o, ok := newOrder(&a.Transactions[len(a.Transactions)-1])
+	if !ok {
+		fail(resp, req, errors.New("order missing from account history"))
+		return
+	}
+	writeJSON(resp, http.StatusCreated, o)
+}
+
+// deposit serves POST /accounts/{id}/deposits, creating the account if needed
+func (s *Server) deposit(resp http.ResponseWriter, req *http.Request) {
+	id, err := idVar(req)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+	body := &DepositRequest{}
+	if err := decode(resp, req, body); err != nil {
+		fail(resp, req, err)
+		return
+	}
+	if !(body.Amount >= 0.01 && body.Amount < 1e15) {
+		fail(resp, req, stocktopus.InvalidInput("Amount must be at least 0.01", nil))
+		return
+	}
+
+	key, err := s.acctKey(req.Context(), teamOf(req), id)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	// Amounts are in cents, like deposits made with a command
+	a, err := s.s.Deposit(req.Context(), math.Round(body.Amount*100)/100, key)
+	if err != nil {
+		fail(resp, req, err)
+		return
+	}
+
+	writeJSON(resp, http.StatusOK, &Balance{Balance: a.Balance})
+}
+
+// existing returns the id and stored account of the request, errNoAccount if there's none
+func (s *Server) existing(req *http.Request) (string, *stocktopus.Account, error) {
+	id, err := idVar(req)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if _, err := s.acctKey(req.Context(), teamOf(req), id); err != nil {
+		return "", nil, err
+	}
+	a, err := s.admin.Account(req.Context(), teamOf(req), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", nil, errNoAccount
+	}
+	return id, a, err
+}
+
+// listKey returns the key of a team list, which may still be its legacy key like it is for
+// commands. Legacy team list keys record the team, so they're found without the token.
+func (s *Server) listKey(ctx context.Context, team, name string) (string, error) {
+	current := keys.TeamList(team, name)
+	legacy, err := s.legacyLists(ctx, team, name)
+	if err != nil || len(legacy) == 0 {
+		return current, err
+	}
+
+	key, err := keys.Resolve(ctx, s.admin.Store, legacy[0].String(), current)
+	if err != nil {
+		return "", fmt.Errorf("Unable to find list: %w", err)
+	}
+	return key, nil
+}
+
+// legacyLists returns the team's lists still under legacy keys, only the named list if name is set
+func (s *Server) legacyLists(ctx context.Context, team, name string) ([]*keys.Legacy, error) {
+	return keys.FindLegacy(ctx, s.admin.Store, func(l *keys.Legacy) bool {
+		return l.Kind == keys.KindTeamList && l.Team == team && (name == "" || l.Name == name)
+	})
+}
+
+// acctKey returns the key of an account, errNotMigrated while the user still has a legacy
+// account and nothing under the current key. Writing to the current key would hide it.
+func (s *Server) acctKey(ctx context.Context, team, id string) (string, error) {
+	current := keys.Account(team, id)
+	if _, err := s.admin.Store.Get(ctx, current); !errors.Is(err, storage.ErrNotFound) {
+		return current, err
+	}
+
+	legacy, err := keys.FindLegacy(ctx, s.admin.Store, func(l *keys.Legacy) bool {
+		return l.Kind == keys.KindAccount && l.User == id
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(legacy) > 0 {
+		return "", errNotMigrated
+	}
+	return current, nil
+}
+
+// unique sorts names and drops repeats
+func unique(names []string) []string {
+	sort.Strings(names)
+	out := names[:0]
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func teamOf(req *http.Request) string {
+	team, _ := req.Context().Value(teamKey{}).(string)
+	return team
+}
+
+func symbolVar(req *http.Request) (string, error) {
+	symbol := mux.Vars(req)["symbol"]
+	if !symbolPattern.MatchString(symbol) {
+		return "", stocktopus.InvalidInput(fmt.Sprintf("%q is not a symbol", symbol), nil)
+	}
+	return strings.ToUpper(symbol), nil
+}
+
+func nameVar(req *http.Request) (string, error) {
+	name := mux.Vars(req)["name"]
+	if !namePattern.MatchString(name) {
+		return "", stocktopus.InvalidInput(fmt.Sprintf("%q is not a list name", name), nil)
+	}
+	return strings.ToLower(name), nil
+}
+
+func idVar(req *http.Request) (string, error) {
+	id := mux.Vars(req)["id"]
+	if !idPattern.MatchString(id) {
+		return "", stocktopus.InvalidInput(fmt.Sprintf("%q is not an account id", id), nil)
+	}
+	return id, nil
+}
+
+// validSymbols checks the number and format of symbols
+func validSymbols(symbols []string) error {
+	if len(symbols) == 0 || len(symbols) > maxSymbols {
+		return stocktopus.InvalidInput(fmt.Sprintf("Between 1 and %d symbols are needed", maxSymbols), nil)
+	}
+	for _, symbol := range symbols {
+		if !symbolPattern.MatchString(symbol) {
+			return stocktopus.InvalidInput(fmt.Sprintf("%q is not a symbol", symbol), nil)
+		}
+	}
+	return nil
+}
+
+// upper returns symbols in upper case
+func upper(symbols []string) []string {
+	u := make([]string, 0, len(symbols))
+	for _, symbol := range symbols {
+		u = append(u, strings.ToUpper(symbol))
+	}
+	return u
+}
+
+// missing returns the symbols a partial lookup couldn't find, those it couldn't quote right now
+// and how many seconds until they can be quoted again
+func missing(err error) ([]string, []string, int) {
+	var perr *stock.PartialError
+	if !errors.As(err, &perr) {
+		return nil, nil, 0
+	}
+	return perr.Unknown(), perr.Unavailable(), int(math.Ceil(perr.RetryAfter().Seconds()))
+}
+
+// sortParam returns the order of the sort query parameter, by change when it's not set
+func sortParam(req *http.Request) (string, error) {
+	by := req.URL.Query().Get("sort")
+	if by == "" {
+		return stocktopus.SortChange, nil
+	}
+	for _, s := range sorts {
+		if by == s {
+			return by, nil
+		}
+	}
+	return "", stocktopus.InvalidInput(fmt.Sprintf("Sort must be one of %s", strings.Join(sorts, ", ")), nil)
+}
+
+// decode strictly decodes a JSON request body into v
+func decode(resp http.ResponseWriter, req *http.Request, v interface{}) error {
+	d := json.NewDecoder(http.MaxBytesReader(resp, req.Body, maxBody))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return stocktopus.InvalidInput("Invalid JSON body: "+err.Error(), err)
+	}
+	return nil
+}
+
+// fail responds with the status for err. Only messages meant for users are shown, internal
+// failures are logged.
+func fail(resp http.ResponseWriter, req *http.Request, err error) {
+	var (
+		ue      *stocktopus.UserError
+		rateErr *stock.RateLimitError
+		partial *stock.PartialError
+	)
+	status, message := http.StatusInternalServerError, stocktopus.InternalErrorMessage
+	if errors.As(err, &ue) {
+		status, message = http.StatusUnprocessableEntity, ue.Message
+	}
+
+	switch {
+	case errors.Is(err, storage.ErrUnavailable):
+		status, message = http.StatusServiceUnavailable, engine.Unavailable
+	case errors.As(err, &rateErr):
+		status = http.StatusTooManyRequests
+		if ue == nil {
+			message = rateErr.Error()
+		}
+		resp.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rateErr.RetryAfter.Seconds()))))
+	case errors.Is(err, stocktopus.ErrNoList), errors.Is(err, errNoAccount),
+		errors.Is(err, stock.ErrUnknownSymbol), errors.As(err, &partial):
+		status = http.StatusNotFound
+	case ue != nil && ue.Invalid:
+		status = http.StatusBadRequest
+	}
+
+	if status == http.StatusInternalServerError {
+		tracing.Log(req.Context()).WithField("msg", "api request failed").Error(err)
+	}
+	writeError(resp, status, message)
+}
+
+func writeError(resp http.ResponseWriter, status int, message string) {
+	writeJSON(resp, status, &Error{Error: message})
+}
+
+func writeJSON(resp http.ResponseWriter, status int, v interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	json.NewEncoder(resp).Encode(v)
+}
@@ -0,0 +1,372 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/iex/pkg/types"
+	"github.com/thorfour/stocktopus/pkg/admin"
+	"github.com/thorfour/stocktopus/pkg/engine"
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stock/stocktest"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+// lookup quotes every symbol but ZZZZ at 2
+func lookup() *stocktest.Lookup {
+	return &stocktest.Lookup{
+		Quote:      stock.Quote{LatestPrice: 2, Change: 0.5, ChangePercent: 0.25},
+		Unknown:    []string{"ZZZZ"},
+		Profile:    &types.Company{CompanyName: "Advanced Micro Devices", CEO: "Lisa Su"},
+		Statistics: &types.Stats{CompanyName: "Advanced Micro Devices", Beta: 1.5},
+	}
+}
+
+func newServer() http.Handler {
+	return newServerWith(storage.NewMemory())
+}
+
+func newServerWith(store storage.Store) http.Handler {
+	return New(engine.New(store, lookup()).Stocktopus(), admin.New(store), map[string]string{"key1": "T1", "key2": "T2"}).Handler()
+}
+
+// call makes a request with key and decodes the response into v
+func call(t *testing.T, h http.Handler, key, method, path, body string, v interface{}) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if v != nil && rec.Body.Len() > 0 {
+		require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v), rec.Body.String())
+	}
+	return rec.Code
+}
+
+func TestAuth(t *testing.T) {
+	h := newServer()
+
+	e := &Error{}
+	require.Equal(t, http.StatusUnauthorized, call(t, h, "", http.MethodGet, "/api/v2/quotes?symbols=amd", "", e))
+	require.Equal(t, "Invalid API key", e.Error)
+	require.Equal(t, http.StatusUnauthorized, call(t, h, "key", http.MethodGet, "/api/v2/quotes?symbols=amd", "", nil))
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodGet, "/api/v2/quotes?symbols=amd", "", nil))
+
+	// The document is public
+	doc := map[string]interface{}{}
+	require.Equal(t, http.StatusOK, call(t, h, "", http.MethodGet, "/api/v2/openapi.json", "", &doc))
+	require.Equal(t, "3.0.3", doc["openapi"])
+
+	require.Equal(t, http.StatusNotFound, call(t, h, "key1", http.MethodGet, "/api/v2/nothing", "", e))
+	require.Equal(t, http.StatusMethodNotAllowed, call(t, h, "key1", http.MethodPut, "/api/v2/quotes", "", e))
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc := struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(openAPI), &doc))
+
+	// Every route is documented
+	router := New(nil, nil, nil).Handler().(*mux.Router)
+	routes := 0
+	require.NoError(t, router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		require.NoError(t, err)
+		methods, err := route.GetMethods()
+		if err != nil || path == Prefix+"/openapi.json" {
+			return nil // The subrouter itself
+		}
+		for _, method := range methods {
+			require.Contains(t, doc.Paths[strings.TrimPrefix(path, Prefix)], strings.ToLower(method), path)
+			routes++
+		}
+		return nil
+	}))
+
+	documented := 0
+	for _, methods := range doc.Paths {
+		documented += len(methods)
+	}
+	require.Equal(t, documented, routes)
+}
+
+func TestQuotes(t *testing.T) {
+	h := newServer()
+
+	q := &Quotes{}
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodGet, "/api/v2/quotes?symbols=intc,amd,zzzz&sort=ticker", "", q))
+	require.Len(t, q.Data, 2)
+	require.Equal(t, "AMD", q.Data[0].Symbol)
+	require.Equal(t, float64(2), q.Data[0].LatestPrice)
+	require.Equal(t, 0.25, q.Data[0].ChangePercent)
+	require.Equal(t, "INTC", q.Data[1].Symbol)
+	require.Equal(t, []string{"ZZZZ"}, q.Missing)
+
+	// Symbols are quoted as they are, a range isn't taken as a history request
+	q = &Quotes{}
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodGet, "/api/v2/quotes?symbols=amd,1m&sort=ticker", "", q))
+	require.Len(t, q.Data, 2)
+	require.Equal(t, "1M", q.Data[0].Symbol)
+	require.Equal(t, "AMD", q.Data[1].Symbol)
+
+	tests := map[string]string{
+		"none":     "/api/v2/quotes",
+		"flag":     "/api/v2/quotes?symbols=--sort",
+		"list":     "/api/v2/quotes?symbols=%23fun",
+		"spaces":   "/api/v2/quotes?symbols=amd%20intc",
+		"sort":     "/api/v2/quotes?symbols=amd&sort=volume",
+		"too many": "/api/v2/quotes?symbols=" + strings.Repeat("a,", maxSymbols) + "a",
+	}
+	for name, path := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, http.StatusBadRequest, call(t, h, "key1", http.MethodGet, path, "", nil))
+		})
+	}
+}
+
+func TestCompanies(t *testing.T) {
+	h := newServer()
+
+	c := &Company{}
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodGet, "/api/v2/companies/amd", "", c))
+	require.Equal(t, &Company{Symbol: "AMD", Name: "Advanced Micro Devices", CEO: "Lisa Su"}, c)
+
+	e := &Error{}
+	require.Equal(t, http.StatusNotFound, call(t, h, "key1", http.MethodGet, "/api/v2/companies/zzzz", "", e))
+	require.Equal(t, "Unknown symbol", e.Error)
+
+	s := &Stats{}
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodGet, "/api/v2/companies/amd/stats", "", s))
+	require.Equal(t, "AMD", s.Symbol)
+	require.Equal(t, 1.5, s.Beta)
+}
+
+func TestWatchlists(t *testing.T) {
+	h := newServer()
+
+	for _, name := range []string{"Fun", "chips", "banks"} {
+		require.Equal(t, http.StatusNoContent, call(t, h, "key1", http.MethodPost, "/api/v2/watchlists/"+name+"/symbols", `{"symbols": ["intc", "amd"]}`, nil))
+	}
+	require.Equal(t, http.StatusBadRequest, call(t, h, "key1", http.MethodPost, "/api/v2/watchlists/fun/symbols", `{"tickers": ["amd"]}`, nil))
+	require.Equal(t, http.StatusBadRequest, call(t, h, "key1", http.MethodPost, "/api/v2/watchlists/fun/symbols", `{"symbols": []}`, nil))
+
+	// Pages follow the cursor
+	var names []string
+	path := "/api/v2/watchlists?limit=2"
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+		lists := []*Watchlist{}
+		page := &Page{Data: &lists}
+		require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodGet, path, "", page))
+		for _, l := range lists {
+			require.Equal(t, []string{"AMD", "INTC"}, l.Symbols)
+			require.Empty(t, l.Quotes)
+			names = append(names, l.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		path = "/api/v2/watchlists?limit=2&cursor=" + page.NextCursor
+	}
+	require.Equal(t, []string{"banks", "chips", "fun"}, names)
+	require.Equal(t, http.StatusBadRequest, call(t, h, "key1", http.MethodGet, "/api/v2/watchlists?limit=0", "", nil))
+	require.Equal(t, http.StatusBadRequest, call(t, h, "key1", http.MethodGet, "/api/v2/watchlists?cursor=%21", "", nil))
+
+	// Lists belong to the team of the key
+	page := &Page{}
+	require.Equal(t, http.StatusOK, call(t, h, "key2", http.MethodGet, "/api/v2/watchlists", "", page))
+	require.Empty(t, page.Data)
+	require.Equal(t, http.StatusNotFound, call(t, h, "key2", http.MethodGet, "/api/v2/watchlists/fun", "", nil))
+
+	l := &Watchlist{}
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodGet, "/api/v2/watchlists/FUN?sort=ticker", "", l))
+	require.Equal(t, "fun", l.Name)
+	require.Equal(t, []string{"AMD", "INTC"}, l.Symbols)
+	require.Len(t, l.Quotes, 2)
+	require.Equal(t, "AMD", l.Quotes[0].Symbol)
+
+	require.Equal(t, http.StatusNoContent, call(t, h, "key1", http.MethodDelete, "/api/v2/watchlists/fun/symbols/amd", "", nil))
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodGet, "/api/v2/watchlists/fun", "", l))
+	require.Equal(t, []string{"INTC"}, l.Symbols)
+
+	require.Equal(t, http.StatusNoContent, call(t, h, "key1", http.MethodDelete, "/api/v2/watchlists/fun", "", nil))
+	e := &Error{}
+	require.Equal(t, http.StatusNotFound, call(t, h, "key1", http.MethodGet, "/api/v2/watchlists/fun", "", e))
+	require.Equal(t, "No list", e.Error)
+	require.Equal(t, http.StatusBadRequest, call(t, h, "key1", http.MethodGet, "/api/v2/watchlists/a:b", "", nil))
+}
+
+func TestAccounts(t *testing.T) {
+	h := newServer()
+
+	e := &Error{}
+	require.Equal(t, http.StatusNotFound, call(t, h, "key1", http.MethodGet, "/api/v2/accounts/U1", "", e))
+	require.Equal(t, "No account", e.Error)
+
+	b := &Balance{}
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodPost, "/api/v2/accounts/U1/deposits", `{"amount": 100.5}`, b))
+	require.Equal(t, 100.5, b.Balance)
+	require.Equal(t, http.StatusBadRequest, call(t, h, "key1", http.MethodPost, "/api/v2/accounts/U1/deposits", `{"amount": -1}`, nil))
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodPost, "/api/v2/accounts/U2/deposits", `{"amount": 1}`, nil))
+
+	for i := 0; i < 3; i++ {
+		o := &Order{}
+		require.Equal(t, http.StatusCreated, call(t, h, "key1", http.MethodPost, "/api/v2/accounts/U1/orders", `{"side": "buy", "symbol": "amd", "shares": 10}`, o))
+		require.Equal(t, "AMD", o.Symbol)
+		require.Equal(t, float64(-20), o.Amount)
+		require.NotNil(t, o.Time)
+	}
+	o := &Order{}
+	require.Equal(t, http.StatusCreated, call(t, h, "key1", http.MethodPost, "/api/v2/accounts/U1/orders", `{"side": "sell", "symbol": "AMD", "shares": 5}`, o))
+	require.Equal(t, &Order{Time: o.Time, Side: sideSell, Symbol: "AMD", Shares: 5, Price: 2, Amount: 10}, o)
+
+	require.Equal(t, http.StatusUnprocessableEntity, call(t, h, "key1", http.MethodPost, "/api/v2/accounts/U1/orders", `{"side": "buy", "symbol": "amd", "shares": 1000}`, e))
+	require.Equal(t, "Insufficient funds", e.Error)
+	require.Equal(t, http.StatusBadRequest, call(t, h, "key1", http.MethodPost, "/api/v2/accounts/U1/orders", `{"side": "short", "symbol": "amd", "shares": 1}`, nil))
+	require.Equal(t, http.StatusBadRequest, call(t, h, "key1", http.MethodPost, "/api/v2/accounts/U1/orders", `{"side": "buy", "symbol": "amd", "shares": 0}`, nil))
+
+	a := &Account{}
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodGet, "/api/v2/accounts/U1", "", a))
+	require.Equal(t, "U1", a.ID)
+	require.Equal(t, 50.5, a.Balance)
+	require.Equal(t, 100.5, a.Value)
+	require.Len(t, a.Holdings, 1)
+	require.Equal(t, "AMD", a.Holdings[0].Symbol)
+	require.Equal(t, uint64(25), a.Holdings[0].Shares)
+	require.Equal(t, float64(50), *a.Holdings[0].MarketValue)
+
+	// Orders are newest first
+	var sides []string
+	path := "/api/v2/accounts/U1/orders?limit=3"
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 2)
+		orders := []*Order{}
+		page := &Page{Data: &orders}
+		require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodGet, path, "", page))
+		for _, o := range orders {
+			sides = append(sides, o.Side)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		path = "/api/v2/accounts/U1/orders?limit=3&cursor=" + page.NextCursor
+	}
+	require.Equal(t, []string{sideSell, sideBuy, sideBuy, sideBuy}, sides)
+
+	accounts := []*AccountSummary{}
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodGet, "/api/v2/accounts", "", &Page{Data: &accounts}))
+	require.Equal(t, []*AccountSummary{{ID: "U1", Balance: 50.5}, {ID: "U2", Balance: 1}}, accounts)
+
+	// Accounts belong to the team of the key
+	require.Equal(t, http.StatusNotFound, call(t, h, "key2", http.MethodGet, "/api/v2/accounts/U1", "", nil))
+}
+
+func TestLegacyData(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+	require.NoError(t, store.AddMembers(ctx, keys.LegacyTeamList("token", "T1", "fun"), "AMD"))
+	require.NoError(t, store.Put(ctx, keys.LegacyAccount("token", "u1"), []byte(`{"Balance": 10}`)))
+	h := newServerWith(store)
+
+	// Legacy team lists are used in place, like they are by commands
+	page := &struct{ Data []*Watchlist }{}
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodGet, "/api/v2/watchlists", "", page))
+	require.Equal(t, []*Watchlist{{Name: "fun", Symbols: []string{"AMD"}}}, page.Data)
+	require.Equal(t, http.StatusNoContent, call(t, h, "key1", http.MethodPost, "/api/v2/watchlists/fun/symbols", `{"symbols": ["tsla"]}`, nil))
+	list := &Watchlist{}
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodGet, "/api/v2/watchlists/fun", "", list))
+	require.Equal(t, []string{"AMD", "TSLA"}, list.Symbols)
+
+	// Legacy accounts don't record their team, they're refused until they're migrated
+	e := &Error{}
+	require.Equal(t, http.StatusUnprocessableEntity, call(t, h, "key1", http.MethodGet, "/api/v2/accounts/u1", "", e))
+	require.Equal(t, errNotMigrated.Message, e.Error)
+	require.Equal(t, http.StatusUnprocessableEntity, call(t, h, "key1", http.MethodPost, "/api/v2/accounts/u1/deposits", `{"amount": 5}`, e))
+	require.Equal(t, http.StatusUnprocessableEntity, call(t, h, "key1", http.MethodPost, "/api/v2/accounts/u1/orders", `{"side": "buy", "symbol": "amd", "shares": 1}`, e))
+
+	// Nothing was written under the current keys to hide the legacy data
+	current, err := store.Keys(ctx, keys.Version+":")
+	require.NoError(t, err)
+	require.Empty(t, current)
+}
+
+func TestConcurrentOrders(t *testing.T) {
+	h := newServer()
+	require.Equal(t, http.StatusOK, call(t, h, "key1", http.MethodPost, "/api/v2/accounts/U1/deposits", `{"amount": 1000}`, nil))
+
+	// Each order is answered with the order it made
+	wg := &sync.WaitGroup{}
+	recs := make([]*httptest.ResponseRecorder, 10)
+	for i := range recs {
+		recs[i] = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v2/accounts/U1/orders", strings.NewReader(fmt.Sprintf(`{"side": "buy", "symbol": "amd", "shares": %d}`, i+1)))
+		req.Header.Set("Authorization", "Bearer key1")
+		wg.Add(1)
+		go func(rec *httptest.ResponseRecorder, req *http.Request) {
+			defer wg.Done()
+			h.ServeHTTP(rec, req)
+		}(recs[i], req)
+	}
+	wg.Wait()
+
+	for i, rec := range recs {
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		o := &Order{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), o))
+		require.Equal(t, uint64(i+1), o.Shares)
+	}
+}
+
+func TestFail(t *testing.T) {
+	tests := []struct {
+		err     error
+		status  int
+		message string
+	}{
+		{err: errors.New("boom"), status: http.StatusInternalServerError, message: stocktopus.InternalErrorMessage},
+		{err: fmt.Errorf("Print failed: %w", storage.ErrUnavailable), status: http.StatusServiceUnavailable, message: engine.Unavailable},
+		{err: stocktopus.WithUsage(stocktopus.InvalidInput("Bad", nil), "buy"), status: http.StatusBadRequest, message: "Bad"},
+		{err: fmt.Errorf("Buy failed: %w", stocktopus.ErrInsufficientFunds), status: http.StatusUnprocessableEntity, message: "Insufficient funds"},
+		{err: &stocktopus.UserError{Message: "Slow down", Cause: &stock.RateLimitError{Provider: "iex"}}, status: http.StatusTooManyRequests, message: "Slow down"},
+		{err: fmt.Errorf("Info failed: %w", &stock.RateLimitError{Provider: "alphavantage", RetryAfter: 30 * time.Second}), status: http.StatusTooManyRequests, message: "alphavantage rate limit reached, try again in 30 seconds"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.message, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			fail(rec, httptest.NewRequest(http.MethodGet, "/", nil), test.err)
+			require.Equal(t, test.status, rec.Code)
+
+			e := &Error{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), e))
+			require.Equal(t, test.message, e.Error)
+			if test.status == http.StatusTooManyRequests {
+				require.NotEmpty(t, rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	h := New(nil, nil, map[string]string{"key1": "T1"}).Handler()
+
+	e := &Error{}
+	require.Equal(t, http.StatusInternalServerError, call(t, h, "key1", http.MethodGet, "/api/v2/quotes?symbols=amd", "", e))
+	require.Equal(t, stocktopus.InternalErrorMessage, e.Error)
+}
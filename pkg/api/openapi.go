@@ -0,0 +1,415 @@
+package api
+
+import "net/http"
+
+// OpenAPI serves the OpenAPI document describing the API
+func OpenAPI(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write([]byte(openAPI))
+}
+
+// openAPI is the OpenAPI 3 document for every route in Handler
+const openAPI = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "stocktopus",
+    "version": "2",
+    "description": "Quotes, team watch lists and play money accounts. Every API key belongs to a team and only reaches that team's data."
+  },
+  "servers": [{"url": "/api/v2"}],
+  "security": [{"apiKey": []}],
+  "paths": {
+    "/quotes": {
+      "get": {
+        "summary": "Latest quotes",
+        "operationId": "getQuotes",
+        "parameters": [
+          {"name": "symbols", "in": "query", "required": true, "description": "Comma separated symbols, at most 100", "schema": {"type": "string"}, "example": "AMD,INTC"},
+          {"$ref": "#/components/parameters/sort"}
+        ],
+        "responses": {
+          "200": {"description": "Quotes for the symbols found", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Quotes"}}}},
+          "400": {"$ref": "#/components/responses/Invalid"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "429": {"$ref": "#/components/responses/RateLimited"}
+        }
+      }
+    },
+    "/companies/{symbol}": {
+      "get": {
+        "summary": "Company profile",
+        "operationId": "getCompany",
+        "parameters": [{"$ref": "#/components/parameters/symbol"}],
+        "responses": {
+          "200": {"description": "The company", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Company"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "429": {"$ref": "#/components/responses/RateLimited"}
+        }
+      }
+    },
+    "/companies/{symbol}/stats": {
+      "get": {
+        "summary": "Key statistics of a company",
+        "operationId": "getStats",
+        "parameters": [{"$ref": "#/components/parameters/symbol"}],
+        "responses": {
+          "200": {"description": "The statistics", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Stats"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "429": {"$ref": "#/components/responses/RateLimited"}
+        }
+      }
+    },
+    "/watchlists": {
+      "get": {
+        "summary": "Team watch lists ordered by name",
+        "operationId": "listWatchlists",
+        "parameters": [{"$ref": "#/components/parameters/limit"}, {"$ref": "#/components/parameters/cursor"}],
+        "responses": {
+          "200": {"description": "A page of watch lists, without quotes", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/WatchlistPage"}}}},
+          "400": {"$ref": "#/components/responses/Invalid"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/watchlists/{name}": {
+      "get": {
+        "summary": "Watch list with the latest quotes",
+        "operationId": "getWatchlist",
+        "parameters": [{"$ref": "#/components/parameters/name"}, {"$ref": "#/components/parameters/sort"}],
+        "responses": {
+          "200": {"description": "The watch list", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Watchlist"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a watch list",
+        "operationId": "deleteWatchlist",
+        "parameters": [{"$ref": "#/components/parameters/name"}],
+        "responses": {
+          "204": {"description": "Deleted"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/watchlists/{name}/symbols": {
+      "post": {
+        "summary": "Add symbols to a watch list, creating it if needed",
+        "operationId": "addSymbols",
+        "parameters": [{"$ref": "#/components/parameters/name"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SymbolsRequest"}}}},
+        "responses": {
+          "204": {"description": "Added"},
+          "400": {"$ref": "#/components/responses/Invalid"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/watchlists/{name}/symbols/{symbol}": {
+      "delete": {
+        "summary": "Remove a symbol from a watch list",
+        "operationId": "removeSymbol",
+        "parameters": [{"$ref": "#/components/parameters/name"}, {"$ref": "#/components/parameters/symbol"}],
+        "responses": {
+          "204": {"description": "Removed"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/accounts": {
+      "get": {
+        "summary": "Play money accounts ordered by id",
+        "operationId": "listAccounts",
+        "parameters": [{"$ref": "#/components/parameters/limit"}, {"$ref": "#/components/parameters/cursor"}],
+        "responses": {
+          "200": {"description": "A page of accounts", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AccountPage"}}}},
+          "400": {"$ref": "#/components/responses/Invalid"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/accounts/{id}": {
+      "get": {
+        "summary": "Account with the latest prices of its holdings",
+        "operationId": "getAccount",
+        "parameters": [{"$ref": "#/components/parameters/id"}],
+        "responses": {
+          "200": {"description": "The account", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Account"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/accounts/{id}/orders": {
+      "get": {
+        "summary": "Buys and sells, newest first",
+        "operationId": "listOrders",
+        "parameters": [{"$ref": "#/components/parameters/id"}, {"$ref": "#/components/parameters/limit"}, {"$ref": "#/components/parameters/cursor"}],
+        "responses": {
+          "200": {"description": "A page of orders", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/OrderPage"}}}},
+          "400": {"$ref": "#/components/responses/Invalid"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      },
+      "post": {
+        "summary": "Buy or sell shares at the latest price",
+        "operationId": "placeOrder",
+        "parameters": [{"$ref": "#/components/parameters/id"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/OrderRequest"}}}},
+        "responses": {
+          "201": {"description": "The order", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Order"}}}},
+          "400": {"$ref": "#/components/responses/Invalid"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "422": {"$ref": "#/components/responses/Rejected"},
+          "429": {"$ref": "#/components/responses/RateLimited"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/accounts/{id}/deposits": {
+      "post": {
+        "summary": "Deposit play money, creating the account if needed",
+        "operationId": "deposit",
+        "parameters": [{"$ref": "#/components/parameters/id"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/DepositRequest"}}}},
+        "responses": {
+          "200": {"description": "The new balance", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Balance"}}}},
+          "400": {"$ref": "#/components/responses/Invalid"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "apiKey": {"type": "http", "scheme": "bearer", "description": "An API key from the api.keys setting"}
+    },
+    "parameters": {
+      "symbol": {"name": "symbol", "in": "path", "required": true, "schema": {"type": "string", "pattern": "^[A-Za-z0-9^][A-Za-z0-9.=^-]{0,15}$"}},
+      "name": {"name": "name", "in": "path", "required": true, "description": "Watch list name, case insensitive", "schema": {"type": "string", "pattern": "^[A-Za-z0-9_-]{1,64}$"}},
+      "id": {"name": "id", "in": "path", "required": true, "description": "User id of the account", "schema": {"type": "string", "pattern": "^[A-Za-z0-9_.@-]{1,64}$"}},
+      "sort": {"name": "sort", "in": "query", "description": "Order of the quotes, by percent change by default", "schema": {"type": "string", "enum": ["change", "ticker", "price"]}},
+      "limit": {"name": "limit", "in": "query", "description": "Page size", "schema": {"type": "integer", "minimum": 1, "maximum": 200, "default": 50}},
+      "cursor": {"name": "cursor", "in": "query", "description": "next_cursor of the previous page", "schema": {"type": "string"}}
+    },
+    "responses": {
+      "Invalid": {"description": "Invalid request", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Unauthorized": {"description": "Missing or unknown API key", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "NotFound": {"description": "No such symbol, watch list or account", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Rejected": {"description": "The order can't be filled, such as for insufficient funds", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "RateLimited": {"description": "The market data provider is rate limited, the error says when to try again", "headers": {"Retry-After": {"description": "Seconds until the request can be retried", "schema": {"type": "integer"}}}, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Unavailable": {"description": "Storage is temporarily unavailable", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    },
+    "schemas": {
+      "Quote": {
+        "type": "object",
+        "required": ["symbol", "latest_price", "change", "change_percent", "open", "high", "low", "previous_close", "volume", "avg_volume", "market_cap", "delayed", "closed"],
+        "properties": {
+          "symbol": {"type": "string"},
+          "latest_price": {"type": "number"},
+          "change": {"type": "number"},
+          "change_percent": {"type": "number", "description": "Fraction, 0.01 is 1%"},
+          "open": {"type": "number"},
+          "high": {"type": "number"},
+          "low": {"type": "number"},
+          "previous_close": {"type": "number"},
+          "volume": {"type": "number"},
+          "avg_volume": {"type": "number"},
+          "market_cap": {"type": "number"},
+          "currency": {"type": "string"},
+          "exchange": {"type": "string"},
+          "latest_update": {"type": "string", "format": "date-time"},
+          "extended_price": {"type": "number", "description": "Pre or post market price"},
+          "delayed": {"type": "boolean", "description": "latest_price isn't real time"},
+          "closed": {"type": "boolean", "description": "The market was closed at the time of the quote"},
+          "unavailable_fields": {"type": "array", "items": {"type": "string", "enum": ["change", "volume"]}, "description": "Fields the provider couldn't fill, they're 0. change covers change and change_percent"}
+        }
+      },
+      "Quotes": {
+        "type": "object",
+        "required": ["data"],
+        "properties": {
+          "data": {"type": "array", "items": {"$ref": "#/components/schemas/Quote"}},
+          "missing": {"type": "array", "items": {"type": "string"}, "description": "Symbols that don't exist"},
+          "unavailable": {"type": "array", "items": {"type": "string"}, "description": "Symbols that couldn't be quoted right now, try again later"},
+          "retry_after": {"type": "integer", "description": "Seconds until the unavailable symbols can be quoted again, when the provider's rate limit is known"}
+        }
+      },
+      "Watchlist": {
+        "type": "object",
+        "required": ["name", "symbols"],
+        "properties": {
+          "name": {"type": "string"},
+          "symbols": {"type": "array", "items": {"type": "string"}},
+          "quotes": {"type": "array", "items": {"$ref": "#/components/schemas/Quote"}, "description": "Only included for a single watch list"},
+          "missing": {"type": "array", "items": {"type": "string"}, "description": "Symbols that don't exist"},
+          "unavailable": {"type": "array", "items": {"type": "string"}, "description": "Symbols that couldn't be quoted right now, try again later"},
+          "retry_after": {"type": "integer", "description": "Seconds until the unavailable symbols can be quoted again, when the provider's rate limit is known"}
+        }
+      },
+      "WatchlistPage": {
+        "type": "object",
+        "required": ["data"],
+        "properties": {
+          "data": {"type": "array", "items": {"$ref": "#/components/schemas/Watchlist"}},
+          "next_cursor": {"type": "string", "description": "Left out on the last page"}
+        }
+      },
+      "AccountSummary": {
+        "type": "object",
+        "required": ["id", "balance"],
+        "properties": {
+          "id": {"type": "string"},
+          "balance": {"type": "number"}
+        }
+      },
+      "AccountPage": {
+        "type": "object",
+        "required": ["data"],
+        "properties": {
+          "data": {"type": "array", "items": {"$ref": "#/components/schemas/AccountSummary"}},
+          "next_cursor": {"type": "string", "description": "Left out on the last page"}
+        }
+      },
+      "Account": {
+        "type": "object",
+        "required": ["id", "balance", "value", "holdings"],
+        "properties": {
+          "id": {"type": "string"},
+          "balance": {"type": "number"},
+          "value": {"type": "number", "description": "Balance and the market value of every holding with a quote"},
+          "holdings": {"type": "array", "items": {"$ref": "#/components/schemas/Holding"}}
+        }
+      },
+      "Holding": {
+        "type": "object",
+        "required": ["symbol", "shares", "strike"],
+        "properties": {
+          "symbol": {"type": "string"},
+          "shares": {"type": "integer", "minimum": 1},
+          "strike": {"type": "number", "description": "Average price paid"},
+          "latest_price": {"type": "number", "description": "Left out when the symbol couldn't be quoted, as are market_value and gain"},
+          "market_value": {"type": "number"},
+          "gain": {"type": "number"}
+        }
+      },
+      "Order": {
+        "type": "object",
+        "required": ["side", "symbol", "shares", "price", "amount"],
+        "properties": {
+          "time": {"type": "string", "format": "date-time", "description": "Left out for orders placed before history was recorded"},
+          "side": {"type": "string", "enum": ["buy", "sell"]},
+          "symbol": {"type": "string"},
+          "shares": {"type": "integer", "minimum": 1},
+          "price": {"type": "number"},
+          "amount": {"type": "number", "description": "Change in balance"}
+        }
+      },
+      "OrderPage": {
+        "type": "object",
+        "required": ["data"],
+        "properties": {
+          "data": {"type": "array", "items": {"$ref": "#/components/schemas/Order"}},
+          "next_cursor": {"type": "string", "description": "Left out on the last page"}
+        }
+      },
+      "Balance": {
+        "type": "object",
+        "required": ["balance"],
+        "properties": {
+          "balance": {"type": "number"}
+        }
+      },
+      "Company": {
+        "type": "object",
+        "required": ["symbol", "name"],
+        "properties": {
+          "symbol": {"type": "string"},
+          "name": {"type": "string"},
+          "exchange": {"type": "string"},
+          "industry": {"type": "string"},
+          "sector": {"type": "string"},
+          "website": {"type": "string"},
+          "description": {"type": "string"},
+          "ceo": {"type": "string"},
+          "issue_type": {"type": "string"},
+          "tags": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "Stats": {
+        "type": "object",
+        "description": "Percentages are fractions, 0.01 is 1%",
+        "required": ["symbol", "company_name"],
+        "properties": {
+          "symbol": {"type": "string"},
+          "company_name": {"type": "string"},
+          "market_cap": {"type": "integer"},
+          "beta": {"type": "number"},
+          "week52_high": {"type": "number"},
+          "week52_low": {"type": "number"},
+          "week52_change": {"type": "number"},
+          "dividend_rate": {"type": "number"},
+          "dividend_yield": {"type": "number"},
+          "latest_eps": {"type": "number"},
+          "ttm_eps": {"type": "number"},
+          "shares_outstanding": {"type": "number"},
+          "pe_ratio_high": {"type": "number"},
+          "pe_ratio_low": {"type": "number"},
+          "day50_moving_avg": {"type": "number"},
+          "day200_moving_avg": {"type": "number"},
+          "ytd_change_percent": {"type": "number"},
+          "year1_change_percent": {"type": "number"},
+          "month1_change_percent": {"type": "number"},
+          "day5_change_percent": {"type": "number"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "required": ["error"],
+        "properties": {
+          "error": {"type": "string"}
+        }
+      },
+      "OrderRequest": {
+        "type": "object",
+        "required": ["side", "symbol", "shares"],
+        "additionalProperties": false,
+        "properties": {
+          "side": {"type": "string", "enum": ["buy", "sell"]},
+          "symbol": {"type": "string"},
+          "shares": {"type": "integer", "minimum": 1}
+        }
+      },
+      "DepositRequest": {
+        "type": "object",
+        "required": ["amount"],
+        "additionalProperties": false,
+        "properties": {
+          "amount": {"type": "number", "minimum": 0.01}
+        }
+      },
+      "SymbolsRequest": {
+        "type": "object",
+        "required": ["symbols"],
+        "additionalProperties": false,
+        "properties": {
+          "symbols": {"type": "array", "items": {"type": "string"}, "minItems": 1, "maxItems": 100}
+        }
+      }
+    }
+  }
+}
+`
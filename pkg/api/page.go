@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+)
+
+const (
+	// defaultLimit is the page size when none is given
+	defaultLimit = 50
+
+	// maxLimit is the largest page size
+	maxLimit = 200
+)
+
+// Page is a page of a collection. NextCursor fetches the next page and is left out on the last.
+type Page struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// pager reads the limit and cursor query parameters. Cursors are the opaque key of the last item
+// of the previous page, so items added or removed between requests don't shift the pages.
+type pager struct {
+	limit int
+	after string
+}
+
+func newPager(req *http.Request) (*pager, error) {
+	p := &pager{limit: defaultLimit}
+
+	if limit := req.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxLimit {
+			return nil, stocktopus.InvalidInput("Limit must be between 1 and "+strconv.Itoa(maxLimit), err)
+		}
+		p.limit = n
+	}
+
+	if cursor := req.URL.Query().Get("cursor"); cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(after) == 0 {
+			return nil, stocktopus.InvalidInput("Invalid cursor", err)
+		}
+		p.after = string(after)
+	}
+
+	return p, nil
+}
+
+// window returns the range of the page within n ordered items, and whether there are more. The
+// page starts at the first item follows reports as coming after the cursor.
+func (p *pager) window(n int, follows func(i int) bool) (int, int, bool) {
+	start := 0
+	if p.after != "" {
+		for start < n && !follows(start) {
+			start++
+		}
+	}
+
+	end := start + p.limit
+	if end >= n {
+		return start, n, false
+	}
+	return start, end, true
+}
+
+// cursor returns the cursor for the page after the item with key
+func cursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
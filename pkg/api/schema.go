@@ -0,0 +1,281 @@
+package api
+
+import (
+	"sort"
+	"time"
+
+	"github.com/thorfour/iex/pkg/types"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+)
+
+// Order sides
+const (
+	sideBuy  = "buy"
+	sideSell = "sell"
+)
+
+// Quote is the latest quote for a symbol. ChangePercent is a fraction, 0.01 is 1%.
+type Quote struct {
+	Symbol        string     `json:"symbol"`
+	LatestPrice   float64    `json:"latest_price"`
+	Change        float64    `json:"change"`
+	ChangePercent float64    `json:"change_percent"`
+	Open          float64    `json:"open"`
+	High          float64    `json:"high"`
+	Low           float64    `json:"low"`
+	PreviousClose float64    `json:"previous_close"`
+	Volume        float64    `json:"volume"`
+	AvgVolume     float64    `json:"avg_volume"`
+	MarketCap     float64    `json:"market_cap"`
+	Currency      string     `json:"currency,omitempty"`
+	Exchange      string     `json:"exchange,omitempty"`
+	LatestUpdate  *time.Time `json:"latest_update,omitempty"`
+	ExtendedPrice float64    `json:"extended_price,omitempty"`
+	Delayed       bool       `json:"delayed"`
+	Closed        bool       `json:"closed"`
+	// Unavailable lists the fields the provider couldn't fill, "change" or "volume"
+	Unavailable []string `json:"unavailable_fields,omitempty"`
+}
+
+// Quotes are the quotes for the symbols requested, with the symbols that don't exist and those
+// that couldn't be quoted right now
+type Quotes struct {
+	Data        []*Quote `json:"data"`
+	Missing     []string `json:"missing,omitempty"`
+	Unavailable []string `json:"unavailable,omitempty"`
+	// RetryAfter is the seconds until the unavailable symbols can be quoted again, if it's known
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// Watchlist is a team watch list. Quotes are only included when a single list is requested.
+type Watchlist struct {
+	Name        string   `json:"name"`
+	Symbols     []string `json:"symbols"`
+	Quotes      []*Quote `json:"quotes,omitempty"`
+	Missing     []string `json:"missing,omitempty"`
+	Unavailable []string `json:"unavailable,omitempty"`
+	RetryAfter  int      `json:"retry_after,omitempty"`
+}
+
+// AccountSummary is an account in the list of a team's accounts
+type AccountSummary struct {
+	ID      string  `json:"id"`
+	Balance float64 `json:"balance"`
+}
+
+// Account is a play money account. Value is the balance and the market value of every holding
+// with a quote.
+type Account struct {
+	ID       string     `json:"id"`
+	Balance  float64    `json:"balance"`
+	Value    float64    `json:"value"`
+	Holdings []*Holding `json:"holdings"`
+}
+
+// Holding is the shares held of a symbol. Strike is the average price paid, the market fields
+// are left out when the symbol couldn't be quoted.
+type Holding struct {
+	Symbol      string   `json:"symbol"`
+	Shares      uint64   `json:"shares"`
+	Strike      float64  `json:"strike"`
+	LatestPrice *float64 `json:"latest_price,omitempty"`
+	MarketValue *float64 `json:"market_value,omitempty"`
+	Gain        *float64 `json:"gain,omitempty"`
+}
+
+// Order is a buy or sell of shares. Amount is the change in balance.
+type Order struct {
+	Time   *time.Time `json:"time,omitempty"`
+	Side   string     `json:"side"`
+	Symbol string     `json:"symbol"`
+	Shares uint64     `json:"shares"`
+	Price  float64    `json:"price"`
+	Amount float64    `json:"amount"`
+}
+
+// Balance is the cash balance of an account
+type Balance struct {
+	Balance float64 `json:"balance"`
+}
+
+// Company is a company profile
+type Company struct {
+	Symbol      string   `json:"symbol"`
+	Name        string   `json:"name"`
+	Exchange    string   `json:"exchange,omitempty"`
+	Industry    string   `json:"industry,omitempty"`
+	Sector      string   `json:"sector,omitempty"`
+	Website     string   `json:"website,omitempty"`
+	Description string   `json:"description,omitempty"`
+	CEO         string   `json:"ceo,omitempty"`
+	IssueType   string   `json:"issue_type,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// Stats are key statistics about a company. Percentages are fractions, 0.01 is 1%.
+type Stats struct {
+	Symbol              string  `json:"symbol"`
+	CompanyName         string  `json:"company_name"`
+	MarketCap           int64   `json:"market_cap"`
+	Beta                float64 `json:"beta"`
+	Week52High          float64 `json:"week52_high"`
+	Week52Low           float64 `json:"week52_low"`
+	Week52Change        float64 `json:"week52_change"`
+	DividendRate        float64 `json:"dividend_rate"`
+	DividendYield       float64 `json:"dividend_yield"`
+	LatestEPS           float64 `json:"latest_eps"`
+	TtmEPS              float64 `json:"ttm_eps"`
+	SharesOutstanding   float64 `json:"shares_outstanding"`
+	PERatioHigh         float64 `json:"pe_ratio_high"`
+	PERatioLow          float64 `json:"pe_ratio_low"`
+	Day50MovingAvg      float64 `json:"day50_moving_avg"`
+	Day200MovingAvg     float64 `json:"day200_moving_avg"`
+	YtdChangePercent    float64 `json:"ytd_change_percent"`
+	Year1ChangePercent  float64 `json:"year1_change_percent"`
+	Month1ChangePercent float64 `json:"month1_change_percent"`
+	Day5ChangePercent   float64 `json:"day5_change_percent"`
+}
+
+// Error is the body of every failed request
+type Error struct {
+	Error string `json:"error"`
+}
+
+// OrderRequest places an order
+type OrderRequest struct {
+	Side   string `json:"side"`
+	Symbol string `json:"symbol"`
+	Shares uint64 `json:"shares"`
+}
+
+// DepositRequest deposits play money
+type DepositRequest struct {
+	Amount float64 `json:"amount"`
+}
+
+// SymbolsRequest adds symbols to a watch list
+type SymbolsRequest struct {
+	Symbols []string `json:"symbols"`
+}
+
+func newQuote(q *stock.Quote) *Quote {
+	return &Quote{
+		Symbol:        q.Ticker,
+		LatestPrice:   q.LatestPrice,
+		Change:        q.Change,
+		ChangePercent: q.ChangePercent,
+		Open:          q.Open,
+		High:          q.High,
+		Low:           q.Low,
+		PreviousClose: q.PreviousClose,
+		Volume:        q.Volume,
+		AvgVolume:     q.AvgVolume,
+		MarketCap:     q.MarketCap,
+		Currency:      q.Currency,
+		Exchange:      q.Exchange,
+		LatestUpdate:  timestamp(q.LatestUpdate),
+		ExtendedPrice: q.ExtendedPrice,
+		Delayed:       q.Delayed,
+		Closed:        q.Closed,
+		Unavailable:   q.Unavailable,
+	}
+}
+
+func newQuotes(list stocktopus.WatchList) []*Quote {
+	quotes := make([]*Quote, 0, len(list))
+	for _, q := range list {
+		quotes = append(quotes, newQuote(q))
+	}
+	return quotes
+}
+
+// newAccount converts an account with its latest prices, holdings are sorted by symbol
+func newAccount(id string, a *stocktopus.Account) *Account {
+	acct := &Account{ID: id, Balance: a.Balance, Value: a.Balance, Holdings: []*Holding{}}
+	for symbol, h := range a.Holdings {
+		holding := &Holding{Symbol: symbol, Shares: h.Shares, Strike: h.Strike}
+		if latest, ok := a.Latest[symbol]; ok {
+			value, gain := latest*float64(h.Shares), (latest-h.Strike)*float64(h.Shares)
+			holding.LatestPrice, holding.MarketValue, holding.Gain = &latest, &value, &gain
+			acct.Value += value
+		}
+		acct.Holdings = append(acct.Holdings, holding)
+	}
+	sort.Slice(acct.Holdings, func(i, j int) bool { return acct.Holdings[i].Symbol < acct.Holdings[j].Symbol })
+
+	return acct
+}
+
+// newOrder converts a buy or sell transaction, other transactions aren't orders
+func newOrder(tx *stocktopus.Transaction) (*Order, bool) {
+	side := ""
+	switch tx.Type {
+	case stocktopus.TxBuy:
+		side = sideBuy
+	case stocktopus.TxSell:
+		side = sideSell
+	default:
+		return nil, false
+	}
+
+	return &Order{
+		Time:   timestamp(tx.Time),
+		Side:   side,
+		Symbol: tx.Ticker,
+		Shares: tx.Shares,
+		Price:  tx.Price,
+		Amount: tx.Amount,
+	}, true
+}
+
+func newCompany(c *types.Company) *Company {
+	return &Company{
+		Symbol:      c.Symbol,
+		Name:        c.CompanyName,
+		Exchange:    c.Exchange,
+		Industry:    c.Industry,
+		Sector:      c.Sector,
+		Website:     c.Website,
+		Description: c.Description,
+		CEO:         c.CEO,
+		IssueType:   c.IssueType,
+		Tags:        c.Tags,
+	}
+}
+
+func newStats(symbol string, s *types.Stats) *Stats {
+	if s.Symbol != "" {
+		symbol = s.Symbol
+	}
+	return &Stats{
+		Symbol:              symbol,
+		CompanyName:         s.CompanyName,
+		MarketCap:           s.Marketcap,
+		Beta:                s.Beta,
+		Week52High:          s.Week52High,
+		Week52Low:           s.Week52Low,
+		Week52Change:        s.Week52Change,
+		DividendRate:        s.DividendRate,
+		DividendYield:       s.DividendYield,
+		LatestEPS:           s.LatestEPS,
+		TtmEPS:              s.TtmEPS,
+		SharesOutstanding:   s.SharesOutstanding,
+		PERatioHigh:         s.PeRatioHigh,
+		PERatioLow:          s.PeRatioLow,
+		Day50MovingAvg:      s.Day50MovingAvg,
+		Day200MovingAvg:     s.Day200MovingAvg,
+		YtdChangePercent:    s.YtdChangePercent,
+		Year1ChangePercent:  s.Year1ChangePercent,
+		Month1ChangePercent: s.Month1ChangePercent,
+		Day5ChangePercent:   s.Day5ChangePercent,
+	}
+}
+
+// timestamp leaves out times that weren't recorded
+func timestamp(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
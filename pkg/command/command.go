@@ -0,0 +1,168 @@
+// Package command parses chat command text against the arguments each command declares
+package command
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind is the type of value an argument takes
+type Kind int
+
+const (
+	// Word is a single token, kept as typed unless it has Choices
+	Word Kind = iota
+	// Ticker is a stock symbol or company name, upper-cased
+	Ticker
+	// Int is a positive whole number
+	Int
+	// Money is a positive dollar amount, e.g. 100, $1,000 or 12.50
+	Money
+	// Text is the rest of the input as typed, flags included. It must be the last argument.
+	Text
+)
+
+// Arg declares a positional argument
+type Arg struct {
+	Name string
+	Kind Kind
+	// Optional arguments can be left out, they must follow the required ones
+	Optional bool
+	// Many takes every remaining token, it must be the last argument
+	Many bool
+	// Choices limits a Word to these values, matched case-insensitively
+	Choices []string
+}
+
+// Flag declares an option given as --name value or --name=value
+type Flag struct {
+	Name    string
+	Usage   string
+	Choices []string
+	Default string
+}
+
+// Spec declares a command and the arguments it takes
+type Spec struct {
+	Name    string
+	Summary string
+	// List accepts an optional #list before the arguments
+	List  bool
+	Args  []Arg
+	Flags []Flag
+}
+
+// Usage shows how the command is typed. Required arguments are in [], optional ones in ().
+func (s *Spec) Usage() string {
+	parts := []string{s.Name}
+	if s.List {
+		parts = append(parts, "(#list)")
+	}
+	for _, a := range s.Args {
+		parts = append(parts, a.usage())
+	}
+	for _, f := range s.Flags {
+		parts = append(parts, fmt.Sprintf("(--%s %s)", f.Name, strings.Join(f.Choices, "|")))
+	}
+	return strings.Join(parts, " ")
+}
+
+// usage shows how the argument is typed
+func (a *Arg) usage() string {
+	name := a.Name
+	switch {
+	case len(a.Choices) == 1 && !a.Optional:
+		return a.Choices[0] // A keyword
+	case len(a.Choices) > 0:
+		name = strings.Join(a.Choices, "|")
+	case a.Many:
+		name += "..."
+	}
+	if a.Optional {
+		return "(" + name + ")"
+	}
+	return "[" + name + "]"
+}
+
+// flag returns the declared flag called name
+func (s *Spec) flag(name string) (*Flag, bool) {
+	for i := range s.Flags {
+		if s.Flags[i].Name == name {
+			return &s.Flags[i], true
+		}
+	}
+	return nil, false
+}
+
+// Command is a parsed command
+type Command struct {
+	// Name of the command
+	Name string
+	// List is the lower-cased name of the #list, empty for the personal list
+	List string
+
+	spec   *Spec
+	values map[string][]value
+	flags  map[string]string
+}
+
+// value is a parsed argument
+type value struct {
+	text   string
+	n      uint64
+	amount float64
+}
+
+// Arg returns the first value of the named argument, or "" if it was left out
+func (c *Command) Arg(name string) string {
+	if v := c.values[name]; len(v) > 0 {
+		return v[0].text
+	}
+	return ""
+}
+
+// Args returns every value of the named argument
+func (c *Command) Args(name string) []string {
+	var args []string
+	for _, v := range c.values[name] {
+		args = append(args, v.text)
+	}
+	return args
+}
+
+// Int returns the value of the named Int argument
+func (c *Command) Int(name string) uint64 {
+	if v := c.values[name]; len(v) > 0 {
+		return v[0].n
+	}
+	return 0
+}
+
+// Money returns the value of the named Money argument
+func (c *Command) Money(name string) float64 {
+	if v := c.values[name]; len(v) > 0 {
+		return v[0].amount
+	}
+	return 0
+}
+
+// Flag returns the value of the named flag, or its default when it wasn't given
+func (c *Command) Flag(name string) string {
+	if v, ok := c.flags[name]; ok {
+		return v
+	}
+	if c.spec != nil {
+		if f, ok := c.spec.flag(name); ok {
+			return f.Default
+		}
+	}
+	return ""
+}
+
+// Usage shows how the command is typed
+func (c *Command) Usage() string {
+	if c.spec == nil {
+		return ""
+	}
+	return c.spec.Usage()
+}
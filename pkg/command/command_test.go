@@ -0,0 +1,144 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+)
+
+var testRegistry = NewRegistry("quote",
+	&Spec{
+		Name:  "quote",
+		Args:  []Arg{{Name: "tickers", Kind: Ticker, Many: true}},
+		Flags: []Flag{{Name: "sort", Choices: []string{"change", "ticker"}, Default: "change"}},
+	},
+	&Spec{
+		Name: "watch",
+		List: true,
+		Args: []Arg{{Name: "tickers", Kind: Ticker, Many: true}},
+	},
+	&Spec{
+		Name: "buy",
+		Args: []Arg{{Name: "ticker", Kind: Ticker}, {Name: "shares", Kind: Int}},
+	},
+	&Spec{
+		Name: "deposit",
+		Args: []Arg{{Name: "amount", Kind: Money}},
+	},
+	&Spec{
+		Name: "export",
+		Args: []Arg{{Name: "format", Optional: true, Choices: []string{"csv", "json"}}},
+	},
+	&Spec{
+		Name: "forget",
+		Args: []Arg{{Name: "me", Choices: []string{"me"}}, {Name: "confirm", Optional: true, Choices: []string{"confirm"}}},
+	},
+	&Spec{
+		Name: "import",
+		List: true,
+		Args: []Arg{{Name: "csv", Kind: Text}},
+	},
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		text    string
+		name    string
+		list    string
+		args    map[string][]string
+		flags   map[string]string
+		shares  uint64
+		amount  float64
+		message string
+	}{
+		"quote":              {text: "amd", name: "quote", args: map[string][]string{"tickers": {"AMD"}}},
+		"spaces":             {text: "  amd \t intc  ", name: "quote", args: map[string][]string{"tickers": {"AMD", "INTC"}}},
+		"quoted name":        {text: `quote "advanced micro" intc`, name: "quote", args: map[string][]string{"tickers": {"ADVANCED MICRO", "INTC"}}},
+		"curly quotes":       {text: "“advanced micro”", name: "quote", args: map[string][]string{"tickers": {"ADVANCED MICRO"}}},
+		"quoted command":     {text: `"watch"`, name: "quote", args: map[string][]string{"tickers": {"WATCH"}}},
+		"flag":               {text: "amd --sort ticker", name: "quote", args: map[string][]string{"tickers": {"AMD"}}, flags: map[string]string{"sort": "ticker"}},
+		"flag equals":        {text: "--SORT=Ticker amd", name: "quote", args: map[string][]string{"tickers": {"AMD"}}, flags: map[string]string{"sort": "ticker"}},
+		"flag default":       {text: "amd", name: "quote", flags: map[string]string{"sort": "change"}},
+		"list":               {text: "WATCH #FunList amd", name: "watch", list: "funlist", args: map[string][]string{"tickers": {"AMD"}}},
+		"personal list":      {text: "watch amd", name: "watch", args: map[string][]string{"tickers": {"AMD"}}},
+		"buy":                {text: "buy amd 10", name: "buy", args: map[string][]string{"ticker": {"AMD"}}, shares: 10},
+		"money":              {text: "deposit $1,000.50", name: "deposit", amount: 1000.5},
+		"choice":             {text: "export JSON", name: "export", args: map[string][]string{"format": {"json"}}},
+		"no choice":          {text: "export", name: "export", args: map[string][]string{"format": nil}},
+		"keywords":           {text: "forget me confirm", name: "forget", args: map[string][]string{"me": {"me"}, "confirm": {"confirm"}}},
+		"text":               {text: "import #team AMD,10,\"1.5\"\nintc", name: "import", list: "team", args: map[string][]string{"csv": {"AMD,10,\"1.5\"\nintc"}}},
+		"missing arg":        {text: "buy amd", name: "buy", message: "Missing shares\nUsage: `buy [ticker] [shares]`"},
+		"too many":           {text: "buy amd 1 2", name: "buy", message: "Too many arguments\nUsage: `buy [ticker] [shares]`"},
+		"bad int":            {text: "buy amd -1", name: "buy", message: "\"-1\" is not a whole number of shares\nUsage: `buy [ticker] [shares]`"},
+		"zero":               {text: "buy amd 0", name: "buy", message: "\"0\" is not a whole number of shares\nUsage: `buy [ticker] [shares]`"},
+		"bad money":          {text: "deposit 1.005", name: "deposit", message: "\"1.005\" is not a dollar amount\nUsage: `deposit [amount]`"},
+		"bad choice":         {text: "export xml", name: "export", message: "Expected csv or json, got \"xml\"\nUsage: `export (csv|json)`"},
+		"bad keyword":        {text: "forget you", name: "forget", message: "Expected me, got \"you\"\nUsage: `forget me (confirm)`"},
+		"unknown flag":       {text: "amd --limit 5", name: "quote", message: "Unknown option --limit\nUsage: `quote [tickers...] (--sort change|ticker)`"},
+		"missing flag value": {text: "amd --sort", name: "quote", message: "Missing value for --sort\nUsage: `quote [tickers...] (--sort change|ticker)`"},
+		"unclosed quote":     {text: `watch "amd`, name: "watch", message: "Unclosed quote\nUsage: `watch (#list) [tickers...]`"},
+		"list as ticker":     {text: "watch amd #list", name: "watch", message: "\"#list\" is not a ticker\nUsage: `watch (#list) [tickers...]`"},
+		"empty list":         {text: "watch # amd", name: "watch", message: "Missing list name after #\nUsage: `watch (#list) [tickers...]`"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cmd, err := testRegistry.Parse(test.text)
+			require.NotNil(t, cmd)
+			require.Equal(t, test.name, cmd.Name)
+			if test.message != "" {
+				require.Equal(t, test.message, stocktopus.UserMessage(err))
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.list, cmd.List)
+			for arg, values := range test.args {
+				require.Equal(t, values, cmd.Args(arg))
+			}
+			for flag, value := range test.flags {
+				require.Equal(t, value, cmd.Flag(flag))
+			}
+			require.Equal(t, test.shares, cmd.Int("shares"))
+			require.Equal(t, test.amount, cmd.Money("amount"))
+		})
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	_, err := testRegistry.Parse(" \n ")
+	require.Equal(t, ErrEmpty, err)
+
+	_, err = NewRegistry("", &Spec{Name: "help"}).Parse("amd")
+	require.Equal(t, "Unknown command \"amd\"", stocktopus.UserMessage(err))
+}
+
+func TestUsage(t *testing.T) {
+	for name, usage := range map[string]string{
+		"quote":  "quote [tickers...] (--sort change|ticker)",
+		"watch":  "watch (#list) [tickers...]",
+		"buy":    "buy [ticker] [shares]",
+		"export": "export (csv|json)",
+		"forget": "forget me (confirm)",
+		"import": "import (#list) [csv]",
+	} {
+		spec, ok := testRegistry.Lookup(name)
+		require.True(t, ok)
+		require.Equal(t, usage, spec.Usage())
+	}
+}
+
+func TestInvalidSpecs(t *testing.T) {
+	for name, spec := range map[string]*Spec{
+		"upper case":        {Name: "Quote"},
+		"many not last":     {Name: "a", Args: []Arg{{Name: "x", Many: true}, {Name: "y"}}},
+		"text not last":     {Name: "a", Args: []Arg{{Name: "x", Kind: Text}, {Name: "y"}}},
+		"optional first":    {Name: "a", Args: []Arg{{Name: "x", Optional: true}, {Name: "y"}}},
+		"choices on ticker": {Name: "a", Args: []Arg{{Name: "x", Kind: Ticker, Choices: []string{"a"}}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Panics(t, func() { NewRegistry("", spec) })
+		})
+	}
+}
@@ -0,0 +1,47 @@
+//go:build go1.18
+// +build go1.18
+
+package command
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+)
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"amd", "watch #list amd intc", `quote "advanced micro" --sort=ticker`, "buy amd 10",
+		"deposit $1,000.50", "export json", "forget me confirm", "import #list amd,1,2\nintc",
+		`watch "amd`, "“amd’", "--sort", "# # #", "buy amd 18446744073709551616", "\xff\xfe",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, text string) {
+		cmd, err := testRegistry.Parse(text)
+		if err != nil {
+			// Every failure is explained to the user
+			var ue *stocktopus.UserError
+			require.True(t, errors.As(err, &ue), "%T: %v", err, err)
+			require.True(t, ue.Invalid)
+			return
+		}
+
+		_, ok := testRegistry.Lookup(cmd.Name)
+		require.True(t, ok)
+		for _, ticker := range cmd.Args("tickers") {
+			require.NotEmpty(t, ticker)
+			require.Equal(t, strings.ToUpper(ticker), ticker)
+		}
+		if cmd.Name == "buy" {
+			require.NotZero(t, cmd.Int("shares"))
+		}
+		if cmd.Name == "deposit" {
+			require.Greater(t, cmd.Money("amount"), float64(0))
+		}
+	})
+}
@@ -0,0 +1,352 @@
+package command
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+)
+
+var (
+	// ErrNumArgs is the cause of errors for missing or extra arguments
+	ErrNumArgs = stocktopus.InvalidInput("Incorrect number of args", nil)
+
+	// ErrEmpty is returned for input without a command
+	ErrEmpty = stocktopus.InvalidInput("Empty command", nil)
+)
+
+// maxTicker is the longest ticker, or company name, accepted
+const maxTicker = 64
+
+// moneyFormat matches dollar amounts with optional thousands separators and cents
+var moneyFormat = regexp.MustCompile(`^\$?(\d{1,3}(,\d{3})+|\d+)(\.\d{1,2})?$`)
+
+// Registry holds the commands a frontend understands
+type Registry struct {
+	specs    []*Spec
+	byName   map[string]*Spec
+	fallback *Spec
+}
+
+// NewRegistry returns a registry of specs. Input that doesn't start with a command name is parsed
+// as the arguments of the fallback command, or rejected if fallback is empty. Invalid specs panic.
+func NewRegistry(fallback string, specs ...*Spec) *Registry {
+	r := &Registry{specs: specs, byName: map[string]*Spec{}}
+	for _, s := range specs {
+		if _, ok := r.byName[s.Name]; ok {
+			panic(fmt.Sprintf("command %s registered twice", s.Name))
+		}
+		if err := s.validate(); err != nil {
+			panic(fmt.Sprintf("command %s: %v", s.Name, err))
+		}
+		r.byName[s.Name] = s
+	}
+
+	if fallback != "" {
+		var ok bool
+		if r.fallback, ok = r.byName[fallback]; !ok {
+			panic(fmt.Sprintf("fallback command %s isn't registered", fallback))
+		}
+	}
+
+	return r
+}
+
+// validate checks the arguments can be parsed unambiguously
+func (s *Spec) validate() error {
+	if s.Name == "" || s.Name != strings.ToLower(s.Name) {
+		return fmt.Errorf("name must be lower-case")
+	}
+	optional := false
+	for i, a := range s.Args {
+		last := i == len(s.Args)-1
+		switch {
+		case (a.Many || a.Kind == Text) && !last:
+			return fmt.Errorf("%s must be the last argument", a.Name)
+		case optional && !a.Optional:
+			return fmt.Errorf("required %s follows an optional argument", a.Name)
+		case len(a.Choices) > 0 && a.Kind != Word:
+			return fmt.Errorf("only words can have choices")
+		}
+		optional = a.Optional
+	}
+	return nil
+}
+
+// Specs returns every command in the order they were registered
+func (r *Registry) Specs() []*Spec {
+	return r.specs
+}
+
+// Lookup returns the command called name
+func (r *Registry) Lookup(name string) (*Spec, bool) {
+	s, ok := r.byName[strings.ToLower(name)]
+	return s, ok
+}
+
+// Parse parses text into a command. When only the arguments are invalid the command is returned
+// along with the error, so callers know which command was meant.
+func (r *Registry) Parse(text string) (*Command, error) {
+	sc := &scanner{input: text}
+	first, ok, err := sc.peek()
+	if err != nil {
+		if r.fallback != nil {
+			return &Command{Name: r.fallback.Name, spec: r.fallback}, r.fallback.invalid(err)
+		}
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrEmpty
+	}
+
+	spec, ok := r.byName[strings.ToLower(first.text)]
+	switch {
+	case ok && !first.quoted:
+		sc.next()
+	case r.fallback != nil:
+		spec = r.fallback
+	default:
+		return nil, stocktopus.InvalidInput(fmt.Sprintf("Unknown command %q", first.text), nil)
+	}
+
+	cmd := &Command{Name: spec.Name, spec: spec, values: map[string][]value{}, flags: map[string]string{}}
+	if err := cmd.parse(sc); err != nil {
+		return cmd, spec.invalid(err)
+	}
+	return cmd, nil
+}
+
+// invalid adds the command's usage to a parse error
+func (s *Spec) invalid(err *stocktopus.UserError) error {
+	err.Usage = s.Usage()
+	return err
+}
+
+// parse assigns the remaining tokens to the command's arguments and flags
+func (c *Command) parse(sc *scanner) *stocktopus.UserError {
+	next := 0 // Index of the next argument to assign
+	for {
+		if next < len(c.spec.Args) && c.spec.Args[next].Kind == Text {
+			if err := c.parseList(sc); err != nil {
+				return err
+			}
+			if rest := sc.rest(); rest != "" {
+				c.values[c.spec.Args[next].Name] = []value{{text: rest}}
+			}
+			break
+		}
+
+		tok, ok, err := sc.peek()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		switch {
+		case !tok.quoted && strings.HasPrefix(tok.text, "--"):
+			sc.next()
+			if err := c.parseFlag(tok.text[2:], sc); err != nil {
+				return err
+			}
+
+		case !tok.quoted && strings.HasPrefix(tok.text, "#") && c.spec.List && c.List == "" && len(c.values) == 0:
+			if err := c.parseList(sc); err != nil {
+				return err
+			}
+
+		case next >= len(c.spec.Args):
+			return stocktopus.InvalidInput("Too many arguments", ErrNumArgs)
+
+		default:
+			sc.next()
+			a := &c.spec.Args[next]
+			v, err := a.parse(tok.text)
+			if err != nil {
+				return err
+			}
+			c.values[a.Name] = append(c.values[a.Name], v)
+			if !a.Many {
+				next++
+			}
+		}
+	}
+
+	for _, a := range c.spec.Args {
+		if !a.Optional && len(c.values[a.Name]) == 0 {
+			return stocktopus.InvalidInput("Missing "+a.Name, ErrNumArgs)
+		}
+	}
+
+	return nil
+}
+
+// parseList takes the #list name when it's the next token
+func (c *Command) parseList(sc *scanner) *stocktopus.UserError {
+	if !c.spec.List || c.List != "" {
+		return nil
+	}
+
+	// Text arguments can start with anything, so a bad token here isn't an error
+	tok, ok, err := sc.peek()
+	if err != nil || !ok || tok.quoted || !strings.HasPrefix(tok.text, "#") {
+		return nil
+	}
+	sc.next()
+
+	if c.List = strings.ToLower(tok.text[1:]); c.List == "" {
+		return stocktopus.InvalidInput("Missing list name after #", nil)
+	}
+	return nil
+}
+
+// parseFlag sets the flag in text, taking its value from the next token if it isn't after an =
+func (c *Command) parseFlag(text string, sc *scanner) *stocktopus.UserError {
+	name, val, hasVal := text, "", false
+	if i := strings.Index(text, "="); i >= 0 {
+		name, val, hasVal = text[:i], text[i+1:], true
+	}
+	name = strings.ToLower(name)
+
+	f, ok := c.spec.flag(name)
+	if !ok {
+		return stocktopus.InvalidInput(fmt.Sprintf("Unknown option --%s", name), nil)
+	}
+
+	if !hasVal {
+		tok, ok, err := sc.next()
+		if err != nil {
+			return err
+		}
+		if !ok || (!tok.quoted && strings.HasPrefix(tok.text, "--")) {
+			return stocktopus.InvalidInput(fmt.Sprintf("Missing value for --%s", name), nil)
+		}
+		val = tok.text
+	}
+
+	if len(f.Choices) > 0 {
+		choice, err := choose(f.Choices, val)
+		if err != nil {
+			return err
+		}
+		val = choice
+	}
+
+	c.flags[name] = val
+	return nil
+}
+
+// parse validates and normalizes a token given for the argument
+func (a *Arg) parse(text string) (value, *stocktopus.UserError) {
+	switch a.Kind {
+	case Ticker:
+		ticker := strings.ToUpper(text)
+		if ticker == "" || len(ticker) > maxTicker || strings.HasPrefix(ticker, "#") ||
+			strings.IndexFunc(ticker, unicode.IsControl) >= 0 || !utf8.ValidString(ticker) {
+			return value{}, stocktopus.InvalidInput(fmt.Sprintf("%q is not a ticker", text), nil)
+		}
+		return value{text: ticker}, nil
+
+	case Int:
+		n, err := strconv.ParseUint(text, 10, 64)
+		if err != nil || n == 0 {
+			return value{}, stocktopus.InvalidInput(fmt.Sprintf("%q is not a whole number of %s", text, a.Name), err)
+		}
+		return value{text: text, n: n}, nil
+
+	case Money:
+		if !moneyFormat.MatchString(text) {
+			return value{}, stocktopus.InvalidInput(fmt.Sprintf("%q is not a dollar amount", text), nil)
+		}
+		amount, err := strconv.ParseFloat(strings.NewReplacer("$", "", ",", "").Replace(text), 64)
+		if err != nil || amount <= 0 || math.IsInf(amount, 0) {
+			return value{}, stocktopus.InvalidInput(fmt.Sprintf("%q is not a dollar amount", text), err)
+		}
+		return value{text: text, amount: amount}, nil
+
+	default:
+		if len(a.Choices) == 0 {
+			return value{text: text}, nil
+		}
+		choice, err := choose(a.Choices, text)
+		return value{text: choice}, err
+	}
+}
+
+// choose returns the choice text matches
+func choose(choices []string, text string) (string, *stocktopus.UserError) {
+	for _, c := range choices {
+		if strings.EqualFold(c, text) {
+			return c, nil
+		}
+	}
+
+	expected := choices[0]
+	if len(choices) > 1 {
+		expected = strings.Join(choices[:len(choices)-1], ", ") + " or " + choices[len(choices)-1]
+	}
+	return "", stocktopus.InvalidInput(fmt.Sprintf("Expected %s, got %q", expected, text), nil)
+}
+
+// token is a word of input
+type token struct {
+	text   string
+	quoted bool
+}
+
+// closing maps each opening quote to the quote that closes it. Chat clients often replace
+// straight quotes with curly ones.
+var closing = map[rune]rune{'"': '"', '\'': '\'', '“': '”', '‘': '’'}
+
+// scanner splits input into whitespace separated tokens. A quote at the start of a token runs
+// to its closing quote, so quoted tokens can hold spaces.
+type scanner struct {
+	input string
+	pos   int
+}
+
+// next returns the next token and advances past it
+func (s *scanner) next() (token, bool, *stocktopus.UserError) {
+	rest := s.input[s.pos:]
+	trimmed := strings.TrimLeftFunc(rest, unicode.IsSpace)
+	s.pos += len(rest) - len(trimmed)
+	if trimmed == "" {
+		return token{}, false, nil
+	}
+
+	r, size := utf8.DecodeRuneInString(trimmed)
+	if end, ok := closing[r]; ok {
+		i := strings.IndexRune(trimmed[size:], end)
+		if i < 0 {
+			return token{}, false, stocktopus.InvalidInput("Unclosed quote", nil)
+		}
+		s.pos += size + i + utf8.RuneLen(end)
+		return token{text: trimmed[size : size+i], quoted: true}, true, nil
+	}
+
+	i := strings.IndexFunc(trimmed, unicode.IsSpace)
+	if i < 0 {
+		i = len(trimmed)
+	}
+	s.pos += i
+	return token{text: trimmed[:i]}, true, nil
+}
+
+// peek returns the next token without advancing
+func (s *scanner) peek() (token, bool, *stocktopus.UserError) {
+	pos := s.pos
+	tok, ok, err := s.next()
+	s.pos = pos
+	return tok, ok, err
+}
+
+// rest returns the remaining input as typed
+func (s *scanner) rest() string {
+	return strings.TrimSpace(s.input[s.pos:])
+}
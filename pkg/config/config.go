@@ -0,0 +1,651 @@
+// Package config loads the stocktopus server configuration. Settings are layered: defaults, then
+// a YAML file, then environment variables, then command line flags.
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/thorfour/stocktopus/pkg/discord"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/storage"
+	"github.com/thorfour/stocktopus/pkg/telegram"
+	"github.com/thorfour/stocktopus/pkg/tracing"
+)
+
+// EnvFile names the config file when -config isn't given
+const EnvFile = "STOCKTOPUS_CONFIG"
+
+// filePrefix marks a secret that's read from a file
+const filePrefix = "file:"
+
+// redacted replaces secrets when printing the config
+const redacted = "REDACTED"
+
+// Providers that can be selected
+const (
+	ProviderIEX          = "iex"
+	ProviderAlphaVantage = "alphavantage"
+)
+
+// Storage backends that can be selected
+const (
+	BackendRedis = "redis"
+	BackendBolt  = "bolt"
+)
+
+// Telegram update modes
+const (
+	TelegramWebhook = "webhook"
+	TelegramPoll    = "poll"
+)
+
+// Config is the complete server configuration
+type Config struct {
+	Server     Server         `yaml:"server"`
+	Storage    Storage        `yaml:"storage"`
+	Provider   Provider       `yaml:"provider"`
+	Cache      Cache          `yaml:"cache"`
+	Features   Features       `yaml:"features"`
+	Slack      Slack          `yaml:"slack"`
+	Discord    Discord        `yaml:"discord"`
+	Mattermost Mattermost     `yaml:"mattermost"`
+	Telegram   Telegram       `yaml:"telegram"`
+	Admin      Admin          `yaml:"admin"`
+	API        API            `yaml:"api"`
+	Exports    Exports        `yaml:"exports"`
+	Retention  Retention      `yaml:"retention"`
+	Health     Health         `yaml:"health"`
+	Tracing    tracing.Config `yaml:"tracing"`
+	Metrics    Metrics        `yaml:"metrics"`
+}
+
+// Server configures the HTTP server
+type Server struct {
+	Port int `yaml:"port"`
+	// TLS serves HTTPS with ACME certificates for Host
+	TLS bool `yaml:"tls"`
+	// Debug turns on debugging and disables TLS
+	Debug     bool   `yaml:"debug"`
+	CertCache string `yaml:"cert_cache"`
+	Host      string `yaml:"host"`
+	Email     string `yaml:"email"`
+	// PublicURL is the base URL users reach the server at, it defaults to https://{host}
+	PublicURL string `yaml:"public_url"`
+	// ShutdownDelay is how long to keep serving after a shutdown signal while failing readiness,
+	// so load balancers stop sending requests first
+	ShutdownDelay time.Duration `yaml:"shutdown_delay"`
+	// ShutdownTimeout is how long in-flight requests and background work get to finish
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// Storage selects and configures the storage backend
+type Storage struct {
+	Backend        string              `yaml:"backend"`
+	DataDir        string              `yaml:"data_dir"`
+	HealthInterval time.Duration       `yaml:"health_interval"`
+	Redis          storage.RedisConfig `yaml:"redis"`
+}
+
+// Provider selects the market data provider
+type Provider struct {
+	Name            string `yaml:"name"`
+	IEXToken        string `yaml:"iex_token"`
+	AlphaVantageKey string `yaml:"alphavantage_key"`
+	Quota           Quota  `yaml:"quota"`
+}
+
+// Quota limits calls to the provider. Without a rate alphavantage is limited to its free tier and
+// iex isn't limited.
+type Quota struct {
+	// Rate is the number of calls allowed every Interval
+	Rate     int           `yaml:"rate"`
+	Interval time.Duration `yaml:"interval"`
+	// Burst is the number of calls that can be made back to back, defaults to Rate
+	Burst int `yaml:"burst"`
+	// Daily caps the number of calls per UTC day, zero means no cap
+	Daily int `yaml:"daily"`
+	// MaxWait is how long a call queues for the rate limit before it's rejected, defaults to the
+	// time between calls
+	MaxWait time.Duration `yaml:"max_wait"`
+}
+
+// Cache configures how long provider data is reused
+type Cache struct {
+	// SymbolDirectoryTTL is how often the symbol directory used by search is refreshed
+	SymbolDirectoryTTL time.Duration `yaml:"symbol_directory_ttl"`
+}
+
+// Features toggles optional behaviour
+type Features struct {
+	// ResolveNames lets users type a company name in place of a ticker
+	ResolveNames bool `yaml:"resolve_names"`
+}
+
+// Slack holds the Slack app credentials
+type Slack struct {
+	ClientID      string `yaml:"client_id"`
+	ClientSecret  string `yaml:"client_secret"`
+	SigningSecret string `yaml:"signing_secret"`
+	BotToken      string `yaml:"bot_token"`
+}
+
+// Discord holds the Discord application settings
+type Discord struct {
+	ApplicationID string `yaml:"application_id"`
+	// PublicKey verifies interactions, empty disables the Discord endpoint
+	PublicKey string `yaml:"public_key"`
+	// BotToken registers the slash commands on startup
+	BotToken string `yaml:"bot_token"`
+	// GuildID registers the commands in a single server, where they update immediately
+	GuildID string `yaml:"guild_id"`
+	APIURL  string `yaml:"api_url"`
+}
+
+// Mattermost configures the Mattermost slash command endpoint
+type Mattermost struct {
+	// Tokens are comma separated token=team_id pairs, one for the slash command created in each
+	// Mattermost team. Empty disables the endpoint.
+	Tokens string `yaml:"tokens"`
+}
+
+// Telegram configures the Telegram bot
+type Telegram struct {
+	// Token is the bot token from BotFather, empty disables the bot
+	Token string `yaml:"token"`
+	// Mode is webhook to receive updates at /telegram, or poll for servers Telegram can't reach
+	Mode string `yaml:"mode"`
+	// SecretToken authenticates webhook updates
+	SecretToken string `yaml:"secret_token"`
+	APIURL      string `yaml:"api_url"`
+}
+
+// Admin configures the admin API
+type Admin struct {
+	// Token authorizes the admin API, empty disables it
+	Token string `yaml:"token"`
+}
+
+// API configures the REST API
+type API struct {
+	// Keys are comma separated key=team pairs, each key can only reach the data of its team.
+	// Empty disables the API.
+	Keys string `yaml:"keys"`
+}
+
+// Exports configures data export links
+type Exports struct {
+	// Secret signs export links, empty disables exports
+	Secret string `yaml:"secret"`
+}
+
+// Retention configures deletion of inactive users
+type Retention struct {
+	// Inactive is how long a user can be inactive before their data is deleted, 0 keeps data forever
+	Inactive time.Duration `yaml:"inactive"`
+	Warning  time.Duration `yaml:"warning"`
+}
+
+// Health configures the health endpoints
+type Health struct {
+	// ProviderTTL is how long a provider check is reused, each check costs a provider call
+	ProviderTTL time.Duration `yaml:"provider_ttl"`
+	// ProviderTicker is quoted to check the provider
+	ProviderTicker string `yaml:"provider_ticker"`
+}
+
+// Metrics configures the metrics that are computed from stored data
+type Metrics struct {
+	// ActivityInterval is how often the active user and team gauges are recounted
+	ActivityInterval time.Duration `yaml:"activity_interval"`
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:      443,
+			TLS:       true,
+			CertCache: "/cert",
+			Host:      "api.stocktopus.io",
+			Email:     "support@stocktopus.io",
+
+			ShutdownDelay:   5 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Storage: Storage{
+			Backend:        BackendRedis,
+			DataDir:        "/data",
+			HealthInterval: 5 * time.Second,
+			Redis:          storage.DefaultRedisConfig(),
+		},
+		Provider: Provider{
+			Name: ProviderIEX,
+			Quota: Quota{
+				Interval: time.Minute,
+			},
+		},
+		Cache: Cache{
+			SymbolDirectoryTTL: stock.DefaultDirectoryTTL,
+		},
+		Features: Features{
+			ResolveNames: true,
+		},
+		Discord: Discord{
+			APIURL: discord.DefaultAPIURL,
+		},
+		Telegram: Telegram{
+			Mode:   TelegramWebhook,
+			APIURL: telegram.DefaultAPIURL,
+		},
+		Retention: Retention{
+			Warning: 7 * 24 * time.Hour,
+		},
+		Health: Health{
+			ProviderTTL:    5 * time.Minute,
+			ProviderTicker: "SPY",
+		},
+		Tracing: tracing.DefaultConfig(),
+		Metrics: Metrics{
+			ActivityInterval: 10 * time.Minute,
+		},
+	}
+}
+
+// secret is a setting that must not be printed, bound to the environment variable that sets it
+type secret struct {
+	name  string
+	env   string
+	value *string
+}
+
+// secrets returns every secret setting
+func (c *Config) secrets() []secret {
+	return []secret{
+		{"storage.redis.password", "REDISPW", &c.Storage.Redis.Password},
+		{"storage.redis.sentinel_password", "REDISSENTINELPW", &c.Storage.Redis.SentinelPassword},
+		{"provider.iex_token", "IEX_API_TOKEN", &c.Provider.IEXToken},
+		{"provider.alphavantage_key", "ALPHAVANTAGE_API_KEY", &c.Provider.AlphaVantageKey},
+		{"slack.client_secret", "CLIENTSECRET", &c.Slack.ClientSecret},
+		{"slack.signing_secret", "SLACKSIGNINGSECRET", &c.Slack.SigningSecret},
+		{"slack.bot_token", "SLACKBOTTOKEN", &c.Slack.BotToken},
+		{"discord.bot_token", "DISCORDBOTTOKEN", &c.Discord.BotToken},
+		{"mattermost.tokens", "MATTERMOSTTOKENS", &c.Mattermost.Tokens},
+		{"telegram.token", "TELEGRAMTOKEN", &c.Telegram.Token},
+		{"telegram.secret_token", "TELEGRAMSECRET", &c.Telegram.SecretToken},
+		{"admin.token", "ADMINTOKEN", &c.Admin.Token},
+		{"api.keys", "APIKEYS", &c.API.Keys},
+		{"exports.secret", "EXPORTSECRET", &c.Exports.Secret},
+	}
+}
+
+// env returns the plain settings that can be set by environment variables
+func (c *Config) env() map[string]*string {
+	return map[string]*string{
+		"REDISUSER":        &c.Storage.Redis.Username,
+		"CLIENTID":         &c.Slack.ClientID,
+		"DISCORDAPPID":     &c.Discord.ApplicationID,
+		"DISCORDPUBLICKEY": &c.Discord.PublicKey,
+		"DISCORDGUILDID":   &c.Discord.GuildID,
+		"TELEGRAMMODE":     &c.Telegram.Mode,
+	}
+}
+
+// Load builds the configuration from the file named by -config or STOCKTOPUS_CONFIG, the
+// environment and the flags in args. Flags are registered on fs, which is parsed.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	// Parse once to find the config file and which flags were given
+	var path string
+	fs.StringVar(&path, "config", os.Getenv(EnvFile), "YAML config file, settings in the environment and flags override it")
+	Default().register(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config failed: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(b, c); err != nil {
+			return nil, fmt.Errorf("parse config %s failed: %w", path, err)
+		}
+	}
+
+	c.loadEnv()
+
+	// Apply the flags that were given on top of the file and environment
+	given := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	c.register(given)
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if given.Lookup(f.Name) == nil || err != nil {
+			return
+		}
+		err = given.Set(f.Name, f.Value.String())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.readSecretFiles(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// register adds a flag for each setting that can be given on the command line
+func (c *Config) register(fs *flag.FlagSet) {
+	fs.IntVar(&c.Server.Port, "p", c.Server.Port, "port to serve on")
+	fs.Var(inverted{&c.Server.TLS}, "n", "turn off TLS")
+	fs.BoolVar(&c.Server.Debug, "d", c.Server.Debug, "turn on debugging. Disable TLS")
+	fs.StringVar(&c.Server.CertCache, "c", c.Server.CertCache, "location to store certs")
+	fs.StringVar(&c.Server.Host, "host", c.Server.Host, "ACME allowed FQDN")
+	fs.StringVar(&c.Server.Email, "email", c.Server.Email, "ACME support email")
+	fs.StringVar(&c.Server.PublicURL, "public-url", c.Server.PublicURL, "base URL users reach the server at, for export links. Defaults to https://{host}")
+	fs.DurationVar(&c.Server.ShutdownDelay, "shutdown-delay", c.Server.ShutdownDelay, "how long to fail readiness before draining on shutdown")
+	fs.DurationVar(&c.Server.ShutdownTimeout, "shutdown-timeout", c.Server.ShutdownTimeout, "how long in-flight requests get to finish on shutdown")
+
+	fs.StringVar(&c.Storage.Backend, "storage", c.Storage.Backend, "storage backend to use: redis or bolt")
+	fs.StringVar(&c.Storage.DataDir, "data", c.Storage.DataDir, "directory for the bolt database")
+	fs.DurationVar(&c.Storage.HealthInterval, "health-interval", c.Storage.HealthInterval, "how often to check storage is reachable")
+	c.Storage.Redis.RegisterFlags(fs)
+
+	fs.StringVar(&c.Provider.Name, "provider", c.Provider.Name, "market data provider: iex or alphavantage")
+	fs.IntVar(&c.Provider.Quota.Rate, "provider-rate", c.Provider.Quota.Rate, "provider calls allowed every -provider-interval, 0 uses the provider's default")
+	fs.DurationVar(&c.Provider.Quota.Interval, "provider-interval", c.Provider.Quota.Interval, "interval -provider-rate calls are allowed in")
+	fs.IntVar(&c.Provider.Quota.Daily, "provider-daily", c.Provider.Quota.Daily, "provider calls allowed per UTC day, 0 for no cap")
+	fs.DurationVar(&c.Provider.Quota.MaxWait, "provider-max-wait", c.Provider.Quota.MaxWait, "how long a provider call queues for the rate limit before it's rejected")
+	fs.DurationVar(&c.Health.ProviderTTL, "provider-check-ttl", c.Health.ProviderTTL, "how long to reuse a provider health check, each check costs a provider call")
+	fs.DurationVar(&c.Cache.SymbolDirectoryTTL, "symbol-ttl", c.Cache.SymbolDirectoryTTL, "how often to refresh the symbol directory used by search")
+	fs.BoolVar(&c.Features.ResolveNames, "resolve", c.Features.ResolveNames, "resolve company names to tickers when a single company matches")
+
+	fs.DurationVar(&c.Retention.Inactive, "retention", c.Retention.Inactive, "delete the data of users inactive for this long, 0 keeps data forever")
+	fs.DurationVar(&c.Retention.Warning, "retention-warning", c.Retention.Warning, "how long before deleting inactive users to warn them")
+
+	c.Tracing.RegisterFlags(fs)
+	fs.DurationVar(&c.Metrics.ActivityInterval, "activity-interval", c.Metrics.ActivityInterval, "how often to recount active users and teams for metrics")
+}
+
+// inverted is a boolean flag that clears a setting when it's given
+type inverted struct {
+	value *bool
+}
+
+func (i inverted) IsBoolFlag() bool { return true }
+
+func (i inverted) String() string {
+	if i.value == nil {
+		return "false"
+	}
+	return strconv.FormatBool(!*i.value)
+}
+
+func (i inverted) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*i.value = !v
+	return nil
+}
+
+// loadEnv overrides settings with the environment variables that are set. A secret can also be
+// read from the file named by its variable with a _FILE suffix.
+func (c *Config) loadEnv() {
+	if addrs := os.Getenv("REDISADDR"); addrs != "" {
+		c.Storage.Redis.Addrs = storage.SplitAddrs(addrs)
+	}
+	for env, value := range c.env() {
+		if v := os.Getenv(env); v != "" {
+			*value = v
+		}
+	}
+	for _, s := range c.secrets() {
+		if v := os.Getenv(s.env); v != "" {
+			*s.value = v
+		}
+		if path := os.Getenv(s.env + "_FILE"); path != "" {
+			*s.value = filePrefix + path
+		}
+	}
+}
+
+// readSecretFiles replaces secrets set to file:PATH with the contents of the file
+func (c *Config) readSecretFiles() error {
+	for _, s := range c.secrets() {
+		if !strings.HasPrefix(*s.value, filePrefix) {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(strings.TrimPrefix(*s.value, filePrefix))
+		if err != nil {
+			return fmt.Errorf("read %s failed: %w", s.name, err)
+		}
+		*s.value = strings.TrimRight(string(b), "\r\n")
+	}
+
+	return nil
+}
+
+// Validate checks the configuration, reporting every problem found
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		add("server.port: %d is not a valid port", c.Server.Port)
+	}
+	if c.TLS() && c.Server.Host == "" {
+		add("server.host: required to request certificates when TLS is on")
+	}
+	if c.Server.PublicURL != "" {
+		if u, err := url.Parse(c.Server.PublicURL); err != nil || u.Scheme == "" || u.Host == "" {
+			add("server.public_url: %q is not an absolute URL", c.Server.PublicURL)
+		}
+	}
+	if c.Server.ShutdownDelay < 0 {
+		add("server.shutdown_delay: can't be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		add("server.shutdown_timeout: must be positive")
+	}
+
+	switch c.Storage.Backend {
+	case BackendRedis:
+		if err := c.Storage.Redis.Validate(); err != nil {
+			add("storage.redis: %v", err)
+		}
+	case BackendBolt:
+		if c.Storage.DataDir == "" {
+			add("storage.data_dir: required for the bolt backend")
+		}
+	default:
+		add("storage.backend: %q is not one of %s or %s", c.Storage.Backend, BackendRedis, BackendBolt)
+	}
+	if c.Storage.HealthInterval <= 0 {
+		add("storage.health_interval: must be positive")
+	}
+
+	switch c.Provider.Name {
+	case ProviderIEX:
+	case ProviderAlphaVantage:
+		if c.Provider.AlphaVantageKey == "" {
+			add("provider.alphavantage_key: required for the alphavantage provider, or set ALPHAVANTAGE_API_KEY")
+		}
+	default:
+		add("provider.name: %q is not one of %s or %s", c.Provider.Name, ProviderIEX, ProviderAlphaVantage)
+	}
+	if q := c.Provider.Quota; q.Rate < 0 || q.Burst < 0 || q.Daily < 0 || q.MaxWait < 0 {
+		add("provider.quota: limits can't be negative")
+	} else if q.Rate > 0 && q.Interval <= 0 {
+		add("provider.quota.interval: must be positive")
+	} else if q.Rate == 0 && (q.Daily > 0 || q.Burst > 0 || q.MaxWait > 0) {
+		add("provider.quota.rate: required to limit the provider")
+	} else if min := c.ProviderQuota().TokenInterval(); q.MaxWait > 0 && q.MaxWait < min {
+		add("provider.quota.max_wait: %v is shorter than the %v between calls, calls past the burst would be rejected", q.MaxWait, min)
+	}
+
+	if c.Discord.PublicKey != "" {
+		if key, err := hex.DecodeString(c.Discord.PublicKey); err != nil || len(key) != 32 {
+			add("discord.public_key: must be the hex encoded public key of the Discord application")
+		}
+	}
+	if c.Discord.BotToken != "" && c.Discord.ApplicationID == "" {
+		add("discord.application_id: required to register commands with discord.bot_token")
+	}
+	if u, err := url.Parse(c.Discord.APIURL); err != nil || u.Scheme == "" || u.Host == "" {
+		add("discord.api_url: %q is not an absolute URL", c.Discord.APIURL)
+	}
+
+	switch c.Telegram.Mode {
+	case TelegramWebhook:
+		if c.Telegram.Token != "" && c.Telegram.SecretToken == "" {
+			add("telegram.secret_token: required to authenticate webhook updates, or set TELEGRAMSECRET")
+		}
+	case TelegramPoll:
+	default:
+		add("telegram.mode: %q is not one of %s or %s", c.Telegram.Mode, TelegramWebhook, TelegramPoll)
+	}
+	if u, err := url.Parse(c.Telegram.APIURL); err != nil || u.Scheme == "" || u.Host == "" {
+		add("telegram.api_url: %q is not an absolute URL", c.Telegram.APIURL)
+	}
+
+	if _, err := c.MattermostTokens(); err != nil {
+		add("mattermost.tokens: %v", err)
+	}
+	if _, err := c.APIKeys(); err != nil {
+		add("api.keys: %v", err)
+	}
+
+	if c.Cache.SymbolDirectoryTTL <= 0 {
+		add("cache.symbol_directory_ttl: must be positive")
+	}
+
+	if c.Health.ProviderTTL <= 0 {
+		add("health.provider_ttl: must be positive")
+	}
+	if c.Health.ProviderTicker == "" {
+		add("health.provider_ticker: required to check the provider")
+	}
+
+	if c.Retention.Inactive < 0 {
+		add("retention.inactive: can't be negative")
+	}
+	if c.Retention.Inactive > 0 && (c.Retention.Warning <= 0 || c.Retention.Warning >= c.Retention.Inactive) {
+		add("retention.warning: must be positive and shorter than retention.inactive")
+	}
+
+	if c.Metrics.ActivityInterval <= 0 {
+		add("metrics.activity_interval: must be positive")
+	}
+
+	if err := c.Tracing.Validate(); err != nil {
+		add("tracing: %v", err)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return errors.New("invalid config:\n  " + strings.Join(problems, "\n  "))
+}
+
+// TLS reports whether the server should serve HTTPS
+func (c *Config) TLS() bool {
+	return c.Server.TLS && !c.Server.Debug
+}
+
+// ExportURL returns the base URL for export links
+func (c *Config) ExportURL() string {
+	if c.Server.PublicURL != "" {
+		return c.Server.PublicURL
+	}
+	return "https://" + c.Server.Host
+}
+
+// TelegramWebhookURL returns the URL Telegram sends updates to
+func (c *Config) TelegramWebhookURL() string {
+	return strings.TrimSuffix(c.ExportURL(), "/") + "/telegram"
+}
+
+// ProviderQuota returns the quota provider calls are limited to. A zero Rate means calls aren't
+// limited.
+func (c *Config) ProviderQuota() stock.Quota {
+	q := c.Provider.Quota
+	if q.Rate == 0 {
+		if c.Provider.Name == ProviderAlphaVantage {
+			return stock.DefaultAlphaQuota
+		}
+		return stock.Quota{}
+	}
+
+	quota := stock.Quota{Rate: q.Rate, Interval: q.Interval, Burst: q.Burst, Daily: q.Daily, MaxWait: q.MaxWait}
+	if quota.MaxWait == 0 {
+		quota.MaxWait = quota.TokenInterval()
+	}
+	return quota
+}
+
+// MattermostTokens returns the team of each Mattermost slash command token
+func (c *Config) MattermostTokens() (map[string]string, error) {
+	return teamPairs(c.Mattermost.Tokens, "token")
+}
+
+// APIKeys returns the team of each API key
+func (c *Config) APIKeys() (map[string]string, error) {
+	return teamPairs(c.API.Keys, "key")
+}
+
+// teamPairs parses comma separated secret=team pairs
+func teamPairs(s, name string) (map[string]string, error) {
+	teams := map[string]string{}
+	for i, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+		// Problems are reported by position, the pair holds a secret
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("pair %d is not %s=team", i+1, name)
+		}
+		if _, ok := teams[kv[0]]; ok {
+			return nil, fmt.Errorf("pair %d repeats a %s", i+1, name)
+		}
+		teams[kv[0]] = kv[1]
+	}
+
+	return teams, nil
+}
+
+// Print writes the configuration as YAML with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	out := *c
+	for _, s := range out.secrets() {
+		if *s.value != "" {
+			*s.value = redacted
+		}
+	}
+
+	b, err := yaml.Marshal(&out)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
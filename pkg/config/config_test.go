@@ -0,0 +1,228 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/tracing"
+)
+
+// setenv sets environment variables for the rest of the test
+func setenv(t *testing.T, env map[string]string) {
+	for k, v := range env {
+		old, ok := os.LookupEnv(k)
+		require.NoError(t, os.Setenv(k, v))
+		k := k
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+// write creates a file in dir
+func write(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func load(args ...string) (*Config, error) {
+	return Load(flag.NewFlagSet("test", flag.ContinueOnError), args)
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stocktopus")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := write(t, dir, "config.yaml", `
+server:
+  port: 8080
+  host: stocktopus.example.com
+storage:
+  redis:
+    addrs: [redis:6379]
+    db: 3
+    password: from-file
+cache:
+  symbol_directory_ttl: 1h
+retention:
+  inactive: 720h
+slack:
+  bot_token: file:`+write(t, dir, "bot", "xoxb-token\n")+`
+`)
+
+	t.Run("defaults", func(t *testing.T) {
+		c, err := load()
+		require.NoError(t, err)
+		require.Equal(t, Default(), c)
+		require.NoError(t, c.Validate())
+	})
+
+	t.Run("file", func(t *testing.T) {
+		c, err := load("-config", path)
+		require.NoError(t, err)
+		require.Equal(t, 8080, c.Server.Port)
+		require.Equal(t, "stocktopus.example.com", c.Server.Host)
+		require.Equal(t, []string{"redis:6379"}, c.Storage.Redis.Addrs)
+		require.Equal(t, 3, c.Storage.Redis.DB)
+		require.Equal(t, "from-file", c.Storage.Redis.Password)
+		require.Equal(t, time.Hour, c.Cache.SymbolDirectoryTTL)
+		require.Equal(t, 720*time.Hour, c.Retention.Inactive)
+		require.Equal(t, "xoxb-token", c.Slack.BotToken)
+		require.Equal(t, 3*time.Second, c.Storage.Redis.ReadTimeout, "unset settings keep their defaults")
+		require.Equal(t, "https://stocktopus.example.com", c.ExportURL())
+	})
+
+	t.Run("env overrides file", func(t *testing.T) {
+		setenv(t, map[string]string{
+			EnvFile:                path,
+			"REDISADDR":            "a:6379, b:6379",
+			"REDISPW":              "from-env",
+			"ADMINTOKEN_FILE":      write(t, dir, "admin", "admin-token"),
+			"CLIENTID":             "client",
+			"ALPHAVANTAGE_API_KEY": "key",
+		})
+
+		c, err := load()
+		require.NoError(t, err)
+		require.Equal(t, 8080, c.Server.Port)
+		require.Equal(t, []string{"a:6379", "b:6379"}, c.Storage.Redis.Addrs)
+		require.Equal(t, "from-env", c.Storage.Redis.Password)
+		require.Equal(t, "admin-token", c.Admin.Token)
+		require.Equal(t, "client", c.Slack.ClientID)
+		require.Equal(t, "key", c.Provider.AlphaVantageKey)
+		require.Equal(t, "xoxb-token", c.Slack.BotToken)
+	})
+
+	t.Run("flags override env", func(t *testing.T) {
+		setenv(t, map[string]string{"REDISPW": "from-env"})
+
+		c, err := load("-config", path, "-p", "9090", "-n", "-redis-db", "0", "-resolve=false", "-provider", "alphavantage", "-tracing", "stdout")
+		require.NoError(t, err)
+		require.Equal(t, 9090, c.Server.Port)
+		require.False(t, c.TLS())
+		require.Equal(t, 0, c.Storage.Redis.DB)
+		require.False(t, c.Features.ResolveNames)
+		require.Equal(t, ProviderAlphaVantage, c.Provider.Name)
+		require.Equal(t, tracing.ExporterStdout, c.Tracing.Exporter)
+		require.Equal(t, "stocktopus.example.com", c.Server.Host)
+		require.Equal(t, "from-env", c.Storage.Redis.Password)
+	})
+
+	t.Run("unknown setting", func(t *testing.T) {
+		_, err := load("-config", write(t, dir, "typo.yaml", "server:\n  prot: 80\n"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "prot")
+	})
+
+	t.Run("missing secret file", func(t *testing.T) {
+		setenv(t, map[string]string{"EXPORTSECRET_FILE": filepath.Join(dir, "missing")})
+		_, err := load()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "exports.secret")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		change func(*Config)
+		err    string
+	}{
+		"defaults":          {change: func(*Config) {}},
+		"port":              {change: func(c *Config) { c.Server.Port = 70000 }, err: "server.port"},
+		"no host":           {change: func(c *Config) { c.Server.Host = "" }, err: "server.host"},
+		"no host debug":     {change: func(c *Config) { c.Server.Host, c.Server.Debug = "", true }},
+		"public url":        {change: func(c *Config) { c.Server.PublicURL = "example.com" }, err: "server.public_url"},
+		"backend":           {change: func(c *Config) { c.Storage.Backend = "etcd" }, err: `storage.backend: "etcd"`},
+		"redis":             {change: func(c *Config) { c.Storage.Redis.Addrs = []string{"a", "b"} }, err: "storage.redis"},
+		"bolt":              {change: func(c *Config) { c.Storage.Backend = BackendBolt }},
+		"provider":          {change: func(c *Config) { c.Provider.Name = "yahoo" }, err: "provider.name"},
+		"alphavantage":      {change: func(c *Config) { c.Provider.Name = ProviderAlphaVantage }, err: "provider.alphavantage_key"},
+		"quota":             {change: func(c *Config) { c.Provider.Quota = Quota{Rate: 5, Interval: time.Minute, Daily: 500} }},
+		"quota rate":        {change: func(c *Config) { c.Provider.Quota.Daily = 500 }, err: "provider.quota.rate"},
+		"quota max wait":    {change: func(c *Config) { c.Provider.Quota = Quota{Rate: 5, Interval: time.Minute, MaxWait: time.Second} }, err: "provider.quota.max_wait: 1s is shorter than the 12s between calls"},
+		"symbol ttl":        {change: func(c *Config) { c.Cache.SymbolDirectoryTTL = 0 }, err: "cache.symbol_directory_ttl"},
+		"shutdown timeout":  {change: func(c *Config) { c.Server.ShutdownTimeout = 0 }, err: "server.shutdown_timeout"},
+		"provider ticker":   {change: func(c *Config) { c.Health.ProviderTicker = "" }, err: "health.provider_ticker"},
+		"retention warning": {change: func(c *Config) { c.Retention.Inactive = time.Hour }, err: "retention.warning"},
+		"tracing exporter":  {change: func(c *Config) { c.Tracing.Exporter = "jaeger" }, err: "tracing: exporter"},
+		"activity interval": {change: func(c *Config) { c.Metrics.ActivityInterval = 0 }, err: "metrics.activity_interval"},
+		"tracing ratio":     {change: func(c *Config) { c.Tracing.SampleRatio = 2 }, err: "tracing: sample ratio"},
+		"discord key":       {change: func(c *Config) { c.Discord.PublicKey = "abc" }, err: "discord.public_key"},
+		"discord app":       {change: func(c *Config) { c.Discord.BotToken = "token" }, err: "discord.application_id"},
+		"discord api":       {change: func(c *Config) { c.Discord.APIURL = "" }, err: "discord.api_url"},
+		"telegram secret":   {change: func(c *Config) { c.Telegram.Token = "123:abc" }, err: "telegram.secret_token"},
+		"telegram poll":     {change: func(c *Config) { c.Telegram.Token, c.Telegram.Mode = "123:abc", TelegramPoll }},
+		"telegram mode":     {change: func(c *Config) { c.Telegram.Mode = "push" }, err: "telegram.mode"},
+		"mattermost tokens": {change: func(c *Config) { c.Mattermost.Tokens = "t1=T1,t2=T2" }},
+		"mattermost team":   {change: func(c *Config) { c.Mattermost.Tokens = "t1" }, err: "mattermost.tokens: pair 1 is not token=team"},
+		"api keys":          {change: func(c *Config) { c.API.Keys = "k1=T1,k2=T2" }},
+		"api key team":      {change: func(c *Config) { c.API.Keys = "k1=T1,secret" }, err: "api.keys: pair 2 is not key=team"},
+		"api key repeated":  {change: func(c *Config) { c.API.Keys = "k1=T1,k1=T2" }, err: "api.keys: pair 2 repeats a key"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := Default()
+			test.change(c)
+			err := c.Validate()
+			if test.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), test.err)
+		})
+	}
+
+	// Every problem is reported at once
+	c := Default()
+	c.Server.Port = 0
+	c.Provider.Name = "yahoo"
+	err := c.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "server.port")
+	require.Contains(t, err.Error(), "provider.name")
+}
+
+func TestProviderQuota(t *testing.T) {
+	c := Default()
+	require.Equal(t, stock.Quota{}, c.ProviderQuota())
+
+	c.Provider.Name = ProviderAlphaVantage
+	require.Equal(t, stock.DefaultAlphaQuota, c.ProviderQuota())
+
+	// Calls wait for the next token by default
+	c.Provider.Quota.Rate = 30
+	require.Equal(t, stock.Quota{Rate: 30, Interval: time.Minute, MaxWait: 2 * time.Second}, c.ProviderQuota())
+}
+
+func TestPrint(t *testing.T) {
+	c := Default()
+	c.Storage.Redis.Password = "hunter2"
+	c.Slack.ClientID = "client"
+	c.Admin.Token = "admin-token"
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, c.Print(buf))
+	require.NotContains(t, buf.String(), "hunter2")
+	require.NotContains(t, buf.String(), "admin-token")
+	require.Contains(t, buf.String(), "password: REDACTED")
+	require.Contains(t, buf.String(), "client_id: client")
+	require.Contains(t, buf.String(), `bot_token: ""`)
+
+	// Printing doesn't change the config
+	require.Equal(t, "hunter2", c.Storage.Redis.Password)
+}
@@ -0,0 +1,77 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultAPIURL is the Discord API the commands are registered with and responses are sent to
+const DefaultAPIURL = "https://discord.com/api/v10"
+
+// client calls the Discord API
+type client struct {
+	api  string
+	http *http.Client
+}
+
+func newClient(api string) *client {
+	return &client{
+		api:  strings.TrimSuffix(api, "/"),
+		http: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// do sends body as JSON to path. Paths can hold interaction tokens, so they're left out of errors.
+func (c *client) do(ctx context.Context, method, path, auth string, body interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.api+path, r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %w", method, unwrapURL(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s request failed: %s %s", method, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// unwrapURL drops the URL from a request error
+func unwrapURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,211 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/thorfour/stocktopus/pkg/command"
+	"github.com/thorfour/stocktopus/pkg/engine"
+)
+
+// Application command option types
+const (
+	optionString  = 3
+	optionInteger = 4
+	optionBoolean = 5
+	optionNumber  = 10
+)
+
+// maxDescription is the longest command or option description Discord accepts
+const maxDescription = 100
+
+// listOption names the option that selects a team watch list
+const listOption = "list"
+
+// applicationCommand is a slash command definition
+type applicationCommand struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Options     []commandOption `json:"options,omitempty"`
+}
+
+type commandOption struct {
+	Type        int            `json:"type"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Required    bool           `json:"required,omitempty"`
+	Choices     []optionChoice `json:"choices,omitempty"`
+	MinValue    *float64       `json:"min_value,omitempty"`
+}
+
+type optionChoice struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// Register creates or replaces the slash commands of application appID with the engine's
+// commands. Commands registered in a guild update immediately, global commands can take an hour.
+func Register(ctx context.Context, api, appID, guildID, botToken string) error {
+	path := fmt.Sprintf("/applications/%s/commands", appID)
+	if guildID != "" {
+		path = fmt.Sprintf("/applications/%s/guilds/%s/commands", appID, guildID)
+	}
+
+	c := newClient(api)
+	if err := c.do(ctx, http.MethodPut, path, "Bot "+botToken, definitions(engine.Commands())); err != nil {
+		return fmt.Errorf("register commands failed: %w", err)
+	}
+
+	return nil
+}
+
+// definitions returns a slash command for each command spec
+func definitions(specs []*command.Spec) []*applicationCommand {
+	cmds := make([]*applicationCommand, 0, len(specs))
+	for _, spec := range specs {
+		cmds = append(cmds, definition(spec))
+	}
+	return cmds
+}
+
+// definition describes a command spec as a slash command. Options must be given in the order
+// Discord shows them, required ones first, so arguments come before the list and flags.
+func definition(spec *command.Spec) *applicationCommand {
+	cmd := &applicationCommand{Name: spec.Name, Description: truncate(spec.Summary, maxDescription)}
+
+	for _, arg := range spec.Args {
+		if keyword(arg) && !arg.Optional {
+			continue // Always given, it's filled in when the command runs
+		}
+		cmd.Options = append(cmd.Options, argOption(arg))
+	}
+	if spec.List {
+		cmd.Options = append(cmd.Options, commandOption{
+			Type:        optionString,
+			Name:        listOption,
+			Description: "team watch list, leave out to use your own",
+		})
+	}
+	for _, f := range spec.Flags {
+		opt := commandOption{Type: optionString, Name: f.Name, Description: truncate(f.Usage, maxDescription)}
+		for _, choice := range f.Choices {
+			opt.Choices = append(opt.Choices, optionChoice{Name: choice, Value: choice})
+		}
+		cmd.Options = append(cmd.Options, opt)
+	}
+
+	return cmd
+}
+
+// argOption describes an argument as an option
+func argOption(arg command.Arg) commandOption {
+	if keyword(arg) {
+		return commandOption{Type: optionBoolean, Name: arg.Name, Description: "set to " + arg.Name}
+	}
+
+	opt := commandOption{Type: optionString, Name: arg.Name, Description: arg.Name, Required: !arg.Optional}
+	switch {
+	case arg.Many:
+		opt.Description = "ticker symbols separated by spaces"
+	case arg.Kind == command.Ticker:
+		opt.Description = "ticker symbol"
+	case arg.Kind == command.Int:
+		opt.Type, opt.MinValue = optionInteger, minValue(1)
+		opt.Description = "number of " + arg.Name
+	case arg.Kind == command.Money:
+		opt.Type, opt.MinValue = optionNumber, minValue(0.01)
+		opt.Description = "amount in dollars"
+	}
+	for _, choice := range arg.Choices {
+		opt.Choices = append(opt.Choices, optionChoice{Name: choice, Value: choice})
+	}
+
+	return opt
+}
+
+// keyword is an argument that can only be one word, it's a boolean when it's optional
+func keyword(arg command.Arg) bool {
+	return len(arg.Choices) == 1
+}
+
+func minValue(v float64) *float64 {
+	return &v
+}
+
+// option is an option given with a slash command
+type option struct {
+	Name  string      `json:"name"`
+	Type  int         `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// commandText rebuilds the text the engine parses from the options of a slash command
+func commandText(spec *command.Spec, opts []option) string {
+	values := make(map[string]string, len(opts))
+	for _, o := range opts {
+		values[o.Name] = format(o.Value)
+	}
+
+	parts := []string{spec.Name}
+	if list := strings.TrimPrefix(values[listOption], "#"); spec.List && list != "" {
+		parts = append(parts, "#"+list)
+	}
+	for _, f := range spec.Flags {
+		if v := values[f.Name]; v != "" {
+			parts = append(parts, "--"+f.Name+"="+v)
+		}
+	}
+	for _, arg := range spec.Args {
+		v, ok := values[arg.Name]
+		switch {
+		case keyword(arg) && !arg.Optional:
+			parts = append(parts, arg.Choices[0])
+		case keyword(arg):
+			if v == "true" {
+				parts = append(parts, arg.Choices[0])
+			}
+		case !ok:
+		case arg.Many, arg.Kind == command.Text:
+			parts = append(parts, v) // Typed the same way as in any other chat
+		default:
+			parts = append(parts, quote(v))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// format renders an option value the way it would be typed
+func format(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
+
+// quote keeps a value with spaces in it together as one argument
+func quote(s string) string {
+	if strings.IndexFunc(s, unicode.IsSpace) < 0 {
+		return s
+	}
+	return `"` + s + `"`
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
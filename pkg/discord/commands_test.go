@@ -0,0 +1,103 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/command"
+	"github.com/thorfour/stocktopus/pkg/engine"
+)
+
+func spec(t *testing.T, name string) *command.Spec {
+	for _, spec := range engine.Commands() {
+		if spec.Name == name {
+			return spec
+		}
+	}
+	t.Fatalf("no command %s", name)
+	return nil
+}
+
+func TestCommandText(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []option
+		text string
+	}{
+		{name: "quote", opts: []option{{Name: "tickers", Value: "amd intc"}, {Name: "sort", Value: "ticker"}}, text: "quote --sort=ticker amd intc"},
+		{name: "watch", opts: []option{{Name: "tickers", Value: "amd"}, {Name: "list", Value: "#fun"}}, text: "watch #fun amd"},
+		{name: "list", opts: []option{{Name: "list", Value: "fun"}}, text: "list #fun"},
+		{name: "list", text: "list"},
+		{name: "buy", opts: []option{{Name: "ticker", Value: "advanced micro"}, {Name: "shares", Value: float64(10)}}, text: `buy "advanced micro" 10`},
+		{name: "deposit", opts: []option{{Name: "amount", Value: 1000.5}}, text: "deposit 1000.5"},
+		{name: "history", opts: []option{{Name: "ticker", Value: "amd"}, {Name: "range", Value: "1m"}}, text: "history amd 1m"},
+		{name: "search", opts: []option{{Name: "text", Value: "advanced micro"}}, text: "search advanced micro"},
+		{name: "export", opts: []option{{Name: "format", Value: "json"}}, text: "export json"},
+		{name: "forget", text: "forget me"},
+		{name: "forget", opts: []option{{Name: "confirm", Value: false}}, text: "forget me"},
+		{name: "forget", opts: []option{{Name: "confirm", Value: true}}, text: "forget me confirm"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			require.Equal(t, test.text, commandText(spec(t, test.name), test.opts))
+		})
+	}
+}
+
+func TestDefinitions(t *testing.T) {
+	cmds := definitions(engine.Commands())
+	require.Len(t, cmds, len(engine.Commands()))
+
+	for _, cmd := range cmds {
+		require.NotEmpty(t, cmd.Description, cmd.Name)
+		require.LessOrEqual(t, len([]rune(cmd.Description)), maxDescription, cmd.Name)
+		require.LessOrEqual(t, len(cmd.Options), 25, cmd.Name)
+
+		// Required options must come first
+		optional := false
+		for _, opt := range cmd.Options {
+			require.NotEmpty(t, opt.Description, cmd.Name)
+			require.LessOrEqual(t, len([]rune(opt.Description)), maxDescription, cmd.Name)
+			require.False(t, optional && opt.Required, "%s: %s", cmd.Name, opt.Name)
+			optional = !opt.Required
+		}
+	}
+
+	forget := definition(spec(t, "forget"))
+	require.Equal(t, []commandOption{{Type: optionBoolean, Name: "confirm", Description: "set to confirm"}}, forget.Options)
+
+	buy := definition(spec(t, "buy"))
+	require.Equal(t, optionInteger, buy.Options[1].Type)
+	require.Equal(t, float64(1), *buy.Options[1].MinValue)
+}
+
+func TestRegister(t *testing.T) {
+	var (
+		path, auth string
+		cmds       []*applicationCommand
+	)
+	api := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		path, auth = req.Method+" "+req.URL.Path, req.Header.Get("Authorization")
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&cmds))
+	}))
+	defer api.Close()
+
+	require.NoError(t, Register(context.Background(), api.URL, "app", "", "token"))
+	require.Equal(t, "PUT /applications/app/commands", path)
+	require.Equal(t, "Bot token", auth)
+	require.Len(t, cmds, len(engine.Commands()))
+
+	require.NoError(t, Register(context.Background(), api.URL, "app", "guild", "token"))
+	require.Equal(t, "PUT /applications/app/guilds/guild/commands", path)
+
+	api.Config.Handler = http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		http.Error(resp, `{"message": "401: Unauthorized"}`, http.StatusUnauthorized)
+	})
+	err := Register(context.Background(), api.URL, "app", "", "bad")
+	require.EqualError(t, err, `register commands failed: PUT request failed: 401 Unauthorized {"message": "401: Unauthorized"}`)
+}
@@ -0,0 +1,329 @@
+// Package discord runs stocktopus commands sent as Discord slash commands
+package discord
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thorfour/stocktopus/pkg/command"
+	"github.com/thorfour/stocktopus/pkg/engine"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/tracing"
+)
+
+var (
+	// ErrBadSignature is returned for requests that weren't signed by Discord
+	ErrBadSignature = errors.New("invalid request signature")
+
+	// ErrStaleRequest is returned for signed requests that are too old, which may be replays
+	ErrStaleRequest = errors.New("request timestamp too old")
+)
+
+const (
+	// maxRequestAge is the oldest signed request that's accepted
+	maxRequestAge = 5 * time.Minute
+
+	// maxInteractionSize limits the size of an interaction body
+	maxInteractionSize = 1 << 20
+
+	// deferAfter is how long a command can run before the response is deferred, Discord
+	// drops interactions that aren't answered within 3 seconds
+	deferAfter = 2 * time.Second
+
+	// followUpTimeout bounds a deferred command, interaction tokens are valid for 15 minutes
+	followUpTimeout = 10 * time.Minute
+
+	// dmTeam is the team of commands sent in direct messages rather than a guild
+	dmTeam = "@me"
+)
+
+// Interaction types
+const (
+	interactionPing    = 1
+	interactionCommand = 2
+)
+
+// Interaction response types
+const (
+	responsePong     = 1
+	responseMessage  = 4
+	responseDeferred = 5
+)
+
+type user struct {
+	ID string `json:"id"`
+}
+
+// interaction is a request from Discord
+type interaction struct {
+	Type          int    `json:"type"`
+	ApplicationID string `json:"application_id"`
+	Token         string `json:"token"`
+	GuildID       string `json:"guild_id"`
+	ChannelID     string `json:"channel_id"`
+	// Member is set in guilds, User in direct messages
+	Member *struct {
+		User user `json:"user"`
+	} `json:"member"`
+	User *user `json:"user"`
+	Data struct {
+		Name    string   `json:"name"`
+		Options []option `json:"options"`
+	} `json:"data"`
+}
+
+// userID returns the ID of the user who sent the interaction
+func (in *interaction) userID() string {
+	if in.Member != nil {
+		return in.Member.User.ID
+	}
+	if in.User != nil {
+		return in.User.ID
+	}
+	return ""
+}
+
+type response struct {
+	Type int      `json:"type"`
+	Data *message `json:"data,omitempty"`
+}
+
+// Server handles Discord interactions
+type Server struct {
+	e          *engine.Engine
+	publicKey  ed25519.PublicKey
+	api        *client
+	commands   map[string]*command.Spec
+	deferAfter time.Duration
+
+	// followUps are the deferred commands still running
+	followUps sync.WaitGroup
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithAPIURL sends deferred responses to a Discord API other than DefaultAPIURL
+func WithAPIURL(api string) Option {
+	return func(s *Server) {
+		s.api = newClient(api)
+	}
+}
+
+// New returns a server that runs commands with e. Interactions must be signed with the private
+// key of publicKey, the hex encoded public key of the Discord application.
+func New(e *engine.Engine, publicKey string, opts ...Option) (*Server, error) {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Discord public key %q", publicKey)
+	}
+
+	s := &Server{
+		e:          e,
+		publicKey:  key,
+		api:        newClient(DefaultAPIURL),
+		commands:   map[string]*command.Spec{},
+		deferAfter: deferAfter,
+	}
+	for _, spec := range engine.Commands() {
+		s.commands[spec.Name] = spec
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
+
+// Handler is a http handler func for Discord interactions
+func (s *Server) Handler(resp http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	body, err := verify(s.publicKey, req, time.Now())
+	if err != nil {
+		tracing.Log(ctx).WithField("msg", "interaction rejected").Warn(err)
+		http.Error(resp, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	in := &interaction{}
+	if err := json.Unmarshal(body, in); err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var r *response
+	switch in.Type {
+	case interactionPing:
+		r = &response{Type: responsePong}
+	case interactionCommand:
+		r = s.command(req, in)
+	default:
+		http.Error(resp, "unsupported interaction type", http.StatusBadRequest)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(resp).Encode(r); err != nil {
+		tracing.Log(ctx).WithField("msg", "encoding failure").Error(err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// reply is the outcome of a command
+type reply struct {
+	result engine.Result
+	err    error
+}
+
+// command runs a slash command. Commands that don't finish within deferAfter are answered with
+// a deferred response and their result is sent when they do.
+func (s *Server) command(req *http.Request, in *interaction) *response {
+	spec, ok := s.commands[in.Data.Name]
+	if !ok {
+		return &response{Type: responseMessage, Data: errorMessage(&stocktopus.UserError{Message: fmt.Sprintf("Unknown command %q", in.Data.Name)})}
+	}
+
+	r := &engine.Request{
+		Platform: engine.Discord,
+		Team:     in.GuildID,
+		User:     in.userID(),
+		Channel:  in.ChannelID,
+		Text:     commandText(spec, in.Data.Options),
+	}
+	if r.Team == "" {
+		// Every direct message shares a team, so team lists are scoped to the conversation
+		r.Team, r.Group = dmTeam, in.ChannelID
+	}
+
+	// The command outlives the request when it's deferred
+	ctx, cancel := context.WithTimeout(detached{req.Context()}, followUpTimeout)
+	done, deferred := make(chan reply), make(chan struct{})
+	s.followUps.Add(1)
+	go func() {
+		defer s.followUps.Done()
+		defer cancel()
+
+		rep := s.run(ctx, r)
+		select {
+		case done <- rep:
+		case <-deferred:
+			s.followUp(ctx, in, rep)
+		}
+	}()
+
+	timer := time.NewTimer(s.deferAfter)
+	defer timer.Stop()
+	select {
+	case rep := <-done:
+		return &response{Type: responseMessage, Data: rep.message()}
+	case <-timer.C:
+		close(deferred)
+		return &response{Type: responseDeferred}
+	}
+}
+
+// run runs a command, a panic is reported to the user as an internal error
+func (s *Server) run(ctx context.Context, r *engine.Request) (rep reply) {
+	defer func() {
+		if p := recover(); p != nil {
+			tracing.Log(ctx).WithField("msg", "panic").WithField("stack", string(debug.Stack())).Error(p)
+			rep = reply{err: fmt.Errorf("panic: %v", p)}
+		}
+	}()
+
+	result, err := s.e.Run(ctx, r)
+	return reply{result: result, err: err}
+}
+
+func (rep reply) message() *message {
+	if rep.err != nil {
+		return errorMessage(rep.err)
+	}
+	return render(rep.result)
+}
+
+// followUp replaces the "thinking" placeholder of a deferred response with the result. The
+// placeholder is public, so a private result is sent as an ephemeral follow-up and the
+// placeholder deleted.
+func (s *Server) followUp(ctx context.Context, in *interaction, rep reply) {
+	webhook := fmt.Sprintf("/webhooks/%s/%s", in.ApplicationID, in.Token)
+	msg := rep.message()
+
+	var err error
+	if msg.Flags&flagEphemeral == 0 {
+		err = s.api.do(ctx, http.MethodPatch, webhook+"/messages/@original", "", msg)
+	} else if err = s.api.do(ctx, http.MethodPost, webhook, "", msg); err == nil {
+		err = s.api.do(ctx, http.MethodDelete, webhook+"/messages/@original", "", nil)
+	}
+	if err != nil {
+		tracing.Log(ctx).WithField("msg", "follow-up failed").Error(err)
+	}
+}
+
+// Wait waits for deferred commands to send their results, or for ctx to expire
+func (s *Server) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.followUps.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// detached keeps the values of a context, such as its trace, without its cancellation
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// verify checks a request was signed by Discord and returns its body
+func verify(publicKey ed25519.PublicKey, req *http.Request, now time.Time) ([]byte, error) {
+	ts := req.Header.Get("X-Signature-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > maxRequestAge || age < -maxRequestAge {
+		return nil, ErrStaleRequest
+	}
+
+	sig, err := hex.DecodeString(req.Header.Get("X-Signature-Ed25519"))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, ErrBadSignature
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, req.Body, maxInteractionSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ed25519.Verify(publicKey, append([]byte(ts), body...), sig) {
+		return nil, ErrBadSignature
+	}
+
+	// Leave the body readable for anything further down the chain
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
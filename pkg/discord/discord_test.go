@@ -0,0 +1,258 @@
+package discord
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/iex/pkg/types"
+	"github.com/thorfour/stocktopus/pkg/engine"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stock/stocktest"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+// lookup quotes every symbol at 2
+func lookup() *stocktest.Lookup {
+	return &stocktest.Lookup{
+		Quote:     stock.Quote{LatestPrice: 2},
+		Headlines: []string{"a", "b"},
+		Profile:   &types.Company{CompanyName: "Advanced Micro Devices", Website: "https://amd.com"},
+	}
+}
+
+func newServer(t *testing.T, lookup stock.Lookup, opts ...Option) (*Server, ed25519.PrivateKey, storage.Store) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	store := storage.NewMemory()
+	s, err := New(engine.New(store, lookup), hex.EncodeToString(pub), opts...)
+	require.NoError(t, err)
+	return s, priv, store
+}
+
+func signedRequest(key ed25519.PrivateKey, body string, ts time.Time) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/discord/interactions", strings.NewReader(body))
+	sec := fmt.Sprint(ts.Unix())
+	req.Header.Set("X-Signature-Timestamp", sec)
+	req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(key, []byte(sec+body))))
+	return req
+}
+
+func commandBody(name string, opts ...option) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"type":           interactionCommand,
+		"application_id": "app",
+		"token":          "interaction-token",
+		"guild_id":       "guild",
+		"channel_id":     "channel",
+		"member":         map[string]interface{}{"user": map[string]string{"id": "user"}},
+		"data":           map[string]interface{}{"name": name, "options": opts},
+	})
+	return string(b)
+}
+
+func serve(s *Server, req *http.Request) (int, *response) {
+	rec := httptest.NewRecorder()
+	s.Handler(rec, req)
+	r := &response{}
+	json.Unmarshal(rec.Body.Bytes(), r)
+	return rec.Code, r
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(nil, "not hex")
+	require.EqualError(t, err, `invalid Discord public key "not hex"`)
+}
+
+func TestVerify(t *testing.T) {
+	s, key, _ := newServer(t, lookup())
+	_, other, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	ping := `{"type":1}`
+
+	tests := map[string]struct {
+		req  *http.Request
+		code int
+	}{
+		"signed":       {req: signedRequest(key, ping, time.Now()), code: http.StatusOK},
+		"wrong key":    {req: signedRequest(other, ping, time.Now()), code: http.StatusUnauthorized},
+		"stale":        {req: signedRequest(key, ping, time.Now().Add(-time.Hour)), code: http.StatusUnauthorized},
+		"unsigned":     {req: httptest.NewRequest(http.MethodPost, "/discord/interactions", strings.NewReader(ping)), code: http.StatusUnauthorized},
+		"unsupported":  {req: signedRequest(key, `{"type":9}`, time.Now()), code: http.StatusBadRequest},
+		"invalid json": {req: signedRequest(key, `{`, time.Now()), code: http.StatusBadRequest},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			code, _ := serve(s, test.req)
+			require.Equal(t, test.code, code)
+		})
+	}
+
+	tampered := signedRequest(key, ping, time.Now())
+	tampered.Body = ioutil.NopCloser(strings.NewReader(`{"type":2}`))
+	code, _ := serve(s, tampered)
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	_, r := serve(s, signedRequest(key, ping, time.Now()))
+	require.Equal(t, &response{Type: responsePong}, r)
+}
+
+func TestCommand(t *testing.T) {
+	s, key, store := newServer(t, lookup())
+
+	tests := []struct {
+		name string
+		body string
+		data *message
+	}{
+		{
+			name: "watch",
+			body: commandBody("watch", option{Name: "tickers", Value: "amd intc"}),
+			data: &message{Content: "Added", Flags: flagEphemeral},
+		},
+		{
+			name: "company",
+			body: commandBody("info", option{Name: "ticker", Value: "amd"}),
+			data: &message{Embeds: []embed{{
+				Title: "Advanced Micro Devices",
+				URL:   "https://amd.com",
+				Fields: []field{
+					{Name: "Industry", Value: "-", Inline: true},
+					{Name: "CEO", Value: "-", Inline: true},
+				},
+			}}},
+		},
+		{
+			name: "invalid",
+			body: commandBody("buy", option{Name: "ticker", Value: "amd"}),
+			data: &message{Content: "Missing shares\nUsage: `buy [ticker] [shares]`", Flags: flagEphemeral},
+		},
+		{
+			name: "unknown",
+			body: commandBody("sing"),
+			data: &message{Content: `Unknown command "sing"`, Flags: flagEphemeral},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, r := serve(s, signedRequest(key, test.body, time.Now()))
+			require.Equal(t, http.StatusOK, code)
+			require.Equal(t, &response{Type: responseMessage, Data: test.data}, r)
+		})
+	}
+
+	// Guild and user ids become the team and user
+	members, err := store.Members(context.Background(), "v2:discord-guild:user:user:list")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"AMD", "INTC"}, members)
+}
+
+func TestDirectMessages(t *testing.T) {
+	s, key, store := newServer(t, lookup())
+
+	dm := func(user, channel, tickers string) string {
+		b, _ := json.Marshal(map[string]interface{}{
+			"type":       interactionCommand,
+			"channel_id": channel,
+			"user":       map[string]string{"id": user},
+			"data": map[string]interface{}{"name": "watch", "options": []option{
+				{Name: "tickers", Value: tickers},
+				{Name: "list", Value: "tech"},
+			}},
+		})
+		return string(b)
+	}
+
+	for _, body := range []string{dm("user1", "dm1", "amd"), dm("user2", "dm2", "intc")} {
+		code, r := serve(s, signedRequest(key, body, time.Now()))
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "Added", r.Data.Content)
+	}
+
+	// Each conversation has its own team lists
+	for channel, tickers := range map[string][]string{"dm1": {"AMD"}, "dm2": {"INTC"}} {
+		members, err := store.Members(context.Background(), "v2:discord-@me-"+channel+":list:tech")
+		require.NoError(t, err)
+		require.Equal(t, tickers, members)
+	}
+	members, err := store.Members(context.Background(), "v2:discord-@me:list:tech")
+	require.NoError(t, err)
+	require.Empty(t, members)
+}
+
+// apiCall is a request to the fake Discord API
+type apiCall struct {
+	method, path string
+	msg          message
+}
+
+func fakeAPI(t *testing.T) (*httptest.Server, func() []apiCall) {
+	var (
+		mu    sync.Mutex
+		calls []apiCall
+	)
+	api := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		c := apiCall{method: req.Method, path: req.URL.Path}
+		if req.Method != http.MethodDelete {
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&c.msg))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, c)
+	}))
+
+	return api, func() []apiCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+}
+
+func TestDeferred(t *testing.T) {
+	api, calls := fakeAPI(t)
+	defer api.Close()
+
+	l := lookup()
+	l.Release = make(chan struct{})
+	s, key, _ := newServer(t, l, WithAPIURL(api.URL))
+	s.deferAfter = 10 * time.Millisecond
+
+	// A public result replaces the placeholder
+	_, r := serve(s, signedRequest(key, commandBody("quote", option{Name: "tickers", Value: "amd intc"}), time.Now()))
+	require.Equal(t, &response{Type: responseDeferred}, r)
+	l.Release <- struct{}{}
+	require.NoError(t, s.Wait(context.Background()))
+
+	require.Len(t, calls(), 1)
+	call := calls()[0]
+	require.Equal(t, "PATCH /webhooks/app/interaction-token/messages/@original", call.method+" "+call.path)
+	require.Contains(t, call.msg.Embeds[0].Description, "AMD")
+	require.Zero(t, call.msg.Flags)
+
+	// A private result is sent to the user alone
+	_, r = serve(s, signedRequest(key, commandBody("buy", option{Name: "ticker", Value: "amd"}, option{Name: "shares", Value: float64(1)}), time.Now()))
+	require.Equal(t, &response{Type: responseDeferred}, r)
+	l.Release <- struct{}{}
+	require.NoError(t, s.Wait(context.Background()))
+
+	require.Len(t, calls(), 3)
+	require.Equal(t, apiCall{
+		method: http.MethodPost,
+		path:   "/webhooks/app/interaction-token",
+		msg:    message{Content: "Insufficient funds", Flags: flagEphemeral},
+	}, calls()[1])
+	require.Equal(t, apiCall{method: http.MethodDelete, path: "/webhooks/app/interaction-token/messages/@original"}, calls()[2])
+}
@@ -0,0 +1,146 @@
+package discord
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thorfour/stocktopus/pkg/engine"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+)
+
+// flagEphemeral shows a message only to the user who ran the command
+const flagEphemeral = 1 << 6
+
+// Embed limits
+const (
+	maxEmbedDescription = 4096
+	maxFieldValue       = 1024
+)
+
+// Embed colors
+const (
+	colorUp   = 0x2ecc71
+	colorDown = 0xe74c3c
+)
+
+// message is the content of an interaction response or follow-up
+type message struct {
+	Content string  `json:"content,omitempty"`
+	Embeds  []embed `json:"embeds,omitempty"`
+	Flags   int     `json:"flags,omitempty"`
+}
+
+type embed struct {
+	Title       string  `json:"title,omitempty"`
+	URL         string  `json:"url,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Color       int     `json:"color,omitempty"`
+	Fields      []field `json:"fields,omitempty"`
+}
+
+type field struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// errorMessage shows err to the user who ran the command
+func errorMessage(err error) *message {
+	return &message{Content: stocktopus.UserMessage(err), Flags: flagEphemeral}
+}
+
+// render formats a command result for Discord
+func render(result engine.Result) *message {
+	msg := &message{}
+	if !result.Public() {
+		msg.Flags = flagEphemeral
+	}
+
+	switch r := result.(type) {
+	case *engine.Message:
+		msg.Content = r.Text
+	case *engine.Quotes:
+		e := embed{Description: code(r.List.String())}
+		if len(r.Missing) > 0 {
+			e.Fields = append(e.Fields, field{Name: "Not found", Value: strings.Join(r.Missing, ", ")})
+		}
+		if len(r.Unavailable) > 0 {
+			e.Fields = append(e.Fields, field{Name: "Temporarily unavailable", Value: strings.Join(r.Unavailable, ", ") + stocktopus.RetryText(r.RetryAfter)})
+		}
+		if r.Chart != "" {
+			q := r.List[0]
+			e.Title, e.URL = q.Ticker, r.Chart
+			e.Description = code(stocktopus.Details(q))
+			e.Color = colorUp
+			if q.Change < 0 {
+				e.Color = colorDown
+			}
+		}
+		msg.Embeds = []embed{e}
+	case *engine.Balance:
+		msg.Content = fmt.Sprintf("New Balance: %v", r.Balance)
+	case *engine.Portfolio:
+		msg.Embeds = []embed{{Title: "Portfolio", Description: code(r.Account.String())}}
+	case *engine.Company:
+		msg.Embeds = []embed{{
+			Title:       r.CompanyName,
+			URL:         r.Website,
+			Description: truncate(r.Description, maxEmbedDescription),
+			Fields: []field{
+				{Name: "Industry", Value: orNone(r.Industry), Inline: true},
+				{Name: "CEO", Value: orNone(r.CEO), Inline: true},
+			},
+		}}
+	case *engine.News:
+		msg.Embeds = []embed{{Title: "News", Description: truncate(strings.Join(r.Headlines, "\n\n"), maxEmbedDescription)}}
+	case *engine.Stats:
+		msg.Embeds = []embed{{Title: r.CompanyName, Description: code(stocktopus.Stats(r.Stats))}}
+	case *engine.History:
+		msg.Embeds = []embed{{Description: code(r.Performance.String())}}
+	case *engine.Search:
+		msg.Embeds = []embed{{Title: "Search", Description: code(r.Results.String())}}
+	case *engine.Export:
+		msg.Content = fmt.Sprintf("[Download your data](%s) (link expires in %v)", r.URL, r.Expires)
+	case *engine.ImportPreview:
+		msg.Content = fmt.Sprintf("%s\nRun `/import csv:confirm` to apply or `/import csv:cancel` to discard", code(r.Import.String()))
+	case *engine.Imported:
+		msg.Content = fmt.Sprintf("Imported %v tickers and %v positions", len(r.Import.Tickers), len(r.Import.Positions))
+	case *engine.Help:
+		msg.Embeds = []embed{help(r)}
+	default:
+		msg.Content = stocktopus.InternalErrorMessage
+	}
+
+	return msg
+}
+
+// help lists every command, or shows the options of a single command
+func help(h *engine.Help) embed {
+	if h.Command != nil {
+		cmd := definition(h.Command)
+		e := embed{Title: "/" + cmd.Name, Description: h.Command.Summary}
+		for _, opt := range cmd.Options {
+			e.Fields = append(e.Fields, field{Name: opt.Name, Value: truncate(opt.Description, maxFieldValue)})
+		}
+		return e
+	}
+
+	e := embed{Title: "Commands"}
+	for _, spec := range h.Specs {
+		e.Fields = append(e.Fields, field{Name: "/" + spec.Name, Value: truncate(spec.Summary, maxFieldValue)})
+	}
+	return e
+}
+
+// code shows a table in a code block, cutting it short to fit in an embed
+func code(s string) string {
+	return "```" + truncate(s, maxEmbedDescription-6) + "```"
+}
+
+// orNone fills in empty field values, which Discord rejects
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
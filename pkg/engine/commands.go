@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thorfour/stocktopus/pkg/command"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+)
+
+// Supported commands
+const (
+	quoteCmd       = "quote"
+	addToList      = "watch"
+	printList      = "list"
+	removeFromList = "unwatch"
+	clear          = "clear"
+	help           = "help"
+	infoCmd        = "info"
+	news           = "news"
+	stats          = "stats"
+	historyCmd     = "history"
+	search         = "search"
+	exportCmd      = "export"
+	importCmd      = "import"
+	forget         = "forget"
+
+	// Play money commands
+	buy       = "buy"
+	sell      = "sell"
+	deposit   = "deposit"
+	portfolio = "portfolio"
+	reset     = "reset"
+)
+
+// userData are the commands that read or write stored lists and accounts, running one is
+// activity that keeps the user's data from being removed
+var userData = map[string]bool{
+	addToList:      true,
+	printList:      true,
+	removeFromList: true,
+	clear:          true,
+	exportCmd:      true,
+	importCmd:      true,
+	buy:            true,
+	sell:           true,
+	deposit:        true,
+	portfolio:      true,
+	reset:          true,
+}
+
+// sortFlag orders quotes and watch lists
+var sortFlag = command.Flag{
+	Name:    "sort",
+	Usage:   "orders the quotes by percent change, ticker or price",
+	Choices: []string{stocktopus.SortChange, stocktopus.SortTicker, stocktopus.SortPrice},
+	Default: stocktopus.SortChange,
+}
+
+// registry declares the arguments of every command. Input that doesn't start with a command is a quote.
+var registry = command.NewRegistry(quoteCmd,
+	&command.Spec{
+		Name:    quoteCmd,
+		Summary: "pull stock quotes for a list of tickers, `quote` can be left out. A ticker followed by a range prints its price history",
+		Args:    []command.Arg{{Name: "tickers", Kind: command.Ticker, Many: true}},
+		Flags:   []command.Flag{sortFlag},
+	},
+	&command.Spec{
+		Name:    printList,
+		Summary: "print out your watch list, or a team #list",
+		List:    true,
+		Flags:   []command.Flag{sortFlag},
+	},
+	&command.Spec{
+		Name:    addToList,
+		Summary: "add tickers to a watch list",
+		List:    true,
+		Args:    []command.Arg{{Name: "tickers", Kind: command.Ticker, Many: true}},
+	},
+	&command.Spec{
+		Name:    removeFromList,
+		Summary: "remove tickers from a watch list",
+		List:    true,
+		Args:    []command.Arg{{Name: "tickers", Kind: command.Ticker, Many: true}},
+	},
+	&command.Spec{
+		Name:    clear,
+		Summary: "remove an entire watch list",
+		List:    true,
+	},
+	&command.Spec{
+		Name:    deposit,
+		Summary: "deposit play money into your account",
+		Args:    []command.Arg{{Name: "amount", Kind: command.Money}},
+	},
+	&command.Spec{
+		Name:    buy,
+		Summary: "purchase shares in a security with play money",
+		Args:    []command.Arg{{Name: "ticker", Kind: command.Ticker}, {Name: "shares", Kind: command.Int}},
+	},
+	&command.Spec{
+		Name:    sell,
+		Summary: "sell shares of a security",
+		Args:    []command.Arg{{Name: "ticker", Kind: command.Ticker}, {Name: "shares", Kind: command.Int}},
+	},
+	&command.Spec{
+		Name:    portfolio,
+		Summary: "print your play money portfolio",
+	},
+	&command.Spec{
+		Name:    reset,
+		Summary: "reset your play money account",
+	},
+	&command.Spec{
+		Name:    stats,
+		Summary: "print statistics about a company",
+		Args:    []command.Arg{{Name: "ticker", Kind: command.Ticker}},
+	},
+	&command.Spec{
+		Name:    infoCmd,
+		Summary: "print a company profile",
+		Args:    []command.Arg{{Name: "ticker", Kind: command.Ticker}},
+	},
+	&command.Spec{
+		Name:    news,
+		Summary: "print the latest news for a company",
+		Args:    []command.Arg{{Name: "ticker", Kind: command.Ticker}},
+	},
+	&command.Spec{
+		Name: historyCmd,
+		Summary: fmt.Sprintf("print price history over a range (%s) with an optional bar interval (%s)",
+			joinRanges(stock.Ranges), joinIntervals(stock.Intervals)),
+		Args: []command.Arg{
+			{Name: "ticker", Kind: command.Ticker},
+			{Name: "range"},
+			{Name: "interval", Optional: true},
+		},
+	},
+	&command.Spec{
+		Name:    search,
+		Summary: "find tickers by company name",
+		Args:    []command.Arg{{Name: "text", Kind: command.Text}},
+	},
+	&command.Spec{
+		Name:    exportCmd,
+		Summary: "download your watch lists, portfolio and history",
+		Args:    []command.Arg{{Name: "format", Optional: true, Choices: []string{"csv", "json"}}},
+	},
+	&command.Spec{
+		Name:    importCmd,
+		Summary: "preview importing tickers, or ticker,shares,price positions. `import confirm` applies the preview and `import cancel` discards it",
+		List:    true,
+		Args:    []command.Arg{{Name: "csv", Kind: command.Text}},
+	},
+	&command.Spec{
+		Name:    forget,
+		Summary: "delete all of your watch lists, portfolio and history",
+		Args: []command.Arg{
+			{Name: "me", Choices: []string{"me"}},
+			{Name: "confirm", Optional: true, Choices: []string{"confirm"}},
+		},
+	},
+	&command.Spec{
+		Name:    help,
+		Summary: "print this list, or how to use a command",
+		Args:    []command.Arg{{Name: "command", Optional: true}},
+	},
+)
+
+// Commands returns every command, for frontends that register them with their platform
+func Commands() []*command.Spec {
+	return registry.Specs()
+}
+
+// helpFor lists every command, or describes the named one
+func helpFor(name string) (Result, error) {
+	if name == "" {
+		return &Help{Specs: registry.Specs()}, nil
+	}
+
+	spec, ok := registry.Lookup(name)
+	if !ok {
+		return nil, stocktopus.InvalidInput(fmt.Sprintf("Unknown command %q", name), nil)
+	}
+
+	return &Help{Specs: []*command.Spec{spec}, Command: spec}, nil
+}
+
+func joinRanges(ranges []stock.Range) string {
+	s := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		s = append(s, string(r))
+	}
+	return strings.Join(s, ", ")
+}
+
+func joinIntervals(intervals []stock.Interval) string {
+	s := make([]string, 0, len(intervals))
+	for _, i := range intervals {
+		s = append(s, string(i))
+	}
+	return strings.Join(s, ", ")
+}
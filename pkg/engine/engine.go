@@ -0,0 +1,472 @@
+// Package engine runs stocktopus commands for any chat frontend. Frontends turn what their
+// platform sends into a Request and render the typed Result.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thorfour/stocktopus/pkg/admin"
+	"github.com/thorfour/stocktopus/pkg/command"
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/storage"
+	"github.com/thorfour/stocktopus/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Platforms commands are sent from
+const (
+	Slack      = "slack"
+	Discord    = "discord"
+	Mattermost = "mattermost"
+	Telegram   = "telegram"
+	// API requests come from the REST API, on behalf of a team rather than from its users
+	API = "api"
+)
+
+// Unavailable is the reply to commands that need storage while it's down
+const Unavailable = "Watch lists and portfolios are temporarily unavailable, quotes, news, stats and info still work. Please try again in a few minutes."
+
+// Request is a command sent from a chat platform
+type Request struct {
+	// Platform the command was sent from
+	Platform string
+	// Team is the workspace, server or group the user belongs to
+	Team string
+	// User that sent the command
+	User string
+	// Channel the command was sent in
+	Channel string
+	// Group scopes team lists to a chat on platforms without teams. Team lists are shared by
+	// the whole team when it's empty.
+	Group string
+	// Text of the command
+	Text string
+	// Token is the Slack verification token that keys were derived from before the v2 key
+	// scheme. It's only used to find legacy data.
+	Token string
+	// Passive requests, such as previews shown while the user types, aren't user activity
+	Passive bool
+}
+
+// team returns the team id data is stored under. Slack ids are used as they are so existing data
+// is found, other platforms are prefixed so their ids can't collide. API keys are configured
+// with the stored team id, so it's used as it is too.
+func (r *Request) team() string {
+	if r.Platform == Slack || r.Platform == API || r.Platform == "" {
+		return r.Team
+	}
+	return r.Platform + "-" + r.Team
+}
+
+// listTeam returns the team id team lists are stored under
+func (r *Request) listTeam() string {
+	if r.Group == "" {
+		return r.team()
+	}
+	return r.team() + "-" + r.Group
+}
+
+// Engine runs stocktopus commands
+type Engine struct {
+	s *stocktopus.Stocktopus
+
+	exportURL    string
+	exportSecret []byte
+	symbolTTL    time.Duration
+}
+
+// Option configures optional Engine features
+type Option func(*Engine)
+
+// WithNameResolution lets users type a company name in place of a ticker when a single company matches
+func WithNameResolution() Option {
+	return func(e *Engine) {
+		e.s.ResolveNames = true
+	}
+}
+
+// WithSymbolTTL sets how often the symbol directory used by search is refreshed
+func WithSymbolTTL(ttl time.Duration) Option {
+	return func(e *Engine) {
+		e.symbolTTL = ttl
+	}
+}
+
+// New returns a new engine
+func New(kvstore storage.Store, stocks stock.Lookup, opts ...Option) *Engine {
+	e := &Engine{
+		s: &stocktopus.Stocktopus{
+			KVStore:        kvstore,
+			StockInterface: stocks,
+		},
+		symbolTTL: stock.DefaultDirectoryTTL,
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	// Enable symbol search for providers that can list their symbols
+	if l, ok := stocks.(stock.SymbolLister); ok {
+		e.s.Symbols = stock.NewDirectory(l, e.symbolTTL)
+	}
+
+	return e
+}
+
+// Stocktopus returns what commands are run with, for frontends like the API whose requests
+// are already typed
+func (e *Engine) Stocktopus() *stocktopus.Stocktopus {
+	return e.s
+}
+
+// Run parses and runs the command in r.Text. Commands that need storage while it's down reply
+// with the Unavailable message rather than failing. Failures are logged, frontends only need to
+// show stocktopus.UserMessage for them.
+func (e *Engine) Run(ctx context.Context, r *Request) (Result, error) {
+	result, err := e.dispatch(ctx, r)
+	switch {
+	case errors.Is(err, storage.ErrUnavailable):
+		return &Message{Text: Unavailable}, nil
+	case err != nil:
+		logError(ctx, err)
+	}
+
+	return result, err
+}
+
+// logError logs the internal details of a failed command, errors caused by the user's input
+// aren't worth logging
+func logError(ctx context.Context, err error) {
+	var ue *stocktopus.UserError
+	switch {
+	case !errors.As(err, &ue):
+		tracing.Log(ctx).WithField("msg", "command failed").Error(err)
+	case ue.Cause != nil && !ue.Invalid:
+		tracing.Log(ctx).WithField("msg", ue.Message).Warn(err)
+	}
+}
+
+// dispatch parses and runs a command, recording its latency and result
+func (e *Engine) dispatch(ctx context.Context, r *Request) (result Result, err error) {
+	cmd, err := registry.Parse(r.Text)
+	start, label := time.Now(), commandLabel(cmd)
+	ctx, span := tracing.Start(ctx, "engine.command",
+		attribute.String("command", label),
+		attribute.String("platform", r.Platform),
+	)
+	defer func() {
+		if r := recover(); r != nil {
+			observe(label, start, errPanic)
+			tracing.End(span, errPanic)
+			panic(r)
+		}
+		if cmd != nil {
+			err = stocktopus.WithUsage(err, cmd.Usage())
+		}
+		observe(label, start, err)
+		tracing.End(span, err)
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	if userData[cmd.Name] {
+		e.touch(ctx, r)
+	}
+
+	return e.command(ctx, cmd, r)
+}
+
+// command runs a parsed stocktopus command
+func (e *Engine) command(ctx context.Context, cmd *command.Command, r *Request) (Result, error) {
+	switch cmd.Name {
+	case buy:
+		key, err := e.acctKey(ctx, r)
+		if err != nil {
+			return nil, fmt.Errorf("Buy failed: %w", err)
+		}
+
+		if _, err := e.s.Buy(ctx, cmd.Arg("ticker"), cmd.Int("shares"), key); err != nil {
+			return nil, fmt.Errorf("Buy failed: %w", err)
+		}
+
+		return &Message{Text: "Done"}, nil
+
+	case sell:
+		key, err := e.acctKey(ctx, r)
+		if err != nil {
+			return nil, fmt.Errorf("Sell failed: %w", err)
+		}
+
+		if _, err := e.s.Sell(ctx, cmd.Arg("ticker"), cmd.Int("shares"), key); err != nil {
+			return nil, fmt.Errorf("Sell failed: %w", err)
+		}
+
+		return &Message{Text: "Done"}, nil
+
+	case deposit:
+		key, err := e.acctKey(ctx, r)
+		if err != nil {
+			return nil, fmt.Errorf("Deposit failed: %w", err)
+		}
+
+		a, err := e.s.Deposit(ctx, cmd.Money("amount"), key)
+		if err != nil {
+			return nil, fmt.Errorf("Deposit failed: %w", err)
+		}
+
+		return &Balance{Balance: a.Balance}, nil
+
+	case portfolio:
+		key, err := e.acctKey(ctx, r)
+		if err != nil {
+			return nil, fmt.Errorf("Portfolio failed: %w", err)
+		}
+
+		a, err := e.s.Portfolio(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("Portfolio failed: %w", err)
+		}
+
+		a, err = e.s.Latest(ctx, a)
+		if err != nil {
+			return nil, fmt.Errorf("Latest failed: %w", err)
+		}
+
+		return &Portfolio{Account: a}, nil
+
+	case reset:
+		key, err := e.acctKey(ctx, r)
+		if err != nil {
+			return nil, fmt.Errorf("Clear failed: %w", err)
+		}
+
+		if err := e.s.Clear(ctx, key); err != nil {
+			return nil, fmt.Errorf("Clear failed: %w", err)
+		}
+
+		return &Balance{}, nil
+
+	case addToList:
+		key, err := e.listKey(ctx, cmd.List, r)
+		if err != nil {
+			return nil, fmt.Errorf("Add failed: %w", err)
+		}
+
+		if err := e.s.Add(ctx, cmd.Args("tickers"), key); err != nil {
+			return nil, fmt.Errorf("Add failed: %w", err)
+		}
+
+		return &Message{Text: "Added"}, nil
+
+	case printList:
+		key, err := e.listKey(ctx, cmd.List, r)
+		if err != nil {
+			return nil, fmt.Errorf("Print failed: %w", err)
+		}
+
+		a, err := e.s.Print(ctx, key)
+		if err != nil && !stocktopus.IsPartial(err) {
+			return nil, fmt.Errorf("Print failed: %w", err)
+		}
+		a.SortBy(cmd.Flag("sort"))
+
+		q := &Quotes{List: a}
+		q.Missing, q.Unavailable, q.RetryAfter = missing(err)
+		return q, nil
+
+	case removeFromList:
+		key, err := e.listKey(ctx, cmd.List, r)
+		if err != nil {
+			return nil, fmt.Errorf("Remove failed: %w", err)
+		}
+
+		if err := e.s.Remove(ctx, cmd.Args("tickers"), key); err != nil {
+			return nil, fmt.Errorf("Remove failed: %w", err)
+		}
+
+		return &Message{Text: "Removed"}, nil
+
+	case clear:
+		key, err := e.listKey(ctx, cmd.List, r)
+		if err != nil {
+			return nil, fmt.Errorf("Clear failed: %w", err)
+		}
+
+		if err := e.s.Clear(ctx, key); err != nil {
+			return nil, fmt.Errorf("Clear failed: %w", err)
+		}
+
+		return &Message{Text: "Removed"}, nil
+
+	case infoCmd:
+		c, err := e.s.Info(ctx, cmd.Arg("ticker"))
+		if err != nil {
+			return nil, fmt.Errorf("Info failed: %w", err)
+		}
+
+		return &Company{Company: c}, nil
+
+	case news:
+		news, err := e.s.News(ctx, cmd.Arg("ticker"))
+		if err != nil {
+			return nil, fmt.Errorf("News failed: %w", err)
+		}
+
+		return &News{Headlines: news}, nil
+
+	case stats:
+		stats, err := e.s.Stats(ctx, cmd.Arg("ticker"))
+		if err != nil {
+			return nil, fmt.Errorf("Stats failed: %w", err)
+		}
+
+		return &Stats{Stats: stats}, nil
+
+	case historyCmd:
+		return e.history(ctx, cmd.Arg("ticker"), cmd.Arg("range"), cmd.Arg("interval"))
+
+	case search:
+		results, err := e.s.Search(ctx, cmd.Arg("text"))
+		if err != nil {
+			return nil, fmt.Errorf("Search failed: %w", err)
+		}
+
+		return &Search{Results: results}, nil
+
+	case exportCmd:
+		return e.export(ctx, cmd.Arg("format"), r)
+
+	case importCmd:
+		return e.importData(ctx, cmd.List, cmd.Arg("csv"), r)
+
+	case forget:
+		if cmd.Arg("confirm") == "" {
+			return &Message{Text: "This deletes all of your watch lists, portfolio and history and can't be undone. Run `forget me confirm` to continue"}, nil
+		}
+
+		if _, err := admin.New(e.s.KVStore).Purge(ctx, r.team(), r.User); err != nil {
+			return nil, fmt.Errorf("Forget failed: %w", err)
+		}
+
+		return &Message{Text: "All of your data has been deleted"}, nil
+
+	case help:
+		return helpFor(cmd.Arg("command"))
+
+	default: // quote
+		tickers := cmd.Args("tickers")
+		if isHistory(cmd) {
+			return e.history(ctx, tickers[0], tickers[1], "")
+		}
+
+		wl, err := e.s.GetQuotes(ctx, tickers)
+		if err != nil && !stocktopus.IsPartial(err) {
+			return nil, fmt.Errorf("GetQuotes failed: %w", err)
+		}
+		wl.SortBy(cmd.Flag("sort"))
+
+		q := &Quotes{List: wl}
+		q.Missing, q.Unavailable, q.RetryAfter = missing(err)
+		if len(wl) == 1 {
+			q.Chart = e.s.GetChartLink(wl[0].Ticker)
+		}
+		return q, nil
+	}
+}
+
+// isHistory returns true for a quote of a single ticker followed by a range, which is a history request
+func isHistory(cmd *command.Command) bool {
+	tickers := cmd.Args("tickers")
+	if cmd.Name != quoteCmd || len(tickers) != 2 {
+		return false
+	}
+	_, err := stock.ParseRange(tickers[1])
+	return err == nil
+}
+
+// missing returns the symbols a partial lookup couldn't find, those it couldn't quote right now
+// and how long until they can be quoted again
+func missing(err error) ([]string, []string, time.Duration) {
+	var perr *stock.PartialError
+	if !errors.As(err, &perr) {
+		return nil, nil, 0
+	}
+	return perr.Unknown(), perr.Unavailable(), perr.RetryAfter()
+}
+
+// history returns the performance of a ticker over a range and optional interval
+func (e *Engine) history(ctx context.Context, ticker, rangeText, intervalText string) (Result, error) {
+	r, err := stock.ParseRange(rangeText)
+	if err != nil {
+		return nil, stocktopus.InvalidInput(fmt.Sprintf("Unknown range %q", rangeText), err)
+	}
+
+	var i stock.Interval
+	if intervalText != "" {
+		if i, err = stock.ParseInterval(intervalText); err != nil {
+			return nil, stocktopus.InvalidInput(fmt.Sprintf("Unknown interval %q", intervalText), err)
+		}
+	}
+
+	p, err := e.s.History(ctx, ticker, r, i)
+	if err != nil {
+		return nil, fmt.Errorf("History failed: %w", err)
+	}
+
+	return &History{Performance: p}, nil
+}
+
+// touch records that the user is active so their data isn't removed by retention. It's only
+// called for commands that use the user's data. API requests are made by dashboards and
+// scripts, not the user.
+func (e *Engine) touch(ctx context.Context, r *Request) {
+	if r.Team == "" || r.User == "" || r.Platform == API || r.Passive {
+		return
+	}
+
+	if err := admin.Touch(ctx, e.s.KVStore, r.team(), r.User, time.Now()); err != nil && !errors.Is(err, storage.ErrUnavailable) {
+		tracing.Log(ctx).WithField("msg", "record activity failed").Error(err)
+	}
+}
+
+// listKey returns the key for the named team list, or the user's personal list if name is empty.
+// A list still stored under its legacy key is used from there.
+func (e *Engine) listKey(ctx context.Context, name string, r *Request) (string, error) {
+	current, legacy := keys.List(r.team(), r.User), keys.LegacyList(r.Token, r.User)
+	if name != "" {
+		current, legacy = keys.TeamList(r.listTeam(), name), keys.LegacyTeamList(r.Token, r.team(), name)
+	}
+
+	key, err := e.resolve(ctx, r, legacy, current)
+	if err != nil {
+		return "", fmt.Errorf("Unable to find list: %w", err)
+	}
+
+	return key, nil
+}
+
+// acctKey returns the key for the user's account, which may still be its legacy key
+func (e *Engine) acctKey(ctx context.Context, r *Request) (string, error) {
+	key, err := e.resolve(ctx, r, keys.LegacyAccount(r.Token, r.User), keys.Account(r.team(), r.User))
+	if err != nil {
+		return "", fmt.Errorf("Unable to find account: %w", err)
+	}
+
+	return key, nil
+}
+
+// resolve returns the legacy key while it still holds the data. Only requests with a token can
+// have legacy data.
+func (e *Engine) resolve(ctx context.Context, r *Request, legacy, current string) (string, error) {
+	if r.Token == "" {
+		return current, nil
+	}
+	return keys.Resolve(ctx, e.s.KVStore, legacy, current)
+}
@@ -0,0 +1,436 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/iex/pkg/types"
+	"github.com/thorfour/stocktopus/pkg/command"
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stock/stocktest"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/storage"
+	"github.com/thorfour/stocktopus/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestCommands(t *testing.T) {
+
+	s := New(
+		storage.NewMemory(),
+		&stocktest.Lister{
+			Lookup: stocktest.Lookup{
+				Quotes: []*stock.Quote{
+					{
+						Ticker:        "AMD",
+						LatestPrice:   1.00,
+						Change:        0,
+						ChangePercent: 0,
+					},
+				},
+				Profile:    &types.Company{},
+				Statistics: &types.Stats{},
+				Headlines:  []string{},
+				Bars: []*stock.Bar{
+					{Open: 1, High: 2, Low: 1, Close: 2, Volume: 100},
+				},
+			},
+			List: []*stock.Symbol{{Ticker: "AMD", Name: "Advanced Micro Devices Inc."}},
+		},
+		WithNameResolution(),
+	)
+
+	tests := []struct {
+		name string
+		text string
+		err  error
+	}{
+		{
+			name: "single quote",
+			text: "amd",
+		},
+		{
+			name: "retrieve empty group list",
+			text: "list #mylist",
+			err:  stocktopus.ErrNoList,
+		},
+		{
+			name: "retrieve empty list",
+			text: "list",
+			err:  stocktopus.ErrNoList,
+		},
+		{
+			name: "add to group list",
+			text: "watch #mylist amd",
+		},
+		{
+			name: "add to list",
+			text: "watch amd",
+		},
+		{
+			name: "retrieve group list",
+			text: "list #mylist",
+		},
+		{
+			name: "retrieve list",
+			text: "list",
+		},
+		{
+			name: "unwatch list",
+			text: "unwatch amd",
+		},
+		{
+			name: "unwatch group list",
+			text: "unwatch #mylist amd",
+		},
+		{
+			name: "clear list",
+			text: "clear",
+		},
+		{
+			name: "clear group list",
+			text: "clear #mylist",
+		},
+		{
+			name: "deposit",
+			text: "deposit 100",
+		},
+		{
+			name: "reset",
+			text: "reset",
+		},
+		{
+			name: "portfolio",
+			text: "portfolio",
+		},
+		{
+			name: "buy insufficient",
+			text: "buy amd 1",
+			err:  stocktopus.ErrInsufficientFunds,
+		},
+		{
+			name: "deposit 1k",
+			text: "deposit 1000",
+		},
+		{
+			name: "buy amd",
+			text: "buy amd 1",
+		},
+		{
+			name: "portfolio with holdings",
+			text: "portfolio",
+		},
+		{
+			name: "sell amd too many",
+			text: "sell amd 10",
+			err:  stocktopus.ErrNumShares,
+		},
+		{
+			name: "sell amd",
+			text: "sell amd 1",
+		},
+		{
+			name: "info",
+			text: "info amd",
+		},
+		{
+			name: "stats",
+			text: "stats amd",
+		},
+		{
+			name: "news",
+			text: "news amd",
+		},
+		{
+			name: "ticker history",
+			text: "amd 1y",
+		},
+		{
+			name: "history",
+			text: "history amd 6m 1wk",
+		},
+		{
+			name: "search",
+			text: "search advanced micro",
+		},
+		{
+			name: "company name",
+			text: "advanced",
+		},
+		{
+			name: "quoted company name",
+			text: `watch "advanced micro"`,
+		},
+		{
+			name: "sorted quotes",
+			text: "amd --sort ticker",
+		},
+		{
+			name: "help",
+			text: "help",
+		},
+		{
+			name: "command help",
+			text: "help buy",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := s.Run(context.Background(), &Request{Platform: Slack, Team: "team", User: "test", Token: "token", Text: test.text})
+			require.True(t, errors.Is(err, test.err))
+		})
+	}
+}
+
+func TestListNames(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+	e := &Engine{s: &stocktopus.Stocktopus{KVStore: store}}
+
+	_, err := e.Run(ctx, &Request{Platform: Slack, Team: "team", User: "test", Text: "watch  #FunList   amd  intc"})
+	require.NoError(t, err)
+
+	members, err := store.Members(ctx, keys.TeamList("team", "funlist"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"AMD", "INTC"}, members)
+}
+
+func TestHelp(t *testing.T) {
+	res, err := helpFor("")
+	require.NoError(t, err)
+	require.Equal(t, &Help{Specs: registry.Specs()}, res)
+
+	res, err = helpFor("LIST")
+	require.NoError(t, err)
+	spec, _ := registry.Lookup("list")
+	require.Equal(t, &Help{Specs: []*command.Spec{spec}, Command: spec}, res)
+
+	_, err = helpFor("nope")
+	require.Equal(t, "Unknown command \"nope\"", stocktopus.UserMessage(err))
+}
+
+func TestQuoteErrors(t *testing.T) {
+	ctx := context.Background()
+	lookup := &stocktest.Lookup{Unknown: []string{"XYZQ"}}
+	e := New(storage.NewMemory(), lookup)
+
+	_, err := e.Run(ctx, &Request{Platform: Slack, Team: "team", User: "test", Text: "watch xyzq"})
+	require.NoError(t, err)
+
+	// Every symbol failing is explained rather than an internal error
+	for _, text := range []string{"xyzq", "list"} {
+		_, err = e.Run(ctx, &Request{Platform: Slack, Team: "team", User: "test", Text: text})
+		require.Equal(t, "Unknown symbol", stocktopus.UserMessage(err), text)
+	}
+
+	lookup.Unknown = nil
+	lookup.Err = &stock.RateLimitError{Provider: "alphavantage", RetryAfter: 30 * time.Second}
+	for _, text := range []string{"amd", "list"} {
+		_, err = e.Run(ctx, &Request{Platform: Slack, Team: "team", User: "test", Text: text})
+		require.Equal(t, "alphavantage rate limit reached, try again in 30 seconds", stocktopus.UserMessage(err), text)
+	}
+}
+
+func TestPlatformTeams(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+	e := &Engine{s: &stocktopus.Stocktopus{KVStore: store}}
+
+	for _, platform := range []string{Slack, Discord} {
+		_, err := e.Run(ctx, &Request{Platform: platform, Team: "1", User: "2", Text: "watch amd"})
+		require.NoError(t, err)
+	}
+
+	// The same ids on different platforms are different teams and users
+	lists, err := store.Keys(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2:1:user:2:list", "v2:1:user:2:seen", "v2:discord-1:user:2:list", "v2:discord-1:user:2:seen"}, lists)
+
+	// Team lists are kept per group, personal data isn't
+	for _, group := range []string{"a", "b"} {
+		_, err := e.Run(ctx, &Request{Platform: Telegram, Team: "users", User: "2", Group: group, Text: "watch #fun amd"})
+		require.NoError(t, err)
+	}
+	lists, err = store.Keys(ctx, "v2:telegram-")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2:telegram-users-a:list:fun", "v2:telegram-users-b:list:fun", "v2:telegram-users:user:2:seen"}, lists)
+
+	// The API works on stored team ids and isn't activity
+	_, err = e.Run(ctx, &Request{Platform: API, Team: "discord-1", User: "2", Text: "deposit 10"})
+	require.NoError(t, err)
+	lists, err = store.Keys(ctx, "v2:discord-1:")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2:discord-1:user:2:account", "v2:discord-1:user:2:list", "v2:discord-1:user:2:seen"}, lists)
+	seen, err := store.Get(ctx, "v2:discord-1:user:2:seen")
+	require.NoError(t, err)
+	_, err = e.Run(ctx, &Request{Platform: API, Team: "discord-1", User: "2", Text: "portfolio"})
+	require.NoError(t, err)
+	again, err := store.Get(ctx, "v2:discord-1:user:2:seen")
+	require.NoError(t, err)
+	require.Equal(t, seen, again)
+}
+
+func TestActivity(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+	e := &Engine{s: &stocktopus.Stocktopus{KVStore: store, StockInterface: &stocktest.Lookup{}}}
+
+	// Only commands that use stored data are activity
+	for _, text := range []string{"help", "quote amd", "amd", "buy amd ten", "search"} {
+		e.Run(ctx, &Request{Platform: Slack, Team: "T1", User: "U1", Text: text})
+	}
+	found, err := store.Keys(ctx, "")
+	require.NoError(t, err)
+	require.Empty(t, found)
+
+	_, err = e.Run(ctx, &Request{Platform: Slack, Team: "T1", User: "U1", Text: "list"})
+	require.True(t, errors.Is(err, stocktopus.ErrNoList))
+	found, err = store.Keys(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2:T1:user:U1:seen"}, found)
+}
+
+func TestLegacyKeys(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+	e := &Engine{s: &stocktopus.Stocktopus{KVStore: store}}
+
+	// Data written by releases that keyed on the verification token
+	require.NoError(t, store.AddMembers(ctx, "[token][test]", "AMD"))
+	require.NoError(t, store.AddMembers(ctx, "[token][funlist team]", "TSLA"))
+	require.NoError(t, store.Put(ctx, "ACCT[token][test]", []byte(`{"Balance":100}`)))
+
+	r := &Request{Platform: Slack, Team: "team", User: "test", Token: "token"}
+
+	// Legacy data is used where it is, so earlier releases still find it
+	key, err := e.listKey(ctx, "", r)
+	require.NoError(t, err)
+	require.Equal(t, "[token][test]", key)
+
+	key, err = e.listKey(ctx, "FUNLIST", r)
+	require.NoError(t, err)
+	require.Equal(t, "[token][funlist team]", key)
+
+	key, err = e.acctKey(ctx, r)
+	require.NoError(t, err)
+	require.Equal(t, "ACCT[token][test]", key)
+	a, err := e.s.Portfolio(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, float64(100), a.Balance)
+
+	// Users without legacy data get the current keys
+	key, err = e.acctKey(ctx, &Request{Platform: Slack, Team: "team", User: "new", Token: "token"})
+	require.NoError(t, err)
+	require.Equal(t, "v2:team:user:new:account", key)
+
+	_, err = (&keys.Migrator{Store: store, Teams: map[string]string{"token": "team"}}).Run(ctx)
+	require.NoError(t, err)
+
+	// A rotated token no longer orphans migrated data
+	r.Token = "rotated"
+	key, err = e.listKey(ctx, "", r)
+	require.NoError(t, err)
+	require.Equal(t, "v2:team:user:test:list", key)
+	key, err = e.acctKey(ctx, r)
+	require.NoError(t, err)
+	require.Equal(t, "v2:team:user:test:account", key)
+	a, err = e.s.Portfolio(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, float64(100), a.Balance)
+}
+
+func TestDegraded(t *testing.T) {
+	ctx := context.Background()
+
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	health := storage.NewHealth(storage.NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})))
+	mr.Close()
+
+	e := &Engine{
+		s: &stocktopus.Stocktopus{
+			KVStore: health.Guard(),
+			StockInterface: &stocktest.Lookup{
+				Quotes:  []*stock.Quote{{Ticker: "AMD", LatestPrice: 2}},
+				Profile: &types.Company{CompanyName: "AMD"},
+			},
+		},
+	}
+
+	r := &Request{Platform: Slack, Team: "team", User: "test"}
+
+	// The first command to touch storage finds it down
+	r.Text = "watch amd"
+	res, err := e.Run(ctx, r)
+	require.NoError(t, err)
+	require.Equal(t, &Message{Text: Unavailable}, res)
+	require.False(t, health.Healthy())
+
+	for _, text := range []string{"list", "buy amd 1", "portfolio", "import amd", "forget me confirm"} {
+		r.Text = text
+		res, err := e.Run(ctx, r)
+		require.NoError(t, err, text)
+		require.Equal(t, &Message{Text: Unavailable}, res, text)
+	}
+
+	// Stateless commands still work
+	r.Text = "amd"
+	res, err = e.Run(ctx, r)
+	require.NoError(t, err)
+	require.Equal(t, "AMD", res.(*Quotes).List[0].Ticker)
+
+	r.Text = "info amd"
+	res, err = e.Run(ctx, r)
+	require.NoError(t, err)
+	require.Equal(t, "AMD", res.(*Company).CompanyName)
+}
+
+func TestTracing(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	e := &Engine{
+		s: &stocktopus.Stocktopus{
+			KVStore: storage.NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()})),
+			StockInterface: &stocktest.Lookup{
+				Quotes: []*stock.Quote{{Ticker: "AMD", LatestPrice: 2}},
+			},
+		},
+	}
+
+	require.NoError(t, e.s.KVStore.AddMembers(context.Background(), keys.List("team", "test"), "AMD"))
+
+	ctx, root := tracing.Start(context.Background(), "request")
+	_, err = e.Run(ctx, &Request{Platform: Slack, Team: "team", User: "test", Text: "list"})
+	require.NoError(t, err)
+	root.End()
+
+	// Every span belongs to the request, nested command, method, then provider and redis calls
+	parents := map[string]string{}
+	names := map[trace.SpanID]string{}
+	for _, span := range spans.Ended() {
+		names[span.SpanContext().SpanID()] = span.Name()
+		require.Equal(t, root.SpanContext().TraceID(), span.SpanContext().TraceID(), span.Name())
+	}
+	for _, span := range spans.Ended() {
+		parents[span.Name()] = names[span.Parent().SpanID()]
+	}
+	require.Equal(t, "request", parents["engine.command"])
+	require.Equal(t, "engine.command", parents["stocktopus.Print"])
+	require.Equal(t, "stocktopus.Print", parents["redis.smembers"])
+	require.Equal(t, "stocktopus.GetQuotes", parents["stock.BatchQuotes"])
+}
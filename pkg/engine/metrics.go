@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thorfour/stocktopus/pkg/command"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+var (
+	commandTimings = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "command_timings",
+		Help: "A histogram of cmd request execution times",
+	},
+		[]string{"command"},
+	)
+
+	commandResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "commands_total",
+		Help: "Count of commands by result (ok, invalid, rejected, not_found, rate_limited, unavailable, panic, error)",
+	},
+		[]string{"command", "result"},
+	)
+)
+
+// errPanic records commands that panicked
+var errPanic = errors.New("command panicked")
+
+// Command labels for quotes, with and without a range
+const (
+	quoteLabel   = "quote"
+	historyLabel = "history"
+)
+
+// commandLabel returns the metric label for a command. Input that couldn't be parsed is counted
+// as a quote, since that's what text without a command is.
+func commandLabel(cmd *command.Command) string {
+	switch {
+	case cmd == nil:
+		return quoteLabel
+	case isHistory(cmd):
+		return historyLabel
+	default:
+		return cmd.Name
+	}
+}
+
+// observe records the latency and result of a command
+func observe(label string, start time.Time, err error) {
+	commandTimings.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	commandResults.WithLabelValues(label, errorClass(err)).Inc()
+}
+
+// errorClass groups command errors by cause
+func errorClass(err error) string {
+	var (
+		rateErr *stock.RateLimitError
+		numErr  *strconv.NumError
+		userErr *stocktopus.UserError
+	)
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, errPanic):
+		return "panic"
+	case errors.Is(err, storage.ErrUnavailable):
+		return "unavailable"
+	case errors.As(err, &rateErr):
+		return "rate_limited"
+	case errors.Is(err, stocktopus.ErrNoList), errors.Is(err, stocktopus.ErrNoHistory),
+		errors.Is(err, stocktopus.ErrNoImport), errors.Is(err, stock.ErrUnknownSymbol):
+		return "not_found"
+	case errors.Is(err, command.ErrNumArgs), errors.Is(err, stocktopus.ErrInvalidArguments),
+		errors.Is(err, stocktopus.ErrInsufficientFunds), errors.Is(err, stocktopus.ErrNumShares),
+		errors.Is(err, stocktopus.ErrEmptyImport), errors.As(err, &numErr):
+		return "invalid"
+	case errors.As(err, &userErr):
+		if userErr.Invalid {
+			return "invalid"
+		}
+		return "rejected"
+	default:
+		return "error"
+	}
+}
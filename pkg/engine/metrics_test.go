@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/command"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+func TestCommandLabel(t *testing.T) {
+	tests := map[string]struct {
+		text  string
+		label string
+	}{
+		"command":   {text: "list", label: "list"},
+		"ticker":    {text: "amd", label: quoteLabel},
+		"tickers":   {text: "amd intc nvda", label: quoteLabel},
+		"history":   {text: "amd 5d", label: historyLabel},
+		"invalid":   {text: "buy amd ten", label: "buy"},
+		"unparsed":  {text: `"amd`, label: quoteLabel},
+		"empty":     {text: " ", label: quoteLabel},
+		"uppercase": {text: "WATCH AMD", label: "watch"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cmd, _ := registry.Parse(test.text)
+			require.Equal(t, test.label, commandLabel(cmd))
+		})
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	_, numErr := strconv.Atoi("ten")
+	tests := map[error]string{
+		nil: "ok",
+		fmt.Errorf("List failed: %w", storage.ErrUnavailable): "unavailable",
+		&stock.RateLimitError{Provider: "alphavantage"}:       "rate_limited",
+		fmt.Errorf("Print failed: %w", stocktopus.ErrNoList):  "not_found",
+		command.ErrNumArgs:               "invalid",
+		numErr:                           "invalid",
+		stocktopus.ErrInsufficientFunds:  "invalid",
+		errors.New("connection refused"): "error",
+	}
+
+	for err, class := range tests {
+		require.Equal(t, class, errorClass(err), fmt.Sprint(err))
+	}
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/thorfour/iex/pkg/types"
+	"github.com/thorfour/stocktopus/pkg/command"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+)
+
+// Result is the typed outcome of a command, each frontend renders them its own way
+type Result interface {
+	// Public is true for results meant for the whole channel, others are only shown to the user
+	Public() bool
+}
+
+// Message is a short reply to the user
+type Message struct {
+	Text string
+}
+
+// Public implements Result
+func (*Message) Public() bool { return false }
+
+// Quotes are the latest quotes for tickers or a watch list
+type Quotes struct {
+	List stocktopus.WatchList
+	// Missing are the symbols that don't exist
+	Missing []string
+	// Unavailable are the symbols that couldn't be quoted right now, e.g. while rate limited
+	Unavailable []string
+	// RetryAfter is how long until the unavailable symbols can be quoted again, if it's known
+	RetryAfter time.Duration
+	// Chart links to a chart when a single ticker was quoted
+	Chart string
+}
+
+// Public implements Result
+func (*Quotes) Public() bool { return true }
+
+// Balance is the user's cash balance after a deposit or reset
+type Balance struct {
+	Balance float64
+}
+
+// Public implements Result
+func (*Balance) Public() bool { return false }
+
+// Portfolio is the user's play money account with the latest prices of its holdings
+type Portfolio struct {
+	Account *stocktopus.Account
+}
+
+// Public implements Result
+func (*Portfolio) Public() bool { return true }
+
+// Company is a company profile
+type Company struct {
+	*types.Company
+}
+
+// Public implements Result
+func (*Company) Public() bool { return true }
+
+// News are the latest headlines for a company
+type News struct {
+	Headlines []string
+}
+
+// Public implements Result
+func (*News) Public() bool { return true }
+
+// Stats are statistics about a company
+type Stats struct {
+	*types.Stats
+}
+
+// Public implements Result
+func (*Stats) Public() bool { return true }
+
+// History is the performance of a ticker over a range
+type History struct {
+	*stocktopus.Performance
+}
+
+// Public implements Result
+func (*History) Public() bool { return true }
+
+// Search are the symbols matching a search
+type Search struct {
+	Results stocktopus.SearchResults
+}
+
+// Public implements Result
+func (*Search) Public() bool { return false }
+
+// Export is a signed link to download the user's data
+type Export struct {
+	URL     string
+	Expires time.Duration
+}
+
+// Public implements Result
+func (*Export) Public() bool { return false }
+
+// ImportPreview is an import waiting to be confirmed or cancelled
+type ImportPreview struct {
+	Import *stocktopus.Import
+}
+
+// Public implements Result
+func (*ImportPreview) Public() bool { return false }
+
+// Imported is an import that was applied
+type Imported struct {
+	Import *stocktopus.Import
+}
+
+// Public implements Result
+func (*Imported) Public() bool { return false }
+
+// Help lists the commands, or describes Command when help for a single command was asked for
+type Help struct {
+	Specs   []*command.Spec
+	Command *command.Spec
+}
+
+// Public implements Result
+func (*Help) Public() bool { return false }
@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thorfour/stocktopus/pkg/keys"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/tracing"
+)
+
+var (
+	// ErrNoExports is returned by export when no signing secret is configured
+	ErrNoExports = &stocktopus.UserError{Message: "Exports are not enabled"}
+
+	// ErrNotMigrated is returned by export while the user's data is still under legacy keys,
+	// which download links can't reach
+	ErrNotMigrated = &stocktopus.UserError{Message: "Exports are available once your data is migrated to the new storage keys"}
+)
+
+// exportLinkTTL is how long an export link can be downloaded for
+const exportLinkTTL = 15 * time.Minute
+
+// WithExports enables the export command. Download links are served from baseURL at /export and
+// are signed with secret.
+func WithExports(baseURL string, secret []byte) Option {
+	return func(e *Engine) {
+		e.exportURL = strings.TrimSuffix(baseURL, "/") + "/export"
+		e.exportSecret = secret
+	}
+}
+
+// export returns a signed link to download the user's data as csv, unless format is json
+func (e *Engine) export(ctx context.Context, format string, r *Request) (Result, error) {
+	if e.exportSecret == nil {
+		return nil, fmt.Errorf("Export failed: %w", ErrNoExports)
+	}
+	if format == "" {
+		format = "csv"
+	}
+
+	// Links only carry the team and user, which don't reach legacy keys
+	listKey, err := e.listKey(ctx, "", r)
+	if err != nil {
+		return nil, fmt.Errorf("Export failed: %w", err)
+	}
+	acctKey, err := e.acctKey(ctx, r)
+	if err != nil {
+		return nil, fmt.Errorf("Export failed: %w", err)
+	}
+	if listKey != keys.List(r.team(), r.User) || acctKey != keys.Account(r.team(), r.User) {
+		return nil, fmt.Errorf("Export failed: %w", ErrNotMigrated)
+	}
+
+	q := url.Values{}
+	q.Set("team", r.team())
+	q.Set("user", r.User)
+	q.Set("format", format)
+	q.Set("expires", strconv.FormatInt(time.Now().Add(exportLinkTTL).Unix(), 10))
+	q.Set("sig", e.sign(q))
+
+	return &Export{URL: e.exportURL + "?" + q.Encode(), Expires: exportLinkTTL}, nil
+}
+
+// sign returns the signature of an export link
+func (e *Engine) sign(q url.Values) string {
+	mac := hmac.New(sha256.New, e.exportSecret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", q.Get("team"), q.Get("user"), q.Get("format"), q.Get("expires"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ExportHandler serves the downloads linked by the export command
+func (e *Engine) ExportHandler(resp http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if e.exportSecret == nil || err != nil || !hmac.Equal([]byte(q.Get("sig")), []byte(e.sign(q))) {
+		http.Error(resp, "invalid link", http.StatusForbidden)
+		return
+	}
+	if time.Now().Unix() > expires {
+		http.Error(resp, "link expired, run export again", http.StatusGone)
+		return
+	}
+
+	ctx := req.Context()
+	team, user := q.Get("team"), q.Get("user")
+
+	teamLists := map[string]string{}
+	found, err := e.s.KVStore.Keys(ctx, keys.TeamList(team, ""))
+	if err != nil {
+		tracing.Log(ctx).WithField("msg", "export failed").Error(err)
+		http.Error(resp, "export failed", http.StatusInternalServerError)
+		return
+	}
+	for _, key := range found {
+		if name, ok := keys.TeamListName(team, key); ok {
+			teamLists[name] = key
+		}
+	}
+
+	data, err := e.s.Export(ctx, keys.List(team, user), keys.Account(team, user), teamLists)
+	if err != nil {
+		tracing.Log(ctx).WithField("msg", "export failed").Error(err)
+		http.Error(resp, "export failed", http.StatusInternalServerError)
+		return
+	}
+
+	format := q.Get("format")
+	resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "stocktopus."+format))
+	switch format {
+	case "json":
+		resp.Header().Set("Content-Type", "application/json")
+		err = data.WriteJSON(resp)
+	default:
+		resp.Header().Set("Content-Type", "text/csv")
+		err = data.WriteCSV(resp)
+	}
+	if err != nil {
+		tracing.Log(ctx).WithField("msg", "export write failed").Error(err)
+	}
+}
+
+// importData previews CSV data to import, or confirms or cancels a previewed import. Data is
+// added to the personal watch list unless list names a team list.
+func (e *Engine) importData(ctx context.Context, list, text string, r *Request) (Result, error) {
+	pending := keys.PendingImport(r.team(), r.User)
+
+	switch strings.ToUpper(text) {
+	case "CONFIRM":
+		imp, err := e.s.ConfirmImport(ctx, pending)
+		if err != nil {
+			return nil, fmt.Errorf("Import failed: %w", err)
+		}
+
+		return &Imported{Import: imp}, nil
+
+	case "CANCEL":
+		if err := e.s.CancelImport(ctx, pending); err != nil {
+			return nil, fmt.Errorf("Import failed: %w", err)
+		}
+
+		return &Message{Text: "Import cancelled"}, nil
+	}
+
+	imp, err := stocktopus.ParseImport(strings.NewReader(text))
+	if err != nil {
+		return nil, fmt.Errorf("Import failed: %w", err)
+	}
+
+	if imp.ListKey, err = e.listKey(ctx, list, r); err != nil {
+		return nil, fmt.Errorf("Import failed: %w", err)
+	}
+	if imp.AcctKey, err = e.acctKey(ctx, r); err != nil {
+		return nil, fmt.Errorf("Import failed: %w", err)
+	}
+
+	if err := e.s.PreviewImport(ctx, imp, pending); err != nil {
+		return nil, fmt.Errorf("Import failed: %w", err)
+	}
+
+	return &ImportPreview{Import: imp}, nil
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stock/stocktest"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+func newTransferEngine() *Engine {
+	e := &Engine{
+		s: &stocktopus.Stocktopus{
+			KVStore: storage.NewMemory(),
+			StockInterface: &stocktest.Lookup{
+				Quotes: []*stock.Quote{{Ticker: "AMD", LatestPrice: 2}},
+			},
+		},
+	}
+	WithExports("https://example.com/", []byte("secret"))(e)
+	return e
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	e := newTransferEngine()
+
+	r := &Request{Platform: Slack, Team: "team", User: "test", Token: "token"}
+
+	r.Text = "import #fun\namd\n"
+	res, err := e.Run(ctx, r)
+	require.NoError(t, err)
+	require.Contains(t, res.(*ImportPreview).Import.String(), "Watch: AMD")
+
+	r.Text = "IMPORT confirm"
+	_, err = e.Run(ctx, r)
+	require.NoError(t, err)
+
+	members, err := e.s.KVStore.Members(ctx, "v2:team:list:fun")
+	require.NoError(t, err)
+	require.Equal(t, []string{"AMD"}, members)
+
+	r.Text = "import cancel"
+	_, err = e.Run(ctx, r)
+	require.NoError(t, err)
+
+	r.Text = "import confirm"
+	_, err = e.Run(ctx, r)
+	require.True(t, errors.Is(err, stocktopus.ErrNoImport))
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	e := newTransferEngine()
+
+	r := &Request{Platform: Slack, Team: "team", User: "test", Token: "token"}
+
+	r.Text = "watch amd"
+	_, err := e.Run(ctx, r)
+	require.NoError(t, err)
+
+	r.Text = "export xml"
+	_, err = e.Run(ctx, r)
+	require.Equal(t, "Expected csv or json, got \"xml\"\nUsage: `export (csv|json)`", stocktopus.UserMessage(err))
+
+	r.Text = "export"
+	res, err := e.Run(ctx, r)
+	require.NoError(t, err)
+
+	link := res.(*Export).URL
+	require.True(t, strings.HasPrefix(link, "https://example.com/export?"))
+
+	rec := httptest.NewRecorder()
+	e.ExportHandler(rec, httptest.NewRequest(http.MethodGet, link, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "text/csv", rec.Header().Get("Content-Type"))
+	require.Contains(t, rec.Body.String(), "watch,,AMD")
+
+	// Tampered links are rejected
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	q := u.Query()
+	q.Set("user", "someone-else")
+	u.RawQuery = q.Encode()
+	rec = httptest.NewRecorder()
+	e.ExportHandler(rec, httptest.NewRequest(http.MethodGet, u.String(), nil))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Links can't reach data still under legacy keys
+	require.NoError(t, e.s.KVStore.Put(ctx, "ACCT[token][legacy]", []byte(`{"Balance":1}`)))
+	_, err = e.Run(ctx, &Request{Platform: Slack, Team: "team", User: "legacy", Token: "token", Text: "export"})
+	require.True(t, errors.Is(err, ErrNotMigrated))
+}
+
+func TestForgetMe(t *testing.T) {
+	ctx := context.Background()
+	e := newTransferEngine()
+
+	r := &Request{Platform: Slack, Team: "team", User: "test", Token: "token"}
+
+	r.Text = "watch amd"
+	_, err := e.Run(ctx, r)
+	require.NoError(t, err)
+
+	// Nothing is deleted without confirmation
+	r.Text = "forget me"
+	_, err = e.Run(ctx, r)
+	require.NoError(t, err)
+	all, err := e.s.KVStore.Keys(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2:team:user:test:list", "v2:team:user:test:seen"}, all)
+
+	r.Text = "forget me confirm"
+	_, err = e.Run(ctx, r)
+	require.NoError(t, err)
+	all, err = e.s.KVStore.Keys(ctx, "")
+	require.NoError(t, err)
+	require.Empty(t, all)
+}
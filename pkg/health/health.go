@@ -0,0 +1,184 @@
+// Package health reports whether the server and the services it depends on are working
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thorfour/stocktopus/pkg/tracing"
+)
+
+var cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "health_check_cache_requests_total",
+	Help: "Count of health check reads by check and result (hit, miss)",
+},
+	[]string{"check", "result"},
+)
+
+// checkTimeout bounds a single run of a check
+const checkTimeout = 5 * time.Second
+
+// Statuses reported for the server and each check
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFailed   = "failed"
+	StatusDraining = "draining"
+)
+
+// CheckFunc returns an error if a dependency isn't working
+type CheckFunc func(ctx context.Context) error
+
+// check is a registered CheckFunc and its cached result
+type check struct {
+	name     string
+	fn       CheckFunc
+	ttl      time.Duration
+	required bool
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// run returns the cached result, running the check first if it's older than ttl
+func (c *check) run(ctx context.Context, now time.Time) Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.checked.IsZero() || now.Sub(c.checked) >= c.ttl {
+		cacheRequests.WithLabelValues(c.name, "miss").Inc()
+		ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+		c.err = c.fn(ctx)
+		cancel()
+		c.checked = now
+		if c.err != nil {
+			tracing.Log(ctx).WithField("msg", "health check failed").WithField("check", c.name).Warn(c.err)
+		}
+	} else {
+		cacheRequests.WithLabelValues(c.name, "hit").Inc()
+	}
+
+	r := Result{Status: StatusOK, Required: c.required, Checked: c.checked}
+	if c.err != nil {
+		r.Status = StatusFailed
+	}
+	return r
+}
+
+// Result is the outcome of a single check. Why a check failed is logged rather than reported,
+// errors from storage and providers can include addresses and keys.
+type Result struct {
+	Status   string    `json:"status"`
+	Required bool      `json:"required"`
+	Checked  time.Time `json:"checked"`
+}
+
+// Report is the overall health. A failed check that isn't required leaves the server degraded
+// but ready, since it can still serve the commands that don't need it.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// Ready reports whether the server should receive traffic
+func (r *Report) Ready() bool {
+	return r.Status == StatusOK || r.Status == StatusDegraded
+}
+
+// Checker runs the registered checks for the health endpoints
+type Checker struct {
+	mu       sync.RWMutex
+	checks   []*check
+	draining int32
+
+	now func() time.Time
+}
+
+// New returns a Checker without any checks
+func New() *Checker {
+	return &Checker{now: time.Now}
+}
+
+// Add registers a check whose result is reused for ttl. The server isn't ready while a required
+// check is failing.
+func (c *Checker) Add(name string, ttl time.Duration, required bool, fn CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks = append(c.checks, &check{name: name, fn: fn, ttl: ttl, required: required})
+}
+
+// Drain marks the server as shutting down so it stops being ready
+func (c *Checker) Drain() {
+	atomic.StoreInt32(&c.draining, 1)
+}
+
+// Report runs any stale checks in parallel and returns the results
+func (c *Checker) Report(ctx context.Context) *Report {
+	c.mu.RLock()
+	checks := c.checks
+	c.mu.RUnlock()
+
+	now := c.now()
+	results := make([]Result, len(checks))
+	wg := sync.WaitGroup{}
+	for i, chk := range checks {
+		wg.Add(1)
+		go func(i int, chk *check) {
+			defer wg.Done()
+			results[i] = chk.run(ctx, now)
+		}(i, chk)
+	}
+	wg.Wait()
+
+	report := &Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	for i, chk := range checks {
+		report.Checks[chk.name] = results[i]
+		switch {
+		case results[i].Status == StatusOK:
+		case chk.required:
+			report.Status = StatusFailed
+		case report.Status == StatusOK:
+			report.Status = StatusDegraded
+		}
+	}
+	if atomic.LoadInt32(&c.draining) == 1 {
+		report.Status = StatusDraining
+	}
+
+	return report
+}
+
+// Live handles liveness probes. The process is alive as long as it can answer, so it always
+// responds OK without running any checks.
+func (c *Checker) Live(resp http.ResponseWriter, req *http.Request) {
+	status := StatusOK
+	if atomic.LoadInt32(&c.draining) == 1 {
+		status = StatusDraining
+	}
+	write(resp, http.StatusOK, &Report{Status: status})
+}
+
+// Ready handles readiness probes, failing while draining or while a required check fails
+func (c *Checker) Ready(resp http.ResponseWriter, req *http.Request) {
+	report := c.Report(req.Context())
+
+	code := http.StatusOK
+	if !report.Ready() {
+		code = http.StatusServiceUnavailable
+	}
+	write(resp, code, report)
+}
+
+func write(resp http.ResponseWriter, code int, report *Report) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(code)
+	json.NewEncoder(resp).Encode(report)
+}
@@ -0,0 +1,82 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	c := New()
+	c.now = func() time.Time { return now }
+
+	var storageErr, providerErr error
+	providerCalls := 0
+	c.Add("storage", 0, true, func(context.Context) error { return storageErr })
+	c.Add("provider", time.Minute, false, func(context.Context) error {
+		providerCalls++
+		return providerErr
+	})
+
+	probe := func(handler http.HandlerFunc) (int, *Report) {
+		resp := httptest.NewRecorder()
+		handler(resp, httptest.NewRequest("GET", "/", nil))
+		report := &Report{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(report))
+		return resp.Code, report
+	}
+
+	code, report := probe(c.Ready)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, StatusOK, report.Status)
+	require.Equal(t, 1, providerCalls)
+
+	// A failing optional check degrades but stays ready, and results are cached
+	providerErr = errors.New("quota exceeded")
+	code, report = probe(c.Ready)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, StatusOK, report.Status)
+	require.Equal(t, 1, providerCalls)
+
+	now = now.Add(time.Minute)
+	code, report = probe(c.Ready)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, StatusDegraded, report.Status)
+	require.Equal(t, StatusFailed, report.Checks["provider"].Status)
+	require.Equal(t, 2, providerCalls)
+
+	// A failing required check isn't ready, but is still alive
+	storageErr = errors.New("disk full")
+	code, report = probe(c.Ready)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, StatusFailed, report.Status)
+	require.Equal(t, StatusFailed, report.Checks["storage"].Status)
+	code, report = probe(c.Live)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, StatusOK, report.Status)
+	require.Empty(t, report.Checks)
+
+	// Liveness never runs a check
+	now = now.Add(time.Minute)
+	probe(c.Live)
+	require.Equal(t, 2, providerCalls)
+
+	// Draining is never ready
+	storageErr, providerErr = nil, nil
+	now = now.Add(time.Minute)
+	c.Drain()
+	code, report = probe(c.Ready)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, StatusDraining, report.Status)
+	require.Equal(t, StatusOK, report.Checks["provider"].Status)
+	code, report = probe(c.Live)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, StatusDraining, report.Status)
+}
@@ -0,0 +1,227 @@
+// Package keys builds the storage keys for watch lists and accounts.
+//
+// Keys are versioned and built from the stable Slack team, user and list names:
+//
+//	v2:{team}:user:{user}:list     personal watch list
+//	v2:{team}:user:{user}:account  play money account
+//	v2:{team}:user:{user}:import   import waiting to be confirmed
+//	v2:{team}:user:{user}:seen     last activity, for retention
+//	v2:{team}:list:{name}          team watch list
+//
+// Earlier releases keyed data on the deprecated Slack verification token, which orphans all data
+// whenever the token is rotated. Those legacy keys can still be built and parsed so existing data
+// can be migrated.
+package keys
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Version prefixes every key in the current scheme
+const Version = "v2"
+
+// Kind is the kind of data a key holds
+type Kind int
+
+// Kinds of keys
+const (
+	KindPersonalList Kind = iota
+	KindTeamList
+	KindAccount
+	KindPendingImport
+	KindActivity
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindPersonalList:
+		return "personal list"
+	case KindTeamList:
+		return "team list"
+	case KindPendingImport:
+		return "pending import"
+	case KindActivity:
+		return "activity"
+	default:
+		return "account"
+	}
+}
+
+// Key is a parsed key from the current scheme
+type Key struct {
+	Kind Kind
+	Team string
+	// User is set for everything but team lists
+	User string
+	// Name is set for team lists
+	Name string
+}
+
+// Parse parses a key from the current scheme
+func Parse(key string) (*Key, bool) {
+	parts := strings.SplitN(key, ":", 4)
+	if len(parts) != 4 || parts[0] != Version || parts[1] == "" {
+		return nil, false
+	}
+
+	team := parts[1]
+	switch parts[2] {
+	case "list":
+		return &Key{Kind: KindTeamList, Team: team, Name: parts[3]}, true
+	case "user":
+		i := strings.LastIndex(parts[3], ":")
+		if i <= 0 {
+			return nil, false
+		}
+		user := parts[3][:i]
+		switch parts[3][i+1:] {
+		case "list":
+			return &Key{Kind: KindPersonalList, Team: team, User: user}, true
+		case "account":
+			return &Key{Kind: KindAccount, Team: team, User: user}, true
+		case "import":
+			return &Key{Kind: KindPendingImport, Team: team, User: user}, true
+		case "seen":
+			return &Key{Kind: KindActivity, Team: team, User: user}, true
+		}
+	}
+
+	return nil, false
+}
+
+// String returns the key as it's stored
+func (k *Key) String() string {
+	switch k.Kind {
+	case KindPersonalList:
+		return List(k.Team, k.User)
+	case KindTeamList:
+		return TeamList(k.Team, k.Name)
+	case KindPendingImport:
+		return PendingImport(k.Team, k.User)
+	case KindActivity:
+		return Activity(k.Team, k.User)
+	default:
+		return Account(k.Team, k.User)
+	}
+}
+
+// List returns the key for a user's personal watch list
+func List(team, user string) string {
+	return fmt.Sprintf("%s:%s:user:%s:list", Version, team, user)
+}
+
+// Account returns the key for a user's play money account
+func Account(team, user string) string {
+	return fmt.Sprintf("%s:%s:user:%s:account", Version, team, user)
+}
+
+// PendingImport returns the key for a user's import that's waiting to be confirmed
+func PendingImport(team, user string) string {
+	return fmt.Sprintf("%s:%s:user:%s:import", Version, team, user)
+}
+
+// Activity returns the key recording when a user was last active
+func Activity(team, user string) string {
+	return fmt.Sprintf("%s:%s:user:%s:seen", Version, team, user)
+}
+
+// User returns the prefix shared by every key belonging to a user
+func User(team, user string) string {
+	return fmt.Sprintf("%s:%s:user:%s:", Version, team, user)
+}
+
+// Team returns the prefix shared by every key belonging to a team
+func Team(team string) string {
+	return fmt.Sprintf("%s:%s:", Version, team)
+}
+
+// TeamList returns the key for a watch list shared by a team. Names are case insensitive.
+func TeamList(team, name string) string {
+	return fmt.Sprintf("%s:%s:list:%s", Version, team, strings.ToLower(name))
+}
+
+// TeamListName returns the name of a team list from its key
+func TeamListName(team, key string) (string, bool) {
+	prefix := TeamList(team, "")
+	if !strings.HasPrefix(key, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(key, prefix), true
+}
+
+// Legacy is a key from the token based scheme
+type Legacy struct {
+	Kind  Kind
+	Token string
+	// Team is only known for team lists
+	Team string
+	User string
+	Name string
+}
+
+// String returns the key as it's stored
+func (l *Legacy) String() string {
+	switch l.Kind {
+	case KindPersonalList:
+		return LegacyList(l.Token, l.User)
+	case KindTeamList:
+		return LegacyTeamList(l.Token, l.Team, l.Name)
+	default:
+		return LegacyAccount(l.Token, l.User)
+	}
+}
+
+// Current returns the key in the current scheme. Team is used for personal lists and accounts
+// since legacy keys didn't record it.
+func (l *Legacy) Current(team string) string {
+	switch l.Kind {
+	case KindPersonalList:
+		return List(team, l.User)
+	case KindTeamList:
+		return TeamList(l.Team, l.Name)
+	default:
+		return Account(team, l.User)
+	}
+}
+
+// LegacyList returns the legacy key for a personal watch list
+func LegacyList(token, user string) string {
+	return fmt.Sprintf("%v%v", []string{token}, []string{user})
+}
+
+// LegacyTeamList returns the legacy key for a team watch list
+func LegacyTeamList(token, team, name string) string {
+	return fmt.Sprintf("%v%v", []string{token}, []string{strings.ToLower(name), team})
+}
+
+// LegacyAccount returns the legacy key for an account
+func LegacyAccount(token, user string) string {
+	return fmt.Sprintf("%v%v%v", "ACCT", []string{token}, []string{user})
+}
+
+// LegacyPrefixes are the prefixes every legacy key starts with
+var LegacyPrefixes = []string{"[", "ACCT["}
+
+var legacyKey = regexp.MustCompile(`^(ACCT)?\[([^\]]*)\]\[([^\]]*)\]$`)
+
+// ParseLegacy parses a key from the token based scheme
+func ParseLegacy(key string) (*Legacy, bool) {
+	m := legacyKey.FindStringSubmatch(key)
+	if m == nil {
+		return nil, false
+	}
+
+	acct, token, fields := m[1] != "", m[2], strings.Split(m[3], " ")
+	switch {
+	case acct && len(fields) == 1:
+		return &Legacy{Kind: KindAccount, Token: token, User: fields[0]}, true
+	case !acct && len(fields) == 1:
+		return &Legacy{Kind: KindPersonalList, Token: token, User: fields[0]}, true
+	case !acct && len(fields) == 2:
+		return &Legacy{Kind: KindTeamList, Token: token, Name: fields[0], Team: fields[1]}, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,203 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+func TestParseLegacy(t *testing.T) {
+	tests := []struct {
+		key    string
+		legacy *Legacy
+	}{
+		{"[tok][U123]", &Legacy{Kind: KindPersonalList, Token: "tok", User: "U123"}},
+		{"[tok][funlist T123]", &Legacy{Kind: KindTeamList, Token: "tok", Team: "T123", Name: "funlist"}},
+		{"ACCT[tok][U123]", &Legacy{Kind: KindAccount, Token: "tok", User: "U123"}},
+		{"[][U123]", &Legacy{Kind: KindPersonalList, User: "U123"}},
+		{"v2:T123:user:U123:list", nil},
+		{"ACCT[tok][funlist T123]", nil},
+		{"[tok][a b c]", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			l, ok := ParseLegacy(test.key)
+			require.Equal(t, test.legacy != nil, ok)
+			require.Equal(t, test.legacy, l)
+			if ok {
+				require.Equal(t, test.key, l.String())
+			}
+		})
+	}
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemory()
+
+	moved, err := Move(ctx, s, "missing", "new")
+	require.NoError(t, err)
+	require.False(t, moved)
+
+	// Sets are merged
+	require.NoError(t, s.AddMembers(ctx, "old", "AMD", "TSLA"))
+	require.NoError(t, s.AddMembers(ctx, "new", "GOOG"))
+	moved, err = Move(ctx, s, "old", "new")
+	require.NoError(t, err)
+	require.True(t, moved)
+	members, err := s.Members(ctx, "new")
+	require.NoError(t, err)
+	require.Equal(t, []string{"AMD", "GOOG", "TSLA"}, members)
+
+	// Existing values win, and what wasn't copied isn't deleted
+	require.NoError(t, s.Put(ctx, "old", []byte("old")))
+	require.NoError(t, s.Put(ctx, "value", []byte("current")))
+	_, err = Move(ctx, s, "old", "value")
+	require.True(t, errors.Is(err, ErrConflict))
+	v, err := s.Get(ctx, "value")
+	require.NoError(t, err)
+	require.Equal(t, []byte("current"), v)
+	v, err = s.Get(ctx, "old")
+	require.NoError(t, err)
+	require.Equal(t, []byte("old"), v)
+
+	// Identical values were already copied
+	require.NoError(t, s.Put(ctx, "value", []byte("old")))
+	moved, err = Move(ctx, s, "old", "value")
+	require.NoError(t, err)
+	require.True(t, moved)
+	keys, err := s.Keys(ctx, "old")
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemory()
+
+	key, err := Resolve(ctx, s, "legacy", "current")
+	require.NoError(t, err)
+	require.Equal(t, "current", key)
+
+	// Legacy data is used in place
+	require.NoError(t, s.AddMembers(ctx, "legacy", "AMD"))
+	key, err = Resolve(ctx, s, "legacy", "current")
+	require.NoError(t, err)
+	require.Equal(t, "legacy", key)
+	members, err := s.Members(ctx, "legacy")
+	require.NoError(t, err)
+	require.Equal(t, []string{"AMD"}, members)
+
+	// Once the current key exists the legacy key is ignored
+	require.NoError(t, s.Put(ctx, "current", []byte("current")))
+	key, err = Resolve(ctx, s, "legacy", "current")
+	require.NoError(t, err)
+	require.Equal(t, "current", key)
+}
+
+func TestFindLegacy(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemory()
+	require.NoError(t, s.AddMembers(ctx, LegacyTeamList("token", "T1", "fun"), "AMD"))
+	require.NoError(t, s.AddMembers(ctx, LegacyList("token", "U1"), "AMD"))
+	require.NoError(t, s.Put(ctx, LegacyAccount("token", "U1"), []byte("{}")))
+	require.NoError(t, s.Put(ctx, Account("T1", "U1"), []byte("{}")))
+
+	found, err := FindLegacy(ctx, s, func(l *Legacy) bool { return l.User == "U1" })
+	require.NoError(t, err)
+	require.ElementsMatch(t, []*Legacy{
+		{Kind: KindPersonalList, Token: "token", User: "U1"},
+		{Kind: KindAccount, Token: "token", User: "U1"},
+	}, found)
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemory()
+
+	require.NoError(t, s.AddMembers(ctx, LegacyList("tok", "U1"), "AMD"))
+	require.NoError(t, s.AddMembers(ctx, LegacyTeamList("tok", "T1", "funlist"), "TSLA"))
+	require.NoError(t, s.Put(ctx, LegacyAccount("tok", "U1"), []byte("{}")))
+	require.NoError(t, s.Put(ctx, LegacyAccount("unknown", "U2"), []byte("{}")))
+	require.NoError(t, s.Put(ctx, List("T1", "U3"), []byte("untouched")))
+
+	m := &Migrator{
+		Store:  s,
+		Teams:  map[string]string{"tok": "T1"},
+		DryRun: true,
+	}
+
+	// Dry runs don't change anything
+	report, err := m.Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &Report{Total: 4, Migrated: 3, Skipped: 1}, report)
+	all, err := s.Keys(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, all, 5)
+
+	var progress []string
+	m.DryRun = false
+	m.Progress = func(done, total int, legacy, current string, result Result, err error) {
+		require.NoError(t, err)
+		progress = append(progress, current)
+	}
+	report, err = m.Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &Report{Total: 4, Migrated: 3, Skipped: 1}, report)
+	require.Len(t, progress, 4)
+
+	all, err = s.Keys(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"ACCT[unknown][U2]",
+		"v2:T1:list:funlist",
+		"v2:T1:user:U1:account",
+		"v2:T1:user:U1:list",
+		"v2:T1:user:U3:list",
+	}, all)
+
+	// Running again only sees what's left
+	report, err = m.Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &Report{Total: 1, Skipped: 1}, report)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		key string
+		k   *Key
+	}{
+		{List("T1", "U1"), &Key{Kind: KindPersonalList, Team: "T1", User: "U1"}},
+		{Account("T1", "U1"), &Key{Kind: KindAccount, Team: "T1", User: "U1"}},
+		{PendingImport("T1", "U1"), &Key{Kind: KindPendingImport, Team: "T1", User: "U1"}},
+		{Activity("T1", "U1"), &Key{Kind: KindActivity, Team: "T1", User: "U1"}},
+		{TeamList("T1", "Fun:List"), &Key{Kind: KindTeamList, Team: "T1", Name: "fun:list"}},
+		{"v2:T1:user:U1:other", nil},
+		{"v1:T1:user:U1:list", nil},
+		{"[tok][U1]", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			k, ok := Parse(test.key)
+			require.Equal(t, test.k != nil, ok)
+			require.Equal(t, test.k, k)
+			if ok {
+				require.Equal(t, test.key, k.String())
+			}
+		})
+	}
+}
+
+func TestParseTokenTeams(t *testing.T) {
+	teams, err := ParseTokenTeams("a=T1,b=T2,")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "T1", "b": "T2"}, teams)
+
+	_, err = ParseTokenTeams("a")
+	require.Error(t, err)
+}
@@ -0,0 +1,250 @@
+package keys
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+// ErrConflict is returned when moving a value to a key that already holds a different value
+var ErrConflict = errors.New("current key already holds a different value")
+
+// Move copies the data at from to to and then deletes from. Sets are merged, but a value is
+// never overwritten: if to already holds a different value from is left in place and ErrConflict
+// is returned. It returns false if there was nothing at from. Moving again after a failure is safe.
+func Move(ctx context.Context, s storage.Store, from, to string) (bool, error) {
+	value, err := s.Get(ctx, from)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return false, nil
+	case errors.Is(err, storage.ErrWrongType):
+		members, err := s.Members(ctx, from)
+		if err != nil {
+			return false, err
+		}
+		if err := s.AddMembers(ctx, to, members...); err != nil {
+			return false, err
+		}
+	case err != nil:
+		return false, err
+	default:
+		err := s.Update(ctx, to, func(old []byte) ([]byte, error) {
+			if old != nil && !bytes.Equal(old, value) {
+				return nil, ErrConflict
+			}
+			return value, nil
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// Only delete once the data is safely at the new key
+	if err := s.Delete(ctx, from); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Resolve returns the key data that may still be under a legacy key is read from and written to:
+// the legacy key while it holds data and nothing is stored at the current key, otherwise the
+// current key. Legacy data is left in place so earlier releases can still use it until the
+// Migrator moves it.
+func Resolve(ctx context.Context, s storage.Store, legacy, current string) (string, error) {
+	found, err := exists(ctx, s, current)
+	if err != nil || found {
+		return current, err
+	}
+
+	found, err = exists(ctx, s, legacy)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return legacy, nil
+	}
+
+	return current, nil
+}
+
+// FindLegacy returns the legacy keys that match. Callers that don't know the token, like the
+// API, use it to find data that hasn't been migrated yet.
+func FindLegacy(ctx context.Context, s storage.Store, match func(*Legacy) bool) ([]*Legacy, error) {
+	var found []*Legacy
+	for _, prefix := range LegacyPrefixes {
+		keys, err := s.Keys(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("list keys failed: %w", err)
+		}
+		for _, key := range keys {
+			if l, ok := ParseLegacy(key); ok && match(l) {
+				found = append(found, l)
+			}
+		}
+	}
+	return found, nil
+}
+
+// exists reports whether a value or set is stored at key
+func exists(ctx context.Context, s storage.Store, key string) (bool, error) {
+	_, err := s.Get(ctx, key)
+	switch {
+	case err == nil, errors.Is(err, storage.ErrWrongType):
+		return true, nil
+	case errors.Is(err, storage.ErrNotFound):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Result is the outcome of migrating a single key
+type Result int
+
+// Migration results
+const (
+	Migrated Result = iota
+	// Skipped keys are personal lists and accounts whose token has no known team. They're still
+	// used while their token is.
+	Skipped
+	Failed
+)
+
+func (r Result) String() string {
+	switch r {
+	case Migrated:
+		return "migrated"
+	case Skipped:
+		return "skipped"
+	default:
+		return "failed"
+	}
+}
+
+// Report counts the results of a migration
+type Report struct {
+	Total    int
+	Migrated int
+	Skipped  int
+	Failed   int
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("%v legacy keys: %v migrated, %v skipped, %v failed", r.Total, r.Migrated, r.Skipped, r.Failed)
+}
+
+// Migrator rewrites every legacy key in a store to the current scheme. Migrated keys are
+// removed, so an interrupted migration can be resumed by running it again.
+type Migrator struct {
+	Store storage.Store
+
+	// Teams maps verification tokens to their team id. Legacy personal lists and accounts only
+	// record the token, so they can't be migrated without it.
+	Teams map[string]string
+
+	// DryRun reports what would be migrated without changing anything
+	DryRun bool
+
+	// Progress, if set, is called after each key
+	Progress func(done, total int, legacy, current string, result Result, err error)
+}
+
+// Run migrates every legacy key
+func (m *Migrator) Run(ctx context.Context) (*Report, error) {
+	var legacy []string
+	for _, prefix := range LegacyPrefixes {
+		found, err := m.Store.Keys(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("list keys failed: %w", err)
+		}
+		for _, key := range found {
+			if _, ok := ParseLegacy(key); ok {
+				legacy = append(legacy, key)
+			}
+		}
+	}
+
+	report := &Report{Total: len(legacy)}
+	for i, key := range legacy {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		current, result, err := m.migrate(ctx, key)
+		switch result {
+		case Migrated:
+			report.Migrated++
+		case Skipped:
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+
+		if m.Progress != nil {
+			m.Progress(i+1, len(legacy), key, current, result, err)
+		}
+	}
+
+	return report, nil
+}
+
+// migrate moves a single legacy key to the current scheme
+func (m *Migrator) migrate(ctx context.Context, key string) (string, Result, error) {
+	l, _ := ParseLegacy(key)
+
+	team := l.Team
+	if l.Kind != KindTeamList {
+		team = m.Teams[l.Token]
+		if team == "" {
+			return "", Skipped, nil
+		}
+	}
+
+	current := l.Current(team)
+	if m.DryRun {
+		return current, Migrated, nil
+	}
+
+	if _, err := Move(ctx, m.Store, key, current); err != nil {
+		return current, Failed, err
+	}
+
+	return current, Migrated, nil
+}
+
+// ParseTokenTeams parses comma separated token=team pairs for Migrator.Teams
+func ParseTokenTeams(s string) (map[string]string, error) {
+	teams := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid token=team pair %q", pair)
+		}
+		teams[kv[0]] = kv[1]
+	}
+
+	return teams, nil
+}
+
+// PrintProgress returns a Migrator.Progress func that writes a line per key to w
+func PrintProgress(w io.Writer) func(done, total int, legacy, current string, result Result, err error) {
+	return func(done, total int, legacy, current string, result Result, err error) {
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "[%v/%v] %s: %v: %v\n", done, total, legacy, result, err)
+		case current == "":
+			fmt.Fprintf(w, "[%v/%v] %s: %v\n", done, total, legacy, result)
+		default:
+			fmt.Fprintf(w, "[%v/%v] %s -> %s: %v\n", done, total, legacy, current, result)
+		}
+	}
+}
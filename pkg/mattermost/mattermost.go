@@ -0,0 +1,224 @@
+// Package mattermost runs stocktopus commands sent as Mattermost slash commands
+package mattermost
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/thorfour/stocktopus/pkg/engine"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/tracing"
+)
+
+const (
+	ephemeral = "ephemeral"
+	inchannel = "in_channel"
+)
+
+// Attachment colors
+const (
+	colorUp   = "#2ecc71"
+	colorDown = "#e74c3c"
+)
+
+// Response is the json struct for a Mattermost slash command response
+type Response struct {
+	ResponseType string        `json:"response_type"`
+	Text         string        `json:"text,omitempty"`
+	Attachments  []*Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment is a message attachment, Mattermost renders them as cards below the text
+type Attachment struct {
+	Fallback  string   `json:"fallback"`
+	Color     string   `json:"color,omitempty"`
+	Title     string   `json:"title,omitempty"`
+	TitleLink string   `json:"title_link,omitempty"`
+	Text      string   `json:"text,omitempty"`
+	Fields    []*Field `json:"fields,omitempty"`
+	ImageURL  string   `json:"image_url,omitempty"`
+}
+
+// Field is a titled value in an attachment
+type Field struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// Server handles Mattermost slash commands
+type Server struct {
+	e      *engine.Engine
+	tokens []commandToken
+}
+
+// commandToken is the token of a slash command and the team it was created in
+type commandToken struct {
+	value []byte
+	team  string
+}
+
+// New returns a server that runs commands with e. Each slash command created in Mattermost has
+// its own token, tokens maps them to the id of the team they were created in. Requests must carry
+// one of tokens and come from its team.
+func New(e *engine.Engine, tokens map[string]string) *Server {
+	s := &Server{e: e}
+	for t, team := range tokens {
+		if t = strings.TrimSpace(t); t != "" {
+			s.tokens = append(s.tokens, commandToken{value: []byte(t), team: strings.TrimSpace(team)})
+		}
+	}
+	return s
+}
+
+// Handler is a http handler func for Mattermost slash commands
+func (s *Server) Handler(resp http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	if err := req.ParseForm(); err != nil {
+		tracing.Log(ctx).WithField("msg", "error parse form").Error(err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !s.authorized(req) {
+		tracing.Log(ctx).WithField("team", req.PostForm.Get("team_id")).Warn("invalid mattermost command token")
+		http.Error(resp, "invalid command token", http.StatusUnauthorized)
+		return
+	}
+
+	var msg *Response
+	result, err := s.e.Run(ctx, &engine.Request{
+		Platform: engine.Mattermost,
+		Team:     req.PostForm.Get("team_id"),
+		User:     req.PostForm.Get("user_id"),
+		Channel:  req.PostForm.Get("channel_id"),
+		Text:     req.PostForm.Get("text"),
+	})
+	if err != nil {
+		msg = &Response{ResponseType: ephemeral, Text: stocktopus.UserMessage(err)}
+	} else {
+		msg = render(result)
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(resp).Encode(msg); err != nil {
+		tracing.Log(ctx).WithField("msg", "encoding failure").Error(err)
+		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// authorized checks the command token, sent both in the form and the Authorization header, and
+// that the request comes from the token's team
+func (s *Server) authorized(req *http.Request) bool {
+	given := req.PostForm.Get("token")
+	if given == "" {
+		given = strings.TrimPrefix(req.Header.Get("Authorization"), "Token ")
+	}
+
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare(t.value, []byte(given)) == 1 {
+			return t.team == req.PostForm.Get("team_id")
+		}
+	}
+	return false
+}
+
+// render formats a command result for Mattermost
+func render(result engine.Result) *Response {
+	resp := &Response{ResponseType: ephemeral}
+	if result.Public() {
+		resp.ResponseType = inchannel
+	}
+
+	switch r := result.(type) {
+	case *engine.Message:
+		resp.Text = r.Text
+	case *engine.Quotes:
+		resp.Text = code(r.List.String()) + missing(r)
+		if r.Chart != "" {
+			q := r.List[0]
+			color := colorUp
+			if q.Change < 0 {
+				color = colorDown
+			}
+			resp.Attachments = []*Attachment{{
+				Fallback: q.Ticker,
+				Color:    color,
+				Title:    q.Ticker,
+				Text:     code(stocktopus.Details(q)),
+				ImageURL: r.Chart,
+			}}
+		}
+	case *engine.Balance:
+		resp.Text = fmt.Sprintf("New Balance: %v", r.Balance)
+	case *engine.Portfolio:
+		resp.Text = code(r.Account.String())
+	case *engine.Company:
+		resp.Attachments = []*Attachment{{
+			Fallback:  r.CompanyName,
+			Title:     r.CompanyName,
+			TitleLink: r.Website,
+			Text:      r.Description,
+			Fields: []*Field{
+				{Title: "Industry", Value: r.Industry, Short: true},
+				{Title: "CEO", Value: r.CEO, Short: true},
+			},
+		}}
+	case *engine.News:
+		resp.Text = strings.Join(r.Headlines, "\n\n")
+	case *engine.Stats:
+		resp.Text = code(stocktopus.Stats(r.Stats))
+	case *engine.History:
+		resp.Text = code(r.Performance.String())
+	case *engine.Search:
+		resp.Text = code(r.Results.String())
+	case *engine.Export:
+		resp.Text = fmt.Sprintf("[Download your data](%s) (link expires in %v)", r.URL, r.Expires)
+	case *engine.ImportPreview:
+		resp.Text = fmt.Sprintf("%s\nRun `import confirm` to apply or `import cancel` to discard", code(r.Import.String()))
+	case *engine.Imported:
+		resp.Text = fmt.Sprintf("Imported %v tickers and %v positions", len(r.Import.Tickers), len(r.Import.Positions))
+	case *engine.Help:
+		resp.Text = help(r)
+	default:
+		resp.Text = stocktopus.InternalErrorMessage
+	}
+
+	return resp
+}
+
+// code shows a table in a code block
+func code(s string) string {
+	return "```\n" + s + "\n```"
+}
+
+// missing lists symbols that couldn't be quoted
+func missing(q *engine.Quotes) string {
+	text := stocktopus.MissingText(q.Missing, q.Unavailable, q.RetryAfter)
+	if text == "" {
+		return ""
+	}
+	return "\n" + strings.TrimSuffix(text, "\n")
+}
+
+// help lists every command, or shows how to use a single command
+func help(h *engine.Help) string {
+	if h.Command != nil {
+		lines := []string{fmt.Sprintf("**%s**", h.Command.Usage()), h.Command.Summary}
+		for _, f := range h.Command.Flags {
+			lines = append(lines, fmt.Sprintf("`--%s` %s", f.Name, f.Usage))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	lines := make([]string, 0, len(h.Specs))
+	for _, spec := range h.Specs {
+		lines = append(lines, fmt.Sprintf("**%s** %s", spec.Usage(), spec.Summary))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,140 @@
+package mattermost
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/iex/pkg/types"
+	"github.com/thorfour/stocktopus/pkg/engine"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stock/stocktest"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+// token and team are the command token and team id in the captured payloads
+const (
+	token = "8xj8pz3dm3fyjrx5ai3wc7fy6h"
+	team  = "rdc9bgriktyx9p4kowh3dmgqyc"
+)
+
+// lookup quotes every symbol at 2, down 0.5
+func lookup() *stocktest.Lookup {
+	return &stocktest.Lookup{
+		Quote:     stock.Quote{LatestPrice: 2, Change: -0.5},
+		Headlines: []string{"a", "b"},
+		Profile:   &types.Company{CompanyName: "Advanced Micro Devices", CEO: "Lisa Su"},
+	}
+}
+
+// captured returns a request with a payload captured from Mattermost
+func captured(t *testing.T, name string) *http.Request {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name+".txt"))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/mattermost", strings.NewReader(strings.TrimSpace(string(b))))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func serve(s *Server, req *http.Request) (int, *Response) {
+	rec := httptest.NewRecorder()
+	s.Handler(rec, req)
+	resp := &Response{}
+	json.Unmarshal(rec.Body.Bytes(), resp)
+	return rec.Code, resp
+}
+
+func TestHandler(t *testing.T) {
+	store := storage.NewMemory()
+	s := New(engine.New(store, lookup()), map[string]string{"other-token": "other-team", token: team})
+
+	tests := []struct {
+		payload  string
+		code     int
+		respType string
+		text     string
+		title    string
+	}{
+		{payload: "quote", code: http.StatusOK, respType: inchannel, text: "AMD", title: "AMD"},
+		{payload: "watch", code: http.StatusOK, respType: ephemeral, text: "Added"},
+		{payload: "help", code: http.StatusOK, respType: ephemeral, text: "**buy [ticker] [shares]**\npurchase shares in a security with play money"},
+		{payload: "invalid", code: http.StatusOK, respType: ephemeral, text: "\"ten\" is not a whole number of shares\nUsage: `buy [ticker] [shares]`"},
+		{payload: "bad_token", code: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.payload, func(t *testing.T) {
+			code, resp := serve(s, captured(t, test.payload))
+			require.Equal(t, test.code, code)
+			if code != http.StatusOK {
+				return
+			}
+			require.Equal(t, test.respType, resp.ResponseType)
+			require.Contains(t, resp.Text, test.text)
+			if test.title != "" {
+				require.Len(t, resp.Attachments, 1)
+				require.Equal(t, test.title, resp.Attachments[0].Title)
+				require.Equal(t, colorDown, resp.Attachments[0].Color)
+				require.Equal(t, "http://finviz.com/chart.ashx?t=AMD&ty=c&ta=1&p=d&s=l", resp.Attachments[0].ImageURL)
+			}
+		})
+	}
+
+	// Team and user ids map to the team's keys
+	members, err := store.Members(context.Background(), "v2:mattermost-rdc9bgriktyx9p4kowh3dmgqyc:list:fun")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"AMD", "INTC"}, members)
+}
+
+func TestAuthorization(t *testing.T) {
+	s := New(engine.New(storage.NewMemory(), lookup()), map[string]string{token: "t", "other-token": "other"})
+
+	form := func(team string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/mattermost", strings.NewReader("team_id="+team+"&user_id=u&text=help"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Token "+token)
+		return req
+	}
+
+	code, _ := serve(s, form("t"))
+	require.Equal(t, http.StatusOK, code)
+
+	// A token only works for its own team
+	code, _ = serve(s, form("other"))
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	// Without tokens every request is rejected
+	code, _ = serve(New(nil, nil), captured(t, "quote"))
+	require.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestRender(t *testing.T) {
+	resp := render(&engine.Company{Company: &types.Company{CompanyName: "Advanced Micro Devices", Website: "https://amd.com", CEO: "Lisa Su"}})
+	require.Equal(t, &Response{
+		ResponseType: inchannel,
+		Attachments: []*Attachment{{
+			Fallback:  "Advanced Micro Devices",
+			Title:     "Advanced Micro Devices",
+			TitleLink: "https://amd.com",
+			Fields: []*Field{
+				{Title: "Industry", Short: true},
+				{Title: "CEO", Value: "Lisa Su", Short: true},
+			},
+		}},
+	}, resp)
+
+	resp = render(&engine.Export{URL: "https://example.com/export?sig=1"})
+	require.Equal(t, &Response{ResponseType: ephemeral, Text: "[Download your data](https://example.com/export?sig=1) (link expires in 0s)"}, resp)
+
+	// Symbols that don't exist are told apart from those that failed this time
+	resp = render(&engine.Quotes{List: stocktopus.WatchList{{Ticker: "AMD"}}, Missing: []string{"FAKE"}, Unavailable: []string{"SLOW"}})
+	require.True(t, strings.HasSuffix(resp.Text, "```\nNot found: FAKE\nTemporarily unavailable: SLOW"), resp.Text)
+}
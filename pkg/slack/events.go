@@ -0,0 +1,137 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thorfour/stocktopus/pkg/tracing"
+)
+
+var (
+	// ErrBadSignature is returned for requests that weren't signed by Slack
+	ErrBadSignature = errors.New("invalid request signature")
+
+	// ErrStaleRequest is returned for signed requests that are too old, which may be replays
+	ErrStaleRequest = errors.New("request timestamp too old")
+)
+
+const (
+	// maxRequestAge is the oldest signed request that's accepted
+	maxRequestAge = 5 * time.Minute
+
+	// maxEventSize limits the size of an event body
+	maxEventSize = 1 << 20
+)
+
+// PurgeFunc deletes everything stored for a team
+type PurgeFunc func(ctx context.Context, team string) (int, error)
+
+// PurgeUserFunc deletes everything stored for a user
+type PurgeUserFunc func(ctx context.Context, team, user string) (int, error)
+
+type event struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	TeamID    string `json:"team_id"`
+	Event     struct {
+		Type   string `json:"type"`
+		Tokens struct {
+			OAuth []string `json:"oauth"`
+		} `json:"tokens"`
+	} `json:"event"`
+}
+
+// EventsHandler handles the Slack Events API. Requests must be signed with signingSecret. When a
+// workspace uninstalls stocktopus all of the workspace's data is purged, and when users revoke
+// their tokens only their own data is.
+func EventsHandler(signingSecret string, purgeTeam PurgeFunc, purgeUser PurgeUserFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		body, err := verify(signingSecret, req, time.Now())
+		if err != nil {
+			tracing.Log(req.Context()).WithField("msg", "event rejected").Warn(err)
+			http.Error(resp, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		e := &event{}
+		if err := json.Unmarshal(body, e); err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch e.Type {
+		case "url_verification":
+			resp.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(resp, e.Challenge)
+			return
+		case "event_callback":
+		default:
+			return
+		}
+
+		switch e.Event.Type {
+		case "app_uninstalled":
+			n, err := purgeTeam(req.Context(), e.TeamID)
+			if err != nil {
+				tracing.Log(req.Context()).WithField("team", e.TeamID).WithField("msg", "workspace purge failed").Error(err)
+				http.Error(resp, "purge failed", http.StatusInternalServerError) // Slack will retry
+				return
+			}
+			tracing.Log(req.Context()).WithField("team", e.TeamID).WithField("event", e.Event.Type).WithField("keys", n).Info("workspace purged")
+		case "tokens_revoked":
+			// Bot tokens are revoked along with an uninstall, which is handled by its own event
+			for _, user := range e.Event.Tokens.OAuth {
+				n, err := purgeUser(req.Context(), e.TeamID, user)
+				if err != nil {
+					tracing.Log(req.Context()).WithField("team", e.TeamID).WithField("user", user).WithField("msg", "user purge failed").Error(err)
+					http.Error(resp, "purge failed", http.StatusInternalServerError)
+					return
+				}
+				tracing.Log(req.Context()).WithField("team", e.TeamID).WithField("user", user).WithField("keys", n).Info("user purged")
+			}
+		}
+	}
+}
+
+// verify checks a request was signed by Slack and returns its body
+func verify(signingSecret string, req *http.Request, now time.Time) ([]byte, error) {
+	if signingSecret == "" {
+		return nil, ErrBadSignature
+	}
+
+	ts, err := strconv.ParseInt(req.Header.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > maxRequestAge || age < -maxRequestAge {
+		return nil, ErrStaleRequest
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, req.Body, maxEventSize))
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	fmt.Fprintf(mac, "v0:%d:", ts)
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(req.Header.Get("X-Slack-Signature"))) {
+		return nil, ErrBadSignature
+	}
+
+	// Leave the body readable for anything further down the chain
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
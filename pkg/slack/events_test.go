@@ -0,0 +1,111 @@
+package slack
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func signedRequest(secret, body string, ts time.Time) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(body))
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%d:%s", ts.Unix(), body)
+	req.Header.Set("X-Slack-Request-Timestamp", fmt.Sprint(ts.Unix()))
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestEventsHandler(t *testing.T) {
+	var purged []string
+	h := EventsHandler("secret", func(_ context.Context, team string) (int, error) {
+		purged = append(purged, team)
+		return 1, nil
+	}, func(_ context.Context, team, user string) (int, error) {
+		purged = append(purged, team+"/"+user)
+		return 1, nil
+	})
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		code   int
+		body   string
+		purged []string
+	}{
+		{
+			name: "challenge",
+			req:  signedRequest("secret", `{"type":"url_verification","challenge":"abc"}`, time.Now()),
+			code: http.StatusOK,
+			body: "abc",
+		},
+		{
+			name:   "uninstalled",
+			req:    signedRequest("secret", `{"type":"event_callback","team_id":"T1","event":{"type":"app_uninstalled"}}`, time.Now()),
+			code:   http.StatusOK,
+			purged: []string{"T1"},
+		},
+		{
+			name:   "tokens revoked",
+			req:    signedRequest("secret", `{"type":"event_callback","team_id":"T2","event":{"type":"tokens_revoked","tokens":{"oauth":["U1","U2"],"bot":["B1"]}}}`, time.Now()),
+			code:   http.StatusOK,
+			purged: []string{"T2/U1", "T2/U2"},
+		},
+		{
+			name: "bot token revoked",
+			req:  signedRequest("secret", `{"type":"event_callback","team_id":"T2","event":{"type":"tokens_revoked","tokens":{"bot":["B1"]}}}`, time.Now()),
+			code: http.StatusOK,
+		},
+		{
+			name: "other event",
+			req:  signedRequest("secret", `{"type":"event_callback","team_id":"T3","event":{"type":"message"}}`, time.Now()),
+			code: http.StatusOK,
+		},
+		{
+			name: "wrong secret",
+			req:  signedRequest("other", `{"type":"event_callback","team_id":"T4","event":{"type":"app_uninstalled"}}`, time.Now()),
+			code: http.StatusUnauthorized,
+		},
+		{
+			name: "replayed",
+			req:  signedRequest("secret", `{"type":"event_callback","team_id":"T5","event":{"type":"app_uninstalled"}}`, time.Now().Add(-time.Hour)),
+			code: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			purged = nil
+			resp := httptest.NewRecorder()
+			h(resp, test.req)
+			require.Equal(t, test.code, resp.Code)
+			if test.body != "" {
+				require.Equal(t, test.body, resp.Body.String())
+			}
+			require.Equal(t, test.purged, purged)
+		})
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		got = req
+		fmt.Fprint(resp, `{"ok":false,"error":"channel_not_found"}`)
+	}))
+	defer srv.Close()
+
+	n := NewNotifier("xoxb-token")
+	n.url = srv.URL
+	err := n.Warn(context.Background(), "T1", "U1", time.Now())
+	require.EqualError(t, err, "post message failed: channel_not_found")
+	require.Equal(t, "Bearer xoxb-token", got.Header.Get("Authorization"))
+}
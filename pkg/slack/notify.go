@@ -0,0 +1,68 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const postMessageURL = "https://slack.com/api/chat.postMessage"
+
+// Notifier sends retention warnings to users as a direct message from the bot
+type Notifier struct {
+	token  string
+	url    string
+	client *http.Client
+}
+
+// NewNotifier returns a Notifier that posts messages with a bot token
+func NewNotifier(botToken string) *Notifier {
+	return &Notifier{
+		token:  botToken,
+		url:    postMessageURL,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Warn tells a user when their data will be deleted unless they use stocktopus again
+func (n *Notifier) Warn(ctx context.Context, team, user string, deleteAt time.Time) error {
+	msg := map[string]string{
+		"channel": user,
+		"text": fmt.Sprintf("You haven't used stocktopus in a while, so your watch lists and portfolio will be deleted on %s. "+
+			"Look at or change them, e.g. `/stocktopus list` or `/stocktopus portfolio`, to keep them, or `/stocktopus forget me` to delete them now.", deleteAt.UTC().Format("January 2, 2006")),
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+n.token)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post message failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("post message failed: %s", resp.Status)
+	}
+	if !result.OK {
+		return fmt.Errorf("post message failed: %s", result.Error)
+	}
+
+	return nil
+}
@@ -3,65 +3,15 @@ package slack
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
-	"strconv"
+	"runtime/debug"
 	"strings"
-	"time"
 
-	"github.com/go-redis/redis/v8"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/sirupsen/logrus"
-	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/engine"
 	"github.com/thorfour/stocktopus/pkg/stocktopus"
-)
-
-var (
-	// ErrNumArgs returned if the correct number of args isn't found
-	ErrNumArgs = fmt.Errorf("Incorrect number of args")
-)
-
-const (
-	helpStr = `*ticker* Displays the latest news for a company
-*[tickers...]*       pull stock quotes for list of tickers
-
-*list (list)*        print out personal watch list
-*watch (list) [tickers...]* add tickers to personal watch list
-*unwatch (list) [tickers...]*   remove single ticker from watch list
-*clear*  remove entire watch list
-
-*deposit [amount]* deposit amount of play money into account
-*sell [ticker] [shares]* Sells number of shares of specified security
-*buy [ticker] [shares]* Purchases number of shares in a security with play money
-*reset resets account
-*portfolio* Prints current portfolio of play money
-
-*stats ticker* print statistics about a company
-*info [ticker]* print a company profile
-
-*help* print this list`
-)
-
-// Supported commands
-const (
-	addToList      = "WATCH"
-	printList      = "LIST"
-	removeFromList = "UNWATCH"
-	clear          = "CLEAR"
-	help           = "HELP"
-	infoCmd        = "INFO"
-	news           = "NEWS"
-	stats          = "STATS"
-
-	// Play money commands
-	buy       = "BUY"
-	sell      = "SELL"
-	deposit   = "DEPOSIT"
-	portfolio = "PORTFOLIO"
-	reset     = "RESET"
+	"github.com/thorfour/stocktopus/pkg/tracing"
 )
 
 const (
@@ -77,29 +27,12 @@ type Response struct {
 
 // SlashServer is a slack server that handles slash commands
 type SlashServer struct {
-	s       *stocktopus.Stocktopus
-	cmdHist *prometheus.HistogramVec
-}
-
-// measureTime is a helper function to measure the execution time of a function
-func (s *SlashServer) measureTime(start time.Time, label string) {
-	s.cmdHist.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	e *engine.Engine
 }
 
-// New returns a new slash server
-func New(kvstore *redis.Client, stocks stock.Lookup) *SlashServer {
-	return &SlashServer{
-		s: &stocktopus.Stocktopus{
-			KVStore:        kvstore,
-			StockInterface: stocks,
-		},
-		cmdHist: promauto.NewHistogramVec(prometheus.HistogramOpts{
-			Name: "command_timings",
-			Help: "A histogram of cmd request execution times",
-		},
-			[]string{"command"},
-		),
-	}
+// New returns a new slash server that runs commands with e
+func New(e *engine.Engine) *SlashServer {
+	return &SlashServer{e: e}
 }
 
 // Handler is a http handler func for processing slack slash requests for stocktopus
@@ -107,7 +40,7 @@ func (s *SlashServer) Handler(resp http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 
 	if err := req.ParseForm(); err != nil {
-		logrus.WithField("msg", "error parse form").Error(err)
+		tracing.Log(ctx).WithField("msg", "error parse form").Error(err)
 		http.Error(resp, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -116,268 +49,127 @@ func (s *SlashServer) Handler(resp http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		msg = &Response{
 			ResponseType: ephemeral,
-			Text:         errors.Unwrap(err).Error(),
+			Text:         stocktopus.UserMessage(err),
 		}
 	}
 
 	resp.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(resp).Encode(msg); err != nil {
-		logrus.WithField("msg", "encoding failure").Error(err)
+		tracing.Log(ctx).WithField("msg", "encoding failure").Error(err)
 		http.Error(resp, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
+// Recover responds with a generic ephemeral error when next panics, so the user isn't left
+// without a reply
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if r == http.ErrAbortHandler {
+				panic(r) // Deliberately aborted, let net/http handle it
+			}
+
+			tracing.Log(req.Context()).WithField("msg", "panic").WithField("stack", string(debug.Stack())).Error(r)
+			resp.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(resp).Encode(&Response{
+				ResponseType: ephemeral,
+				Text:         stocktopus.InternalErrorMessage,
+			})
+		}()
+
+		next.ServeHTTP(resp, req)
+	})
+}
+
 // Process a slack request
 func (s *SlashServer) Process(ctx context.Context, args url.Values) (*Response, error) {
 	text, ok := args["text"]
 	if !ok {
-		return nil, errors.New("Bad request")
+		return nil, &stocktopus.UserError{Message: "Bad request"}
 	}
 
 	if len(text) == 0 {
-		return nil, errors.New("Empty request")
+		return nil, &stocktopus.UserError{Message: "Empty request"}
 	}
-	text = strings.Split(strings.ToUpper(text[0]), " ")
 
-	if len(text) > 1 {
-		return s.command(ctx, text[0], text[1:], args)
+	result, err := s.e.Run(ctx, &engine.Request{
+		Platform: engine.Slack,
+		Team:     args.Get("team_id"),
+		User:     args.Get("user_id"),
+		Channel:  args.Get("channel_id"),
+		Text:     text[0],
+		Token:    args.Get("token"),
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return s.command(ctx, text[0], nil, args)
+	return render(result), nil
 }
 
-// Command processes a stocktopus command
-func (s *SlashServer) command(ctx context.Context, cmd string, args []string, info map[string][]string) (*Response, error) {
-	defer s.measureTime(time.Now(), cmd)
-
-	switch cmd {
-	case buy:
-		if len(args) != 2 {
-			return nil, ErrNumArgs
-		}
-
-		shares, err := strconv.Atoi(args[1])
-		if err != nil {
-			return nil, err
-		}
-
-		if _, err := s.s.Buy(ctx, args[0], uint64(shares), acctKey(info)); err != nil {
-			return nil, fmt.Errorf("Buy failed: %w", err)
-		}
-
-		return &Response{
-			ResponseType: ephemeral,
-			Text:         "Done",
-		}, nil
-
-	case sell:
-		if len(args) != 2 {
-			return nil, ErrNumArgs
-		}
-
-		shares, err := strconv.Atoi(args[1])
-		if err != nil {
-			return nil, err
-		}
-
-		if _, err := s.s.Sell(ctx, args[0], uint64(shares), acctKey(info)); err != nil {
-			return nil, fmt.Errorf("Sell failed: %w", err)
-		}
-
-		return &Response{
-			ResponseType: ephemeral,
-			Text:         "Done",
-		}, nil
-
-	case deposit:
-		if len(args) != 1 {
-			return nil, ErrNumArgs
-		}
-		amount, err := strconv.Atoi(args[0])
-		if err != nil {
-			return nil, err
-		}
-
-		a, err := s.s.Deposit(ctx, float64(amount), acctKey(info))
-		if err != nil {
-			return nil, fmt.Errorf("Deposit failed: %w", err)
-		}
-
-		return &Response{
-			ResponseType: ephemeral,
-			Text:         fmt.Sprintf("New Balance: %v", a.Balance),
-		}, nil
-
-	case portfolio:
-		if len(args) != 0 {
-			return nil, ErrNumArgs
-		}
-		a, err := s.s.Portfolio(ctx, acctKey(info))
-		if err != nil {
-			return nil, fmt.Errorf("Portfolio failed: %w", err)
-		}
-
-		a, err = s.s.Latest(ctx, a)
-		if err != nil {
-			return nil, fmt.Errorf("Latest failed: %w", err)
-		}
-
-		return &Response{
-			ResponseType: inchannel,
-			Text:         fmt.Sprintf("```%s```", a),
-		}, nil
-
-	case reset:
-		if len(args) != 0 {
-			return nil, ErrNumArgs
-		}
-		if err := s.s.Clear(ctx, acctKey(info)); err != nil {
-			return nil, fmt.Errorf("Clear failed: %w", err)
-		}
-
-		return &Response{
-			ResponseType: ephemeral,
-			Text:         "New Balance: 0",
-		}, nil
-
-	case addToList:
-		if len(args) == 0 {
-			return nil, ErrNumArgs
-		}
-		if err := s.s.Add(ctx, args, listkey(args, info)); err != nil {
-			return nil, fmt.Errorf("Add failed: %w", err)
-		}
-
-		return &Response{
-			ResponseType: ephemeral,
-			Text:         "Added",
-		}, nil
-
-	case printList:
-		a, err := s.s.Print(ctx, listkey(args, info))
-		if err != nil {
-			return nil, fmt.Errorf("Print failed: %w", err)
-		}
-
-		// TODO get chart link
-
-		return &Response{
-			ResponseType: inchannel,
-			Text:         fmt.Sprintf("```%s```", a),
-		}, nil
-
-	case removeFromList:
-		if err := s.s.Remove(ctx, args, listkey(args, info)); err != nil {
-			return nil, fmt.Errorf("Remove failed: %w", err)
-		}
-
-		return &Response{
-			ResponseType: ephemeral,
-			Text:         "Removed",
-		}, nil
-	case clear:
-		if err := s.s.Clear(ctx, listkey(args, info)); err != nil {
-			return nil, fmt.Errorf("Clear failed: %w", err)
-		}
-
-		return &Response{
-			ResponseType: ephemeral,
-			Text:         "Removed",
-		}, nil
-
-	case infoCmd:
-		if len(args) != 1 {
-			return nil, ErrNumArgs
-		}
-		c, err := s.s.Info(args[0])
-		if err != nil {
-			return nil, fmt.Errorf("Info failed: %w", err)
-		}
-
-		return &Response{
-			ResponseType: inchannel,
-			Text:         strings.Join([]string{c.CompanyName, c.Industry, c.Website, c.CEO, c.Description}, "\n"),
-		}, nil
-
-	case news:
-		if len(args) != 1 {
-			return nil, ErrNumArgs
-		}
-		news, err := s.s.News(args[0])
-		if err != nil {
-			return nil, fmt.Errorf("News failed: %w", err)
-		}
-
-		return &Response{
-			ResponseType: inchannel,
-			Text:         strings.Join(news, "\n\n"),
-		}, nil
-
-	case stats:
-		if len(args) != 1 {
-			return nil, ErrNumArgs
-		}
-		stats, err := s.s.Stats(args[0])
-		if err != nil {
-			return nil, fmt.Errorf("Stats failed: %w", err)
-		}
-
-		// TODO filter stats?
-
-		return &Response{
-			ResponseType: inchannel,
-			Text:         fmt.Sprintf("```%s```", stocktopus.Stats(stats)),
-		}, nil
-
-	case help:
-		return &Response{
-			ResponseType: ephemeral,
-			Text:         helpStr,
-		}, nil
+// render formats a command result for Slack
+func render(result engine.Result) *Response {
+	resp := &Response{ResponseType: ephemeral}
+	if result.Public() {
+		resp.ResponseType = inchannel
+	}
 
+	switch r := result.(type) {
+	case *engine.Message:
+		resp.Text = r.Text
+	case *engine.Quotes:
+		if r.Chart != "" {
+			resp.Text = fmt.Sprintf("```%s%s\n%s```\n%s", r.List, stocktopus.MissingText(r.Missing, r.Unavailable, r.RetryAfter), stocktopus.Details(r.List[0]), r.Chart)
+		} else {
+			resp.Text = fmt.Sprintf("```%s%s```", r.List, stocktopus.MissingText(r.Missing, r.Unavailable, r.RetryAfter))
+		}
+	case *engine.Balance:
+		resp.Text = fmt.Sprintf("New Balance: %v", r.Balance)
+	case *engine.Portfolio:
+		resp.Text = fmt.Sprintf("```%s```", r.Account)
+	case *engine.Company:
+		resp.Text = strings.Join([]string{r.CompanyName, r.Industry, r.Website, r.CEO, r.Description}, "\n")
+	case *engine.News:
+		resp.Text = strings.Join(r.Headlines, "\n\n")
+	case *engine.Stats:
+		resp.Text = fmt.Sprintf("```%s```", stocktopus.Stats(r.Stats))
+	case *engine.History:
+		resp.Text = fmt.Sprintf("```%s```", r.Performance)
+	case *engine.Search:
+		resp.Text = fmt.Sprintf("```%s```", r.Results)
+	case *engine.Export:
+		resp.Text = fmt.Sprintf("<%s|Download your data> (link expires in %v)", r.URL, r.Expires)
+	case *engine.ImportPreview:
+		resp.Text = fmt.Sprintf("```%s```\nRun `import confirm` to apply or `import cancel` to discard", r.Import)
+	case *engine.Imported:
+		resp.Text = fmt.Sprintf("Imported %v tickers and %v positions", len(r.Import.Tickers), len(r.Import.Positions))
+	case *engine.Help:
+		resp.Text = help(r)
 	default:
-		// treat cmd as a ticker
-		args = append(args, cmd)
+		resp.Text = stocktopus.InternalErrorMessage
+	}
 
-		wl, err := s.s.GetQuotes(args)
-		if err != nil {
-			return nil, fmt.Errorf("GetQuotes failed: %w", err)
-		}
+	return resp
+}
 
-		// Return quote with chart link it only a single ticker was returned
-		if len(wl) == 1 {
-			chartlink := s.s.GetChartLink(wl[0].Ticker)
-			return &Response{
-				ResponseType: inchannel,
-				Text:         fmt.Sprintf("```%s```\n%s", wl, chartlink),
-			}, nil
+// help lists every command, or shows how to use a single command
+func help(h *engine.Help) string {
+	if h.Command != nil {
+		lines := []string{fmt.Sprintf("*%s*", h.Command.Usage()), h.Command.Summary}
+		for _, f := range h.Command.Flags {
+			lines = append(lines, fmt.Sprintf("`--%s` %s", f.Name, f.Usage))
 		}
-
-		return &Response{
-			ResponseType: inchannel,
-			Text:         fmt.Sprintf("```%s```", wl),
-		}, nil
+		return strings.Join(lines, "\n")
 	}
-}
 
-func listkey(text []string, decodedMap url.Values) string {
-
-	// User and token to be used as watch list lookup
-	user := decodedMap["user_id"]
-	token := decodedMap["token"]
-
-	// If the first arg starts with '#' then it's the name of the list
-	if len(text) > 0 && strings.HasPrefix(text[0], "#") {
-		user = []string{strings.ToLower(text[0][1:]), decodedMap["team_id"][0]}
+	lines := make([]string, 0, len(h.Specs))
+	for _, spec := range h.Specs {
+		lines = append(lines, fmt.Sprintf("*%s* %s", spec.Usage(), spec.Summary))
 	}
-
-	return fmt.Sprintf("%v%v", token, user)
-}
-
-func acctKey(decodedMap url.Values) string {
-	// User and token to be used as lookup
-	user := decodedMap["user_id"]
-	token := decodedMap["token"]
-	return fmt.Sprintf("%v%v%v", "ACCT", token, user)
+	return strings.Join(lines, "\n")
 }
@@ -2,170 +2,105 @@ package slack
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
-	"github.com/alicebob/miniredis"
-	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/require"
 	"github.com/thorfour/iex/pkg/types"
+	"github.com/thorfour/stocktopus/pkg/engine"
 	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stock/stocktest"
 	"github.com/thorfour/stocktopus/pkg/stocktopus"
+	"github.com/thorfour/stocktopus/pkg/storage"
 )
 
-// fakeLookup implements the stock.Lookup interface
-type fakeLookup struct {
-	fakeQuotes  []*stock.Quote
-	fakeCompany *types.Company
-	fakeStats   *types.Stats
-	fakeNews    []string
+// brokenStore fails to add members with an internal error
+type brokenStore struct {
+	storage.Store
 }
 
-func (f *fakeLookup) Price(string) (float64, error)                { return 1.00, nil }
-func (f *fakeLookup) BatchQuotes([]string) ([]*stock.Quote, error) { return f.fakeQuotes, nil }
-func (f *fakeLookup) News(string) ([]string, error)                { return f.fakeNews, nil }
-func (f *fakeLookup) Stats(string) (*types.Stats, error)           { return f.fakeStats, nil }
-func (f *fakeLookup) Company(string) (*types.Company, error)       { return f.fakeCompany, nil }
+func (brokenStore) AddMembers(context.Context, string, ...string) error {
+	return errors.New("SAdd failed: connection reset")
+}
+
+func TestHandler(t *testing.T) {
+	s := New(engine.New(brokenStore{storage.NewMemory()}, &stocktest.Lookup{}))
+
+	tests := map[string]string{
+		"buy amd":        "Missing shares\nUsage: `buy [ticker] [shares]`",
+		"buy amd ten":    "\"ten\" is not a whole number of shares\nUsage: `buy [ticker] [shares]`",
+		"sell amd -1":    "\"-1\" is not a whole number of shares\nUsage: `sell [ticker] [shares]`",
+		"deposit lots":   "\"lots\" is not a dollar amount\nUsage: `deposit [amount]`",
+		"history amd 7x": "Unknown range \"7x\"\nUsage: `history [ticker] [range] (interval)`",
+		"watch amd":      stocktopus.InternalErrorMessage,
+	}
 
-func TestCommands(t *testing.T) {
+	for text, expected := range tests {
+		t.Run(text, func(t *testing.T) {
+			form := url.Values{"text": {text}, "user_id": {"test"}, "team_id": {"team"}}
+			req := httptest.NewRequest(http.MethodPost, "/v1", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			resp := httptest.NewRecorder()
+			s.Handler(resp, req)
 
-	// Start mini redis instance to connect to
-	mr, err := miniredis.Run()
-	require.NoError(t, err)
-	defer mr.Close()
+			msg := &Response{}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(msg))
+			require.Equal(t, ephemeral, msg.ResponseType)
+			require.Equal(t, expected, msg.Text)
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	h := Recover(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		var s *SlashServer
+		s.e.Run(context.Background(), nil)
+	}))
+
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/v1", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	msg := &Response{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(msg))
+	require.Equal(t, &Response{ResponseType: ephemeral, Text: stocktopus.InternalErrorMessage}, msg)
+}
 
-	s := New(
-		redis.NewClient(&redis.Options{
-			Addr: mr.Addr(),
-		}),
-		&fakeLookup{
-			fakeQuotes: []*stock.Quote{
-				{
-					Ticker:        "AMD",
-					LatestPrice:   1.00,
-					Change:        0,
-					ChangePercent: 0,
-				},
-			},
-			fakeCompany: &types.Company{},
-			fakeStats:   &types.Stats{},
-			fakeNews:    []string{},
-		},
-	)
+func TestProcess(t *testing.T) {
+	s := New(engine.New(storage.NewMemory(), &stocktest.Lookup{
+		Headlines: []string{"a", "b"},
+		Quotes:    []*stock.Quote{{Ticker: "AMD", LatestPrice: 2}},
+		Profile:   &types.Company{CompanyName: "Advanced Micro Devices", CEO: "Lisa Su"},
+	}))
 
+	// Run in order, list shows what watch added
 	tests := []struct {
-		name string
-		text string
-		err  error
+		text     string
+		respType string
+		contains string
 	}{
-		{
-			name: "single quote",
-			text: "amd",
-		},
-		{
-			name: "retrieve empty group list",
-			text: "list #mylist",
-			err:  stocktopus.ErrNoList,
-		},
-		{
-			name: "retrieve empty list",
-			text: "list",
-			err:  stocktopus.ErrNoList,
-		},
-		{
-			name: "add to group list",
-			text: "watch #mylist amd",
-		},
-		{
-			name: "add to list",
-			text: "watch amd",
-		},
-		{
-			name: "retrieve group list",
-			text: "list #mylist",
-		},
-		{
-			name: "retrieve list",
-			text: "list",
-		},
-		{
-			name: "unwatch list",
-			text: "unwatch amd",
-		},
-		{
-			name: "unwatch group list",
-			text: "unwatch #mylist amd",
-		},
-		{
-			name: "clear list",
-			text: "clear",
-		},
-		{
-			name: "clear group list",
-			text: "clear #mylist",
-		},
-		{
-			name: "deposit",
-			text: "deposit 100",
-		},
-		{
-			name: "reset",
-			text: "reset",
-		},
-		{
-			name: "portfolio",
-			text: "portfolio",
-		},
-		{
-			name: "buy insufficient",
-			text: "buy amd 1",
-			err:  stocktopus.ErrInsufficientFunds,
-		},
-		{
-			name: "deposit 1k",
-			text: "deposit 1000",
-		},
-		{
-			name: "buy amd",
-			text: "buy amd 1",
-		},
-		{
-			name: "portfolio with holdings",
-			text: "portfolio",
-		},
-		{
-			name: "sell amd too many",
-			text: "sell amd 10",
-			err:  stocktopus.ErrNumShares,
-		},
-		{
-			name: "sell amd",
-			text: "sell amd 1",
-		},
-		{
-			name: "info",
-			text: "info amd",
-		},
-		{
-			name: "stats",
-			text: "stats amd",
-		},
-		{
-			name: "news",
-			text: "news amd",
-		},
+		{text: "watch amd", respType: ephemeral, contains: "Added"},
+		{text: "deposit 10", respType: ephemeral, contains: "New Balance: 10"},
+		{text: "info amd", respType: inchannel, contains: "Advanced Micro Devices\n\n\nLisa Su\n"},
+		{text: "news amd", respType: inchannel, contains: "a\n\nb"},
+		{text: "amd", respType: inchannel, contains: "http://finviz.com/chart.ashx?t=AMD"},
+		{text: "list", respType: inchannel, contains: "AMD"},
+		{text: "help", respType: ephemeral, contains: "*buy [ticker] [shares]* purchase shares in a security with play money"},
+		{text: "help list", respType: ephemeral, contains: "*list (#list) (--sort change|ticker|price)*\nprint out your watch list, or a team #list\n`--sort` orders"},
 	}
 
 	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			v := url.Values{}
-			v.Add("user_id", "test")
-			v.Add("token", "token")
-			v.Add("team_id", "team")
-			v.Add("text", test.text)
-			_, err := s.Process(context.Background(), v)
-			require.True(t, errors.Is(err, test.err))
+		t.Run(test.text, func(t *testing.T) {
+			v := url.Values{"text": {test.text}, "user_id": {"test"}, "team_id": {"team"}}
+			resp, err := s.Process(context.Background(), v)
+			require.NoError(t, err)
+			require.Equal(t, test.respType, resp.ResponseType)
+			require.Contains(t, resp.Text, test.contains)
 		})
 	}
 }
@@ -1,8 +1,15 @@
 package stock
 
 import (
+	"context"
+	"encoding/csv"
 	"fmt"
-	"sync"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	av "github.com/cmckee-dev/go-alpha-vantage"
 	iex "github.com/thorfour/iex/pkg/api"
@@ -13,68 +20,207 @@ import (
 type AlphaWrapper struct {
 	// APIKey is the API key from alpha vantage
 	APIKey string
+
+	// Limiter is shared by all calls to alpha vantage, nil disables rate limiting
+	Limiter *Limiter
+}
+
+// NewAlphaWrapper returns an AlphaWrapper limited to the free tier quota
+func NewAlphaWrapper(apiKey string) *AlphaWrapper {
+	return &AlphaWrapper{
+		APIKey:  apiKey,
+		Limiter: NewLimiter("alphavantage", DefaultAlphaQuota),
+	}
 }
 
 // Price reutrns the current price of the ticker
 func (w *AlphaWrapper) Price(ticker string) (float64, error) {
 	client := av.NewClient(w.APIKey)
 
+	if err := w.Limiter.Wait(context.Background()); err != nil {
+		return 0, err
+	}
+
 	series, err := client.StockTimeSeriesIntraday(av.TimeIntervalOneMinute, ticker)
 	if err != nil {
 		return 0, err
 	}
+	if len(series) == 0 {
+		return 0, ErrUnknownSymbol
+	}
 
 	return series[len(series)-1].Close, nil
 }
 
+// alphaWorkers is the number of alpha vantage requests made in parallel
+const alphaWorkers = 5
+
+// alphaURL is the alpha vantage query endpoint
+const alphaURL = "https://www.alphavantage.co/query"
+
 // BatchQuotes returns a slice of quotes for the given tickers
 func (w *AlphaWrapper) BatchQuotes(tickers []string) ([]*Quote, error) {
-	client := av.NewClient(w.APIKey)
+	// AlphaVantage doesn't provide batch requests, make one request per ticker
+	return batchQuotes(tickers, 1, alphaWorkers, func(symbols []string) ([]*Quote, error) {
+		if err := w.Limiter.Wait(context.Background()); err != nil {
+			return nil, err
+		}
+
+		q, err := w.globalQuote(symbols[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return []*Quote{q}, nil
+	})
+}
+
+// globalQuote requests the GLOBAL_QUOTE endpoint, which the alpha vantage library doesn't wrap
+func (w *AlphaWrapper) globalQuote(symbol string) (*Quote, error) {
+	params := url.Values{}
+	params.Set("function", "GLOBAL_QUOTE")
+	params.Set("symbol", symbol)
+	params.Set("apikey", w.APIKey)
+	params.Set("datatype", "csv")
+
+	resp, err := http.Get(alphaURL + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	// AlphaVantage doesn't provide batch requests, make them all in parallel
-	resp := make(chan *Quote, len(tickers))
-	errCh := make(chan error, len(tickers))
-	wg := new(sync.WaitGroup)
-	wg.Add(len(tickers))
-	for _, ticker := range tickers {
-		go func(symbol string) {
-			defer wg.Done()
-			series, err := client.StockTimeSeriesIntraday(av.TimeIntervalOneMinute, symbol)
-			if err != nil {
-				errCh <- err
-				return
-			}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("alpha vantage request failed: %s", resp.Status)
+	}
 
-			// convert the series into a quote object
-			q := &Quote{
-				Ticker:        symbol,
-				LatestPrice:   series[len(series)-1].Close,
-				Change:        series[len(series)-1].Close - series[0].Close,
-				ChangePercent: ((series[len(series)-1].Close - series[0].Close) / series[len(series)-1].Close), // NOTE: return as a decimal percentage i.e 0.10 = 10%
-			}
+	return parseGlobalQuote(symbol, resp.Body)
+}
 
-			// return the quotes
-			resp <- q
+// parseGlobalQuote parses a GLOBAL_QUOTE csv response. Blank change and volume columns are marked
+// unavailable, the change is worked out from the previous close when it's given.
+func parseGlobalQuote(symbol string, body io.Reader) (*Quote, error) {
+	r := csv.NewReader(body)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unexpected alpha vantage response: %w", err)
+	}
 
-		}(ticker)
+	// symbol,open,high,low,price,volume,latestDay,previousClose,change,changePercent
+	if len(records) == 0 || len(records[0]) != 10 {
+		return nil, fmt.Errorf("unexpected alpha vantage response")
+	}
+	if len(records) < 2 || len(records[1]) != 10 {
+		return nil, ErrUnknownSymbol
 	}
 
-	wg.Wait()
-	close(resp)
-	close(errCh)
+	rec := records[1]
+	f := make([]float64, len(rec))
+	has := make([]bool, len(rec))
+	for _, i := range []int{1, 2, 3, 4, 5, 7, 8, 9} {
+		v := strings.TrimSuffix(strings.TrimSpace(rec[i]), "%")
+		if v == "" {
+			continue
+		}
+		if f[i], err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("unable to parse quote: %w", err)
+		}
+		has[i] = true
+	}
+	if !has[4] {
+		return nil, fmt.Errorf("unable to parse quote: no price for %s", symbol)
+	}
+	latestDay, err := time.ParseInLocation("2006-01-02", rec[6], marketTZ)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse quote: %w", err)
+	}
 
-	// check for errors
-	if len(errCh) != 0 {
-		return nil, <-errCh
+	q := &Quote{
+		Ticker:        symbol,
+		Open:          f[1],
+		High:          f[2],
+		Low:           f[3],
+		LatestPrice:   f[4],
+		Volume:        f[5],
+		PreviousClose: f[7],
+		Change:        f[8],
+		ChangePercent: f[9] / 100, // NOTE: return as a decimal percentage i.e 0.10 = 10%
+		LatestUpdate:  latestDay,
+		Closed:        !marketOpen(time.Now(), latestDay),
 	}
 
-	// Create list of quotes from responses
-	var quotes []*Quote
-	for q := range resp {
-		quotes = append(quotes, q)
+	switch {
+	case has[8] && has[9]:
+	case has[7] && f[7] != 0:
+		q.Change = q.LatestPrice - q.PreviousClose
+		q.ChangePercent = q.Change / q.PreviousClose
+	default:
+		q.Change, q.ChangePercent = 0, 0
+		q.Unavailable = append(q.Unavailable, FieldChange)
+	}
+	if !has[5] {
+		q.Unavailable = append(q.Unavailable, FieldVolume)
 	}
 
-	return quotes, nil
+	return q, nil
+}
+
+// Symbols returns every active listing from alpha vantage
+func (w *AlphaWrapper) Symbols() ([]*Symbol, error) {
+	if err := w.Limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("function", "LISTING_STATUS")
+	params.Set("apikey", w.APIKey)
+
+	resp, err := http.Get(alphaURL + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("alpha vantage request failed: %s", resp.Status)
+	}
+
+	r := csv.NewReader(resp.Body)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	// symbol,name,exchange,assetType,ipoDate,delistingDate,status
+	if len(records) == 0 || len(records[0]) != 7 {
+		return nil, fmt.Errorf("unexpected alpha vantage response")
+	}
+
+	symbols := make([]*Symbol, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		if len(rec) != 7 {
+			continue
+		}
+
+		symbols = append(symbols, &Symbol{
+			Ticker:   rec[0],
+			Name:     rec[1],
+			Exchange: rec[2],
+			Type:     rec[3],
+		})
+	}
+
+	return symbols, nil
+}
+
+// marketOpen returns true if now falls within regular US trading hours of the given trading day
+func marketOpen(now, day time.Time) bool {
+	now = now.In(marketTZ)
+	y, m, d := day.Date()
+	open := time.Date(y, m, d, 9, 30, 0, 0, marketTZ)
+	close := time.Date(y, m, d, 16, 0, 0, 0, marketTZ)
+	return !now.Before(open) && now.Before(close)
 }
 
 // News returns recent news for a ticker NOTE: alphavantage doesn't have a news API, so use IEX instead
@@ -101,3 +247,48 @@ func (w *AlphaWrapper) Stats(_ string) (*iextype.Stats, error) {
 func (w *AlphaWrapper) Company(_ string) (*iextype.Company, error) {
 	return nil, fmt.Errorf("Unimplemented Feature")
 }
+
+// History returns price history for a ticker over the given range. AlphaVantage only returns the
+// latest 100 points of a series, so longer ranges use coarser series.
+func (w *AlphaWrapper) History(ticker string, r Range, i Interval) ([]*Bar, error) {
+	client := av.NewClient(w.APIKey)
+
+	if err := w.Limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+
+	var series []*av.TimeSeriesValue
+	var err error
+	switch r {
+	case Range1Day:
+		series, err = client.StockTimeSeriesIntraday(av.TimeIntervalFiveMinute, ticker)
+	case Range5Days:
+		series, err = client.StockTimeSeriesIntraday(av.TimeIntervalThirtyMinute, ticker)
+	case Range1Month:
+		series, err = client.StockTimeSeries(av.TimeSeriesDaily, ticker)
+	case Range6Months, Range1Year:
+		series, err = client.StockTimeSeries(av.TimeSeriesWeekly, ticker)
+	default:
+		series, err = client.StockTimeSeries(av.TimeSeriesMonthly, ticker)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(series) == 0 {
+		return nil, ErrUnknownSymbol
+	}
+
+	bars := make([]*Bar, 0, len(series))
+	for _, v := range series {
+		bars = append(bars, &Bar{
+			Time:   v.Time,
+			Open:   v.Open,
+			High:   v.High,
+			Low:    v.Low,
+			Close:  v.Close,
+			Volume: v.Volume,
+		})
+	}
+
+	return shapeHistory(bars, r, i), nil
+}
@@ -0,0 +1,66 @@
+package stock
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGlobalQuote(t *testing.T) {
+	const header = "symbol,open,high,low,price,volume,latestDay,previousClose,change,changePercent\n"
+
+	tests := map[string]struct {
+		body        string
+		change      float64
+		percent     float64
+		volume      float64
+		unavailable []string
+		err         string
+	}{
+		"complete": {
+			body:   header + "AMD,48.0,51.0,47.5,50.0,1234567,2020-06-01,49.0,1.0,2.0408%\n",
+			change: 1, percent: 0.020408, volume: 1234567,
+		},
+		"change from previous close": {
+			body:   header + "AMD,48.0,51.0,47.5,50.0,1234567,2020-06-01,40.0,,\n",
+			change: 10, percent: 0.25, volume: 1234567,
+		},
+		"unavailable": {
+			body:        header + "AMD,48.0,51.0,47.5,50.0,,2020-06-01,,,\n",
+			unavailable: []string{FieldChange, FieldVolume},
+		},
+		"unknown symbol": {body: header, err: ErrUnknownSymbol.Error()},
+		"no price":       {body: header + "AMD,48.0,51.0,47.5,,1,2020-06-01,49.0,1.0,2%\n", err: "no price"},
+		"not a quote":    {body: `{"Note": "Thank you for using Alpha Vantage!"}`, err: "unexpected alpha vantage response"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			q, err := parseGlobalQuote("AMD", strings.NewReader(test.body))
+			if test.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, float64(50), q.LatestPrice)
+			require.InDelta(t, test.change, q.Change, 1e-9)
+			require.InDelta(t, test.percent, q.ChangePercent, 1e-6)
+			require.Equal(t, test.volume, q.Volume)
+			require.Equal(t, test.unavailable, q.Unavailable)
+			for _, f := range []string{FieldChange, FieldVolume} {
+				require.Equal(t, !contains(test.unavailable, f), q.Has(f), f)
+			}
+		})
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package stock
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownSymbol is returned for a symbol the provider has no quote for
+var ErrUnknownSymbol = errors.New("unknown symbol")
+
+// PartialError is returned by BatchQuotes when some, but not all, of the requested symbols
+// could not be quoted. The quotes that were found are returned alongside it.
+type PartialError struct {
+	// Errors maps each symbol that failed to the reason it failed
+	Errors map[string]error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("no quotes for %s", strings.Join(e.Symbols(), ", "))
+}
+
+// Symbols returns the sorted list of symbols that failed
+func (e *PartialError) Symbols() []string {
+	return e.symbols(func(error) bool { return true })
+}
+
+// Unknown returns the sorted list of symbols the provider has no quote for
+func (e *PartialError) Unknown() []string {
+	return e.symbols(func(err error) bool { return errors.Is(err, ErrUnknownSymbol) })
+}
+
+// Unavailable returns the sorted list of symbols that failed for any other reason, such as a
+// rate limit or network failure. Quoting them again later may work.
+func (e *PartialError) Unavailable() []string {
+	return e.symbols(func(err error) bool { return !errors.Is(err, ErrUnknownSymbol) })
+}
+
+// RetryAfter returns how long until every rate limited symbol could be quoted again, zero if none
+// were rate limited
+func (e *PartialError) RetryAfter() time.Duration {
+	var retry time.Duration
+	for _, err := range e.Errors {
+		var rateErr *RateLimitError
+		if errors.As(err, &rateErr) && rateErr.RetryAfter > retry {
+			retry = rateErr.RetryAfter
+		}
+	}
+	return retry
+}
+
+func (e *PartialError) symbols(match func(error) bool) []string {
+	symbols := make([]string, 0, len(e.Errors))
+	for s, err := range e.Errors {
+		if match(err) {
+			symbols = append(symbols, s)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// batchFunc fetches quotes for a single chunk of symbols. Symbols missing from the result are
+// treated as unknown.
+type batchFunc func([]string) ([]*Quote, error)
+
+// batchQuotes splits tickers into chunks of at most size symbols and fetches them with at most
+// workers chunks in flight. If no quotes are found the first error is returned, otherwise any
+// failed symbols are reported in a *PartialError.
+func batchQuotes(tickers []string, size, workers int, fetch batchFunc) ([]*Quote, error) {
+	tickers = dedup(tickers)
+	chunks := chunk(tickers, size)
+
+	var (
+		mu     sync.Mutex
+		quotes []*Quote
+		errs   = map[string]error{}
+	)
+
+	sem := make(chan struct{}, workers)
+	wg := new(sync.WaitGroup)
+	wg.Add(len(chunks))
+	for _, c := range chunks {
+		sem <- struct{}{}
+		go func(symbols []string) {
+			defer func() { <-sem }()
+			defer wg.Done()
+
+			found, err := fetch(symbols)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				for _, s := range symbols {
+					errs[s] = err
+				}
+				return
+			}
+
+			seen := map[string]bool{}
+			for _, q := range found {
+				seen[strings.ToUpper(q.Ticker)] = true
+			}
+			for _, s := range symbols {
+				if !seen[s] {
+					errs[s] = ErrUnknownSymbol
+				}
+			}
+			quotes = append(quotes, found...)
+		}(c)
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return quotes, nil
+	}
+
+	perr := &PartialError{Errors: errs}
+	if len(quotes) == 0 {
+		first := perr.Symbols()[0]
+		if errors.Is(errs[first], ErrUnknownSymbol) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownSymbol, first)
+		}
+		return nil, errs[first]
+	}
+
+	return quotes, perr
+}
+
+// chunk splits tickers into slices of at most size
+func chunk(tickers []string, size int) [][]string {
+	var chunks [][]string
+	for len(tickers) > size {
+		chunks = append(chunks, tickers[:size])
+		tickers = tickers[size:]
+	}
+	if len(tickers) > 0 {
+		chunks = append(chunks, tickers)
+	}
+	return chunks
+}
+
+// dedup upper cases tickers and removes empty and duplicate entries
+func dedup(tickers []string) []string {
+	seen := make(map[string]bool, len(tickers))
+	out := make([]string, 0, len(tickers))
+	for _, t := range tickers {
+		t = strings.ToUpper(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	return out
+}
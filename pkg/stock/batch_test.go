@@ -0,0 +1,90 @@
+package stock
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchQuotes(t *testing.T) {
+	var tickers []string
+	for i := 0; i < 250; i++ {
+		tickers = append(tickers, fmt.Sprintf("t%v", i))
+	}
+
+	var calls int32
+	quotes, err := batchQuotes(tickers, 100, 2, func(symbols []string) ([]*Quote, error) {
+		atomic.AddInt32(&calls, 1)
+		require.True(t, len(symbols) <= 100)
+
+		var quotes []*Quote
+		for _, s := range symbols {
+			quotes = append(quotes, &Quote{Ticker: s})
+		}
+		return quotes, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, quotes, 250)
+	require.Equal(t, int32(3), calls)
+}
+
+func TestBatchQuotesPartial(t *testing.T) {
+	fetch := func(symbols []string) ([]*Quote, error) {
+		var quotes []*Quote
+		for _, s := range symbols {
+			if s == "FAKE" || s == "BOGUS" {
+				continue
+			}
+			quotes = append(quotes, &Quote{Ticker: s})
+		}
+		return quotes, nil
+	}
+
+	quotes, err := batchQuotes([]string{"amd", "fake", "AMD", "bogus", "tsla"}, 2, 2, fetch)
+	require.Len(t, quotes, 2)
+
+	perr := &PartialError{}
+	require.True(t, errors.As(err, &perr))
+	require.Equal(t, []string{"BOGUS", "FAKE"}, perr.Symbols())
+	require.Equal(t, []string{"BOGUS", "FAKE"}, perr.Unknown())
+	require.Empty(t, perr.Unavailable())
+	require.True(t, errors.Is(perr.Errors["FAKE"], ErrUnknownSymbol))
+	require.Equal(t, "no quotes for BOGUS, FAKE", err.Error())
+
+	// Nothing found returns a plain error
+	quotes, err = batchQuotes([]string{"fake"}, 2, 2, fetch)
+	require.Nil(t, quotes)
+	require.True(t, errors.Is(err, ErrUnknownSymbol))
+	require.False(t, errors.As(err, &perr))
+}
+
+func TestBatchQuotesError(t *testing.T) {
+	fail := errors.New("request failed")
+	quotes, err := batchQuotes([]string{"amd", "tsla"}, 1, 1, func(symbols []string) ([]*Quote, error) {
+		if symbols[0] == "TSLA" {
+			return nil, fail
+		}
+		return []*Quote{{Ticker: symbols[0]}}, nil
+	})
+	require.Len(t, quotes, 1)
+
+	perr := &PartialError{}
+	require.True(t, errors.As(err, &perr))
+	require.Equal(t, fail, perr.Errors["TSLA"])
+	require.Empty(t, perr.Unknown())
+	require.Equal(t, []string{"TSLA"}, perr.Unavailable())
+	require.Zero(t, perr.RetryAfter())
+}
+
+func TestPartialRetryAfter(t *testing.T) {
+	perr := &PartialError{Errors: map[string]error{
+		"FAKE": ErrUnknownSymbol,
+		"AMD":  &RateLimitError{Provider: "alphavantage", RetryAfter: 12 * time.Second},
+		"TSLA": fmt.Errorf("quote failed: %w", &RateLimitError{Provider: "alphavantage", RetryAfter: 24 * time.Second}),
+	}}
+	require.Equal(t, 24*time.Second, perr.RetryAfter())
+}
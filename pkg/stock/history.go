@@ -0,0 +1,219 @@
+package stock
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Bar is a single open/high/low/close/volume bar of price history
+type Bar struct {
+	// Time is the start of the bar
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+}
+
+// Range is the span of price history to look up
+type Range string
+
+// Supported ranges
+const (
+	Range1Day    Range = "1d" // intraday
+	Range5Days   Range = "5d"
+	Range1Month  Range = "1m"
+	Range6Months Range = "6m"
+	Range1Year   Range = "1y"
+	Range5Years  Range = "5y"
+)
+
+// Ranges lists every supported range from shortest to longest
+var Ranges = []Range{Range1Day, Range5Days, Range1Month, Range6Months, Range1Year, Range5Years}
+
+// ParseRange parses a range such as 1d or 6M
+func ParseRange(s string) (Range, error) {
+	r := Range(strings.ToLower(s))
+	for _, valid := range Ranges {
+		if r == valid {
+			return r, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown range %q", s)
+}
+
+// DefaultInterval is the bar size used when no interval is requested for the range
+func (r Range) DefaultInterval() Interval {
+	switch r {
+	case Range1Day:
+		return Interval5Minutes
+	case Range5Days:
+		return Interval30Minutes
+	case Range5Years:
+		return Interval1Week
+	default:
+		return Interval1Day
+	}
+}
+
+// start returns the earliest time included in the range ending with the bar at end
+func (r Range) start(end time.Time) time.Time {
+	y, m, d := end.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, end.Location())
+	switch r {
+	case Range1Day:
+		return day
+	case Range5Days:
+		return day.AddDate(0, 0, -6)
+	case Range1Month:
+		return day.AddDate(0, -1, 0)
+	case Range6Months:
+		return day.AddDate(0, -6, 0)
+	case Range1Year:
+		return day.AddDate(-1, 0, 0)
+	default:
+		return day.AddDate(-5, 0, 0)
+	}
+}
+
+// Interval is the size of a single bar
+type Interval string
+
+// Supported intervals
+const (
+	Interval1Minute   Interval = "1min"
+	Interval5Minutes  Interval = "5min"
+	Interval30Minutes Interval = "30min"
+	Interval1Hour     Interval = "1h"
+	Interval1Day      Interval = "1d"
+	Interval1Week     Interval = "1wk"
+	Interval1Month    Interval = "1mo"
+)
+
+// Intervals lists every supported interval from shortest to longest
+var Intervals = []Interval{Interval1Minute, Interval5Minutes, Interval30Minutes, Interval1Hour, Interval1Day, Interval1Week, Interval1Month}
+
+// ParseInterval parses an interval such as 5min or 1wk
+func ParseInterval(s string) (Interval, error) {
+	i := Interval(strings.ToLower(s))
+	for _, valid := range Intervals {
+		if i == valid {
+			return i, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown interval %q", s)
+}
+
+// bucket returns the start of the bar that t falls in
+func (i Interval) bucket(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch i {
+	case Interval1Minute:
+		return t.Truncate(time.Minute)
+	case Interval5Minutes:
+		return t.Truncate(5 * time.Minute)
+	case Interval30Minutes:
+		return t.Truncate(30 * time.Minute)
+	case Interval1Hour:
+		return t.Truncate(time.Hour)
+	case Interval1Week:
+		day := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		offset := (int(day.Weekday()) + 6) % 7 // weeks start on Monday
+		return day.AddDate(0, 0, -offset)
+	case Interval1Month:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// shapeHistory sorts bars, drops any outside of the range and merges them into bars of the given
+// interval. Bars that are already coarser than the interval are left as they are.
+func shapeHistory(bars []*Bar, r Range, i Interval) []*Bar {
+	if len(bars) == 0 {
+		return nil
+	}
+	if i == "" {
+		i = r.DefaultInterval()
+	}
+
+	sort.Slice(bars, func(a, b int) bool { return bars[a].Time.Before(bars[b].Time) })
+	start := r.start(bars[len(bars)-1].Time)
+
+	var out []*Bar
+	var cur *Bar
+	var curBucket time.Time
+	for _, b := range bars {
+		if b.Time.Before(start) {
+			continue
+		}
+
+		bucket := i.bucket(b.Time)
+		if cur != nil && bucket.Equal(curBucket) {
+			cur.High = math.Max(cur.High, b.High)
+			cur.Low = math.Min(cur.Low, b.Low)
+			cur.Close = b.Close
+			cur.Volume += b.Volume
+			continue
+		}
+
+		curBucket = bucket
+		cur = &Bar{
+			Time:   b.Time,
+			Open:   b.Open,
+			High:   b.High,
+			Low:    b.Low,
+			Close:  b.Close,
+			Volume: b.Volume,
+		}
+		out = append(out, cur)
+	}
+
+	return out
+}
+
+// BarInterval works out the size of sorted bars from the shortest gap between them, since a
+// provider may return coarser bars than were asked for. Bars shorter than a day are reported as
+// Interval1Day, and it returns "" for fewer than two bars.
+func BarInterval(bars []*Bar) Interval {
+	if len(bars) < 2 {
+		return ""
+	}
+
+	gap := bars[1].Time.Sub(bars[0].Time)
+	for j := 2; j < len(bars); j++ {
+		if g := bars[j].Time.Sub(bars[j-1].Time); g < gap {
+			gap = g
+		}
+	}
+
+	// Holidays and month lengths move bars a few days, weekends never split a week
+	switch {
+	case gap >= 20*24*time.Hour:
+		return Interval1Month
+	case gap >= 4*24*time.Hour:
+		return Interval1Week
+	default:
+		return Interval1Day
+	}
+}
+
+// TradingDay returns the date of the trading session t falls in, in the exchange's timezone
+func TradingDay(t time.Time) string {
+	return t.In(marketTZ).Format("2006-01-02")
+}
+
+// marketTZ is the timezone US exchanges report times in
+var marketTZ = func() *time.Location {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		return time.FixedZone("ET", -5*60*60)
+	}
+	return loc
+}()
@@ -0,0 +1,82 @@
+package stock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	r, err := ParseRange("6M")
+	require.NoError(t, err)
+	require.Equal(t, Range6Months, r)
+
+	_, err = ParseRange("2y")
+	require.Error(t, err)
+
+	i, err := ParseInterval("1WK")
+	require.NoError(t, err)
+	require.Equal(t, Interval1Week, i)
+}
+
+func TestShapeHistory(t *testing.T) {
+	start := time.Date(2020, 6, 1, 9, 30, 0, 0, marketTZ)
+
+	// Two days of minute bars
+	var bars []*Bar
+	for d := 0; d < 2; d++ {
+		for m := 0; m < 10; m++ {
+			bars = append(bars, &Bar{
+				Time:   start.AddDate(0, 0, d).Add(time.Duration(m) * time.Minute),
+				Open:   float64(m),
+				High:   float64(m + 1),
+				Low:    float64(m),
+				Close:  float64(m),
+				Volume: 1,
+			})
+		}
+	}
+
+	// Intraday only keeps the last day, in 5 minute bars
+	shaped := shapeHistory(bars, Range1Day, "")
+	require.Len(t, shaped, 2)
+	require.Equal(t, &Bar{
+		Time:   start.AddDate(0, 0, 1),
+		Open:   0,
+		High:   5,
+		Low:    0,
+		Close:  4,
+		Volume: 5,
+	}, shaped[0])
+
+	// Daily bars over 5 days
+	shaped = shapeHistory(bars, Range5Days, Interval1Day)
+	require.Len(t, shaped, 2)
+	require.Equal(t, float64(10), shaped[1].Volume)
+	require.Equal(t, float64(10), shaped[1].High)
+
+	// Weekly bars merge both days (Monday and Tuesday)
+	shaped = shapeHistory(bars, Range1Month, Interval1Week)
+	require.Len(t, shaped, 1)
+	require.Equal(t, float64(20), shaped[0].Volume)
+
+	require.Nil(t, shapeHistory(nil, Range1Year, ""))
+}
+
+func TestBarInterval(t *testing.T) {
+	bars := func(days ...int) []*Bar {
+		start := time.Date(2020, 1, 31, 16, 0, 0, 0, time.UTC)
+		out := make([]*Bar, 0, len(days))
+		for _, d := range days {
+			out = append(out, &Bar{Time: start.AddDate(0, 0, d)})
+		}
+		return out
+	}
+
+	require.Equal(t, Interval(""), BarInterval(bars(0)))
+	require.Equal(t, Interval1Day, BarInterval([]*Bar{{Time: time.Unix(0, 0)}, {Time: time.Unix(300, 0)}}))
+	require.Equal(t, Interval1Day, BarInterval(bars(0, 3, 4, 5)), "weekends don't make daily bars weekly")
+	require.Equal(t, Interval1Week, BarInterval(bars(0, 7, 13, 21)), "holidays don't make weekly bars daily")
+	require.Equal(t, Interval1Month, BarInterval(bars(0, 28, 60)))
+}
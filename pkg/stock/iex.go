@@ -1,38 +1,120 @@
 package stock
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
 	iex "github.com/thorfour/iex/pkg/api"
+	"github.com/thorfour/iex/pkg/endpoint"
 	iextype "github.com/thorfour/iex/pkg/types"
 )
 
 // IexWrapper is a wrapper around the IEX library
-type IexWrapper struct{}
+type IexWrapper struct {
+	// Limiter is shared by all calls to IEX, nil disables rate limiting
+	Limiter *Limiter
+}
 
 // Price returns the current price of the ticker
 func (w *IexWrapper) Price(ticker string) (float64, error) {
+	if err := w.Limiter.Wait(context.Background()); err != nil {
+		return 0, err
+	}
+
 	return iex.Price(ticker)
 }
 
-// BatchQuotes returns a slice of quotes for the given tickers
+const (
+	// iexBatchSize is the most symbols IEX accepts in a single batch request
+	iexBatchSize = 100
+	// iexBatchWorkers is the number of batch requests made in parallel
+	iexBatchWorkers = 4
+)
+
+// BatchQuotes returns a slice of quotes for the given tickers. Large lists are split into multiple batch requests.
 func (w *IexWrapper) BatchQuotes(tickers []string) ([]*Quote, error) {
-	batch, err := iex.BatchQuotes(tickers)
-	if err != nil {
+	return batchQuotes(tickers, iexBatchSize, iexBatchWorkers, w.batch)
+}
+
+// iexQuote is the subset of an IEX quote stocktopus uses. The iex library type is missing some of the newer fields.
+type iexQuote struct {
+	Symbol           string  `json:"symbol"`
+	CalculationPrice string  `json:"calculationPrice"`
+	LatestPrice      float64 `json:"latestPrice"`
+	LatestSource     string  `json:"latestSource"`
+	LatestUpdate     int64   `json:"latestUpdate"`
+	LatestVolume     float64 `json:"latestVolume"`
+	Change           float64 `json:"change"`
+	ChangePercent    float64 `json:"changePercent"`
+	Open             float64 `json:"open"`
+	High             float64 `json:"high"`
+	Low              float64 `json:"low"`
+	PreviousClose    float64 `json:"previousClose"`
+	AvgTotalVolume   float64 `json:"avgTotalVolume"`
+	MarketCap        float64 `json:"marketCap"`
+	Currency         string  `json:"currency"`
+	PrimaryExchange  string  `json:"primaryExchange"`
+	ExtendedPrice    float64 `json:"extendedPrice"`
+	IsUSMarketOpen   bool    `json:"isUSMarketOpen"`
+}
+
+// quote converts the IEX quote into a Quote
+func (q *iexQuote) quote(ticker string) *Quote {
+	quote := &Quote{
+		Ticker:        ticker,
+		LatestPrice:   q.LatestPrice,
+		Change:        q.Change,
+		ChangePercent: q.ChangePercent,
+		Open:          q.Open,
+		High:          q.High,
+		Low:           q.Low,
+		PreviousClose: q.PreviousClose,
+		Volume:        q.LatestVolume,
+		AvgVolume:     q.AvgTotalVolume,
+		MarketCap:     q.MarketCap,
+		Currency:      q.Currency,
+		Exchange:      q.PrimaryExchange,
+		ExtendedPrice: q.ExtendedPrice,
+		Closed:        !q.IsUSMarketOpen,
+		Delayed:       q.CalculationPrice == "sip" || strings.Contains(strings.ToLower(q.LatestSource), "delayed"),
+	}
+
+	if q.LatestUpdate > 0 {
+		quote.LatestUpdate = time.Unix(0, q.LatestUpdate*int64(time.Millisecond))
+	}
+
+	return quote
+}
+
+// batch requests quotes for at most iexBatchSize tickers
+func (w *IexWrapper) batch(tickers []string) ([]*Quote, error) {
+	if err := w.Limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("symbols", strings.Join(tickers, ","))
+	params.Set("types", iextype.QuoteStr)
+
+	batch := map[string]map[string]*iexQuote{}
+	if err := iexGet(path.Join(iextype.StockStr, iextype.MrktStr, iextype.BatchStr), params, &batch); err != nil {
 		return nil, err
 	}
 
 	var quotes []*Quote
-	for ticker := range batch {
-		q, err := batch.Quote(ticker)
-		if err != nil {
-			return nil, err
+	for ticker, types := range batch {
+		q, ok := types[iextype.QuoteStr]
+		if !ok || q == nil {
+			continue
 		}
 
-		quotes = append(quotes, &Quote{
-			Ticker:        ticker,
-			LatestPrice:   q.LatestPrice,
-			Change:        q.Change,
-			ChangePercent: q.ChangePercent,
-		})
+		quotes = append(quotes, q.quote(ticker))
 	}
 
 	return quotes, nil
@@ -40,6 +122,10 @@ func (w *IexWrapper) BatchQuotes(tickers []string) ([]*Quote, error) {
 
 // News returns recent news for a ticker
 func (w *IexWrapper) News(ticker string) ([]string, error) {
+	if err := w.Limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+
 	latest, err := iex.News(ticker)
 	if err != nil {
 		return nil, err
@@ -55,10 +141,174 @@ func (w *IexWrapper) News(ticker string) ([]string, error) {
 
 // Stats returns the stats for a ticker
 func (w *IexWrapper) Stats(ticker string) (*iextype.Stats, error) {
+	if err := w.Limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+
 	return iex.Stats(ticker)
 }
 
 // Company wraps the iex Company call
 func (w *IexWrapper) Company(ticker string) (*iextype.Company, error) {
+	if err := w.Limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+
 	return iex.Company(ticker)
 }
+
+// iexSymbol is a single entry of the IEX reference symbol list
+type iexSymbol struct {
+	Symbol    string `json:"symbol"`
+	Name      string `json:"name"`
+	Exchange  string `json:"exchange"`
+	Type      string `json:"type"`
+	IsEnabled bool   `json:"isEnabled"`
+}
+
+// Symbols returns every symbol IEX supports
+func (w *IexWrapper) Symbols() ([]*Symbol, error) {
+	if err := w.Limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+
+	var list []iexSymbol
+	if err := iexGet("ref-data/symbols", nil, &list); err != nil {
+		return nil, err
+	}
+
+	symbols := make([]*Symbol, 0, len(list))
+	for _, s := range list {
+		if !s.IsEnabled {
+			continue
+		}
+
+		symbols = append(symbols, &Symbol{
+			Ticker:   s.Symbol,
+			Name:     s.Name,
+			Exchange: s.Exchange,
+			Type:     s.Type,
+		})
+	}
+
+	return symbols, nil
+}
+
+// iexBar is a single point of an IEX chart response
+type iexBar struct {
+	Date   string  `json:"date"`
+	Minute string  `json:"minute"`
+	Open   float64 `json:"open"`
+	High   float64 `json:"high"`
+	Low    float64 `json:"low"`
+	Close  float64 `json:"close"`
+	Volume float64 `json:"volume"`
+}
+
+// History returns price history for a ticker over the given range
+func (w *IexWrapper) History(ticker string, r Range, i Interval) ([]*Bar, error) {
+	if err := w.Limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+
+	p, err := chartPath(ticker, r)
+	if err != nil {
+		return nil, err
+	}
+	var chart []iexBar
+	if err := iexGet(p, nil, &chart); err != nil {
+		return nil, err
+	}
+
+	bars := make([]*Bar, 0, len(chart))
+	for _, c := range chart {
+		if c.Close == 0 { // intraday minutes without any trades
+			continue
+		}
+
+		t, err := parseIexTime(c.Date, c.Minute)
+		if err != nil {
+			return nil, err
+		}
+
+		bars = append(bars, &Bar{
+			Time:   t,
+			Open:   c.Open,
+			High:   c.High,
+			Low:    c.Low,
+			Close:  c.Close,
+			Volume: c.Volume,
+		})
+	}
+
+	return shapeHistory(bars, r, i), nil
+}
+
+// parseIexTime converts the date and optional minute fields of a chart response
+func parseIexTime(date, minute string) (time.Time, error) {
+	layout := "2006-01-02"
+	if len(date) == 8 {
+		layout = "20060102"
+	}
+	if minute != "" {
+		return time.ParseInLocation(layout+" 15:04", date+" "+minute, marketTZ)
+	}
+	return time.ParseInLocation(layout, date, marketTZ)
+}
+
+// chartPath is the escaped path of the chart endpoint, so a ticker can't change the request path
+func chartPath(ticker string, r Range) (string, error) {
+	symbol := url.PathEscape(ticker)
+	if symbol == "" || symbol == "." || symbol == ".." {
+		return "", ErrUnknownSymbol
+	}
+	return path.Join(iextype.StockStr, symbol, iextype.ChartStr, string(r)), nil
+}
+
+// iexURL returns the url of an endpoint, p is an escaped path relative to the API version
+func iexURL(p string, params url.Values) (string, error) {
+	raw := path.Join("/", iextype.APIVersion, p)
+	decoded, err := url.PathUnescape(raw)
+	if err != nil {
+		return "", err
+	}
+
+	u := &url.URL{
+		Scheme:   "https",
+		Host:     iextype.APIURL,
+		Path:     decoded,
+		RawPath:  raw,
+		RawQuery: params.Encode(),
+	}
+	return u.String(), nil
+}
+
+// iexGet requests an IEX endpoint that the iex library doesn't wrap and decodes the json response
+// into v. p is an escaped path relative to the API version.
+func iexGet(p string, params url.Values, v interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("token", endpoint.Token)
+
+	u, err := iexURL(p, params)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ErrUnknownSymbol
+	default:
+		return fmt.Errorf("iex request failed: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,43 @@
+package stock
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIexQuote(t *testing.T) {
+	q := (&iexQuote{
+		Symbol:           "AMD",
+		CalculationPrice: "sip",
+		LatestPrice:      50,
+		LatestUpdate:     1591041600000,
+		Open:             48,
+		PrimaryExchange:  "NASDAQ",
+		IsUSMarketOpen:   true,
+	}).quote("AMD")
+
+	require.True(t, q.Delayed)
+	require.False(t, q.Closed)
+	require.Equal(t, float64(48), q.Open)
+	require.Equal(t, "NASDAQ", q.Exchange)
+	require.Equal(t, int64(1591041600), q.LatestUpdate.Unix())
+}
+
+func TestChartPath(t *testing.T) {
+	for ticker, exp := range map[string]string{
+		"AMD":         "https://cloud.iexapis.com/v1/stock/AMD/chart/5d",
+		"../market?x": "https://cloud.iexapis.com/v1/stock/..%2Fmarket%3Fx/chart/5d",
+		"BRK/B":       "https://cloud.iexapis.com/v1/stock/BRK%2FB/chart/5d",
+	} {
+		p, err := chartPath(ticker, Range5Days)
+		require.NoError(t, err)
+		u, err := iexURL(p, url.Values{})
+		require.NoError(t, err)
+		require.Equal(t, exp, u, ticker)
+	}
+
+	_, err := chartPath("..", Range5Days)
+	require.Equal(t, ErrUnknownSymbol, err)
+}
@@ -0,0 +1,138 @@
+package stock
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thorfour/iex/pkg/types"
+)
+
+var (
+	providerTimings = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "stock_provider_request_duration_seconds",
+		Help: "A histogram of provider call latency by provider and method",
+	},
+		[]string{"provider", "method"},
+	)
+
+	providerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stock_provider_errors_total",
+		Help: "Count of failed provider calls by provider, method and class (partial, unknown_symbol, rate_limited, timeout, error)",
+	},
+		[]string{"provider", "method", "class"},
+	)
+
+	directoryRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "symbol_directory_cache_requests_total",
+		Help: "Count of symbol directory reads by result (hit, miss)",
+	},
+		[]string{"result"},
+	)
+)
+
+// Instrument returns a Lookup that records the latency and errors of every call to l. The
+// result is a SymbolLister when l is.
+func Instrument(l Lookup, provider string) Lookup {
+	m := &measured{l: l, provider: provider}
+	if lister, ok := l.(SymbolLister); ok {
+		return &measuredLister{measured: m, lister: lister}
+	}
+	return m
+}
+
+// measured records metrics for each provider call
+type measured struct {
+	l        Lookup
+	provider string
+}
+
+func (m *measured) providerName() string {
+	return m.provider
+}
+
+// observe records a call to method that started at start
+func (m *measured) observe(method string, start time.Time, err error) {
+	providerTimings.WithLabelValues(m.provider, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		providerErrors.WithLabelValues(m.provider, method, providerErrorClass(err)).Inc()
+	}
+}
+
+func (m *measured) BatchQuotes(tickers []string) ([]*Quote, error) {
+	start := time.Now()
+	quotes, err := m.l.BatchQuotes(tickers)
+	m.observe("BatchQuotes", start, err)
+	return quotes, err
+}
+
+func (m *measured) Price(ticker string) (float64, error) {
+	start := time.Now()
+	price, err := m.l.Price(ticker)
+	m.observe("Price", start, err)
+	return price, err
+}
+
+func (m *measured) News(ticker string) ([]string, error) {
+	start := time.Now()
+	news, err := m.l.News(ticker)
+	m.observe("News", start, err)
+	return news, err
+}
+
+func (m *measured) Stats(ticker string) (*types.Stats, error) {
+	start := time.Now()
+	stats, err := m.l.Stats(ticker)
+	m.observe("Stats", start, err)
+	return stats, err
+}
+
+func (m *measured) Company(ticker string) (*types.Company, error) {
+	start := time.Now()
+	company, err := m.l.Company(ticker)
+	m.observe("Company", start, err)
+	return company, err
+}
+
+func (m *measured) History(ticker string, r Range, i Interval) ([]*Bar, error) {
+	start := time.Now()
+	bars, err := m.l.History(ticker, r, i)
+	m.observe("History", start, err)
+	return bars, err
+}
+
+// measuredLister is a measured provider that can list its symbols
+type measuredLister struct {
+	*measured
+	lister SymbolLister
+}
+
+func (m *measuredLister) Symbols() ([]*Symbol, error) {
+	start := time.Now()
+	symbols, err := m.lister.Symbols()
+	m.observe("Symbols", start, err)
+	return symbols, err
+}
+
+// providerErrorClass groups provider errors by cause
+func providerErrorClass(err error) string {
+	var (
+		partial *PartialError
+		rateErr *RateLimitError
+		netErr  net.Error
+	)
+	switch {
+	case errors.As(err, &partial):
+		return "partial"
+	case errors.Is(err, ErrUnknownSymbol):
+		return "unknown_symbol"
+	case errors.As(err, &rateErr):
+		return "rate_limited"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,49 @@
+package stock
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/iex/pkg/types"
+)
+
+// failingLookup fails every call with err
+type failingLookup struct {
+	err error
+}
+
+func (f *failingLookup) BatchQuotes([]string) ([]*Quote, error)          { return nil, f.err }
+func (f *failingLookup) Price(string) (float64, error)                   { return 0, f.err }
+func (f *failingLookup) News(string) ([]string, error)                   { return nil, f.err }
+func (f *failingLookup) Stats(string) (*types.Stats, error)              { return nil, f.err }
+func (f *failingLookup) Company(string) (*types.Company, error)          { return nil, f.err }
+func (f *failingLookup) History(string, Range, Interval) ([]*Bar, error) { return nil, f.err }
+
+func TestInstrument(t *testing.T) {
+	// Symbol listing survives instrumentation so search keeps working
+	_, ok := Instrument(&IexWrapper{}, "iex").(SymbolLister)
+	require.True(t, ok)
+	_, ok = Instrument(&failingLookup{}, "fake").(SymbolLister)
+	require.False(t, ok)
+
+	tests := map[string]struct {
+		err   error
+		class string
+	}{
+		"partial":    {err: &PartialError{Errors: map[string]error{"FAKE": ErrUnknownSymbol}}, class: "partial"},
+		"unknown":    {err: ErrUnknownSymbol, class: "unknown_symbol"},
+		"rate limit": {err: &RateLimitError{Provider: "test"}, class: "rate_limited"},
+		"other":      {err: errors.New("boom"), class: "error"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			l := Instrument(&failingLookup{err: test.err}, "test-"+name)
+			_, err := l.Price("AMD")
+			require.Equal(t, test.err, err)
+			require.Equal(t, 1.0, testutil.ToFloat64(providerErrors.WithLabelValues("test-"+name, "Price", test.class)))
+		})
+	}
+}
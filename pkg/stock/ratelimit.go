@@ -0,0 +1,224 @@
+package stock
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	quotaCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stock_provider_quota_calls_total",
+		Help: "Count of provider calls that went through a rate limiter by result (allowed, queued, rejected)",
+	},
+		[]string{"provider", "result"},
+	)
+
+	quotaDailyUsed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "stock_provider_quota_daily_used",
+		Help: "Number of provider calls consumed from the daily quota",
+	},
+		[]string{"provider"},
+	)
+
+	quotaDailyRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "stock_provider_quota_daily_remaining",
+		Help: "Number of provider calls left in the daily quota",
+	},
+		[]string{"provider"},
+	)
+)
+
+// DefaultAlphaQuota matches the free AlphaVantage tier of 5 calls a minute and 500 calls a day.
+// Calls past the burst queue for the next token.
+var DefaultAlphaQuota = Quota{
+	Rate:     5,
+	Interval: time.Minute,
+	Daily:    500,
+	MaxWait:  12 * time.Second,
+}
+
+// RateLimitError is returned when a provider call was rejected by a Limiter
+type RateLimitError struct {
+	// Provider that the limit applies to
+	Provider string
+	// RetryAfter is how long until the call would be allowed
+	RetryAfter time.Duration
+	// Daily is true when the daily cap was reached
+	Daily bool
+}
+
+func (e *RateLimitError) Error() string {
+	secs := int(math.Ceil(e.RetryAfter.Seconds()))
+	if e.Daily {
+		return fmt.Sprintf("%s daily quota reached, try again in %d seconds", e.Provider, secs)
+	}
+	return fmt.Sprintf("%s rate limit reached, try again in %d seconds", e.Provider, secs)
+}
+
+// Quota describes how many calls a provider accepts
+type Quota struct {
+	// Rate is the number of calls allowed every Interval
+	Rate int
+	// Interval over which Rate calls are allowed
+	Interval time.Duration
+	// Burst is the maximum number of calls that can be made back to back. Defaults to Rate
+	Burst int
+	// Daily caps the number of calls per UTC day. Zero means no cap
+	Daily int
+	// MaxWait is how long a call will queue for a token before it is rejected. Unless it's at
+	// least Interval/Rate, the time between tokens, most calls past the burst are rejected.
+	MaxWait time.Duration
+}
+
+// TokenInterval is the time it takes for a single token to be added
+func (q Quota) TokenInterval() time.Duration {
+	if q.Rate <= 0 {
+		return 0
+	}
+	return q.Interval / time.Duration(q.Rate)
+}
+
+// Limiter is a token bucket rate limiter with an optional daily cap.
+// A single Limiter should be shared by every client of a provider.
+type Limiter struct {
+	provider string
+	quota    Quota
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	day    time.Time
+	used   int
+
+	now func() time.Time
+}
+
+// NewLimiter returns a limiter for the named provider that enforces the given quota
+func NewLimiter(provider string, q Quota) *Limiter {
+	if q.Burst <= 0 {
+		q.Burst = q.Rate
+	}
+
+	l := &Limiter{
+		provider: provider,
+		quota:    q,
+		tokens:   float64(q.Burst),
+		now:      time.Now,
+	}
+	l.last = l.now()
+	l.day = startOfDay(l.last)
+
+	return l
+}
+
+// Wait blocks until a call to the provider is allowed. If the call would have to queue longer
+// than the quota's MaxWait, or the daily cap has been reached, a *RateLimitError is returned.
+// A nil Limiter never blocks.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	wait, err := l.reserve()
+	if err != nil {
+		quotaCalls.WithLabelValues(l.provider, "rejected").Inc()
+		return err
+	}
+
+	if wait <= 0 {
+		quotaCalls.WithLabelValues(l.provider, "allowed").Inc()
+		return nil
+	}
+
+	quotaCalls.WithLabelValues(l.provider, "queued").Inc()
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token from the bucket, returning how long the caller must wait before using it
+func (l *Limiter) reserve() (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.refill(now)
+
+	if l.quota.Daily > 0 && l.used >= l.quota.Daily {
+		return 0, &RateLimitError{
+			Provider:   l.provider,
+			RetryAfter: l.day.Add(24 * time.Hour).Sub(now),
+			Daily:      true,
+		}
+	}
+
+	var wait time.Duration
+	if l.tokens < 1 {
+		wait = time.Duration((1 - l.tokens) / l.perNano())
+		if wait > l.quota.MaxWait {
+			return 0, &RateLimitError{
+				Provider:   l.provider,
+				RetryAfter: wait,
+			}
+		}
+	}
+
+	l.tokens--
+	l.used++
+	l.record()
+
+	return wait, nil
+}
+
+// cancel returns a token for a queued call that was abandoned
+func (l *Limiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens++
+	if l.used > 0 {
+		l.used--
+	}
+	l.record()
+}
+
+// refill adds tokens for the time elapsed since the last call and resets the daily count at midnight UTC
+func (l *Limiter) refill(now time.Time) {
+	if day := startOfDay(now); day.After(l.day) {
+		l.day = day
+		l.used = 0
+	}
+
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = math.Min(float64(l.quota.Burst), l.tokens+float64(elapsed)*l.perNano())
+		l.last = now
+	}
+}
+
+// perNano is the number of tokens added per nanosecond
+func (l *Limiter) perNano() float64 {
+	return float64(l.quota.Rate) / float64(l.quota.Interval)
+}
+
+func (l *Limiter) record() {
+	quotaDailyUsed.WithLabelValues(l.provider).Set(float64(l.used))
+	if l.quota.Daily > 0 {
+		quotaDailyRemaining.WithLabelValues(l.provider).Set(float64(l.quota.Daily - l.used))
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
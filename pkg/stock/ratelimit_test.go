@@ -0,0 +1,80 @@
+package stock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter("test", Quota{
+		Rate:     2,
+		Interval: time.Minute,
+		Daily:    3,
+	})
+	l.now = func() time.Time { return now }
+	l.last = now
+	l.day = startOfDay(now)
+
+	ctx := context.Background()
+
+	// Burst defaults to the rate
+	require.NoError(t, l.Wait(ctx))
+	require.NoError(t, l.Wait(ctx))
+
+	// Bucket is empty and no queueing is allowed
+	err := l.Wait(ctx)
+	rerr := &RateLimitError{}
+	require.True(t, errors.As(err, &rerr))
+	require.False(t, rerr.Daily)
+	require.Equal(t, 30*time.Second, rerr.RetryAfter)
+	require.Equal(t, "test rate limit reached, try again in 30 seconds", err.Error())
+
+	// Refill a token
+	now = now.Add(30 * time.Second)
+	require.NoError(t, l.Wait(ctx))
+
+	// Daily cap reached even though the bucket has refilled
+	now = now.Add(time.Minute)
+	err = l.Wait(ctx)
+	require.True(t, errors.As(err, &rerr))
+	require.True(t, rerr.Daily)
+
+	// Next day resets the cap
+	now = now.Add(12 * time.Hour)
+	require.NoError(t, l.Wait(ctx))
+}
+
+func TestLimiterQueue(t *testing.T) {
+	l := NewLimiter("test", Quota{
+		Rate:     1,
+		Interval: 50 * time.Millisecond,
+		MaxWait:  time.Second,
+	})
+
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Wait(ctx))
+	}
+	require.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	// A cancelled caller gives its token back
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Error(t, l.Wait(ctx))
+}
+
+func TestDefaultAlphaQuota(t *testing.T) {
+	// Calls past the burst can wait for the next token
+	require.True(t, DefaultAlphaQuota.MaxWait >= DefaultAlphaQuota.TokenInterval())
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	require.NoError(t, l.Wait(context.Background()))
+}
@@ -0,0 +1,333 @@
+package stock
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultDirectoryTTL is how often the symbol directory is refreshed from the provider
+const DefaultDirectoryTTL = 24 * time.Hour
+
+// ErrAmbiguous is returned when a name matches more than one symbol
+var ErrAmbiguous = errors.New("ambiguous name")
+
+// Symbol is a single listing supported by a provider
+type Symbol struct {
+	Ticker   string
+	Name     string
+	Exchange string
+	// Type of security i.e common stock or etf
+	Type string
+}
+
+// SymbolLister is implemented by providers that can list every symbol they support
+type SymbolLister interface {
+	Symbols() ([]*Symbol, error)
+}
+
+// Match scores
+const (
+	scoreTicker       = 100
+	scoreName         = 90
+	scoreNamePrefix   = 70
+	scoreTickerPrefix = 60
+	scoreWordPrefix   = 50
+	scoreContains     = 30
+)
+
+// Bounds of the wait before retrying a failed refresh, it doubles with every failure
+const (
+	minRefreshRetry = 10 * time.Second
+	maxRefreshRetry = 10 * time.Minute
+)
+
+// Directory is a local copy of a provider's symbol list used for searching. It's refreshed from
+// the provider once it's older than its ttl.
+type Directory struct {
+	lister SymbolLister
+	ttl    time.Duration
+
+	mu     sync.Mutex
+	snap   *Snapshot
+	flight chan struct{} // closed when the refresh in flight is done, nil if there isn't one
+	err    error         // of the last refresh
+	retry  time.Time     // no refresh is tried before retry after a failure
+	wait   time.Duration
+
+	now func() time.Time
+}
+
+// Snapshot is the symbol list as of a single refresh. Resolving every ticker of a request
+// against the same snapshot loads the directory once.
+type Snapshot struct {
+	symbols []*Symbol
+	tickers map[string]*Symbol
+	names   []string
+	fetched time.Time
+}
+
+// NewDirectory returns an empty directory that loads symbols from the lister on first use
+func NewDirectory(l SymbolLister, ttl time.Duration) *Directory {
+	return &Directory{
+		lister: l,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// Refresh reloads the directory from the provider
+func (d *Directory) Refresh() error {
+	symbols, err := d.lister.Symbols()
+	if err != nil {
+		return err
+	}
+
+	snap := &Snapshot{
+		symbols: symbols,
+		tickers: make(map[string]*Symbol, len(symbols)),
+		names:   make([]string, len(symbols)),
+	}
+	for i, s := range symbols {
+		snap.tickers[strings.ToUpper(s.Ticker)] = s
+		snap.names[i] = normalizeName(s.Name)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	snap.fetched = d.now()
+	d.snap = snap
+
+	return nil
+}
+
+// Snapshot returns the current symbols, refreshing them if they're out of date. Only one refresh
+// runs at a time: callers wait for it while the directory is empty and use the stale symbols
+// otherwise. A failed refresh of a populated directory is logged and the stale symbols are used,
+// and after a failure the provider isn't asked again until a backoff has passed.
+func (d *Directory) Snapshot() (*Snapshot, error) {
+	d.mu.Lock()
+	now := d.now()
+	snap := d.snap
+	if snap != nil && now.Sub(snap.fetched) <= d.ttl {
+		d.mu.Unlock()
+		directoryRequests.WithLabelValues("hit").Inc()
+		return snap, nil
+	}
+
+	if flight := d.flight; flight != nil {
+		d.mu.Unlock()
+		directoryRequests.WithLabelValues("hit").Inc()
+		if snap != nil {
+			return snap, nil
+		}
+
+		<-flight
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.snap == nil {
+			return nil, d.err
+		}
+		return d.snap, nil
+	}
+
+	if now.Before(d.retry) {
+		err := d.err
+		d.mu.Unlock()
+		directoryRequests.WithLabelValues("hit").Inc()
+		if snap != nil {
+			return snap, nil
+		}
+		return nil, err
+	}
+
+	directoryRequests.WithLabelValues("miss").Inc()
+	flight := make(chan struct{})
+	d.flight = flight
+	d.mu.Unlock()
+
+	err := d.Refresh()
+
+	d.mu.Lock()
+	d.flight, d.err = nil, err
+	if err != nil {
+		d.wait *= 2
+		if d.wait < minRefreshRetry {
+			d.wait = minRefreshRetry
+		}
+		if d.wait > maxRefreshRetry {
+			d.wait = maxRefreshRetry
+		}
+		d.retry = now.Add(d.wait)
+	} else {
+		d.wait, d.retry = 0, time.Time{}
+	}
+	snap = d.snap
+	d.mu.Unlock()
+	close(flight)
+
+	switch {
+	case err == nil:
+		return snap, nil
+	case snap != nil:
+		logrus.WithField("msg", "symbol directory refresh failed").Error(err)
+		return snap, nil
+	default:
+		return nil, err
+	}
+}
+
+// Lookup returns the symbol for an exact ticker
+func (d *Directory) Lookup(ticker string) (*Symbol, bool, error) {
+	snap, err := d.Snapshot()
+	if err != nil {
+		return nil, false, err
+	}
+
+	s, ok := snap.Lookup(ticker)
+	return s, ok, nil
+}
+
+// Search returns up to limit symbols matching the text, best matches first
+func (d *Directory) Search(text string, limit int) ([]*Symbol, error) {
+	snap, err := d.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return snap.Search(text, limit), nil
+}
+
+// Resolve returns the ticker for a company name when exactly one company has that name.
+// ErrAmbiguous is returned if more than one company does, and ErrUnknownSymbol if none do.
+func (d *Directory) Resolve(name string) (string, error) {
+	snap, err := d.Snapshot()
+	if err != nil {
+		return "", err
+	}
+
+	return snap.Resolve(name)
+}
+
+// Lookup returns the symbol for an exact ticker
+func (s *Snapshot) Lookup(ticker string) (*Symbol, bool) {
+	sym, ok := s.tickers[strings.ToUpper(ticker)]
+	return sym, ok
+}
+
+// Search returns up to limit symbols matching the text, best matches first
+func (s *Snapshot) Search(text string, limit int) []*Symbol {
+	matches := s.search(text)
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	symbols := make([]*Symbol, 0, len(matches))
+	for _, m := range matches {
+		symbols = append(symbols, m.symbol)
+	}
+
+	return symbols
+}
+
+// Resolve returns the ticker for a company name when exactly one company has that name.
+// ErrAmbiguous is returned if more than one company does, and ErrUnknownSymbol if none do.
+func (s *Snapshot) Resolve(name string) (string, error) {
+	var best []match
+	for _, m := range s.search(name) {
+		if m.score < scoreName {
+			break
+		}
+		best = append(best, m)
+	}
+
+	switch {
+	case len(best) == 0:
+		return "", ErrUnknownSymbol
+	case len(best) > 1 && best[0].score == best[1].score:
+		return "", ErrAmbiguous
+	default:
+		return best[0].symbol.Ticker, nil
+	}
+}
+
+type match struct {
+	symbol *Symbol
+	score  int
+}
+
+// search scores every symbol against text and returns the matches sorted by score
+func (s *Snapshot) search(text string) []match {
+	ticker := strings.ToUpper(strings.TrimSpace(text))
+	name := normalizeName(text)
+	if name == "" {
+		return nil
+	}
+
+	var matches []match
+	for i, sym := range s.symbols {
+		if score := scoreSymbol(sym, s.names[i], ticker, name); score > 0 {
+			matches = append(matches, match{symbol: sym, score: score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if len(a.symbol.Name) != len(b.symbol.Name) {
+			return len(a.symbol.Name) < len(b.symbol.Name)
+		}
+		return a.symbol.Ticker < b.symbol.Ticker
+	})
+
+	return matches
+}
+
+// scoreSymbol ranks how well a symbol matches the search. Zero means no match.
+func scoreSymbol(s *Symbol, normalized, ticker, name string) int {
+	switch {
+	case strings.EqualFold(s.Ticker, ticker):
+		return scoreTicker
+	case normalized == name:
+		return scoreName
+	case strings.HasPrefix(normalized, name):
+		return scoreNamePrefix
+	case strings.HasPrefix(strings.ToUpper(s.Ticker), ticker):
+		return scoreTickerPrefix
+	case strings.Contains(" "+normalized, " "+name):
+		return scoreWordPrefix
+	case strings.Contains(normalized, name):
+		return scoreContains
+	default:
+		return 0
+	}
+}
+
+// nameSuffixes are dropped from company names so "apple" matches "Apple Inc."
+var nameSuffixes = map[string]bool{
+	"inc": true, "incorporated": true, "corp": true, "corporation": true, "co": true, "company": true,
+	"ltd": true, "limited": true, "plc": true, "llc": true, "lp": true, "sa": true, "ag": true, "nv": true,
+	"holdings": true, "group": true, "class": true, "a": true, "b": true, "c": true, "the": true,
+}
+
+// normalizeName lower cases a name, strips punctuation and drops common corporate suffixes
+func normalizeName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '&')
+	})
+
+	for len(words) > 1 && nameSuffixes[words[len(words)-1]] {
+		words = words[:len(words)-1]
+	}
+	if len(words) > 1 && words[0] == "the" {
+		words = words[1:]
+	}
+
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,138 @@
+package stock
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLister struct {
+	symbols []*Symbol
+	err     error
+	calls   int
+	// release blocks calls until it's closed when set
+	release chan struct{}
+}
+
+func (f *fakeLister) Symbols() ([]*Symbol, error) {
+	f.calls++
+	if f.release != nil {
+		<-f.release
+	}
+	return f.symbols, f.err
+}
+
+func TestDirectorySearch(t *testing.T) {
+	l := &fakeLister{
+		symbols: []*Symbol{
+			{Ticker: "APLE", Name: "Apple Hospitality REIT Inc"},
+			{Ticker: "AAPL", Name: "Apple Inc."},
+			{Ticker: "GOOG", Name: "Alphabet Inc. Class C"},
+			{Ticker: "GOOGL", Name: "Alphabet Inc. Class A"},
+			{Ticker: "PINE", Name: "Alpine Income Property Trust Inc"},
+			{Ticker: "MSFT", Name: "Microsoft Corporation"},
+		},
+	}
+	d := NewDirectory(l, time.Hour)
+
+	results, err := d.Search("apple", 10)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, "AAPL", results[0].Ticker)
+	require.Equal(t, "APLE", results[1].Ticker)
+
+	// Ticker matches rank above names
+	results, err = d.Search("GOOG", 1)
+	require.NoError(t, err)
+	require.Equal(t, "GOOG", results[0].Ticker)
+
+	ticker, err := d.Resolve("APPLE")
+	require.NoError(t, err)
+	require.Equal(t, "AAPL", ticker)
+
+	ticker, err = d.Resolve("microsoft")
+	require.NoError(t, err)
+	require.Equal(t, "MSFT", ticker)
+
+	_, err = d.Resolve("alphabet")
+	require.True(t, errors.Is(err, ErrAmbiguous))
+
+	_, err = d.Resolve("alp")
+	require.True(t, errors.Is(err, ErrUnknownSymbol))
+
+	_, ok, err := d.Lookup("msft")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.Equal(t, 1, l.calls)
+}
+
+func TestDirectoryRefresh(t *testing.T) {
+	now := time.Now()
+	l := &fakeLister{
+		symbols: []*Symbol{{Ticker: "AMD", Name: "Advanced Micro Devices Inc."}},
+	}
+	d := NewDirectory(l, time.Hour)
+	d.now = func() time.Time { return now }
+
+	_, ok, err := d.Lookup("AMD")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Stale directory is refreshed
+	now = now.Add(2 * time.Hour)
+	l.symbols = append(l.symbols, &Symbol{Ticker: "TSLA", Name: "Tesla Inc"})
+	_, ok, err = d.Lookup("TSLA")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Failed refresh keeps the stale symbols
+	now = now.Add(2 * time.Hour)
+	l.err = errors.New("provider down")
+	_, ok, err = d.Lookup("TSLA")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 3, l.calls)
+
+	// Empty directory returns the error
+	d = NewDirectory(l, time.Hour)
+	d.now = func() time.Time { return now }
+	_, _, err = d.Lookup("TSLA")
+	require.Error(t, err)
+
+	// Failures are remembered until the retry backoff has passed
+	_, err = d.Search("tesla", 1)
+	require.Error(t, err)
+	require.Equal(t, 4, l.calls)
+	now = now.Add(minRefreshRetry + time.Second)
+	l.err = nil
+	_, ok, err = d.Lookup("TSLA")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 5, l.calls)
+}
+
+func TestDirectorySingleFlight(t *testing.T) {
+	l := &fakeLister{
+		symbols: []*Symbol{{Ticker: "AMD", Name: "Advanced Micro Devices Inc."}},
+		release: make(chan struct{}),
+	}
+	d := NewDirectory(l, time.Hour)
+
+	// Callers of an empty directory wait for the one refresh
+	errs := make(chan error)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := d.Snapshot()
+			errs <- err
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(l.release)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, <-errs)
+	}
+	require.Equal(t, 1, l.calls)
+}
@@ -1,6 +1,8 @@
 package stock
 
 import (
+	"time"
+
 	"github.com/leekchan/accounting"
 	"github.com/thorfour/iex/pkg/types"
 )
@@ -15,6 +17,51 @@ type Quote struct {
 	Change float64
 	// ChangePercent daily percent change
 	ChangePercent float64
+	// Open price of the latest session
+	Open float64
+	// High price of the latest session
+	High float64
+	// Low price of the latest session
+	Low float64
+	// PreviousClose is the closing price of the session before the latest
+	PreviousClose float64
+	// Volume traded in the latest session
+	Volume float64
+	// AvgVolume is the average daily volume
+	AvgVolume float64
+	// MarketCap of the company
+	MarketCap float64
+	// Currency the prices are in
+	Currency string
+	// Exchange the ticker is primarily listed on
+	Exchange string
+	// LatestUpdate is when LatestPrice was last updated
+	LatestUpdate time.Time
+	// ExtendedPrice is the pre or post market price
+	ExtendedPrice float64
+	// Delayed is true when LatestPrice isn't real time
+	Delayed bool
+	// Closed is true when the market was closed at the time of the quote
+	Closed bool
+	// Unavailable lists the fields the provider couldn't fill, they're left at zero
+	Unavailable []string
+}
+
+// Quote fields a provider may not be able to fill
+const (
+	// FieldChange covers Change and ChangePercent
+	FieldChange = "change"
+	FieldVolume = "volume"
+)
+
+// Has returns false if the provider couldn't fill the field
+func (q *Quote) Has(field string) bool {
+	for _, f := range q.Unavailable {
+		if f == field {
+			return false
+		}
+	}
+	return true
 }
 
 // Lookup is the interface for a package to do stock lookups
@@ -24,6 +71,7 @@ type Lookup interface {
 	News(string) ([]string, error)
 	Stats(string) (*types.Stats, error)
 	Company(string) (*types.Company, error)
+	History(string, Range, Interval) ([]*Bar, error)
 }
 
 // StatsToRows converts a stats struct into a label list of printable values
@@ -0,0 +1,121 @@
+// Package stocktest is a configurable stock.Lookup for tests
+package stocktest
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/thorfour/iex/pkg/types"
+	"github.com/thorfour/stocktopus/pkg/stock"
+)
+
+// Lookup implements the stock.Lookup interface with canned answers.
+// The zero value quotes every ticker at 0 and has empty company data.
+type Lookup struct {
+	// Quotes are returned for every quote lookup when set
+	Quotes []*stock.Quote
+	// Quote is copied for each requested ticker when Quotes isn't set
+	Quote stock.Quote
+	// Unknown symbols fail with stock.ErrUnknownSymbol
+	Unknown []string
+	// Err is returned with every quote lookup
+	Err error
+	// Release blocks quote lookups until it's closed or sent to, when it's set
+	Release chan struct{}
+
+	Headlines  []string
+	Profile    *types.Company
+	Statistics *types.Stats
+	Bars       []*stock.Bar
+
+	calls int32
+}
+
+// QuoteCalls returns the number of quote lookups made
+func (l *Lookup) QuoteCalls() int {
+	return int(atomic.LoadInt32(&l.calls))
+}
+
+func (l *Lookup) unknown(symbol string) bool {
+	for _, u := range l.Unknown {
+		if u == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// Price returns the price of the quote template
+func (l *Lookup) Price(string) (float64, error) { return l.Quote.LatestPrice, nil }
+
+// BatchQuotes returns Quotes, or a copy of the quote template for each known ticker
+func (l *Lookup) BatchQuotes(tickers []string) ([]*stock.Quote, error) {
+	if l.Release != nil {
+		<-l.Release
+	}
+	atomic.AddInt32(&l.calls, 1)
+
+	if l.Quotes != nil {
+		return l.Quotes, l.Err
+	}
+
+	quotes := make([]*stock.Quote, 0, len(tickers))
+	missing := map[string]error{}
+	for _, t := range tickers {
+		if l.unknown(t) {
+			missing[t] = stock.ErrUnknownSymbol
+			continue
+		}
+		q := l.Quote
+		q.Ticker = t
+		quotes = append(quotes, &q)
+	}
+	switch {
+	case len(missing) == 0:
+		return quotes, l.Err
+	case len(quotes) == 0:
+		return nil, fmt.Errorf("%w: %s", stock.ErrUnknownSymbol, tickers[0])
+	default:
+		return quotes, &stock.PartialError{Errors: missing}
+	}
+}
+
+// News returns Headlines
+func (l *Lookup) News(string) ([]string, error) { return l.Headlines, nil }
+
+// Stats returns Statistics, or empty stats when it isn't set
+func (l *Lookup) Stats(string) (*types.Stats, error) {
+	if l.Statistics == nil {
+		return &types.Stats{}, nil
+	}
+	return l.Statistics, nil
+}
+
+// Company returns a copy of Profile for the symbol, or empty company data when it isn't set
+func (l *Lookup) Company(symbol string) (*types.Company, error) {
+	if l.unknown(symbol) {
+		return nil, stock.ErrUnknownSymbol
+	}
+	c := &types.Company{}
+	if l.Profile != nil {
+		*c = *l.Profile
+	}
+	if c.Symbol == "" {
+		c.Symbol = symbol
+	}
+	return c, nil
+}
+
+// History returns Bars
+func (l *Lookup) History(string, stock.Range, stock.Interval) ([]*stock.Bar, error) {
+	return l.Bars, nil
+}
+
+// Lister is a Lookup that also lists the symbols it knows about
+type Lister struct {
+	Lookup
+	List []*stock.Symbol
+}
+
+// Symbols returns List
+func (l *Lister) Symbols() ([]*stock.Symbol, error) { return l.List, nil }
@@ -0,0 +1,84 @@
+package stock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/thorfour/iex/pkg/types"
+	"github.com/thorfour/stocktopus/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traced records a span for each provider call as a child of the span in ctx
+type traced struct {
+	ctx      context.Context
+	l        Lookup
+	provider string
+}
+
+// Traced returns a Lookup that traces each call to l as part of the request ctx belongs to
+func Traced(ctx context.Context, l Lookup) Lookup {
+	provider := fmt.Sprintf("%T", l)
+	if m, ok := l.(named); ok {
+		provider = m.providerName()
+	}
+	return &traced{ctx: ctx, l: l, provider: provider}
+}
+
+// named is implemented by wrappers that know the name of the provider they wrap
+type named interface {
+	providerName() string
+}
+
+func (t *traced) start(call string, tickers ...string) trace.Span {
+	_, span := tracing.Start(t.ctx, "stock."+call,
+		attribute.String("stock.provider", t.provider),
+		attribute.String("stock.tickers", strings.Join(tickers, ",")),
+	)
+	return span
+}
+
+func (t *traced) BatchQuotes(tickers []string) ([]*Quote, error) {
+	span := t.start("BatchQuotes", tickers...)
+	quotes, err := t.l.BatchQuotes(tickers)
+	tracing.End(span, err)
+	return quotes, err
+}
+
+func (t *traced) Price(ticker string) (float64, error) {
+	span := t.start("Price", ticker)
+	price, err := t.l.Price(ticker)
+	tracing.End(span, err)
+	return price, err
+}
+
+func (t *traced) News(ticker string) ([]string, error) {
+	span := t.start("News", ticker)
+	news, err := t.l.News(ticker)
+	tracing.End(span, err)
+	return news, err
+}
+
+func (t *traced) Stats(ticker string) (*types.Stats, error) {
+	span := t.start("Stats", ticker)
+	stats, err := t.l.Stats(ticker)
+	tracing.End(span, err)
+	return stats, err
+}
+
+func (t *traced) Company(ticker string) (*types.Company, error) {
+	span := t.start("Company", ticker)
+	company, err := t.l.Company(ticker)
+	tracing.End(span, err)
+	return company, err
+}
+
+func (t *traced) History(ticker string, r Range, i Interval) ([]*Bar, error) {
+	span := t.start("History", ticker)
+	span.SetAttributes(attribute.String("stock.range", string(r)), attribute.String("stock.interval", string(i)))
+	bars, err := t.l.History(ticker, r, i)
+	tracing.End(span, err)
+	return bars, err
+}
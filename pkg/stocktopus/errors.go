@@ -0,0 +1,86 @@
+package stocktopus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/thorfour/stocktopus/pkg/stock"
+)
+
+// InternalErrorMessage is shown for failures that aren't the user's to fix
+const InternalErrorMessage = "Something went wrong, please try again in a moment"
+
+// UserError is a failure that can be explained to the user. Only Message and Usage are shown,
+// Cause is for the logs.
+type UserError struct {
+	// Message explains the failure to the user
+	Message string
+	// Usage shows how the command is used, for failures caused by the user's input
+	Usage string
+	// Invalid is set when the user's input was the problem, so showing usage would help
+	Invalid bool
+	// Cause is the internal error behind the failure, if any
+	Cause error
+}
+
+func (e *UserError) Error() string {
+	if e.Cause == nil || e.Cause.Error() == e.Message {
+		return e.Message
+	}
+	return e.Message + ": " + e.Cause.Error()
+}
+
+// Unwrap returns the cause
+func (e *UserError) Unwrap() error {
+	return e.Cause
+}
+
+// Text is what's shown to the user
+func (e *UserError) Text() string {
+	if e.Usage == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s\nUsage: `%s`", e.Message, e.Usage)
+}
+
+// InvalidInput returns a UserError for input the user needs to correct
+func InvalidInput(message string, cause error) *UserError {
+	return &UserError{Message: message, Invalid: true, Cause: cause}
+}
+
+// WithUsage adds a usage hint to err if it's caused by invalid input
+func WithUsage(err error, usage string) error {
+	var ue *UserError
+	if !errors.As(err, &ue) || !ue.Invalid || ue.Usage != "" {
+		return err
+	}
+	return &UserError{Message: ue.Message, Usage: usage, Invalid: true, Cause: err}
+}
+
+// UserMessage returns what to show the user for err, internal details are never included
+func UserMessage(err error) string {
+	var ue *UserError
+	if errors.As(err, &ue) {
+		return ue.Text()
+	}
+	return InternalErrorMessage
+}
+
+// lookupError explains provider failures the user can act on, anything else is wrapped with
+// format as an internal error
+func lookupError(format string, err error) error {
+	var (
+		rateErr *stock.RateLimitError
+		partial *stock.PartialError
+	)
+	switch {
+	case errors.As(err, &rateErr):
+		return &UserError{Message: rateErr.Error(), Cause: err}
+	case errors.As(err, &partial):
+		return &UserError{Message: "Unknown symbol " + strings.Join(partial.Symbols(), ", "), Cause: err}
+	case errors.Is(err, stock.ErrUnknownSymbol):
+		return &UserError{Message: "Unknown symbol", Cause: err}
+	}
+	return fmt.Errorf(format, err)
+}
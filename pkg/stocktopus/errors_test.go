@@ -0,0 +1,37 @@
+package stocktopus
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/stock"
+)
+
+func TestUserMessage(t *testing.T) {
+	tests := map[string]struct {
+		err  error
+		text string
+	}{
+		"user error":    {err: fmt.Errorf("Buy failed: %w", ErrInsufficientFunds), text: "Insufficient funds"},
+		"internal":      {err: fmt.Errorf("Unable to add to list: %w", errors.New("SAdd failed: connection reset")), text: InternalErrorMessage},
+		"usage":         {err: WithUsage(fmt.Errorf("Add failed: %w", ErrInvalidArguments), "watch [tickers...]"), text: "Invalid number of arguments\nUsage: `watch [tickers...]`"},
+		"no usage":      {err: WithUsage(ErrNoList, "list (list)"), text: "No list"},
+		"rate limited":  {err: lookupError("quote failed: %w", &stock.RateLimitError{Provider: "alphavantage", RetryAfter: 5 * time.Second}), text: "alphavantage rate limit reached, try again in 5 seconds"},
+		"unknown":       {err: lookupError("quote failed: %w", &stock.PartialError{Errors: map[string]error{"FAKE": stock.ErrUnknownSymbol}}), text: "Unknown symbol FAKE"},
+		"provider down": {err: lookupError("quote failed: %w", errors.New("503 from https://cloud.iexapis.com/?token=secret")), text: InternalErrorMessage},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, test.text, UserMessage(test.err))
+		})
+	}
+
+	// Adding usage keeps the original error
+	err := WithUsage(ErrInvalidArguments, "watch [tickers...]")
+	require.True(t, errors.Is(err, ErrInvalidArguments))
+	require.Equal(t, "Invalid number of arguments", err.Error())
+}
@@ -7,30 +7,48 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
-	redis "github.com/go-redis/redis/v8"
 	"github.com/thorfour/iex/pkg/types"
 	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/storage"
+	"github.com/thorfour/stocktopus/pkg/tracing"
 )
 
 var (
 	// ErrInvalidArguments is returned when the wrong number of args are passed in
-	ErrInvalidArguments = errors.New("Error: invalid number of arguments")
+	ErrInvalidArguments = InvalidInput("Invalid number of arguments", nil)
 
 	// ErrInsufficientFunds returns for play money buys that don't have a high enough balance
-	ErrInsufficientFunds = errors.New("Insufficient funds")
+	ErrInsufficientFunds = &UserError{Message: "Insufficient funds"}
 
 	// ErrNumShares not enough shares for given sell action
-	ErrNumShares = errors.New("Not enough shares")
+	ErrNumShares = &UserError{Message: "Not enough shares"}
 
 	// ErrNoList when a given is is not found
-	ErrNoList = errors.New("No list")
+	ErrNoList = &UserError{Message: "No list"}
+
+	// ErrNoHistory when no price history is found for the requested range
+	ErrNoHistory = &UserError{Message: "No price history"}
+
+	// ErrNoSearch when the stock provider doesn't support symbol search
+	ErrNoSearch = &UserError{Message: "Symbol search is not supported"}
 )
 
+// maxSearchResults is the most matches returned from a search
+const maxSearchResults = 10
+
 // Stocktopus facilitates the retrieval and storage of stock information
 type Stocktopus struct {
-	KVStore        *redis.Client
+	KVStore        storage.Store
 	StockInterface stock.Lookup
+
+	// Symbols is used for symbol search, nil if the provider doesn't support it
+	Symbols *stock.Directory
+	// ResolveNames replaces company names with their ticker when a single company matches
+	ResolveNames bool
+
+	now func() time.Time
 }
 
 //-------------------------------------
@@ -41,12 +59,15 @@ type Stocktopus struct {
 
 // Add ticker(s) to a watch list
 func (s *Stocktopus) Add(ctx context.Context, tickers []string, key string) error {
+	ctx, span := tracing.Start(ctx, "stocktopus.Add")
+	defer span.End()
+
 	if len(tickers) == 0 {
 		return ErrInvalidArguments
 	}
 
-	if _, err := s.KVStore.SAdd(ctx, key, tickers).Result(); err != nil {
-		return fmt.Errorf("SAdd failed: %w", err)
+	if err := s.KVStore.AddMembers(ctx, key, s.resolve(tickers)...); err != nil {
+		return fmt.Errorf("Unable to add to list: %w", err)
 	}
 
 	return nil
@@ -54,29 +75,32 @@ func (s *Stocktopus) Add(ctx context.Context, tickers []string, key string) erro
 
 // Print returns a watchlist
 func (s *Stocktopus) Print(ctx context.Context, key string) (WatchList, error) {
-	list, err := s.KVStore.SMembers(ctx, key).Result()
+	ctx, span := tracing.Start(ctx, "stocktopus.Print")
+	defer span.End()
+
+	list, err := s.KVStore.Members(ctx, key)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("SMembers failed: %w", err)
+		return nil, fmt.Errorf("Unable to load list: %w", err)
 	}
 
 	if len(list) == 0 {
 		return nil, ErrNoList
 	}
 
-	return s.GetQuotes(list)
+	return s.GetQuotes(ctx, list)
 }
 
 // Remove ticker(s) from a watch list
 func (s *Stocktopus) Remove(ctx context.Context, tickers []string, key string) error {
+	ctx, span := tracing.Start(ctx, "stocktopus.Remove")
+	defer span.End()
+
 	if len(tickers) == 0 {
 		return ErrInvalidArguments
 	}
 
-	if _, err := s.KVStore.SRem(ctx, key, tickers).Result(); err != nil {
-		return fmt.Errorf("SRem failed: %w", err)
+	if err := s.KVStore.RemoveMembers(ctx, key, s.resolve(tickers)...); err != nil {
+		return fmt.Errorf("Unable to remove from list: %w", err)
 	}
 
 	return nil
@@ -84,8 +108,11 @@ func (s *Stocktopus) Remove(ctx context.Context, tickers []string, key string) e
 
 // Clear a watchlist or account by deleting the key from the KVStore
 func (s *Stocktopus) Clear(ctx context.Context, key string) error {
-	if _, err := s.KVStore.Del(ctx, key).Result(); err != nil {
-		return fmt.Errorf("Del failed: %w", err)
+	ctx, span := tracing.Start(ctx, "stocktopus.Clear")
+	defer span.End()
+
+	if err := s.KVStore.Delete(ctx, key); err != nil {
+		return fmt.Errorf("Unable to delete: %w", err)
 	}
 
 	return nil
@@ -100,26 +127,30 @@ func (s *Stocktopus) Clear(ctx context.Context, key string) error {
 // Deposit play money in account
 // NOTE: amount is a float because of legacy mistakes
 func (s *Stocktopus) Deposit(ctx context.Context, amount float64, key string) (*Account, error) {
-	acct, err := s.account(ctx, key)
-	if err != nil {
-		return nil, err
-	}
-
-	acct.Balance += amount
-
-	if err := s.saveAccount(ctx, key, acct); err != nil {
-		return nil, err
-	}
-
-	return acct, nil
+	ctx, span := tracing.Start(ctx, "stocktopus.Deposit")
+	defer span.End()
+
+	return s.updateAccount(ctx, key, func(acct *Account) error {
+		acct.Balance += amount
+		acct.Transactions = append(acct.Transactions, Transaction{
+			Time:   s.timestamp(),
+			Type:   TxDeposit,
+			Amount: amount,
+		})
+		return nil
+	})
 }
 
 // Buy shares for play money portfolio
 func (s *Stocktopus) Buy(ctx context.Context, ticker string, shares uint64, key string) (*Account, error) {
+	ctx, span := tracing.Start(ctx, "stocktopus.Buy")
+	defer span.End()
 
-	quote, err := s.StockInterface.BatchQuotes([]string{ticker})
+	ticker = s.resolveOne(ticker)
+
+	quote, err := s.stocks(ctx).BatchQuotes([]string{ticker})
 	if err != nil {
-		return nil, fmt.Errorf("quote failed: %w", err)
+		return nil, lookupError("quote failed: %w", err)
 	}
 	if len(quote) == 0 {
 		return nil, fmt.Errorf("quote: no info returned")
@@ -127,36 +158,41 @@ func (s *Stocktopus) Buy(ctx context.Context, ticker string, shares uint64, key
 
 	price := quote[0].LatestPrice
 
-	acct, err := s.account(ctx, key)
-	if err != nil {
-		return nil, err
-	}
-
-	if acct.Balance < (price * float64(shares)) {
-		return nil, ErrInsufficientFunds
-	}
-
-	// Add to account
-	acct.Balance -= (price * float64(shares))
-	h := acct.Holdings[ticker]
-	acct.Holdings[ticker] = Holding{
-		Strike: price,
-		Shares: h.Shares + shares,
-	}
-
-	if err := s.saveAccount(ctx, key, acct); err != nil {
-		return nil, err
-	}
+	return s.updateAccount(ctx, key, func(acct *Account) error {
+		if acct.Balance < (price * float64(shares)) {
+			return ErrInsufficientFunds
+		}
 
-	return acct, nil
+		// Add to account
+		acct.Balance -= (price * float64(shares))
+		h := acct.Holdings[ticker]
+		acct.Holdings[ticker] = Holding{
+			Strike: price,
+			Shares: h.Shares + shares,
+		}
+		acct.Transactions = append(acct.Transactions, Transaction{
+			Time:   s.timestamp(),
+			Type:   TxBuy,
+			Ticker: ticker,
+			Shares: shares,
+			Price:  price,
+			Amount: -(price * float64(shares)),
+		})
+
+		return nil
+	})
 }
 
 // Sell shares for play money portfolio
 func (s *Stocktopus) Sell(ctx context.Context, ticker string, shares uint64, key string) (*Account, error) {
+	ctx, span := tracing.Start(ctx, "stocktopus.Sell")
+	defer span.End()
+
+	ticker = s.resolveOne(ticker)
 
-	quote, err := s.StockInterface.BatchQuotes([]string{ticker})
+	quote, err := s.stocks(ctx).BatchQuotes([]string{ticker})
 	if err != nil {
-		return nil, fmt.Errorf("quote failed: %w", err)
+		return nil, lookupError("quote failed: %w", err)
 	}
 	if len(quote) == 0 {
 		return nil, fmt.Errorf("quote: no info returned")
@@ -164,42 +200,49 @@ func (s *Stocktopus) Sell(ctx context.Context, ticker string, shares uint64, key
 
 	price := quote[0].LatestPrice
 
-	acct, err := s.account(ctx, key)
-	if err != nil {
-		return nil, err
-	}
-
-	h, ok := acct.Holdings[ticker]
-	if !ok || h.Shares < shares {
-		return nil, ErrNumShares
-	}
-
-	newShares := h.Shares - shares
-	if newShares == 0 {
-		delete(acct.Holdings, ticker)
-	} else {
-		acct.Holdings[ticker] = Holding{
-			Strike: h.Strike,
-			Shares: newShares,
+	return s.updateAccount(ctx, key, func(acct *Account) error {
+		h, ok := acct.Holdings[ticker]
+		if !ok || h.Shares < shares {
+			return ErrNumShares
 		}
-	}
-
-	acct.Balance += float64(shares) * price
 
-	if err := s.saveAccount(ctx, key, acct); err != nil {
-		return nil, fmt.Errorf("Unable to save account: %w", err)
-	}
+		newShares := h.Shares - shares
+		if newShares == 0 {
+			delete(acct.Holdings, ticker)
+		} else {
+			acct.Holdings[ticker] = Holding{
+				Strike: h.Strike,
+				Shares: newShares,
+			}
+		}
 
-	return acct, nil
+		acct.Balance += float64(shares) * price
+		acct.Transactions = append(acct.Transactions, Transaction{
+			Time:   s.timestamp(),
+			Type:   TxSell,
+			Ticker: ticker,
+			Shares: shares,
+			Price:  price,
+			Amount: float64(shares) * price,
+		})
+
+		return nil
+	})
 }
 
 // Portfolio returns the account for a given key
 func (s *Stocktopus) Portfolio(ctx context.Context, key string) (*Account, error) {
+	ctx, span := tracing.Start(ctx, "stocktopus.Portfolio")
+	defer span.End()
+
 	return s.account(ctx, key)
 }
 
 // Latest populates the Latest map in the account (it is not saved)
 func (s *Stocktopus) Latest(ctx context.Context, acct *Account) (*Account, error) {
+	ctx, span := tracing.Start(ctx, "stocktopus.Latest")
+	defer span.End()
+
 	if len(acct.Holdings) == 0 {
 		return acct, nil
 	}
@@ -208,12 +251,12 @@ func (s *Stocktopus) Latest(ctx context.Context, acct *Account) (*Account, error
 	for ticker := range acct.Holdings {
 		tickers = append(tickers, ticker)
 	}
-	quotes, err := s.GetQuotes(tickers)
-	if err != nil {
+	quotes, err := s.GetQuotes(ctx, tickers)
+	if err != nil && !IsPartial(err) {
 		return nil, err
 	}
 
-	// Populate latest prices
+	// Populate latest prices, holdings without a quote are left out
 	acct.Latest = map[string]float64{}
 	for _, q := range quotes {
 		acct.Latest[q.Ticker] = q.LatestPrice
@@ -229,45 +272,105 @@ func (s *Stocktopus) Latest(ctx context.Context, acct *Account) (*Account, error
 //-------------------------------------
 
 // Info returns info about a given company
-func (s *Stocktopus) Info(ticker string) (*types.Company, error) {
-	info, err := s.StockInterface.Company(ticker)
+func (s *Stocktopus) Info(ctx context.Context, ticker string) (*types.Company, error) {
+	ctx, span := tracing.Start(ctx, "stocktopus.Info")
+	defer span.End()
+
+	info, err := s.stocks(ctx).Company(s.resolveOne(ticker))
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get company info: %w", err)
+		return nil, lookupError("Failed to get company info: %w", err)
 	}
 
 	return info, nil
 }
 
 // News returns the headlines for a given company
-func (s *Stocktopus) News(ticker string) ([]string, error) {
-	news, err := s.StockInterface.News(ticker)
+func (s *Stocktopus) News(ctx context.Context, ticker string) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "stocktopus.News")
+	defer span.End()
+
+	news, err := s.stocks(ctx).News(s.resolveOne(ticker))
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get news: %w", err)
+		return nil, lookupError("Failed to get news: %w", err)
 	}
 
 	return news, nil
 }
 
 // Stats returns company statistics
-func (s *Stocktopus) Stats(ticker string) (*types.Stats, error) {
-	stats, err := s.StockInterface.Stats(ticker)
+func (s *Stocktopus) Stats(ctx context.Context, ticker string) (*types.Stats, error) {
+	ctx, span := tracing.Start(ctx, "stocktopus.Stats")
+	defer span.End()
+
+	stats, err := s.stocks(ctx).Stats(s.resolveOne(ticker))
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get stats: %w", err)
+		return nil, lookupError("Failed to get stats: %w", err)
 	}
 
 	return stats, nil
 }
 
+// History returns a summary of a ticker's performance over the given range
+func (s *Stocktopus) History(ctx context.Context, ticker string, r stock.Range, i stock.Interval) (*Performance, error) {
+	ctx, span := tracing.Start(ctx, "stocktopus.History")
+	defer span.End()
+
+	ticker = s.resolveOne(ticker)
+	bars, err := s.stocks(ctx).History(ticker, r, i)
+	if err != nil {
+		return nil, lookupError("Failed to get history: %w", err)
+	}
+
+	if len(bars) == 0 {
+		return nil, ErrNoHistory
+	}
+
+	if i == "" {
+		i = r.DefaultInterval()
+	}
+	return NewPerformance(strings.ToUpper(ticker), r, i, bars), nil
+}
+
+// Search returns the symbols that best match the text
+func (s *Stocktopus) Search(ctx context.Context, text string) (SearchResults, error) {
+	_, span := tracing.Start(ctx, "stocktopus.Search")
+	defer span.End()
+
+	if s.Symbols == nil {
+		return nil, ErrNoSearch
+	}
+
+	symbols, err := s.Symbols.Search(text, maxSearchResults)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to search symbols: %w", err)
+	}
+
+	return SearchResults(symbols), nil
+}
+
 //-------------------------------------
 //
 // Helper funtions
 //
 //-------------------------------------
 
+// stocks returns the stock lookup, traced as part of the request ctx belongs to
+func (s *Stocktopus) stocks(ctx context.Context) stock.Lookup {
+	return stock.Traced(ctx, s.StockInterface)
+}
+
+// timestamp returns the time to record a transaction at
+func (s *Stocktopus) timestamp() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now().UTC()
+}
+
 func (s *Stocktopus) account(ctx context.Context, key string) (*Account, error) {
-	serialized, err := s.KVStore.Get(ctx, key).Result()
+	serialized, err := s.KVStore.Get(ctx, key)
 	if err != nil {
-		if errors.Is(err, redis.Nil) { // If no key found return fresh account
+		if errors.Is(err, storage.ErrNotFound) { // If no key found return fresh account
 			return &Account{
 				Holdings: map[string]Holding{},
 			}, nil
@@ -275,39 +378,80 @@ func (s *Stocktopus) account(ctx context.Context, key string) (*Account, error)
 		return nil, fmt.Errorf("Unable to load account: %w", err)
 	}
 
-	// Deserialize into struct
-	acct := &Account{}
-	if err := json.Unmarshal([]byte(serialized), acct); err != nil {
-		return nil, fmt.Errorf("Unable to parse account: %w", err)
+	return ParseAccount(serialized)
+}
+
+// updateAccount applies fn to the account stored at key in a single transaction
+func (s *Stocktopus) updateAccount(ctx context.Context, key string, fn func(*Account) error) (*Account, error) {
+	var acct *Account
+	err := s.KVStore.Update(ctx, key, func(old []byte) ([]byte, error) {
+		var err error
+		if acct, err = ParseAccount(old); err != nil {
+			return nil, err
+		}
+
+		// History starts now for new accounts and for those created before it was recorded
+		if acct.Opening == nil && len(acct.Transactions) == 0 {
+			opening := acct.Balance
+			acct.Opening = &opening
+		}
+
+		if err := fn(acct); err != nil {
+			return nil, err
+		}
+		acct.trim()
+
+		b, err := json.Marshal(acct)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to serialize account: %w", err)
+		}
+
+		return b, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return acct, nil
 }
 
-func (s *Stocktopus) saveAccount(ctx context.Context, key string, acct *Account) error {
-	b, err := json.Marshal(acct)
-	if err != nil {
-		return fmt.Errorf("Failed to serialize account: %w", err)
+// ParseAccount deserializes a stored account, returning a fresh account for a nil value
+func ParseAccount(serialized []byte) (*Account, error) {
+	acct := &Account{}
+	if serialized != nil {
+		if err := json.Unmarshal(serialized, acct); err != nil {
+			return nil, fmt.Errorf("Unable to parse account: %w", err)
+		}
 	}
 
-	if _, err := s.KVStore.Set(ctx, key, b, 0).Result(); err != nil {
-		return fmt.Errorf("Failed to save account: %w", err)
+	if acct.Holdings == nil {
+		acct.Holdings = map[string]Holding{}
 	}
 
-	return nil
+	return acct, nil
 }
 
-// GetQuotes returns a list of quotes from tickers
-func (s *Stocktopus) GetQuotes(tickers []string) (WatchList, error) {
-	quotes, err := s.StockInterface.BatchQuotes(tickers)
-	if err != nil {
-		return nil, err
+// GetQuotes returns a list of quotes from tickers. If only some of the tickers could be quoted
+// the partial list is returned along with a *stock.PartialError naming the missing symbols.
+func (s *Stocktopus) GetQuotes(ctx context.Context, tickers []string) (WatchList, error) {
+	ctx, span := tracing.Start(ctx, "stocktopus.GetQuotes")
+	defer span.End()
+
+	quotes, err := s.stocks(ctx).BatchQuotes(s.resolve(tickers))
+	if err != nil && !IsPartial(err) {
+		return nil, lookupError("quote failed: %w", err)
 	}
 
 	// Sort the list
 	sort.Sort(WatchList(quotes))
 
-	return WatchList(quotes), nil
+	return WatchList(quotes), err
+}
+
+// IsPartial returns true if the error only reports symbols missing from an otherwise successful lookup
+func IsPartial(err error) bool {
+	var perr *stock.PartialError
+	return errors.As(err, &perr)
 }
 
 // GetChartLink returns a chart link for a given ticker
@@ -315,3 +459,43 @@ func (s *Stocktopus) GetChartLink(ticker string) string {
 	symbol := strings.ToUpper(ticker)
 	return fmt.Sprintf("http://finviz.com/chart.ashx?t=%s&ty=c&ta=1&p=d&s=l", symbol)
 }
+
+// resolve replaces company names with tickers when name resolution is enabled. Every name is
+// resolved against the same snapshot of the directory.
+func (s *Stocktopus) resolve(tickers []string) []string {
+	if s.Symbols == nil || !s.ResolveNames {
+		return tickers
+	}
+
+	snap, err := s.Symbols.Snapshot()
+	if err != nil {
+		return tickers
+	}
+
+	resolved := make([]string, 0, len(tickers))
+	for _, t := range tickers {
+		resolved = append(resolved, resolveName(snap, t))
+	}
+
+	return resolved
+}
+
+// resolveOne returns the ticker for a company name if exactly one company matches, otherwise the name is returned unchanged
+func (s *Stocktopus) resolveOne(name string) string {
+	return s.resolve([]string{name})[0]
+}
+
+// resolveName returns the ticker for a company name in snap, or the name if it's a known ticker
+// or doesn't match exactly one company
+func resolveName(snap *stock.Snapshot, name string) string {
+	if _, ok := snap.Lookup(name); ok {
+		return name
+	}
+
+	ticker, err := snap.Resolve(name)
+	if err != nil {
+		return name
+	}
+
+	return ticker
+}
@@ -2,42 +2,24 @@ package stocktopus
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
-	"github.com/alicebob/miniredis"
-	redis "github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/require"
 	"github.com/thorfour/iex/pkg/types"
 	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stock/stocktest"
+	"github.com/thorfour/stocktopus/pkg/storage"
 )
 
-// fakeLookup implements the stock.Lookup interface
-type fakeLookup struct {
-	fakeQuotes  []*stock.Quote
-	fakeCompany *types.Company
-	fakeStats   *types.Stats
-	fakeNews    []string
-}
-
-func (f *fakeLookup) Price(string) (float64, error)                  { return 1.00, nil }
-func (f *fakeLookup) BatchQuotes(q []string) ([]*stock.Quote, error) { return f.fakeQuotes, nil }
-func (f *fakeLookup) News(string) ([]string, error)                  { return f.fakeNews, nil }
-func (f *fakeLookup) Stats(string) (*types.Stats, error)             { return f.fakeStats, nil }
-func (f *fakeLookup) Company(string) (*types.Company, error)         { return f.fakeCompany, nil }
-
 func TestAccount(t *testing.T) {
-
-	// Start mini redis instance to connect to
-	mr, err := miniredis.Run()
-	require.NoError(t, err)
-	defer mr.Close()
-
+	now := time.Date(2020, 6, 1, 15, 0, 0, 0, time.UTC)
 	s := &Stocktopus{
-		KVStore: redis.NewClient(&redis.Options{
-			Addr: mr.Addr(),
-		}),
-		StockInterface: &fakeLookup{
-			fakeQuotes: []*stock.Quote{
+		now:     func() time.Time { return now },
+		KVStore: storage.NewMemory(),
+		StockInterface: &stocktest.Lookup{
+			Quotes: []*stock.Quote{
 				{
 					Ticker:        "AMD",
 					LatestPrice:   1.00,
@@ -45,24 +27,29 @@ func TestAccount(t *testing.T) {
 					ChangePercent: 0,
 				},
 			},
-			fakeCompany: &types.Company{},
-			fakeStats:   &types.Stats{},
-			fakeNews:    []string{},
+			Profile:    &types.Company{},
+			Statistics: &types.Stats{},
+			Headlines:  []string{},
 		},
 	}
 
 	ctx := context.Background()
 	a, err := s.Deposit(ctx, 1000, "mykey")
 	require.NoError(t, err)
+	deposit := Transaction{Time: now, Type: TxDeposit, Amount: 1000}
+	opening := float64(0)
 	require.Equal(t, &Account{
-		Balance:  1000,
-		Holdings: map[string]Holding{},
+		Balance:      1000,
+		Holdings:     map[string]Holding{},
+		Transactions: []Transaction{deposit},
+		Opening:      &opening,
 	}, a)
 
 	require.Equal(t, "Balance: $1000.00", a.String())
 
 	a, err = s.Buy(ctx, "AMD", 1, "mykey")
 	require.NoError(t, err)
+	buy := Transaction{Time: now, Type: TxBuy, Ticker: "AMD", Shares: 1, Price: 1, Amount: -1}
 	require.Equal(t, &Account{
 		Balance: 999,
 		Holdings: map[string]Holding{
@@ -71,6 +58,8 @@ func TestAccount(t *testing.T) {
 				Shares: 1,
 			},
 		},
+		Transactions: []Transaction{deposit, buy},
+		Opening:      &opening,
 	}, a)
 
 	a, err = s.Latest(ctx, a)
@@ -86,6 +75,8 @@ func TestAccount(t *testing.T) {
 		Latest: map[string]float64{
 			"AMD": 1.00,
 		},
+		Transactions: []Transaction{deposit, buy},
+		Opening:      &opening,
 	}, a)
 
 	exp :=
@@ -102,26 +93,29 @@ Total: $1000.00`
 
 	a, err = s.Sell(ctx, "AMD", 1, "mykey")
 	require.NoError(t, err)
+	sell := Transaction{Time: now, Type: TxSell, Ticker: "AMD", Shares: 1, Price: 1, Amount: 1}
 	require.Equal(t, &Account{
-		Balance:  1000,
-		Holdings: map[string]Holding{},
+		Balance:      1000,
+		Holdings:     map[string]Holding{},
+		Transactions: []Transaction{deposit, buy, sell},
+		Opening:      &opening,
 	}, a)
 	require.Equal(t, "Balance: $1000.00", a.String())
+
+	// Accounts from before history was recorded open at their balance
+	require.NoError(t, s.KVStore.Put(ctx, "oldkey", []byte(`{"Balance":50}`)))
+	a, err = s.Deposit(ctx, 10, "oldkey")
+	require.NoError(t, err)
+	require.Equal(t, float64(50), *a.Opening)
+	require.Equal(t, float64(60), a.Balance)
 }
 
 func TestWatchList(t *testing.T) {
 
-	// Start mini redis instance to connect to
-	mr, err := miniredis.Run()
-	require.NoError(t, err)
-	defer mr.Close()
-
 	s := &Stocktopus{
-		KVStore: redis.NewClient(&redis.Options{
-			Addr: mr.Addr(),
-		}),
-		StockInterface: &fakeLookup{
-			fakeQuotes: []*stock.Quote{
+		KVStore: storage.NewMemory(),
+		StockInterface: &stocktest.Lookup{
+			Quotes: []*stock.Quote{
 				{
 					Ticker:        "AMD",
 					LatestPrice:   1.00,
@@ -135,9 +129,9 @@ func TestWatchList(t *testing.T) {
 					ChangePercent: 0,
 				},
 			},
-			fakeCompany: &types.Company{},
-			fakeStats:   &types.Stats{},
-			fakeNews:    []string{},
+			Profile:    &types.Company{},
+			Statistics: &types.Stats{},
+			Headlines:  []string{},
 		},
 	}
 
@@ -175,7 +169,7 @@ func TestWatchList(t *testing.T) {
 	require.Equal(t, exp, wl.String())
 
 	require.NoError(t, s.Remove(ctx, []string{"AMD"}, "mykey"))
-	s.StockInterface.(*fakeLookup).fakeQuotes = []*stock.Quote{
+	s.StockInterface.(*stocktest.Lookup).Quotes = []*stock.Quote{
 		{
 			Ticker:        "TSLA",
 			LatestPrice:   8.00,
@@ -203,3 +197,208 @@ func TestWatchList(t *testing.T) {
 
 	require.Equal(t, exp, wl.String())
 }
+
+func TestPartialWatchList(t *testing.T) {
+	s := &Stocktopus{
+		StockInterface: &stocktest.Lookup{
+			Quotes: []*stock.Quote{
+				{
+					Ticker:        "AMD",
+					LatestPrice:   1.00,
+					Change:        0,
+					ChangePercent: 0,
+				},
+			},
+			Err: &stock.PartialError{
+				Errors: map[string]error{
+					"FAKE": stock.ErrUnknownSymbol,
+					"SLOW": &stock.RateLimitError{Provider: "iex"},
+				},
+			},
+		},
+	}
+
+	wl, err := s.GetQuotes(context.Background(), []string{"AMD", "FAKE"})
+	require.True(t, IsPartial(err))
+	require.Len(t, wl, 1)
+
+	exp :=
+		`    Company       Current Price       Todays Change       Percent Change 
+------------  ------------------  ------------------  -------------------
+        AMD                   1                0.00                0.000 
+       Avg.                 ---                 ---               0.000% 
+Not found: FAKE
+Temporarily unavailable: SLOW
+`
+
+	require.Equal(t, exp, wl.String()+Missing(err))
+
+	// Rate limited symbols say when to try again
+	require.Equal(t, "Temporarily unavailable: SLOW, try again in 24 seconds\n", MissingText(nil, []string{"SLOW"}, 23500*time.Millisecond))
+}
+
+func TestHistory(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	s := &Stocktopus{
+		StockInterface: &stocktest.Lookup{
+			Bars: []*stock.Bar{
+				{Time: start, Open: 10, High: 12, Low: 9, Close: 11, Volume: 1000},
+				{Time: start.AddDate(0, 0, 1), Open: 11, High: 15, Low: 10, Close: 14, Volume: 3000},
+				{Time: start.AddDate(0, 0, 2), Open: 14, High: 14, Low: 8, Close: 12, Volume: 2000},
+			},
+		},
+	}
+
+	p, err := s.History(context.Background(), "amd", stock.Range5Days, "")
+	require.NoError(t, err)
+	require.Equal(t, &Performance{
+		Ticker:         "AMD",
+		Range:          stock.Range5Days,
+		Start:          start,
+		End:            start.AddDate(0, 0, 2),
+		Open:           10,
+		Close:          12,
+		High:           15,
+		Low:            8,
+		AvgVolume:      2000,
+		VolumeInterval: stock.Interval1Day,
+		Return:         0.2,
+	}, p)
+
+	exp :=
+		` AMD               Value                           
+----------------  ---------------------------------
+ Period            5d (2020-06-01 - 2020-06-03)    
+ Return            20.00%                          
+ Open              10.00                           
+ Close             12.00                           
+ High              15.00                           
+ Low               8.00                            
+ Avg. Volume       2,000                           
+`
+	require.Equal(t, exp, p.String())
+
+	// Intraday bars are added up by trading day
+	open := time.Date(2020, 6, 1, 13, 30, 0, 0, time.UTC)
+	s.StockInterface.(*stocktest.Lookup).Bars = []*stock.Bar{
+		{Time: open, Open: 10, High: 10, Low: 10, Close: 10, Volume: 1000},
+		{Time: open.Add(6 * time.Hour), Open: 10, High: 10, Low: 10, Close: 10, Volume: 3000},
+		{Time: open.AddDate(0, 0, 1), Open: 10, High: 10, Low: 10, Close: 10, Volume: 2000},
+	}
+	p, err = s.History(context.Background(), "amd", stock.Range5Days, stock.Interval1Hour)
+	require.NoError(t, err)
+	require.Equal(t, float64(3000), p.AvgVolume)
+
+	// Longer bars are averaged as they are, whatever interval was asked for
+	friday := time.Date(2020, 6, 5, 0, 0, 0, 0, time.UTC)
+	s.StockInterface.(*stocktest.Lookup).Bars = []*stock.Bar{
+		{Time: friday, Open: 10, High: 10, Low: 10, Close: 10, Volume: 5000},
+		{Time: friday.AddDate(0, 0, 6), Open: 10, High: 10, Low: 10, Close: 10, Volume: 4000}, // A holiday week
+		{Time: friday.AddDate(0, 0, 14), Open: 10, High: 10, Low: 10, Close: 10, Volume: 6000},
+	}
+	p, err = s.History(context.Background(), "amd", stock.Range6Months, "")
+	require.NoError(t, err)
+	require.Equal(t, float64(5000), p.AvgVolume)
+	require.Equal(t, stock.Interval1Week, p.VolumeInterval)
+	require.Contains(t, p.String(), "Avg. Volume (1wk)")
+
+	s.StockInterface.(*stocktest.Lookup).Bars = []*stock.Bar{
+		{Time: time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC), Open: 10, High: 10, Low: 10, Close: 10, Volume: 20000},
+		{Time: time.Date(2020, 2, 28, 0, 0, 0, 0, time.UTC), Open: 10, High: 10, Low: 10, Close: 10, Volume: 40000},
+	}
+	p, err = s.History(context.Background(), "amd", stock.Range5Years, "")
+	require.NoError(t, err)
+	require.Equal(t, float64(30000), p.AvgVolume)
+	require.Equal(t, stock.Interval1Month, p.VolumeInterval)
+	require.Contains(t, p.String(), "Avg. Volume (1mo)")
+
+	s.StockInterface.(*stocktest.Lookup).Bars = nil
+	_, err = s.History(context.Background(), "amd", stock.Range5Days, "")
+	require.True(t, errors.Is(err, ErrNoHistory))
+}
+
+func TestQuoteMarkers(t *testing.T) {
+	q := &stock.Quote{
+		Ticker:        "AMD",
+		LatestPrice:   50,
+		Open:          48,
+		High:          51,
+		Low:           47.5,
+		PreviousClose: 49,
+		Volume:        1234567,
+		AvgVolume:     2000000,
+		Exchange:      "NASDAQ",
+		Currency:      "USD",
+		LatestUpdate:  time.Date(2020, 6, 1, 20, 0, 0, 0, time.UTC),
+		Closed:        true,
+	}
+
+	exp :=
+		`    Company       Current Price       Todays Change       Percent Change 
+------------  ------------------  ------------------  -------------------
+        AMD         50 (closed)                0.00                0.000 
+       Avg.                 ---                 ---               0.000% 
+`
+	require.Equal(t, exp, WatchList{q}.String())
+
+	exp =
+		` AMD               Value                   
+----------------  -------------------------
+ Open              48.00                   
+ Day Range         47.50 - 51.00           
+ Prev. Close       49.00                   
+ Volume            1,234,567               
+ Avg. Volume       2,000,000               
+ Exchange          NASDAQ USD              
+ As Of             2020-06-01 20:00 UTC    
+`
+	require.Equal(t, exp, Details(q))
+}
+
+func TestUnavailableFields(t *testing.T) {
+	w := WatchList{
+		{Ticker: "TSLA", LatestPrice: 5, Unavailable: []string{stock.FieldChange, stock.FieldVolume}},
+		{Ticker: "AMD", LatestPrice: 2, Change: -0.5, ChangePercent: -0.2},
+	}
+
+	// Quotes without a change sort after every loss and aren't averaged
+	w.SortBy(SortChange)
+	require.Equal(t, "AMD", w[0].Ticker)
+	out := w.String()
+	require.Regexp(t, `TSLA\s+5\s+n/a\s+n/a`, out)
+	require.Regexp(t, `Avg\.\s+---\s+---\s+-20\.000%`, out)
+
+	require.Regexp(t, `Volume\s+n/a`, Details(w[1]))
+}
+
+type fakeLister []*stock.Symbol
+
+func (f fakeLister) Symbols() ([]*stock.Symbol, error) { return f, nil }
+
+func TestResolveNames(t *testing.T) {
+	s := &Stocktopus{
+		KVStore:        storage.NewMemory(),
+		StockInterface: &stocktest.Lookup{},
+		Symbols: stock.NewDirectory(fakeLister{
+			{Ticker: "AAPL", Name: "Apple Inc."},
+			{Ticker: "APLE", Name: "Apple Hospitality REIT Inc"},
+			{Ticker: "GOOG", Name: "Alphabet Inc. Class C"},
+			{Ticker: "GOOGL", Name: "Alphabet Inc. Class A"},
+		}, time.Hour),
+		ResolveNames: true,
+	}
+
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, []string{"APPLE", "APLE", "ALPHABET"}, "mykey"))
+	members, err := s.KVStore.Members(ctx, "mykey")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"AAPL", "APLE", "ALPHABET"}, members)
+
+	results, err := s.Search(ctx, "apple")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	s.Symbols = nil
+	_, err = s.Search(ctx, "apple")
+	require.True(t, errors.Is(err, ErrNoSearch))
+}
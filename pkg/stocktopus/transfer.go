@@ -0,0 +1,420 @@
+package stocktopus
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bndr/gotabulate"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/storage"
+	"github.com/thorfour/stocktopus/pkg/tracing"
+)
+
+var (
+	// ErrNoImport is returned when confirming an import that was never previewed
+	ErrNoImport = &UserError{Message: "No import to confirm, send the data with import first"}
+
+	// ErrImportExpired is returned when confirming an import that was previewed too long ago
+	ErrImportExpired = &UserError{Message: "Import expired, send the data with import again"}
+
+	// ErrEmptyImport is returned when there's nothing valid to import
+	ErrEmptyImport = InvalidInput("Nothing to import", nil)
+)
+
+const (
+	// importTTL is how long a previewed import can be confirmed for
+	importTTL = time.Hour
+
+	// maxImportRows limits the size of a single import
+	maxImportRows = 500
+)
+
+//-------------------------------------
+//
+// Export
+//
+//-------------------------------------
+
+// Export is a copy of everything stored for a user
+type Export struct {
+	Exported     time.Time
+	WatchList    []string
+	TeamLists    map[string][]string `json:",omitempty"`
+	Balance      float64
+	Holdings     map[string]Holding
+	Lots         []Lot
+	Transactions []Transaction
+}
+
+// Export collects the user's watch list and account, along with any team lists keyed by name
+func (s *Stocktopus) Export(ctx context.Context, listKey, acctKey string, teamLists map[string]string) (*Export, error) {
+	ctx, span := tracing.Start(ctx, "stocktopus.Export")
+	defer span.End()
+
+	list, err := s.KVStore.Members(ctx, listKey)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load list: %w", err)
+	}
+
+	acct, err := s.account(ctx, acctKey)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Export{
+		Exported:     s.timestamp(),
+		WatchList:    list,
+		Balance:      acct.Balance,
+		Holdings:     acct.Holdings,
+		Lots:         acct.Lots(),
+		Transactions: acct.Transactions,
+	}
+
+	for name, key := range teamLists {
+		members, err := s.KVStore.Members(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load list %s: %w", name, err)
+		}
+		if len(members) == 0 {
+			continue
+		}
+		if e.TeamLists == nil {
+			e.TeamLists = map[string][]string{}
+		}
+		e.TeamLists[name] = members
+	}
+
+	return e, nil
+}
+
+// WriteJSON writes the export as JSON
+func (e *Export) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(e)
+}
+
+// WriteCSV writes the export as a single CSV where the first column is the kind of record
+func (e *Export) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	write := func(kind, list, ticker string, shares uint64, price, amount float64, t time.Time) {
+		row := []string{kind, list, ticker, "", "", "", ""}
+		if shares != 0 {
+			row[3] = strconv.FormatUint(shares, 10)
+		}
+		if price != 0 {
+			row[4] = strconv.FormatFloat(price, 'f', -1, 64)
+		}
+		if amount != 0 || kind == "balance" {
+			row[5] = strconv.FormatFloat(amount, 'f', -1, 64)
+		}
+		if !t.IsZero() {
+			row[6] = t.UTC().Format(time.RFC3339)
+		}
+		cw.Write(row)
+	}
+
+	cw.Write([]string{"record", "list", "ticker", "shares", "price", "amount", "time"})
+	for _, ticker := range e.WatchList {
+		write("watch", "", ticker, 0, 0, 0, time.Time{})
+	}
+
+	names := make([]string, 0, len(e.TeamLists))
+	for name := range e.TeamLists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, ticker := range e.TeamLists[name] {
+			write("watch", "#"+name, ticker, 0, 0, 0, time.Time{})
+		}
+	}
+
+	write("balance", "", "", 0, 0, e.Balance, time.Time{})
+	for _, l := range e.Lots {
+		write("lot", "", l.Ticker, l.Shares, l.Price, 0, l.Time)
+	}
+	for _, tx := range e.Transactions {
+		write(strings.ToLower(tx.Type), "", tx.Ticker, tx.Shares, tx.Price, tx.Amount, tx.Time)
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+//-------------------------------------
+//
+// Import
+//
+//-------------------------------------
+
+// Position is an imported holding
+type Position struct {
+	Ticker string
+	Shares uint64
+	// Price is the cost basis per share, the latest price is used if it's zero
+	Price float64
+}
+
+// Import is a set of tickers and positions waiting to be confirmed
+type Import struct {
+	Created time.Time
+	// ListKey is the watch list tickers are added to
+	ListKey string
+	// AcctKey is the account positions are added to
+	AcctKey   string
+	Tickers   []string
+	Positions []Position
+	// Rejected lists the rows that won't be imported and why
+	Rejected []string
+}
+
+var validTicker = regexp.MustCompile(`^[A-Z0-9.:\-]{1,20}$`)
+
+// ParseImport reads a CSV of tickers or positions. A row of ticker,shares with an optional
+// cost basis price is a position, any other row is a list of tickers. A header row is ignored.
+func ParseImport(r io.Reader) (*Import, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	imp := &Import{}
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, InvalidInput("Invalid CSV: "+err.Error(), err)
+		}
+		if row > maxImportRows {
+			return nil, InvalidInput(fmt.Sprintf("Too many rows, the limit is %v", maxImportRows), nil)
+		}
+
+		first := strings.ToLower(strings.TrimSpace(record[0]))
+		if row == 1 && (first == "ticker" || first == "symbol") {
+			continue
+		}
+
+		// A position has a whole number of shares in the second column
+		if len(record) == 2 || len(record) == 3 {
+			if shares, err := strconv.ParseUint(strings.TrimSpace(record[1]), 10, 64); err == nil {
+				imp.addPosition(row, record, shares)
+				continue
+			}
+		}
+
+		for _, field := range record {
+			ticker := strings.ToUpper(strings.TrimSpace(field))
+			switch {
+			case ticker == "":
+			case !validTicker.MatchString(ticker):
+				imp.Rejected = append(imp.Rejected, fmt.Sprintf("row %v: invalid ticker %q", row, field))
+			default:
+				imp.Tickers = append(imp.Tickers, ticker)
+			}
+		}
+	}
+
+	return imp, nil
+}
+
+// addPosition validates and adds a position row
+func (imp *Import) addPosition(row int, record []string, shares uint64) {
+	ticker := strings.ToUpper(strings.TrimSpace(record[0]))
+	if !validTicker.MatchString(ticker) {
+		imp.Rejected = append(imp.Rejected, fmt.Sprintf("row %v: invalid ticker %q", row, record[0]))
+		return
+	}
+	if shares == 0 {
+		imp.Rejected = append(imp.Rejected, fmt.Sprintf("row %v: no shares", row))
+		return
+	}
+
+	p := Position{Ticker: ticker, Shares: shares}
+	if len(record) == 3 && strings.TrimSpace(record[2]) != "" {
+		price, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(record[2]), "$"), 64)
+		if err != nil || price < 0 {
+			imp.Rejected = append(imp.Rejected, fmt.Sprintf("row %v: invalid price %q", row, record[2]))
+			return
+		}
+		p.Price = price
+	}
+
+	imp.Positions = append(imp.Positions, p)
+}
+
+// PreviewImport checks every ticker against the stock provider, fills in missing prices and
+// saves the import at pendingKey to be confirmed later
+func (s *Stocktopus) PreviewImport(ctx context.Context, imp *Import, pendingKey string) error {
+	ctx, span := tracing.Start(ctx, "stocktopus.PreviewImport")
+	defer span.End()
+
+	tickers := append([]string{}, imp.Tickers...)
+	for _, p := range imp.Positions {
+		tickers = append(tickers, p.Ticker)
+	}
+	if len(tickers) == 0 {
+		return ErrEmptyImport
+	}
+
+	quotes, err := s.stocks(ctx).BatchQuotes(tickers)
+	if err != nil && !IsPartial(err) && !errors.Is(err, stock.ErrUnknownSymbol) {
+		return lookupError("quote failed: %w", err)
+	}
+
+	latest := map[string]float64{}
+	for _, q := range quotes {
+		latest[strings.ToUpper(q.Ticker)] = q.LatestPrice
+	}
+
+	valid := imp.Tickers[:0]
+	seen := map[string]bool{}
+	for _, t := range imp.Tickers {
+		_, ok := latest[t]
+		switch {
+		case !ok:
+			imp.Rejected = append(imp.Rejected, fmt.Sprintf("%s: not found", t))
+		case !seen[t]:
+			valid = append(valid, t)
+		}
+		seen[t] = true
+	}
+	imp.Tickers = valid
+
+	positions := imp.Positions[:0]
+	for _, p := range imp.Positions {
+		price, ok := latest[p.Ticker]
+		if !ok {
+			imp.Rejected = append(imp.Rejected, fmt.Sprintf("%s: not found", p.Ticker))
+			continue
+		}
+		if p.Price == 0 {
+			p.Price = price
+		}
+		positions = append(positions, p)
+	}
+	imp.Positions = positions
+
+	if len(imp.Tickers) == 0 && len(imp.Positions) == 0 {
+		return InvalidInput(fmt.Sprintf("%s: %s", ErrEmptyImport.Message, strings.Join(imp.Rejected, ", ")), ErrEmptyImport)
+	}
+
+	imp.Created = s.timestamp()
+	b, err := json.Marshal(imp)
+	if err != nil {
+		return fmt.Errorf("Failed to serialize import: %w", err)
+	}
+
+	if err := s.KVStore.Put(ctx, pendingKey, b); err != nil {
+		return fmt.Errorf("Unable to save import: %w", err)
+	}
+
+	return nil
+}
+
+// ConfirmImport applies the import saved at pendingKey
+func (s *Stocktopus) ConfirmImport(ctx context.Context, pendingKey string) (*Import, error) {
+	ctx, span := tracing.Start(ctx, "stocktopus.ConfirmImport")
+	defer span.End()
+
+	b, err := s.KVStore.Get(ctx, pendingKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNoImport
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load import: %w", err)
+	}
+
+	imp := &Import{}
+	if err := json.Unmarshal(b, imp); err != nil {
+		return nil, fmt.Errorf("Unable to parse import: %w", err)
+	}
+
+	if s.timestamp().Sub(imp.Created) > importTTL {
+		s.KVStore.Delete(ctx, pendingKey)
+		return nil, ErrImportExpired
+	}
+
+	if len(imp.Tickers) > 0 {
+		if err := s.KVStore.AddMembers(ctx, imp.ListKey, imp.Tickers...); err != nil {
+			return nil, fmt.Errorf("Unable to add to list: %w", err)
+		}
+	}
+
+	if len(imp.Positions) > 0 {
+		_, err := s.updateAccount(ctx, imp.AcctKey, func(acct *Account) error {
+			for _, p := range imp.Positions {
+				h := acct.Holdings[p.Ticker]
+				acct.Holdings[p.Ticker] = Holding{
+					Strike: p.Price,
+					Shares: h.Shares + p.Shares,
+				}
+				acct.Transactions = append(acct.Transactions, Transaction{
+					Time:   s.timestamp(),
+					Type:   TxImport,
+					Ticker: p.Ticker,
+					Shares: p.Shares,
+					Price:  p.Price,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.KVStore.Delete(ctx, pendingKey); err != nil {
+		return nil, fmt.Errorf("Unable to delete import: %w", err)
+	}
+
+	return imp, nil
+}
+
+// CancelImport discards the import saved at pendingKey
+func (s *Stocktopus) CancelImport(ctx context.Context, pendingKey string) error {
+	ctx, span := tracing.Start(ctx, "stocktopus.CancelImport")
+	defer span.End()
+
+	if err := s.KVStore.Delete(ctx, pendingKey); err != nil {
+		return fmt.Errorf("Unable to delete import: %w", err)
+	}
+
+	return nil
+}
+
+func (imp *Import) String() string {
+	var b strings.Builder
+	if len(imp.Tickers) > 0 {
+		fmt.Fprintf(&b, "Watch: %s\n", strings.Join(imp.Tickers, ", "))
+	}
+
+	if len(imp.Positions) > 0 {
+		rows := make([][]interface{}, 0, len(imp.Positions))
+		for _, p := range imp.Positions {
+			rows = append(rows, []interface{}{p.Ticker, p.Shares, p.Price})
+		}
+
+		t := gotabulate.Create(rows)
+		t.SetHeaders([]string{"Ticker", "Shares", "Strike"})
+		t.SetAlign("left")
+		t.SetHideLines([]string{"bottomLine", "betweenLine", "top"})
+		b.WriteString(t.Render("simple"))
+	}
+
+	if len(imp.Rejected) > 0 {
+		fmt.Fprintf(&b, "Skipped: %s\n", strings.Join(imp.Rejected, "; "))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,178 @@
+package stocktopus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stock/stocktest"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+func TestLots(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 6, d, 0, 0, 0, 0, time.UTC) }
+	a := &Account{
+		Holdings: map[string]Holding{
+			"AMD":  {Strike: 3, Shares: 12},
+			"TSLA": {Strike: 5, Shares: 1},
+		},
+		Transactions: []Transaction{
+			{Time: day(1), Type: TxBuy, Ticker: "AMD", Shares: 5, Price: 1},
+			{Time: day(2), Type: TxBuy, Ticker: "AMD", Shares: 5, Price: 2},
+			{Time: day(3), Type: TxSell, Ticker: "AMD", Shares: 7, Price: 2},
+			{Time: day(4), Type: TxImport, Ticker: "AMD", Shares: 4, Price: 3},
+		},
+	}
+
+	// TSLA was bought before transactions were recorded, and AMD has 5 shares from before
+	require.Equal(t, []Lot{
+		{Ticker: "AMD", Shares: 5, Price: 3},
+		{Ticker: "AMD", Shares: 3, Price: 2, Time: day(2)},
+		{Ticker: "AMD", Shares: 4, Price: 3, Time: day(4)},
+		{Ticker: "TSLA", Shares: 1, Price: 5},
+	}, a.Lots())
+}
+
+func TestTrim(t *testing.T) {
+	s := &Stocktopus{KVStore: storage.NewMemory(), StockInterface: &stocktest.Lookup{Quotes: []*stock.Quote{{Ticker: "AMD", LatestPrice: 1}}}}
+	ctx := context.Background()
+
+	_, err := s.Deposit(ctx, 10, "acct")
+	require.NoError(t, err)
+	_, err = s.Buy(ctx, "AMD", 2, "acct")
+	require.NoError(t, err)
+	_, err = s.Buy(ctx, "AMD", 3, "acct")
+	require.NoError(t, err)
+	_, err = s.Sell(ctx, "AMD", 1, "acct")
+	require.NoError(t, err)
+	before, err := s.Portfolio(ctx, "acct")
+	require.NoError(t, err)
+
+	var a *Account
+	for i := len(before.Transactions); i < MaxTransactions+2; i++ {
+		a, err = s.Deposit(ctx, 1, "acct")
+		require.NoError(t, err)
+	}
+
+	// The oldest transactions are dropped without changing the balance or lots
+	require.Len(t, a.Transactions, MaxTransactions)
+	require.Equal(t, 2, a.Dropped)
+	require.Equal(t, float64(8), *a.Opening, "the deposit and first buy")
+	require.Equal(t, before.Lots(), a.Lots())
+	require.Len(t, a.Carried, 1)
+
+	total := *a.Opening
+	for _, tx := range a.Transactions {
+		total += tx.Amount
+	}
+	require.Equal(t, a.Balance, total)
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 6, 1, 15, 0, 0, 0, time.UTC)
+	s := &Stocktopus{
+		KVStore: storage.NewMemory(),
+		StockInterface: &stocktest.Lookup{
+			Quotes: []*stock.Quote{{Ticker: "AMD", LatestPrice: 2}},
+		},
+		now: func() time.Time { return now },
+	}
+
+	require.NoError(t, s.Add(ctx, []string{"AMD"}, "list"))
+	require.NoError(t, s.Add(ctx, []string{"TSLA"}, "team"))
+	_, err := s.Deposit(ctx, 100, "acct")
+	require.NoError(t, err)
+	_, err = s.Buy(ctx, "AMD", 10, "acct")
+	require.NoError(t, err)
+
+	e, err := s.Export(ctx, "list", "acct", map[string]string{"fun": "team", "empty": "missing"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"fun": {"TSLA"}}, e.TeamLists)
+
+	b := &bytes.Buffer{}
+	require.NoError(t, e.WriteCSV(b))
+	require.Equal(t, `record,list,ticker,shares,price,amount,time
+watch,,AMD,,,,
+watch,#fun,TSLA,,,,
+balance,,,,,80,
+lot,,AMD,10,2,,2020-06-01T15:00:00Z
+deposit,,,,,100,2020-06-01T15:00:00Z
+buy,,AMD,10,2,-20,2020-06-01T15:00:00Z
+`, b.String())
+
+	b.Reset()
+	require.NoError(t, e.WriteJSON(b))
+	require.Contains(t, b.String(), `"WatchList": [`)
+}
+
+func TestParseImport(t *testing.T) {
+	imp, err := ParseImport(strings.NewReader("ticker,shares,price\namd,10,$1.50\ntsla, 2\nGOOG,MSFT\nbad ticker\nnflx,0\n"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"GOOG", "MSFT"}, imp.Tickers)
+	require.Equal(t, []Position{
+		{Ticker: "AMD", Shares: 10, Price: 1.5},
+		{Ticker: "TSLA", Shares: 2},
+	}, imp.Positions)
+	require.Equal(t, []string{`row 5: invalid ticker "bad ticker"`, "row 6: no shares"}, imp.Rejected)
+
+	_, err = ParseImport(strings.NewReader(`amd,"unterminated`))
+	require.Error(t, err)
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 6, 1, 15, 0, 0, 0, time.UTC)
+	s := &Stocktopus{
+		KVStore: storage.NewMemory(),
+		StockInterface: &stocktest.Lookup{
+			Quotes: []*stock.Quote{{Ticker: "AMD", LatestPrice: 2}, {Ticker: "TSLA", LatestPrice: 5}},
+			Err:    &stock.PartialError{Errors: map[string]error{"FAKE": stock.ErrUnknownSymbol}},
+		},
+		now: func() time.Time { return now },
+	}
+
+	_, err := s.ConfirmImport(ctx, "pending")
+	require.True(t, errors.Is(err, ErrNoImport))
+
+	imp, err := ParseImport(strings.NewReader("tsla,fake\namd,10\nfake,1,1"))
+	require.NoError(t, err)
+	imp.ListKey, imp.AcctKey = "list", "acct"
+	require.NoError(t, s.PreviewImport(ctx, imp, "pending"))
+	require.Equal(t, []string{"TSLA"}, imp.Tickers)
+	require.Equal(t, []Position{{Ticker: "AMD", Shares: 10, Price: 2}}, imp.Positions)
+	require.Equal(t, []string{"FAKE: not found", "FAKE: not found"}, imp.Rejected)
+
+	// Nothing changes until the import is confirmed
+	members, err := s.KVStore.Members(ctx, "list")
+	require.NoError(t, err)
+	require.Empty(t, members)
+
+	_, err = s.ConfirmImport(ctx, "pending")
+	require.NoError(t, err)
+
+	members, err = s.KVStore.Members(ctx, "list")
+	require.NoError(t, err)
+	require.Equal(t, []string{"TSLA"}, members)
+
+	a, err := s.Portfolio(ctx, "acct")
+	require.NoError(t, err)
+	require.Equal(t, Holding{Strike: 2, Shares: 10}, a.Holdings["AMD"])
+	require.Equal(t, float64(0), a.Balance)
+
+	_, err = s.ConfirmImport(ctx, "pending")
+	require.True(t, errors.Is(err, ErrNoImport))
+
+	// Previews expire
+	imp, err = ParseImport(strings.NewReader("amd"))
+	require.NoError(t, err)
+	require.NoError(t, s.PreviewImport(ctx, imp, "pending"))
+	now = now.Add(2 * importTTL)
+	_, err = s.ConfirmImport(ctx, "pending")
+	require.True(t, errors.Is(err, ErrImportExpired))
+}
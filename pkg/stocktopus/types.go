@@ -1,9 +1,15 @@
 package stocktopus
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/bndr/gotabulate"
+	"github.com/leekchan/accounting"
 	"github.com/thorfour/iex/pkg/types"
 	"github.com/thorfour/stocktopus/pkg/stock"
 )
@@ -14,32 +20,67 @@ type WatchList []*stock.Quote
 
 func (w WatchList) Len() int { return len(w) }
 
-func (w WatchList) Less(i, j int) bool { return w[i].ChangePercent > w[j].ChangePercent }
+// Less orders by percent change, quotes without a change go last
+func (w WatchList) Less(i, j int) bool {
+	if hi, hj := w[i].Has(stock.FieldChange), w[j].Has(stock.FieldChange); hi != hj {
+		return hi
+	}
+	return w[i].ChangePercent > w[j].ChangePercent
+}
 
 func (w WatchList) Swap(i, j int) { w[i], w[j] = w[j], w[i] }
 
+// Watch list sort orders
+const (
+	SortChange = "change"
+	SortTicker = "ticker"
+	SortPrice  = "price"
+)
+
+// SortBy orders the list by percent change, ticker or latest price
+func (w WatchList) SortBy(order string) {
+	switch order {
+	case SortTicker:
+		sort.SliceStable(w, func(i, j int) bool { return w[i].Ticker < w[j].Ticker })
+	case SortPrice:
+		sort.SliceStable(w, func(i, j int) bool { return w[i].LatestPrice > w[j].LatestPrice })
+	default:
+		sort.Sort(w)
+	}
+}
+
 func (w WatchList) String() string {
 	rows := make([][]interface{}, 0, len(w))
 	cumsum := float64(0)
+	changes := 0
 	for _, quote := range w {
+		if !quote.Has(stock.FieldChange) {
+			rows = append(rows, []interface{}{quote.Ticker, price(quote), "n/a", "n/a"})
+			continue
+		}
 		rows = append(rows,
 			[]interface{}{
 				quote.Ticker,
-				quote.LatestPrice,
+				price(quote),
 				fmt.Sprintf("%0.2f", quote.Change),
 				fmt.Sprintf("%0.3f", (100 * quote.ChangePercent)),
 			},
 		)
 		cumsum += (100 * quote.ChangePercent)
+		changes++
 	}
 
-	// Add an average row at the bottom
+	// Add an average row at the bottom, of the quotes that have a change
+	avg := "n/a"
+	if changes > 0 {
+		avg = fmt.Sprintf("%0.3f%%", cumsum/float64(changes))
+	}
 	rows = append(rows,
 		[]interface{}{
 			"Avg.",
 			"---",
 			"---",
-			fmt.Sprintf("%0.3f%%", cumsum/float64(len(rows))),
+			avg,
 		},
 	)
 
@@ -51,11 +92,253 @@ func (w WatchList) String() string {
 	return t.Render("simple")
 }
 
+// price renders the latest price with a marker if it isn't a live price
+func price(q *stock.Quote) interface{} {
+	switch {
+	case q.Closed:
+		return fmt.Sprintf("%v (closed)", q.LatestPrice)
+	case q.Delayed:
+		return fmt.Sprintf("%v (delayed)", q.LatestPrice)
+	default:
+		return q.LatestPrice
+	}
+}
+
+// Details renders the full quote for a single ticker
+func Details(q *stock.Quote) string {
+	ac := accounting.Accounting{Precision: 0}
+	rows := [][]interface{}{
+		{"Open", fmt.Sprintf("%0.2f", q.Open)},
+		{"Day Range", fmt.Sprintf("%0.2f - %0.2f", q.Low, q.High)},
+		{"Prev. Close", fmt.Sprintf("%0.2f", q.PreviousClose)},
+	}
+	if q.Has(stock.FieldVolume) {
+		rows = append(rows, []interface{}{"Volume", ac.FormatMoney(q.Volume)})
+	} else {
+		rows = append(rows, []interface{}{"Volume", "n/a"})
+	}
+	if q.AvgVolume != 0 {
+		rows = append(rows, []interface{}{"Avg. Volume", ac.FormatMoney(q.AvgVolume)})
+	}
+	if q.MarketCap != 0 {
+		rows = append(rows, []interface{}{"Marketcap", ac.FormatMoney(q.MarketCap)})
+	}
+	if q.ExtendedPrice != 0 && q.Closed {
+		rows = append(rows, []interface{}{"Extended Hours", fmt.Sprintf("%0.2f", q.ExtendedPrice)})
+	}
+	if q.Exchange != "" {
+		rows = append(rows, []interface{}{"Exchange", strings.TrimSpace(fmt.Sprintf("%s %s", q.Exchange, q.Currency))})
+	}
+	if !q.LatestUpdate.IsZero() {
+		rows = append(rows, []interface{}{"As Of", q.LatestUpdate.UTC().Format("2006-01-02 15:04 MST")})
+	}
+
+	t := gotabulate.Create(rows)
+	t.SetHeaders([]string{q.Ticker, "Value"})
+	t.SetAlign("left")
+	t.SetHideLines([]string{"bottomLine", "betweenLine", "top"})
+
+	return t.Render("simple")
+}
+
+// Missing renders the symbols reported by a partial lookup so they can be shown below a WatchList.
+// Symbols that don't exist are told apart from those that only failed this time. It returns an
+// empty string for any other error.
+func Missing(err error) string {
+	var perr *stock.PartialError
+	if !errors.As(err, &perr) {
+		return ""
+	}
+
+	return MissingText(perr.Unknown(), perr.Unavailable(), perr.RetryAfter())
+}
+
+// MissingText renders symbols that weren't found and symbols that couldn't be quoted right now,
+// one line for each. A non zero retry says when the unavailable symbols can be quoted again.
+func MissingText(unknown, unavailable []string, retry time.Duration) string {
+	text := ""
+	if len(unknown) > 0 {
+		text += fmt.Sprintf("Not found: %s\n", strings.Join(unknown, ", "))
+	}
+	if len(unavailable) > 0 {
+		text += fmt.Sprintf("Temporarily unavailable: %s%s\n", strings.Join(unavailable, ", "), RetryText(retry))
+	}
+	return text
+}
+
+// RetryText is a hint to try again after retry, it's empty when retry is zero
+func RetryText(retry time.Duration) string {
+	if retry <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(", try again in %d seconds", int(math.Ceil(retry.Seconds())))
+}
+
+// SearchResults are the symbols matching a search
+type SearchResults []*stock.Symbol
+
+func (r SearchResults) String() string {
+	if len(r) == 0 {
+		return "No matches"
+	}
+
+	rows := make([][]interface{}, 0, len(r))
+	for _, s := range r {
+		rows = append(rows, []interface{}{s.Ticker, s.Name, s.Exchange})
+	}
+
+	t := gotabulate.Create(rows)
+	t.SetHeaders([]string{"Ticker", "Name", "Exchange"})
+	t.SetAlign("left")
+	t.SetHideLines([]string{"bottomLine", "betweenLine", "top"})
+
+	return t.Render("simple")
+}
+
 // Account is a users play money account
 type Account struct {
 	Balance  float64
 	Holdings map[string]Holding
 	Latest   map[string]float64
+	// Transactions is the history of the account, oldest first. Accounts created before history
+	// was recorded only have the transactions since, and only the latest MaxTransactions are kept.
+	Transactions []Transaction `json:",omitempty"`
+	// Opening is the balance before the first transaction. It's nil when it isn't known, for
+	// accounts that recorded transactions before the opening balance was.
+	Opening *float64 `json:",omitempty"`
+	// Dropped counts the transactions removed from the start of the history
+	Dropped int `json:",omitempty"`
+	// Carried are the lots still open from the dropped transactions
+	Carried []Lot `json:",omitempty"`
+}
+
+// MaxTransactions is the most transactions kept in an account's history
+const MaxTransactions = 1000
+
+// trim drops the oldest transactions beyond MaxTransactions. Their amounts move into the
+// opening balance and their open lots are carried, so neither the balance nor the lots change.
+func (a *Account) trim() {
+	extra := len(a.Transactions) - MaxTransactions
+	if extra <= 0 {
+		return
+	}
+	dropped := a.Transactions[:extra]
+
+	if a.Opening != nil {
+		opening := *a.Opening
+		for _, tx := range dropped {
+			opening += tx.Amount
+		}
+		a.Opening = &opening
+	}
+
+	open := openLots(a.Carried, dropped)
+	tickers := make([]string, 0, len(open))
+	for ticker := range open {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+	a.Carried = nil
+	for _, ticker := range tickers {
+		a.Carried = append(a.Carried, open[ticker]...)
+	}
+
+	a.Transactions = append([]Transaction(nil), a.Transactions[extra:]...)
+	a.Dropped += extra
+}
+
+// Transaction types
+const (
+	TxDeposit = "DEPOSIT"
+	TxBuy     = "BUY"
+	TxSell    = "SELL"
+	TxImport  = "IMPORT"
+)
+
+// Transaction is a single change to an account
+type Transaction struct {
+	Time   time.Time
+	Type   string
+	Ticker string  `json:",omitempty"`
+	Shares uint64  `json:",omitempty"`
+	Price  float64 `json:",omitempty"`
+	// Amount is the change in balance
+	Amount float64
+}
+
+// Lot is a block of shares bought at the same time and price
+type Lot struct {
+	Ticker string
+	Shares uint64
+	Price  float64
+	// Time is zero for shares bought before transactions were recorded
+	Time time.Time
+}
+
+// Lots returns the open lots of every holding, matching sells to the oldest buys first
+func (a *Account) Lots() []Lot {
+	open := openLots(a.Carried, a.Transactions)
+
+	tickers := make([]string, 0, len(a.Holdings))
+	for ticker := range a.Holdings {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+
+	var lots []Lot
+	for _, ticker := range tickers {
+		h := a.Holdings[ticker]
+		held := uint64(0)
+		for _, l := range open[ticker] {
+			held += l.Shares
+		}
+
+		// Reconcile with the holding, shares from before the history are one lot at the strike price
+		switch {
+		case held < h.Shares:
+			lots = append(lots, Lot{Ticker: ticker, Shares: h.Shares - held, Price: h.Strike})
+			lots = append(lots, open[ticker]...)
+		default:
+			lots = append(lots, sellLots(open[ticker], held-h.Shares)...)
+		}
+	}
+
+	return lots
+}
+
+// openLots returns the lots of each ticker left open after txs, starting from carried
+func openLots(carried []Lot, txs []Transaction) map[string][]Lot {
+	open := map[string][]Lot{}
+	for _, l := range carried {
+		open[l.Ticker] = append(open[l.Ticker], l)
+	}
+	for _, tx := range txs {
+		switch tx.Type {
+		case TxBuy, TxImport:
+			open[tx.Ticker] = append(open[tx.Ticker], Lot{Ticker: tx.Ticker, Shares: tx.Shares, Price: tx.Price, Time: tx.Time})
+		case TxSell:
+			open[tx.Ticker] = sellLots(open[tx.Ticker], tx.Shares)
+		}
+	}
+	for ticker, lots := range open {
+		if len(lots) == 0 {
+			delete(open, ticker)
+		}
+	}
+	return open
+}
+
+// sellLots removes shares from the oldest lots
+func sellLots(lots []Lot, shares uint64) []Lot {
+	for len(lots) > 0 && shares > 0 {
+		if lots[0].Shares > shares {
+			lots[0].Shares -= shares
+			break
+		}
+		shares -= lots[0].Shares
+		lots = lots[1:]
+	}
+	return lots
 }
 
 // Holding is a specific stock holding
@@ -111,6 +394,92 @@ func (a *Account) String() string {
 	return fmt.Sprintf("%v\n%v", table, summary)
 }
 
+// Performance summarizes price history over a range
+type Performance struct {
+	Ticker string
+	Range  stock.Range
+	Start  time.Time
+	End    time.Time
+	Open   float64
+	Close  float64
+	High   float64
+	Low    float64
+	// AvgVolume is the average volume of each VolumeInterval
+	AvgVolume float64
+	// VolumeInterval is a trading day for daily and shorter bars, otherwise the bar interval
+	VolumeInterval stock.Interval
+	// Return over the range as a decimal percentage i.e 0.10 = 10%
+	Return float64
+}
+
+// NewPerformance summarizes a non-empty list of bars sorted by time. Volume is averaged over the
+// size of the bars themselves, i is only used when there are too few bars to tell.
+func NewPerformance(ticker string, r stock.Range, i stock.Interval, bars []*stock.Bar) *Performance {
+	first, last := bars[0], bars[len(bars)-1]
+	p := &Performance{
+		Ticker:         ticker,
+		Range:          r,
+		Start:          first.Time,
+		End:            last.Time,
+		Open:           first.Open,
+		Close:          last.Close,
+		High:           first.High,
+		Low:            first.Low,
+		VolumeInterval: stock.Interval1Day,
+	}
+	if size := stock.BarInterval(bars); size != "" {
+		i = size
+	}
+	if i == stock.Interval1Week || i == stock.Interval1Month {
+		p.VolumeInterval = i
+	}
+
+	volume, days := float64(0), map[string]bool{}
+	for _, b := range bars {
+		p.High = math.Max(p.High, b.High)
+		p.Low = math.Min(p.Low, b.Low)
+		volume += b.Volume
+		days[stock.TradingDay(b.Time)] = true
+	}
+
+	// Intraday bars are added up into the trading day they're part of
+	periods := len(bars)
+	if p.VolumeInterval == stock.Interval1Day {
+		periods = len(days)
+	}
+	p.AvgVolume = volume / float64(periods)
+
+	if p.Open != 0 {
+		p.Return = (p.Close - p.Open) / p.Open
+	}
+
+	return p
+}
+
+func (p *Performance) String() string {
+	ac := accounting.Accounting{Precision: 0}
+	rows := [][]interface{}{
+		{"Period", fmt.Sprintf("%s (%s - %s)", p.Range, p.Start.Format("2006-01-02"), p.End.Format("2006-01-02"))},
+		{"Return", fmt.Sprintf("%0.2f%%", 100*p.Return)},
+		{"Open", fmt.Sprintf("%0.2f", p.Open)},
+		{"Close", fmt.Sprintf("%0.2f", p.Close)},
+		{"High", fmt.Sprintf("%0.2f", p.High)},
+		{"Low", fmt.Sprintf("%0.2f", p.Low)},
+	}
+	if p.VolumeInterval == stock.Interval1Day {
+		rows = append(rows, []interface{}{"Avg. Volume", ac.FormatMoney(p.AvgVolume)})
+	} else {
+		rows = append(rows, []interface{}{fmt.Sprintf("Avg. Volume (%s)", p.VolumeInterval), ac.FormatMoney(p.AvgVolume)})
+	}
+
+	t := gotabulate.Create(rows)
+	t.SetHeaders([]string{p.Ticker, "Value"})
+	t.SetAlign("left")
+	t.SetHideLines([]string{"bottomLine", "betweenLine", "top"})
+
+	return t.Render("simple")
+}
+
 // Stats converts a stats object into a string
 func Stats(s *types.Stats) string {
 
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Backuper is implemented by stores that can write a consistent copy of themselves while online
+type Backuper interface {
+	Backup(w io.Writer) (int64, error)
+}
+
+// BackupHandler streams a backup of the store as a file download. Requests must carry token as
+// a bearer token, an empty token disables the endpoint.
+func BackupHandler(b Backuper, token string) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(resp, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		given := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(resp, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		name := fmt.Sprintf("stocktopus-%s.db", time.Now().UTC().Format("20060102T150405Z"))
+		resp.Header().Set("Content-Type", "application/octet-stream")
+		resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		n, err := b.Backup(resp)
+		if err != nil {
+			// Headers are already sent so the client sees a truncated download
+			logrus.WithField("msg", "backup failed").WithField("bytes", n).Error(err)
+			return
+		}
+
+		logrus.WithField("bytes", n).Info("backup complete")
+	}
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltFile is the name of the database file created in the data directory
+const BoltFile = "stocktopus.db"
+
+var (
+	metaBucket   = []byte("meta")
+	valuesBucket = []byte("values")
+	setsBucket   = []byte("sets")
+
+	versionKey = []byte("schema_version")
+)
+
+// migrations bring the database schema up to date. migrations[i] upgrades a database from
+// version i to version i+1, so new migrations must only ever be appended.
+var migrations = []func(tx *bolt.Tx) error{
+	// 1: values are stored in one bucket and each set is a nested bucket of members
+	func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{valuesBucket, setsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// Bolt is a Store kept in a single file on local disk, so no external database is needed
+type Bolt struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens, or creates, the database in dir and applies any outstanding migrations
+func OpenBolt(dir string) (*Bolt, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create data directory failed: %w", err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, BoltFile), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open database failed: %w", err)
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Bolt{db: db}, nil
+}
+
+// migrate applies every migration newer than the database's schema version
+func migrate(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+
+		var version uint64
+		if v := meta.Get(versionKey); v != nil {
+			version = binary.BigEndian.Uint64(v)
+		}
+		if version > uint64(len(migrations)) {
+			return fmt.Errorf("database schema version %v is newer than supported version %v", version, len(migrations))
+		}
+
+		for ; version < uint64(len(migrations)); version++ {
+			if err := migrations[version](tx); err != nil {
+				return fmt.Errorf("migration %v failed: %w", version+1, err)
+			}
+		}
+
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, version)
+		return meta.Put(versionKey, v)
+	})
+}
+
+// Get returns the value stored at key
+func (b *Bolt) Get(_ context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(setsBucket).Bucket([]byte(key)) != nil {
+			return ErrWrongType
+		}
+
+		v := tx.Bucket(valuesBucket).Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+
+		// Values are only valid for the life of the transaction
+		value = copyBytes(v)
+		return nil
+	})
+
+	return value, err
+}
+
+// Put stores a value at key
+func (b *Bolt) Put(_ context.Context, key string, value []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := deleteSet(tx, key); err != nil {
+			return err
+		}
+		return tx.Bucket(valuesBucket).Put([]byte(key), nonNil(value))
+	})
+}
+
+// Update replaces the value at key inside a read-write transaction. Bolt only allows one writer
+// at a time so fn is never retried.
+func (b *Bolt) Update(_ context.Context, key string, fn UpdateFunc) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(setsBucket).Bucket([]byte(key)) != nil {
+			return ErrWrongType
+		}
+
+		values := tx.Bucket(valuesBucket)
+		value, err := fn(copyBytes(values.Get([]byte(key))))
+		if err != nil {
+			return err
+		}
+
+		return values.Put([]byte(key), nonNil(value))
+	})
+}
+
+// AddMembers adds members to the set at key
+func (b *Bolt) AddMembers(_ context.Context, key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(valuesBucket).Get([]byte(key)) != nil {
+			return ErrWrongType
+		}
+
+		set, err := tx.Bucket(setsBucket).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+
+		for _, member := range members {
+			if err := set.Put([]byte(member), []byte{}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// RemoveMembers removes members from the set at key
+func (b *Bolt) RemoveMembers(_ context.Context, key string, members ...string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(valuesBucket).Get([]byte(key)) != nil {
+			return ErrWrongType
+		}
+
+		set := tx.Bucket(setsBucket).Bucket([]byte(key))
+		if set == nil {
+			return nil
+		}
+
+		for _, member := range members {
+			if err := set.Delete([]byte(member)); err != nil {
+				return err
+			}
+		}
+
+		// Like redis, empty sets don't exist
+		if k, _ := set.Cursor().First(); k == nil {
+			return deleteSet(tx, key)
+		}
+
+		return nil
+	})
+}
+
+// Members returns the members of the set at key
+func (b *Bolt) Members(_ context.Context, key string) ([]string, error) {
+	members := []string{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(valuesBucket).Get([]byte(key)) != nil {
+			return ErrWrongType
+		}
+
+		set := tx.Bucket(setsBucket).Bucket([]byte(key))
+		if set == nil {
+			return nil
+		}
+
+		return set.ForEach(func(k, _ []byte) error {
+			members = append(members, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// Delete removes keys
+func (b *Bolt) Delete(_ context.Context, keys ...string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			if err := tx.Bucket(valuesBucket).Delete([]byte(key)); err != nil {
+				return err
+			}
+			if err := deleteSet(tx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Keys returns every key starting with prefix
+func (b *Bolt) Keys(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{valuesBucket, setsBucket} {
+			c := tx.Bucket(name).Cursor()
+			p := []byte(prefix)
+			for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+				keys = append(keys, string(k))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Ping checks the database is still open
+func (b *Bolt) Ping(context.Context) error {
+	return b.db.View(func(*bolt.Tx) error { return nil })
+}
+
+// Close closes the database file
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+// Backup writes a consistent copy of the database to w without blocking writers
+func (b *Bolt) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+
+	return n, err
+}
+
+// deleteSet removes the set at key if there is one
+func deleteSet(tx *bolt.Tx, key string) error {
+	sets := tx.Bucket(setsBucket)
+	if sets.Bucket([]byte(key)) == nil {
+		return nil
+	}
+	return sets.DeleteBucket([]byte(key))
+}
+
+// nonNil returns an empty value for nil since bolt treats a nil value as a missing key
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrUnavailable is returned by a guarded store while storage is unhealthy
+var ErrUnavailable = errors.New("storage unavailable")
+
+// healthCheckTimeout bounds each health check ping
+const healthCheckTimeout = 2 * time.Second
+
+var (
+	healthGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "storage_healthy",
+		Help: "1 if the last storage health check succeeded, 0 if stateful commands are unavailable",
+	})
+	healthChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_health_checks_total",
+		Help: "Storage health checks by result",
+	}, []string{"result"})
+)
+
+// Health tracks whether a store is reachable. Stores are assumed healthy until a check fails.
+type Health struct {
+	store Store
+
+	mu      sync.RWMutex
+	healthy bool
+	err     error
+}
+
+// NewHealth returns a health tracker for s
+func NewHealth(s Store) *Health {
+	healthGauge.Set(1)
+	return &Health{
+		store:   s,
+		healthy: true,
+	}
+}
+
+// Run checks the store every interval until ctx is cancelled
+func (h *Health) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.Check(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check pings the store once and records the result
+func (h *Health) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	err := h.store.Ping(ctx)
+	result := "ok"
+	if err != nil {
+		result = "failed"
+	}
+	healthChecks.WithLabelValues(result).Inc()
+
+	h.set(err)
+	return err
+}
+
+// set records the result of using the store
+func (h *Health) set(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case err == nil && !h.healthy:
+		logrus.Info("storage recovered")
+		healthGauge.Set(1)
+	case err != nil && h.healthy:
+		logrus.WithField("msg", "storage unhealthy, stateful commands disabled").Error(err)
+		healthGauge.Set(0)
+	}
+	h.healthy, h.err = err == nil, err
+}
+
+// Healthy reports whether the last check succeeded
+func (h *Health) Healthy() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.healthy
+}
+
+// Err returns the error from the last failed check, or nil while healthy
+func (h *Health) Err() error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.err
+}
+
+// Guard returns a store that fails fast with ErrUnavailable while storage is unhealthy, and marks
+// storage unhealthy as soon as an operation fails to reach it
+func (h *Health) Guard() Store {
+	return &guarded{Store: h.store, health: h}
+}
+
+type guarded struct {
+	Store
+	health *Health
+}
+
+// check returns ErrUnavailable if storage is known to be down
+func (g *guarded) check() error {
+	if !g.health.Healthy() {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// done marks storage unhealthy if err shows it couldn't be reached
+func (g *guarded) done(err error) error {
+	if err == nil || !connectionErr(err) {
+		return err
+	}
+
+	g.health.set(err)
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
+
+// connectionErr reports whether err came from failing to talk to the store
+func connectionErr(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (g *guarded) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := g.check(); err != nil {
+		return nil, err
+	}
+	b, err := g.Store.Get(ctx, key)
+	return b, g.done(err)
+}
+
+func (g *guarded) Put(ctx context.Context, key string, value []byte) error {
+	if err := g.check(); err != nil {
+		return err
+	}
+	return g.done(g.Store.Put(ctx, key, value))
+}
+
+func (g *guarded) Update(ctx context.Context, key string, fn UpdateFunc) error {
+	if err := g.check(); err != nil {
+		return err
+	}
+	return g.done(g.Store.Update(ctx, key, fn))
+}
+
+func (g *guarded) AddMembers(ctx context.Context, key string, members ...string) error {
+	if err := g.check(); err != nil {
+		return err
+	}
+	return g.done(g.Store.AddMembers(ctx, key, members...))
+}
+
+func (g *guarded) RemoveMembers(ctx context.Context, key string, members ...string) error {
+	if err := g.check(); err != nil {
+		return err
+	}
+	return g.done(g.Store.RemoveMembers(ctx, key, members...))
+}
+
+func (g *guarded) Members(ctx context.Context, key string) ([]string, error) {
+	if err := g.check(); err != nil {
+		return nil, err
+	}
+	m, err := g.Store.Members(ctx, key)
+	return m, g.done(err)
+}
+
+func (g *guarded) Delete(ctx context.Context, keys ...string) error {
+	if err := g.check(); err != nil {
+		return err
+	}
+	return g.done(g.Store.Delete(ctx, keys...))
+}
+
+func (g *guarded) Keys(ctx context.Context, prefix string) ([]string, error) {
+	if err := g.check(); err != nil {
+		return nil, err
+	}
+	k, err := g.Store.Keys(ctx, prefix)
+	return k, g.done(err)
+}
+
+// Ping always reaches the store so it can be used to recover
+func (g *guarded) Ping(ctx context.Context) error {
+	return g.health.Check(ctx)
+}
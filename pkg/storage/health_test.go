@@ -0,0 +1,49 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+func TestHealth(t *testing.T) {
+	ctx := context.Background()
+
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+	addr := mr.Addr()
+
+	h := storage.NewHealth(storage.NewRedis(redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})))
+	s := h.Guard()
+
+	require.NoError(t, s.Put(ctx, "key", []byte("value")))
+	require.True(t, h.Healthy())
+	require.NoError(t, h.Err())
+
+	// Domain errors don't affect health
+	_, err = s.Get(ctx, "missing")
+	require.True(t, errors.Is(err, storage.ErrNotFound))
+	require.True(t, h.Healthy())
+
+	// A failed operation marks storage down and later operations fail fast
+	mr.Close()
+	_, err = s.Get(ctx, "key")
+	require.True(t, errors.Is(err, storage.ErrUnavailable))
+	require.False(t, h.Healthy())
+	require.True(t, errors.Is(s.AddMembers(ctx, "set", "a"), storage.ErrUnavailable))
+	require.Error(t, h.Err())
+
+	// A check fails until redis is back
+	require.Error(t, h.Check(ctx))
+	require.NoError(t, mr.StartAddr(addr))
+	require.NoError(t, h.Check(ctx))
+	require.True(t, h.Healthy())
+	require.NoError(t, h.Err())
+	require.NoError(t, s.AddMembers(ctx, "set", "a"))
+}
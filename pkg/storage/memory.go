@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Memory is a Store that keeps everything in memory, for tests and local development
+type Memory struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	sets   map[string]map[string]struct{}
+}
+
+// NewMemory returns an empty in-memory Store
+func NewMemory() *Memory {
+	return &Memory{
+		values: map[string][]byte{},
+		sets:   map[string]map[string]struct{}{},
+	}
+}
+
+// Get returns the value stored at key
+func (m *Memory) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sets[key]; ok {
+		return nil, ErrWrongType
+	}
+
+	v, ok := m.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return copyBytes(v), nil
+}
+
+// Put stores a value at key
+func (m *Memory) Put(_ context.Context, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sets, key)
+	m.values[key] = copyBytes(value)
+	return nil
+}
+
+// Update replaces the value at key while holding the store lock
+func (m *Memory) Update(_ context.Context, key string, fn UpdateFunc) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sets[key]; ok {
+		return ErrWrongType
+	}
+
+	value, err := fn(copyBytes(m.values[key]))
+	if err != nil {
+		return err
+	}
+
+	m.values[key] = copyBytes(value)
+	return nil
+}
+
+// AddMembers adds members to the set at key
+func (m *Memory) AddMembers(_ context.Context, key string, members ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.values[key]; ok {
+		return ErrWrongType
+	}
+
+	set, ok := m.sets[key]
+	if !ok {
+		set = map[string]struct{}{}
+		m.sets[key] = set
+	}
+	for _, member := range members {
+		set[member] = struct{}{}
+	}
+
+	return nil
+}
+
+// RemoveMembers removes members from the set at key
+func (m *Memory) RemoveMembers(_ context.Context, key string, members ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.values[key]; ok {
+		return ErrWrongType
+	}
+
+	set := m.sets[key]
+	for _, member := range members {
+		delete(set, member)
+	}
+
+	// Like redis, empty sets don't exist
+	if len(set) == 0 {
+		delete(m.sets, key)
+	}
+
+	return nil
+}
+
+// Members returns the members of the set at key
+func (m *Memory) Members(_ context.Context, key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.values[key]; ok {
+		return nil, ErrWrongType
+	}
+
+	members := make([]string, 0, len(m.sets[key]))
+	for member := range m.sets[key] {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	return members, nil
+}
+
+// Delete removes keys
+func (m *Memory) Delete(_ context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.values, key)
+		delete(m.sets, key)
+	}
+
+	return nil
+}
+
+// Keys returns every key starting with prefix
+func (m *Memory) Keys(_ context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	for key := range m.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.sets {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Ping always succeeds
+func (m *Memory) Ping(context.Context) error { return nil }
+
+// Close is a no-op
+func (m *Memory) Close() error { return nil }
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	opTimings = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "storage_operation_duration_seconds",
+		Help: "A histogram of storage operation latency by backend and operation",
+	},
+		[]string{"backend", "operation"},
+	)
+
+	opErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_operation_errors_total",
+		Help: "Count of failed storage operations by backend and operation, missing keys aren't failures",
+	},
+		[]string{"backend", "operation"},
+	)
+)
+
+// measured records metrics for every operation on a Store
+type measured struct {
+	s       Store
+	backend string
+}
+
+// Instrument returns a Store that records the latency and errors of every operation on s
+func Instrument(s Store, backend string) Store {
+	return &measured{s: s, backend: backend}
+}
+
+// observe records an operation that started at start
+func (m *measured) observe(op string, start time.Time, err error) {
+	opTimings.WithLabelValues(m.backend, op).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		opErrors.WithLabelValues(m.backend, op).Inc()
+	}
+}
+
+func (m *measured) Get(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+	b, err := m.s.Get(ctx, key)
+	m.observe("get", start, err)
+	return b, err
+}
+
+func (m *measured) Put(ctx context.Context, key string, value []byte) error {
+	start := time.Now()
+	err := m.s.Put(ctx, key, value)
+	m.observe("put", start, err)
+	return err
+}
+
+func (m *measured) Update(ctx context.Context, key string, fn UpdateFunc) error {
+	start := time.Now()
+	err := m.s.Update(ctx, key, fn)
+	m.observe("update", start, err)
+	return err
+}
+
+func (m *measured) AddMembers(ctx context.Context, key string, members ...string) error {
+	start := time.Now()
+	err := m.s.AddMembers(ctx, key, members...)
+	m.observe("add_members", start, err)
+	return err
+}
+
+func (m *measured) RemoveMembers(ctx context.Context, key string, members ...string) error {
+	start := time.Now()
+	err := m.s.RemoveMembers(ctx, key, members...)
+	m.observe("remove_members", start, err)
+	return err
+}
+
+func (m *measured) Members(ctx context.Context, key string) ([]string, error) {
+	start := time.Now()
+	members, err := m.s.Members(ctx, key)
+	m.observe("members", start, err)
+	return members, err
+}
+
+func (m *measured) Delete(ctx context.Context, keys ...string) error {
+	start := time.Now()
+	err := m.s.Delete(ctx, keys...)
+	m.observe("delete", start, err)
+	return err
+}
+
+func (m *measured) Keys(ctx context.Context, prefix string) ([]string, error) {
+	start := time.Now()
+	keys, err := m.s.Keys(ctx, prefix)
+	m.observe("keys", start, err)
+	return keys, err
+}
+
+func (m *measured) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := m.s.Ping(ctx)
+	m.observe("ping", start, err)
+	return err
+}
+
+func (m *measured) Close() error {
+	return m.s.Close()
+}
@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrument(t *testing.T) {
+	ctx := context.Background()
+	s := Instrument(NewMemory(), "test")
+
+	// Missing keys aren't failures
+	_, err := s.Get(ctx, "missing")
+	require.Error(t, err)
+	require.Equal(t, 0.0, testutil.ToFloat64(opErrors.WithLabelValues("test", "get")))
+
+	require.NoError(t, s.Put(ctx, "key", []byte("value")))
+	_, err = s.Members(ctx, "key")
+	require.True(t, errors.Is(err, ErrWrongType))
+	require.Equal(t, 1.0, testutil.ToFloat64(opErrors.WithLabelValues("test", "members")))
+}
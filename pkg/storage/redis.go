@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/thorfour/stocktopus/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// maxUpdateRetries is how many times an Update is retried when the key changes underneath it
+	maxUpdateRetries = 20
+	// maxUpdateBackoff caps the random wait between Update retries
+	maxUpdateBackoff = 50 * time.Millisecond
+)
+
+// scanCount is the number of keys requested per SCAN call
+const scanCount = 1000
+
+// Redis is a Store backed by a redis server, sentinel group or cluster
+type Redis struct {
+	client redis.UniversalClient
+}
+
+// NewRedis returns a Store using the given redis client
+func NewRedis(client redis.UniversalClient) *Redis {
+	client.AddHook(tracingHook{})
+	return &Redis{
+		client: client,
+	}
+}
+
+// Get returns the value stored at key
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	b, err := r.client.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, redisErr("Get", err)
+	}
+
+	return b, nil
+}
+
+// Put stores a value at key
+func (r *Redis) Put(ctx context.Context, key string, value []byte) error {
+	return redisErr("Set", r.client.Set(ctx, key, value, 0).Err())
+}
+
+// Update uses an optimistic WATCH/MULTI transaction to replace the value at key
+func (r *Redis) Update(ctx context.Context, key string, fn UpdateFunc) error {
+	txf := func(tx *redis.Tx) error {
+		old, err := tx.Get(ctx, key).Bytes()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return redisErr("Get", err)
+		}
+
+		value, err := fn(old)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, value, 0)
+			return nil
+		})
+		return err
+	}
+
+	backoff := time.Millisecond
+	for i := 0; i < maxUpdateRetries; i++ {
+		err := r.client.Watch(ctx, txf, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+
+		// Lost the race to another writer, wait a bit before trying again
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(backoff)))):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff < maxUpdateBackoff {
+			backoff *= 2
+		}
+	}
+
+	return ErrConflict
+}
+
+// AddMembers adds members to the set at key
+func (r *Redis) AddMembers(ctx context.Context, key string, members ...string) error {
+	return redisErr("SAdd", r.client.SAdd(ctx, key, members).Err())
+}
+
+// RemoveMembers removes members from the set at key
+func (r *Redis) RemoveMembers(ctx context.Context, key string, members ...string) error {
+	return redisErr("SRem", r.client.SRem(ctx, key, members).Err())
+}
+
+// Members returns the members of the set at key
+func (r *Redis) Members(ctx context.Context, key string) ([]string, error) {
+	members, err := r.client.SMembers(ctx, key).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, redisErr("SMembers", err)
+	}
+
+	return members, nil
+}
+
+// Delete removes keys
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// A cluster rejects a DEL of keys in different slots, delete them one by one
+	if _, ok := r.client.(*redis.ClusterClient); ok {
+		_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				pipe.Del(ctx, key)
+			}
+			return nil
+		})
+		return redisErr("Del", err)
+	}
+
+	return redisErr("Del", r.client.Del(ctx, keys...).Err())
+}
+
+// Keys scans for every key starting with prefix. A cluster spreads keys over its masters, so
+// every master is scanned.
+func (r *Redis) Keys(ctx context.Context, prefix string) ([]string, error) {
+	match := globEscaper.Replace(prefix) + "*"
+
+	cluster, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return scan(ctx, r.client, match)
+	}
+
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		found, err := scan(ctx, master, match)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, found...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// scan returns every key of a single server matching the glob pattern match
+func scan(ctx context.Context, client redis.Cmdable, match string) ([]string, error) {
+	var (
+		keys   []string
+		cursor uint64
+	)
+
+	for {
+		page, next, err := client.Scan(ctx, cursor, match, scanCount).Result()
+		if err != nil {
+			return nil, redisErr("Scan", err)
+		}
+
+		keys = append(keys, page...)
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// Ping checks the redis server is reachable
+func (r *Redis) Ping(ctx context.Context) error {
+	return redisErr("Ping", r.client.Ping(ctx).Err())
+}
+
+// Close closes the redis client
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+// globEscaper escapes the characters redis treats as patterns in a SCAN match
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// redisErr converts redis errors into storage errors
+func redisErr(op string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, redis.Nil):
+		return ErrNotFound
+	case strings.HasPrefix(err.Error(), "WRONGTYPE"):
+		return fmt.Errorf("%s failed: %w", op, ErrWrongType)
+	default:
+		return fmt.Errorf("%s failed: %w", op, err)
+	}
+}
+
+// tracingHook records a span for each redis command run on behalf of a traced request.
+// Commands from background work like health checks aren't traced.
+type tracingHook struct{}
+
+type redisSpanKey struct{}
+
+func (tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return startRedisSpan(ctx, cmd.Name(), attribute.String("db.operation", cmd.Name())), nil
+}
+
+func (tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endRedisSpan(ctx, cmd.Err())
+	return nil
+}
+
+func (tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	names := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		names = append(names, cmd.Name())
+	}
+	return startRedisSpan(ctx, "pipeline", attribute.StringSlice("db.operation", names)), nil
+}
+
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+			break
+		}
+	}
+	endRedisSpan(ctx, err)
+	return nil
+}
+
+func startRedisSpan(ctx context.Context, name string, op attribute.KeyValue) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	ctx, span := tracing.Start(ctx, "redis."+name, attribute.String("db.system", "redis"), op)
+	return context.WithValue(ctx, redisSpanKey{}, span)
+}
+
+func endRedisSpan(ctx context.Context, err error) {
+	span, ok := ctx.Value(redisSpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	if errors.Is(err, redis.Nil) {
+		err = nil
+	}
+	tracing.End(span, err)
+}
@@ -0,0 +1,352 @@
+package storage
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrUnreachable is returned by ConnectRedis when redis can't be reached before the startup timeout
+var ErrUnreachable = errors.New("redis unreachable")
+
+// maxStartupBackoff caps the wait between connection attempts at startup
+const maxStartupBackoff = 5 * time.Second
+
+// RedisConfig describes how to connect to a single redis server, a sentinel group or a cluster
+type RedisConfig struct {
+	// Addrs are the server addresses, the sentinel addresses when MasterName is set, or the seed
+	// nodes of a cluster
+	Addrs []string `yaml:"addrs"`
+	// MasterName is the name of the master monitored by sentinel, setting it enables failover
+	MasterName string `yaml:"master_name"`
+	// Cluster connects to a redis cluster
+	Cluster bool `yaml:"cluster"`
+
+	Username         string `yaml:"username"`
+	Password         string `yaml:"password"`
+	SentinelPassword string `yaml:"sentinel_password"`
+	DB               int    `yaml:"db"`
+
+	// TLS encrypts connections, it's implied by any of the certificate settings
+	TLS                bool   `yaml:"tls"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+
+	PoolSize     int           `yaml:"pool_size"`
+	MinIdleConns int           `yaml:"min_idle_conns"`
+	DialTimeout  time.Duration `yaml:"dial_timeout"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	// StartupTimeout is how long ConnectRedis waits for redis to become reachable
+	StartupTimeout time.Duration `yaml:"startup_timeout"`
+
+	// loaded is the TLS config from the last Validate, so certificates are read once
+	loaded *loadedTLS
+}
+
+// tlsSettings are the settings a TLS config is built from
+type tlsSettings struct {
+	tls                                   bool
+	caFile, certFile, keyFile, serverName string
+	insecureSkipVerify                    bool
+}
+
+// loadedTLS is a TLS config and the settings it was built from
+type loadedTLS struct {
+	settings tlsSettings
+	config   *tls.Config
+}
+
+// DefaultRedisConfig returns the settings used when nothing is configured
+func DefaultRedisConfig() RedisConfig {
+	return RedisConfig{
+		DialTimeout:    5 * time.Second,
+		ReadTimeout:    3 * time.Second,
+		WriteTimeout:   3 * time.Second,
+		StartupTimeout: 30 * time.Second,
+	}
+}
+
+// RegisterFlags adds flags for the connection settings to fs, defaulting to the current settings.
+// Addresses and secrets are read from the environment by LoadEnv.
+func (c *RedisConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.MasterName, "redis-master", c.MasterName, "sentinel master name, REDISADDR lists the sentinels")
+	fs.BoolVar(&c.Cluster, "redis-cluster", c.Cluster, "connect to a redis cluster, REDISADDR lists the seed nodes")
+	fs.IntVar(&c.DB, "redis-db", c.DB, "redis database index, not supported by clusters")
+	fs.BoolVar(&c.TLS, "redis-tls", c.TLS, "connect to redis over TLS")
+	fs.StringVar(&c.CAFile, "redis-ca", c.CAFile, "PEM file of CAs to verify the redis server with, instead of the system pool")
+	fs.StringVar(&c.CertFile, "redis-cert", c.CertFile, "PEM client certificate to present to redis")
+	fs.StringVar(&c.KeyFile, "redis-key", c.KeyFile, "PEM key for -redis-cert")
+	fs.StringVar(&c.ServerName, "redis-server-name", c.ServerName, "name to verify the redis server certificate against, defaults to the host")
+	fs.BoolVar(&c.InsecureSkipVerify, "redis-insecure-skip-verify", c.InsecureSkipVerify, "don't verify the redis server certificate")
+	fs.IntVar(&c.PoolSize, "redis-pool-size", c.PoolSize, "maximum connections per redis node, 0 uses 10 per CPU")
+	fs.IntVar(&c.MinIdleConns, "redis-min-idle", c.MinIdleConns, "idle connections to keep open per redis node")
+	fs.DurationVar(&c.DialTimeout, "redis-dial-timeout", c.DialTimeout, "timeout for connecting to redis")
+	fs.DurationVar(&c.ReadTimeout, "redis-read-timeout", c.ReadTimeout, "timeout for redis reads")
+	fs.DurationVar(&c.WriteTimeout, "redis-write-timeout", c.WriteTimeout, "timeout for redis writes")
+	fs.DurationVar(&c.StartupTimeout, "redis-startup-timeout", c.StartupTimeout, "how long to wait for redis to become reachable at startup")
+}
+
+// LoadEnv overrides the settings with the comma separated addresses in REDISADDR and the
+// credentials in REDISUSER, REDISPW and REDISSENTINELPW, when they're set
+func (c *RedisConfig) LoadEnv() {
+	if addrs := os.Getenv("REDISADDR"); addrs != "" {
+		c.Addrs = SplitAddrs(addrs)
+	}
+	for env, value := range map[string]*string{
+		"REDISUSER":       &c.Username,
+		"REDISPW":         &c.Password,
+		"REDISSENTINELPW": &c.SentinelPassword,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*value = v
+		}
+	}
+}
+
+// SplitAddrs splits a comma separated list of addresses
+func SplitAddrs(addrs string) []string {
+	var split []string
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			split = append(split, addr)
+		}
+	}
+	return split
+}
+
+// mode describes the configured topology for error messages
+func (c *RedisConfig) mode() string {
+	switch {
+	case c.MasterName != "":
+		return "sentinel"
+	case c.Cluster:
+		return "cluster"
+	default:
+		return "single"
+	}
+}
+
+// Validate checks the settings can be used, including loading any certificates. The loaded
+// certificates are kept for Client, they're only read again if the TLS settings change.
+func (c *RedisConfig) Validate() error {
+	switch {
+	case c.MasterName != "" && c.Cluster:
+		return errors.New("redis sentinel and cluster modes can't be combined")
+	case c.Cluster && c.DB != 0:
+		return errors.New("redis clusters only support database 0")
+	case c.MasterName == "" && !c.Cluster && len(c.Addrs) > 1:
+		return errors.New("several redis addresses given, set a sentinel master name or cluster mode")
+	}
+
+	settings := tlsSettings{
+		tls:                c.TLS,
+		caFile:             c.CAFile,
+		certFile:           c.CertFile,
+		keyFile:            c.KeyFile,
+		serverName:         c.ServerName,
+		insecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.loaded != nil && c.loaded.settings == settings {
+		return nil
+	}
+	config, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+	c.loaded = &loadedTLS{settings: settings, config: config}
+	return nil
+}
+
+// Client builds a redis client for the configuration without connecting
+func (c *RedisConfig) Client() (redis.UniversalClient, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	addrs := c.Addrs
+	if len(addrs) == 0 {
+		addrs = []string{"localhost:6379"}
+	}
+
+	opts := &redis.UniversalOptions{
+		Addrs:        addrs,
+		MasterName:   c.MasterName,
+		Username:     c.Username,
+		Password:     c.Password,
+		DB:           c.DB,
+		PoolSize:     c.PoolSize,
+		MinIdleConns: c.MinIdleConns,
+		DialTimeout:  c.DialTimeout,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+		TLSConfig:    c.loaded.config, // Loaded by Validate
+	}
+
+	switch c.mode() {
+	case "sentinel":
+		failover := opts.Failover()
+		failover.SentinelPassword = c.SentinelPassword
+		return redis.NewFailoverClient(failover), nil
+	case "cluster":
+		return redis.NewClusterClient(opts.Cluster()), nil
+	default:
+		return redis.NewClient(opts.Simple()), nil
+	}
+}
+
+// usesTLS reports whether TLS is enabled explicitly or by a certificate setting
+func (c *RedisConfig) usesTLS() bool {
+	return c.TLS || c.CAFile != "" || c.CertFile != "" || c.KeyFile != ""
+}
+
+// tlsConfig loads the certificates, it returns nil if TLS isn't enabled
+func (c *RedisConfig) tlsConfig() (*tls.Config, error) {
+	if !c.usesTLS() {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read redis CA failed: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("redis CA %s contains no PEM certificates", c.CAFile)
+		}
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("a redis client certificate needs both a certificate and a key file")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load redis client certificate failed: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// OpenRedis returns a Store for the configuration without checking redis can be reached
+func OpenRedis(c *RedisConfig) (*Redis, error) {
+	client, err := c.Client()
+	if err != nil {
+		return nil, fmt.Errorf("redis config invalid: %w", err)
+	}
+
+	return NewRedis(client), nil
+}
+
+// ConnectRedis builds a client for the configuration and waits up to StartupTimeout for redis to
+// answer, so misconfiguration is reported at startup rather than on the first command
+func ConnectRedis(ctx context.Context, c *RedisConfig) (*Redis, error) {
+	r, err := OpenRedis(c)
+	if err != nil {
+		return nil, err
+	}
+	client := r.client
+
+	if c.StartupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.StartupTimeout)
+		defer cancel()
+	}
+
+	wait := 100 * time.Millisecond
+	for {
+		err = client.Ping(ctx).Err()
+		if err == nil {
+			return r, nil
+		}
+
+		// Only an unreachable server is worth waiting for, anything else is misconfiguration
+		if !unreachable(err) {
+			break
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			client.Close()
+			return nil, c.explain(err)
+		}
+		if wait *= 2; wait > maxStartupBackoff {
+			wait = maxStartupBackoff
+		}
+	}
+
+	client.Close()
+	return nil, c.explain(err)
+}
+
+// unreachable reports whether err means the server couldn't be reached at all
+func unreachable(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && !isTLSErr(err) ||
+		strings.Contains(err.Error(), "all sentinels are unreachable") ||
+		strings.Contains(err.Error(), "LOADING")
+}
+
+// isTLSErr reports whether err came from the TLS handshake or certificate verification
+func isTLSErr(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "tls:") || strings.Contains(msg, "x509:")
+}
+
+// explain turns a failed startup ping into an error saying which setting is likely wrong
+func (c *RedisConfig) explain(err error) error {
+	msg := err.Error()
+	addrs := strings.Join(c.Addrs, ",")
+	if addrs == "" {
+		addrs = "localhost:6379"
+	}
+
+	switch {
+	case c.usesTLS() && (errors.Is(err, io.EOF) || strings.Contains(msg, "first record does not look like a TLS handshake")):
+		return fmt.Errorf("redis at %s doesn't use TLS, remove -redis-tls and the certificate flags: %w", addrs, err)
+	case isTLSErr(err):
+		return fmt.Errorf("redis TLS handshake failed, check -redis-ca, -redis-cert and -redis-server-name: %w", err)
+	case !c.usesTLS() && (errors.Is(err, io.EOF) || strings.Contains(msg, "connection reset")):
+		return fmt.Errorf("redis at %s closed the connection, it may require -redis-tls: %w", addrs, err)
+	case strings.Contains(msg, "NOAUTH"), strings.Contains(msg, "WRONGPASS"),
+		strings.Contains(msg, "invalid password"), strings.Contains(msg, "invalid username-password"):
+		return fmt.Errorf("redis rejected the credentials, check REDISUSER and REDISPW: %w", err)
+	case strings.Contains(msg, "DB index is out of range"):
+		return fmt.Errorf("redis has no database %d, check -redis-db: %w", c.DB, err)
+	case strings.Contains(msg, "cluster support disabled"):
+		return fmt.Errorf("redis at %s isn't a cluster, remove -redis-cluster: %w", addrs, err)
+	case strings.Contains(msg, "all sentinels are unreachable"):
+		return fmt.Errorf("no sentinel at %s knows master %q, check REDISADDR, -redis-master and REDISSENTINELPW: %w", addrs, c.MasterName, err)
+	case errors.Is(err, context.DeadlineExceeded), unreachable(err):
+		return fmt.Errorf("%w: can't reach %s redis at %s, check REDISADDR: %v", ErrUnreachable, c.mode(), addrs, err)
+	default:
+		return fmt.Errorf("redis connection check failed: %w", err)
+	}
+}
@@ -0,0 +1,171 @@
+package storage_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+func TestRedisConfigClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stocktopus")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	notPEM := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600))
+
+	tests := map[string]struct {
+		config  storage.RedisConfig
+		cluster bool
+		err     string
+	}{
+		"single":                {config: storage.RedisConfig{Addrs: []string{"redis:6379"}, DB: 2}},
+		"default address":       {config: storage.RedisConfig{}},
+		"sentinel":              {config: storage.RedisConfig{Addrs: []string{"s1:26379", "s2:26379"}, MasterName: "mymaster"}},
+		"cluster":               {config: storage.RedisConfig{Addrs: []string{"n1:6379", "n2:6379"}, Cluster: true}, cluster: true},
+		"tls":                   {config: storage.RedisConfig{TLS: true}},
+		"sentinel and cluster":  {config: storage.RedisConfig{MasterName: "mymaster", Cluster: true}, err: "can't be combined"},
+		"cluster database":      {config: storage.RedisConfig{Cluster: true, DB: 1}, err: "only support database 0"},
+		"several addresses":     {config: storage.RedisConfig{Addrs: []string{"a:6379", "b:6379"}}, err: "several redis addresses"},
+		"missing CA":            {config: storage.RedisConfig{CAFile: filepath.Join(dir, "missing.pem")}, err: "read redis CA failed"},
+		"CA not PEM":            {config: storage.RedisConfig{CAFile: notPEM}, err: "contains no PEM certificates"},
+		"certificate no key":    {config: storage.RedisConfig{CertFile: notPEM}, err: "both a certificate and a key"},
+		"certificate not valid": {config: storage.RedisConfig{CertFile: notPEM, KeyFile: notPEM}, err: "load redis client certificate failed"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			client, err := test.config.Client()
+			if test.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			defer client.Close()
+
+			_, isCluster := client.(*redis.ClusterClient)
+			require.Equal(t, test.cluster, isCluster)
+		})
+	}
+}
+
+func TestRedisConfigLoadsTLSOnce(t *testing.T) {
+	caFile, _ := testCertificate(t)
+	c := storage.RedisConfig{CAFile: caFile}
+	require.NoError(t, c.Validate())
+
+	// The client reuses the certificates Validate loaded
+	require.NoError(t, os.Remove(caFile))
+	client, err := c.Client()
+	require.NoError(t, err)
+	client.Close()
+
+	// Changed settings are loaded again
+	c.CAFile = caFile + ".moved"
+	_, err = c.Client()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "read redis CA failed")
+}
+
+func TestConnectRedis(t *testing.T) {
+	ctx := context.Background()
+
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	secured, err := miniredis.Run()
+	require.NoError(t, err)
+	defer secured.Close()
+	secured.RequireAuth("secret")
+
+	// Reserve an address nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := l.Addr().String()
+	l.Close()
+
+	caFile, serverTLS := testCertificate(t)
+	encrypted, err := miniredis.RunTLS(serverTLS)
+	require.NoError(t, err)
+	defer encrypted.Close()
+
+	tests := map[string]struct {
+		config storage.RedisConfig
+		err    string
+	}{
+		"connected":          {config: storage.RedisConfig{Addrs: []string{mr.Addr()}}},
+		"password":           {config: storage.RedisConfig{Addrs: []string{secured.Addr()}, Password: "secret"}},
+		"wrong password":     {config: storage.RedisConfig{Addrs: []string{secured.Addr()}, Password: "wrong"}, err: "check REDISUSER and REDISPW"},
+		"no password":        {config: storage.RedisConfig{Addrs: []string{secured.Addr()}}, err: "check REDISUSER and REDISPW"},
+		"unreachable":        {config: storage.RedisConfig{Addrs: []string{closed}, StartupTimeout: 300 * time.Millisecond}, err: "check REDISADDR"},
+		"invalid config":     {config: storage.RedisConfig{Cluster: true, DB: 3}, err: "redis config invalid"},
+		"tls":                {config: storage.RedisConfig{Addrs: []string{encrypted.Addr()}, CAFile: caFile}},
+		"tls unknown CA":     {config: storage.RedisConfig{Addrs: []string{encrypted.Addr()}, TLS: true}, err: "TLS handshake failed"},
+		"tls to plain redis": {config: storage.RedisConfig{Addrs: []string{mr.Addr()}, CAFile: caFile}, err: "doesn't use TLS"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := storage.ConnectRedis(ctx, &test.config)
+			if test.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			defer s.Close()
+			require.NoError(t, s.Ping(ctx))
+		})
+	}
+}
+
+// testCertificate creates a self signed certificate for 127.0.0.1, returning the path to it as a
+// CA and a server config using it
+func testCertificate(t *testing.T) (string, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stocktopus test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "stocktopus")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, certPEM, 0600))
+
+	return caFile, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrNotFound is returned when a key doesn't exist
+	ErrNotFound = errors.New("key not found")
+
+	// ErrWrongType is returned when a value operation is used on a set or a set operation on a value
+	ErrWrongType = errors.New("key holds the wrong kind of value")
+
+	// ErrConflict is returned when an Update keeps losing to concurrent writers
+	ErrConflict = errors.New("too many concurrent updates")
+)
+
+// UpdateFunc is given the current value of a key, or nil if the key doesn't exist, and returns
+// the value to replace it with. It may be called more than once if the update has to be retried.
+type UpdateFunc func(old []byte) ([]byte, error)
+
+// Store persists watch lists, accounts and any other stocktopus data. Keys either hold a single
+// value or a set of string members.
+type Store interface {
+	// Get returns the value stored at key, or ErrNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put stores a value at key, replacing anything already there
+	Put(ctx context.Context, key string, value []byte) error
+	// Update atomically replaces the value at key with the result of fn
+	Update(ctx context.Context, key string, fn UpdateFunc) error
+
+	// AddMembers adds members to the set at key
+	AddMembers(ctx context.Context, key string, members ...string) error
+	// RemoveMembers removes members from the set at key
+	RemoveMembers(ctx context.Context, key string, members ...string) error
+	// Members returns the members of the set at key, or an empty list if it doesn't exist
+	Members(ctx context.Context, key string) ([]string, error)
+
+	// Delete removes keys of any kind. Missing keys are ignored
+	Delete(ctx context.Context, keys ...string) error
+	// Keys returns every key that starts with prefix
+	Keys(ctx context.Context, prefix string) ([]string, error)
+
+	// Ping checks that the store is reachable
+	Ping(ctx context.Context) error
+	// Close releases any resources held by the store
+	Close() error
+}
@@ -0,0 +1,127 @@
+package storage_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/storage"
+	"github.com/thorfour/stocktopus/pkg/storage/storagetest"
+)
+
+func TestMemory(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		return storage.NewMemory()
+	})
+}
+
+func TestRedis(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		mr, err := miniredis.Run()
+		require.NoError(t, err)
+		t.Cleanup(mr.Close)
+
+		return storage.NewRedis(redis.NewClient(&redis.Options{
+			Addr: mr.Addr(),
+		}))
+	})
+}
+
+func TestRedisCluster(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		mr, err := miniredis.Run()
+		require.NoError(t, err)
+		t.Cleanup(mr.Close)
+
+		return storage.NewRedis(redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs: []string{mr.Addr()},
+		}))
+	})
+}
+
+func TestBolt(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		dir, err := ioutil.TempDir("", "stocktopus")
+		require.NoError(t, err)
+		t.Cleanup(func() { os.RemoveAll(dir) })
+
+		b, err := storage.OpenBolt(dir)
+		require.NoError(t, err)
+		return b
+	})
+}
+
+func TestBoltReopen(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "stocktopus")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b, err := storage.OpenBolt(dir)
+	require.NoError(t, err)
+	require.NoError(t, b.Put(ctx, "key", []byte("value")))
+	require.NoError(t, b.AddMembers(ctx, "set", "a", "b"))
+	require.NoError(t, b.Close())
+
+	// Reopening an up to date database must not rerun migrations or lose data
+	b, err = storage.OpenBolt(dir)
+	require.NoError(t, err)
+	defer b.Close()
+
+	v, err := b.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), v)
+
+	members, err := b.Members(ctx, "set")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, members)
+}
+
+func TestBoltBackup(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "stocktopus")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b, err := storage.OpenBolt(filepath.Join(dir, "live"))
+	require.NoError(t, err)
+	defer b.Close()
+	require.NoError(t, b.Put(ctx, "key", []byte("value")))
+
+	h := storage.BackupHandler(b, "secret")
+
+	// Missing or wrong token
+	for _, auth := range []string{"", "Bearer wrong"} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/backup", nil)
+		req.Header.Set("Authorization", auth)
+		resp := httptest.NewRecorder()
+		h(resp, req)
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/backup", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp := httptest.NewRecorder()
+	h(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	// The backup must be a working database
+	restored := filepath.Join(dir, "restored")
+	require.NoError(t, os.MkdirAll(restored, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(restored, storage.BoltFile), resp.Body.Bytes(), 0600))
+
+	r, err := storage.OpenBolt(restored)
+	require.NoError(t, err)
+	defer r.Close()
+
+	v, err := r.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), v)
+}
@@ -0,0 +1,202 @@
+// Package storagetest is the conformance suite every storage.Store implementation must pass
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+// Run runs the conformance suite. newStore must return an empty store for every call.
+func Run(t *testing.T, newStore func(t *testing.T) storage.Store) {
+	tests := []struct {
+		name string
+		test func(*testing.T, storage.Store)
+	}{
+		{"values", testValues},
+		{"update", testUpdate},
+		{"concurrent update", testConcurrentUpdate},
+		{"sets", testSets},
+		{"wrong type", testWrongType},
+		{"delete", testDelete},
+		{"keys", testKeys},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newStore(t)
+			defer s.Close()
+			require.NoError(t, s.Ping(context.Background()))
+			test.test(t, s)
+		})
+	}
+}
+
+func testValues(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	_, err := s.Get(ctx, "missing")
+	require.True(t, errors.Is(err, storage.ErrNotFound))
+
+	require.NoError(t, s.Put(ctx, "key", []byte("value")))
+	v, err := s.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), v)
+
+	require.NoError(t, s.Put(ctx, "key", []byte("other")))
+	v, err = s.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("other"), v)
+}
+
+func testUpdate(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Update(ctx, "key", func(old []byte) ([]byte, error) {
+		require.Nil(t, old)
+		return []byte("1"), nil
+	}))
+
+	require.NoError(t, s.Update(ctx, "key", func(old []byte) ([]byte, error) {
+		require.Equal(t, []byte("1"), old)
+		return []byte("2"), nil
+	}))
+
+	// A failed update leaves the value alone
+	fail := errors.New("fail")
+	err := s.Update(ctx, "key", func(old []byte) ([]byte, error) {
+		return []byte("3"), fail
+	})
+	require.True(t, errors.Is(err, fail))
+
+	v, err := s.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("2"), v)
+}
+
+func testConcurrentUpdate(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+	workers := 5
+	increments := 10
+
+	// Errors are collected and checked here, require can't stop the test from another goroutine
+	errs := make(chan error, workers*increments)
+	wg := new(sync.WaitGroup)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				err := s.Update(ctx, "counter", func(old []byte) ([]byte, error) {
+					n := 0
+					if old != nil {
+						var err error
+						if n, err = strconv.Atoi(string(old)); err != nil {
+							return nil, err
+						}
+					}
+					return []byte(strconv.Itoa(n + 1)), nil
+				})
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	v, err := s.Get(ctx, "counter")
+	require.NoError(t, err)
+	require.Equal(t, strconv.Itoa(workers*increments), string(v))
+}
+
+func testSets(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	members, err := s.Members(ctx, "missing")
+	require.NoError(t, err)
+	require.Empty(t, members)
+
+	require.NoError(t, s.AddMembers(ctx, "set", "AMD", "TSLA"))
+	require.NoError(t, s.AddMembers(ctx, "set", "AMD", "GOOG"))
+	members, err = s.Members(ctx, "set")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"AMD", "GOOG", "TSLA"}, members)
+
+	require.NoError(t, s.RemoveMembers(ctx, "set", "AMD", "MISSING"))
+	members, err = s.Members(ctx, "set")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"GOOG", "TSLA"}, members)
+
+	// Removing every member removes the set
+	require.NoError(t, s.RemoveMembers(ctx, "set", "GOOG", "TSLA"))
+	keys, err := s.Keys(ctx, "set")
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	require.NoError(t, s.RemoveMembers(ctx, "missing", "AMD"))
+}
+
+func testWrongType(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "value", []byte("value")))
+	require.NoError(t, s.AddMembers(ctx, "set", "AMD"))
+
+	require.True(t, errors.Is(s.AddMembers(ctx, "value", "AMD"), storage.ErrWrongType))
+	_, err := s.Members(ctx, "value")
+	require.True(t, errors.Is(err, storage.ErrWrongType))
+	_, err = s.Get(ctx, "set")
+	require.True(t, errors.Is(err, storage.ErrWrongType))
+
+	// Put replaces a set
+	require.NoError(t, s.Put(ctx, "set", []byte("value")))
+	v, err := s.Get(ctx, "set")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), v)
+}
+
+func testDelete(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "value", []byte("value")))
+	require.NoError(t, s.AddMembers(ctx, "set", "AMD"))
+	require.NoError(t, s.Delete(ctx, "value", "set", "missing"))
+	require.NoError(t, s.Delete(ctx))
+
+	_, err := s.Get(ctx, "value")
+	require.True(t, errors.Is(err, storage.ErrNotFound))
+	members, err := s.Members(ctx, "set")
+	require.NoError(t, err)
+	require.Empty(t, members)
+}
+
+func testKeys(t *testing.T, s storage.Store) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "a:1", []byte("1")))
+	require.NoError(t, s.Put(ctx, "a:2", []byte("2")))
+	require.NoError(t, s.AddMembers(ctx, "a:set", "AMD"))
+	require.NoError(t, s.Put(ctx, "b:1", []byte("1")))
+	require.NoError(t, s.Put(ctx, "a*[b]?", []byte("1")))
+
+	keys, err := s.Keys(ctx, "a:")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a:1", "a:2", "a:set"}, keys)
+
+	// Prefixes are matched literally
+	keys, err = s.Keys(ctx, "a*")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a*[b]?"}, keys)
+
+	keys, err = s.Keys(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, keys, 5)
+}
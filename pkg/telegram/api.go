@@ -0,0 +1,75 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultAPIURL is the Telegram Bot API
+const DefaultAPIURL = "https://api.telegram.org"
+
+// APIError is an error returned by the Bot API
+type APIError struct {
+	Code        int
+	Description string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Description)
+}
+
+// call calls a Bot API method with params sent as JSON, decoding its result into result when
+// it's not nil. The bot token is part of the URL, so it's left out of errors.
+func (b *Bot) call(ctx context.Context, method string, params, result interface{}) error {
+	if params == nil {
+		params = struct{}{}
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bot%s/%s", strings.TrimSuffix(b.api, "/"), b.token, method), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s failed: invalid API URL", method)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("%s failed: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	reply := struct {
+		OK          bool            `json:"ok"`
+		Result      json.RawMessage `json:"result"`
+		ErrorCode   int             `json:"error_code"`
+		Description string          `json:"description"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("%s failed: %s", method, resp.Status)
+	}
+	if !reply.OK {
+		return fmt.Errorf("%s failed: %w", method, &APIError{Code: reply.ErrorCode, Description: reply.Description})
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(reply.Result, result); err != nil {
+		return fmt.Errorf("%s failed: %w", method, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/thorfour/stocktopus/pkg/engine"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stocktopus"
+)
+
+// maxText is the longest text put in a message, leaving room for markup within the 4096
+// characters Telegram accepts
+const maxText = 3500
+
+// parseHTML formats messages with Telegram's subset of HTML
+const parseHTML = "HTML"
+
+// outgoing is the sendMessage request for a reply
+type outgoing struct {
+	ChatID         int64  `json:"chat_id"`
+	Text           string `json:"text"`
+	ParseMode      string `json:"parse_mode,omitempty"`
+	ReplyTo        int64  `json:"reply_to_message_id,omitempty"`
+	DisablePreview bool   `json:"disable_web_page_preview,omitempty"`
+}
+
+// article is an inline query result
+type article struct {
+	Type        string         `json:"type"`
+	ID          string         `json:"id"`
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Content     messageContent `json:"input_message_content"`
+}
+
+type messageContent struct {
+	Text      string `json:"message_text"`
+	ParseMode string `json:"parse_mode"`
+}
+
+// errorReply shows err to the user
+func errorReply(err error) *outgoing {
+	return &outgoing{Text: stocktopus.UserMessage(err), DisablePreview: true}
+}
+
+// render formats a command result for Telegram
+func render(result engine.Result) *outgoing {
+	msg := &outgoing{ParseMode: parseHTML, DisablePreview: true}
+
+	switch r := result.(type) {
+	case *engine.Message:
+		msg.Text = html.EscapeString(r.Text)
+	case *engine.Quotes:
+		msg.Text = pre(r.List.String()) + missing(r)
+		if r.Chart != "" {
+			// The preview of the first link shows the chart
+			msg.Text = fmt.Sprintf(`<a href="%s">%s</a>%s`, html.EscapeString(r.Chart), html.EscapeString(r.List[0].Ticker), pre(stocktopus.Details(r.List[0])))
+			msg.DisablePreview = false
+		}
+	case *engine.Balance:
+		msg.Text = fmt.Sprintf("New Balance: %v", r.Balance)
+	case *engine.Portfolio:
+		msg.Text = pre(r.Account.String())
+	case *engine.Company:
+		lines := []string{fmt.Sprintf("<b>%s</b>", html.EscapeString(r.CompanyName))}
+		for _, s := range []string{r.Industry, r.Website, r.CEO, truncate(r.Description, maxText)} {
+			lines = append(lines, html.EscapeString(s))
+		}
+		msg.Text = strings.Join(lines, "\n")
+	case *engine.News:
+		msg.Text = html.EscapeString(truncate(strings.Join(r.Headlines, "\n\n"), maxText))
+	case *engine.Stats:
+		msg.Text = pre(stocktopus.Stats(r.Stats))
+	case *engine.History:
+		msg.Text = pre(r.Performance.String())
+	case *engine.Search:
+		msg.Text = pre(r.Results.String())
+	case *engine.Export:
+		msg.Text = fmt.Sprintf(`<a href="%s">Download your data</a> (link expires in %v)`, html.EscapeString(r.URL), r.Expires)
+	case *engine.ImportPreview:
+		msg.Text = pre(r.Import.String()) + "\nSend /import confirm to apply or /import cancel to discard"
+	case *engine.Imported:
+		msg.Text = fmt.Sprintf("Imported %v tickers and %v positions", len(r.Import.Tickers), len(r.Import.Positions))
+	case *engine.Help:
+		msg.Text = help(r)
+	default:
+		msg.Text = stocktopus.InternalErrorMessage
+	}
+
+	return msg
+}
+
+// articles returns an inline result for each quote, choosing one sends its details to the chat
+func articles(q *engine.Quotes) []*article {
+	results := make([]*article, 0, len(q.List))
+	for _, quote := range q.List {
+		change := "change unavailable"
+		if quote.Has(stock.FieldChange) {
+			change = fmt.Sprintf("%+0.2f (%+0.3f%%)", quote.Change, 100*quote.ChangePercent)
+		}
+		results = append(results, &article{
+			Type:        "article",
+			ID:          quote.Ticker,
+			Title:       fmt.Sprintf("%s %0.2f", quote.Ticker, quote.LatestPrice),
+			Description: change,
+			Content:     messageContent{Text: pre(stocktopus.Details(quote)), ParseMode: parseHTML},
+		})
+	}
+	return results
+}
+
+// help lists every command, or shows how to use a single command
+func help(h *engine.Help) string {
+	if h.Command != nil {
+		lines := []string{fmt.Sprintf("<b>/%s</b>", html.EscapeString(h.Command.Usage())), html.EscapeString(h.Command.Summary)}
+		for _, f := range h.Command.Flags {
+			lines = append(lines, fmt.Sprintf("<code>--%s</code> %s", f.Name, html.EscapeString(f.Usage)))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	lines := make([]string, 0, len(h.Specs))
+	for _, spec := range h.Specs {
+		lines = append(lines, fmt.Sprintf("<b>/%s</b> %s", html.EscapeString(spec.Usage()), html.EscapeString(spec.Summary)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// pre shows a table in monospace, cutting it short to fit in a message
+func pre(s string) string {
+	return "<pre>" + html.EscapeString(truncate(s, maxText)) + "</pre>"
+}
+
+// missing lists symbols that couldn't be quoted
+func missing(q *engine.Quotes) string {
+	text := stocktopus.MissingText(q.Missing, q.Unavailable, q.RetryAfter)
+	if text == "" {
+		return ""
+	}
+	return html.EscapeString("\n" + strings.TrimSuffix(text, "\n"))
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
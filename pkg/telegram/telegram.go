@@ -0,0 +1,419 @@
+// Package telegram runs stocktopus commands sent to a Telegram bot, by webhook or long polling
+package telegram
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/thorfour/stocktopus/pkg/engine"
+	"github.com/thorfour/stocktopus/pkg/tracing"
+)
+
+const (
+	// team is the team of every Telegram user, Telegram has no workspaces so accounts and
+	// personal lists are keyed by user id alone
+	team = "users"
+
+	// pollTimeout is how long a getUpdates request waits for updates
+	pollTimeout = 30 * time.Second
+
+	// pollRetry is how long to wait after getUpdates fails
+	pollRetry = 5 * time.Second
+
+	// inlineCacheTime is how long Telegram caches the results of an inline query, in seconds
+	inlineCacheTime = 60
+
+	// inlineDebounce is how long an inline query waits for the user to keep typing. Telegram
+	// sends a query for every keystroke.
+	inlineDebounce = 300 * time.Millisecond
+
+	// inlineQuoteTTL is how long the results of an inline query are reused for the same query
+	inlineQuoteTTL = 15 * time.Second
+
+	// maxInlineCache is the most inline query results kept
+	maxInlineCache = 1000
+
+	// maxUpdateSize limits the size of a webhook update
+	maxUpdateSize = 1 << 20
+)
+
+// chatPrivate is the type of a one to one chat with the bot
+const chatPrivate = "private"
+
+type user struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username,omitempty"`
+}
+
+type chat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+}
+
+type message struct {
+	MessageID int64  `json:"message_id"`
+	From      *user  `json:"from"`
+	Chat      chat   `json:"chat"`
+	Text      string `json:"text"`
+}
+
+type inlineQuery struct {
+	ID    string `json:"id"`
+	From  user   `json:"from"`
+	Query string `json:"query"`
+}
+
+// update is an incoming update, only messages and inline queries are handled
+type update struct {
+	UpdateID    int64        `json:"update_id"`
+	Message     *message     `json:"message"`
+	InlineQuery *inlineQuery `json:"inline_query"`
+}
+
+// Bot is a Telegram bot that runs stocktopus commands
+type Bot struct {
+	e      *engine.Engine
+	token  string
+	api    string
+	secret string
+	client *http.Client
+
+	debounce time.Duration
+	mu       sync.Mutex
+	username string           // the bot's own username, fetched on the first message
+	typing   map[int64]string // newest inline query id of each user
+	answers  map[string]*answer
+}
+
+// answer is the results of an inline query, reused until it expires
+type answer struct {
+	results []*article
+	expires time.Time
+}
+
+// Option configures a Bot
+type Option func(*Bot)
+
+// WithAPIURL uses a Bot API other than DefaultAPIURL
+func WithAPIURL(api string) Option {
+	return func(b *Bot) {
+		b.api = api
+	}
+}
+
+// WithSecretToken requires webhook requests to carry secret, which is registered with the webhook
+func WithSecretToken(secret string) Option {
+	return func(b *Bot) {
+		b.secret = secret
+	}
+}
+
+// New returns a bot with the token from BotFather that runs commands with e
+func New(e *engine.Engine, token string, opts ...Option) *Bot {
+	b := &Bot{
+		e:      e,
+		token:  token,
+		api:    DefaultAPIURL,
+		client: &http.Client{Timeout: pollTimeout + 10*time.Second},
+
+		debounce: inlineDebounce,
+		typing:   map[int64]string{},
+		answers:  map[string]*answer{},
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// SetWebhook asks Telegram to send updates to url
+func (b *Bot) SetWebhook(ctx context.Context, url string) error {
+	return b.call(ctx, "setWebhook", map[string]interface{}{
+		"url":             url,
+		"secret_token":    b.secret,
+		"allowed_updates": []string{"message", "inline_query"},
+	}, nil)
+}
+
+// Handler is a http handler func for webhook updates
+func (b *Bot) Handler(resp http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	header := req.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if b.secret == "" || subtle.ConstantTimeCompare([]byte(header), []byte(b.secret)) != 1 {
+		tracing.Log(ctx).Warn("invalid telegram secret token")
+		http.Error(resp, "invalid secret token", http.StatusUnauthorized)
+		return
+	}
+
+	u := &update{}
+	if err := json.NewDecoder(http.MaxBytesReader(resp, req.Body, maxUpdateSize)).Decode(u); err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Failures are logged, Telegram would only retry the update
+	b.handle(ctx, u)
+}
+
+// Poll fetches updates with long polling until ctx is done, for servers Telegram can't reach.
+// Any webhook is removed first, Telegram only delivers updates one way. Failures are retried.
+func (b *Bot) Poll(ctx context.Context) {
+	var (
+		offset  int64
+		deleted bool
+	)
+	for {
+		var (
+			updates []*update
+			err     error
+		)
+		if deleted {
+			err = b.call(ctx, "getUpdates", map[string]interface{}{
+				"offset":          offset,
+				"timeout":         int(pollTimeout.Seconds()),
+				"allowed_updates": []string{"message", "inline_query"},
+			}, &updates)
+		} else {
+			err = b.call(ctx, "deleteWebhook", nil, nil)
+			deleted = err == nil
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			tracing.Log(ctx).WithField("msg", "telegram poll failed").Error(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollRetry):
+			}
+			continue
+		}
+
+		wg := &sync.WaitGroup{}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			wg.Add(1)
+			go func(u *update) {
+				defer wg.Done()
+				b.handle(ctx, u)
+			}(u)
+		}
+		wg.Wait()
+	}
+}
+
+// handle runs the command in a message or answers an inline query. A panic is logged rather
+// than stopping the poll loop.
+func (b *Bot) handle(ctx context.Context, u *update) {
+	defer func() {
+		if r := recover(); r != nil {
+			tracing.Log(ctx).WithField("msg", "panic").WithField("stack", string(debug.Stack())).Error(r)
+		}
+	}()
+
+	var err error
+	switch {
+	case u.Message != nil:
+		err = b.message(ctx, u.Message)
+	case u.InlineQuery != nil:
+		err = b.inline(ctx, u.InlineQuery)
+	}
+	if err != nil {
+		tracing.Log(ctx).WithField("msg", "telegram reply failed").Error(err)
+	}
+}
+
+// message runs a command and replies with its result. Private results are sent to the user
+// directly when the command was sent in a group.
+func (b *Bot) message(ctx context.Context, m *message) error {
+	if m.From == nil {
+		return nil // Sent on behalf of a channel
+	}
+	username, err := b.me(ctx)
+	if err != nil {
+		return err
+	}
+	text, ok := commandText(m.Text, username, m.Chat.Type == chatPrivate)
+	if !ok {
+		return nil
+	}
+
+	result, err := b.e.Run(ctx, &engine.Request{
+		Platform: engine.Telegram,
+		Team:     team,
+		User:     strconv.FormatInt(m.From.ID, 10),
+		Channel:  strconv.FormatInt(m.Chat.ID, 10),
+		Group:    strconv.FormatInt(m.Chat.ID, 10),
+		Text:     text,
+	})
+	reply, public := errorReply(err), false
+	if err == nil {
+		reply, public = render(result), result.Public()
+	}
+
+	if m.Chat.Type == chatPrivate || public {
+		reply.ChatID, reply.ReplyTo = m.Chat.ID, m.MessageID
+		return b.call(ctx, "sendMessage", reply, nil)
+	}
+
+	reply.ChatID = m.From.ID
+	err = b.call(ctx, "sendMessage", reply, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+		// Bots can't message users who haven't started a chat with them
+		return b.call(ctx, "sendMessage", &outgoing{
+			ChatID:  m.Chat.ID,
+			ReplyTo: m.MessageID,
+			Text:    "Start a private chat with me to see this, the reply is only for you",
+		}, nil)
+	}
+	return err
+}
+
+// me returns the bot's username, it's only fetched until it's known
+func (b *Bot) me(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	username := b.username
+	b.mu.Unlock()
+	if username != "" {
+		return username, nil
+	}
+
+	me := &user{}
+	if err := b.call(ctx, "getMe", nil, me); err != nil {
+		return "", fmt.Errorf("get bot username failed: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.username = me.Username
+	return b.username, nil
+}
+
+// inline answers an inline query with a card for each ticker quoted. Only the query a user
+// settles on is answered, and answers are reused for a while.
+func (b *Bot) inline(ctx context.Context, q *inlineQuery) error {
+	query := strings.ToUpper(strings.Join(strings.Fields(q.Query), " "))
+	results := []*article{}
+	if query != "" {
+		if !b.settled(ctx, q) {
+			return nil // Telegram drops queries that aren't answered
+		}
+
+		if cached, ok := b.answer(query); ok {
+			results = cached
+		} else {
+			result, err := b.e.Run(ctx, &engine.Request{
+				Platform: engine.Telegram,
+				Team:     team,
+				User:     strconv.FormatInt(q.From.ID, 10),
+				Text:     "quote " + q.Query,
+				Passive:  true,
+			})
+			if quotes, ok := result.(*engine.Quotes); ok && err == nil {
+				results = articles(quotes)
+				b.remember(query, results)
+			}
+		}
+	}
+
+	return b.call(ctx, "answerInlineQuery", map[string]interface{}{
+		"inline_query_id": q.ID,
+		"results":         results,
+		"cache_time":      inlineCacheTime,
+	}, nil)
+}
+
+// settled waits for the debounce and reports whether q is still the user's newest query
+func (b *Bot) settled(ctx context.Context, q *inlineQuery) bool {
+	b.mu.Lock()
+	b.typing[q.From.ID] = q.ID
+	b.mu.Unlock()
+
+	t := time.NewTimer(b.debounce)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.typing[q.From.ID] != q.ID {
+		return false
+	}
+	delete(b.typing, q.From.ID)
+	return true
+}
+
+// answer returns the unexpired results of a query
+func (b *Bot) answer(query string) ([]*article, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	a, ok := b.answers[query]
+	if !ok || time.Now().After(a.expires) {
+		return nil, false
+	}
+	return a.results, true
+}
+
+// remember keeps the results of a query, expired answers are dropped to make room
+func (b *Bot) remember(query string, results []*article) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if len(b.answers) >= maxInlineCache {
+		for q, a := range b.answers {
+			if now.After(a.expires) {
+				delete(b.answers, q)
+			}
+		}
+	}
+	if len(b.answers) < maxInlineCache {
+		b.answers[query] = &answer{results: results, expires: now.Add(inlineQuoteTTL)}
+	}
+}
+
+// commandText turns a message into the text the engine parses. Commands start with a slash and
+// can be addressed to the bot with /command@username, commands addressed to other bots are
+// ignored. Other messages are only commands in a private chat with the bot.
+func commandText(text, username string, private bool) (string, bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		if !private {
+			return "", false
+		}
+		return text, text != ""
+	}
+
+	name, rest := text[1:], ""
+	if i := strings.IndexFunc(name, unicode.IsSpace); i >= 0 {
+		name, rest = name[:i], name[i:]
+	}
+	if i := strings.Index(name, "@"); i >= 0 {
+		if !strings.EqualFold(name[i+1:], username) {
+			return "", false
+		}
+		name = name[:i]
+	}
+	if name == "start" {
+		name = "help" // Sent when a user first opens a chat with the bot
+	}
+
+	return fmt.Sprintf("%s%s", name, rest), name != ""
+}
@@ -0,0 +1,337 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thorfour/stocktopus/pkg/engine"
+	"github.com/thorfour/stocktopus/pkg/stock"
+	"github.com/thorfour/stocktopus/pkg/stock/stocktest"
+	"github.com/thorfour/stocktopus/pkg/storage"
+)
+
+const (
+	botToken = "123:abc"
+	secret   = "webhook-secret"
+)
+
+// lookup quotes every symbol at 2, up 0.5
+func lookup() *stocktest.Lookup {
+	return &stocktest.Lookup{
+		Quote:     stock.Quote{LatestPrice: 2, Change: 0.5, ChangePercent: 0.25},
+		Headlines: []string{"a & b"},
+	}
+}
+
+// call is a Bot API call made to the fake API
+type call struct {
+	method string
+	params map[string]interface{}
+}
+
+// fakeAPI is a local Bot API. Methods reply with the result set in results, or with an error
+// for a chat id in blocked.
+type fakeAPI struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	calls   []call
+	results map[string][]interface{}
+	blocked float64
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	api := &fakeAPI{results: map[string][]interface{}{}}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		prefix := "/bot" + botToken + "/"
+		require.True(t, strings.HasPrefix(req.URL.Path, prefix), req.URL.Path)
+		c := call{method: strings.TrimPrefix(req.URL.Path, prefix)}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&c.params))
+
+		api.mu.Lock()
+		api.calls = append(api.calls, c)
+		var result interface{} = true
+		if c.method == "getUpdates" {
+			result = []interface{}{}
+		}
+		if r := api.results[c.method]; len(r) > 0 {
+			result, api.results[c.method] = r[0], r[1:]
+		}
+		blocked := api.blocked != 0 && c.params["chat_id"] == api.blocked
+		api.mu.Unlock()
+
+		if blocked {
+			json.NewEncoder(resp).Encode(map[string]interface{}{"ok": false, "error_code": 403, "description": "Forbidden: bot can't initiate conversation with a user"})
+			return
+		}
+		json.NewEncoder(resp).Encode(map[string]interface{}{"ok": true, "result": result})
+	}))
+	return api
+}
+
+// sent returns the calls of method and forgets every call
+func (f *fakeAPI) sent(method string) []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var params []map[string]interface{}
+	for _, c := range f.calls {
+		if c.method == method {
+			params = append(params, c.params)
+		}
+	}
+	f.calls = nil
+	return params
+}
+
+func newBot(t *testing.T) (*Bot, *fakeAPI, storage.Store) {
+	api := newFakeAPI(t)
+	t.Cleanup(api.Close)
+
+	store := storage.NewMemory()
+	b := New(engine.New(store, lookup()), botToken, WithAPIURL(api.URL), WithSecretToken(secret))
+	api.results["getMe"] = []interface{}{map[string]interface{}{"id": 1, "username": "stocktopusbot"}}
+	b.debounce = 0
+	return b, api, store
+}
+
+func inlineUpdate(id, query string) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"update_id":    2,
+		"inline_query": map[string]interface{}{"id": id, "from": map[string]interface{}{"id": 42}, "query": query},
+	})
+	return string(b)
+}
+
+func webhook(b *Bot, body, token string) int {
+	req := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(body))
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", token)
+	rec := httptest.NewRecorder()
+	b.Handler(rec, req)
+	return rec.Code
+}
+
+func messageUpdate(chatID int64, chatType, text string) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"update_id": 1,
+		"message": map[string]interface{}{
+			"message_id": 7,
+			"from":       map[string]interface{}{"id": 42},
+			"chat":       map[string]interface{}{"id": chatID, "type": chatType},
+			"text":       text,
+		},
+	})
+	return string(b)
+}
+
+func TestCommandText(t *testing.T) {
+	tests := []struct {
+		text    string
+		private bool
+		command string
+		ok      bool
+	}{
+		{text: "/quote AAPL", command: "quote AAPL", ok: true},
+		{text: "/quote@stocktopusbot aapl msft", command: "quote aapl msft", ok: true},
+		{text: "/list@StocktopusBot", command: "list", ok: true},
+		{text: "/list@someotherbot"},
+		{text: "/list", command: "list", ok: true},
+		{text: "/import #fun\namd", command: "import #fun\namd", ok: true},
+		{text: "/start", private: true, command: "help", ok: true},
+		{text: "aapl", private: true, command: "aapl", ok: true},
+		{text: "aapl"},
+		{text: "/", command: ""},
+		{text: " ", private: true, command: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			command, ok := commandText(test.text, "stocktopusbot", test.private)
+			require.Equal(t, test.ok, ok)
+			require.Equal(t, test.command, command)
+		})
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	b, api, store := newBot(t)
+
+	// Only updates with the secret token are accepted
+	require.Equal(t, http.StatusUnauthorized, webhook(b, messageUpdate(42, chatPrivate, "/list"), ""))
+	require.Equal(t, http.StatusUnauthorized, webhook(b, messageUpdate(42, chatPrivate, "/list"), "guess"))
+	require.Equal(t, http.StatusBadRequest, webhook(b, "{", secret))
+	require.Empty(t, api.sent("sendMessage"))
+
+	// Private chat
+	require.Equal(t, http.StatusOK, webhook(b, messageUpdate(42, chatPrivate, "/quote@stocktopusbot amd"), secret))
+	sent := api.sent("sendMessage")
+	require.Len(t, sent, 1)
+	require.Equal(t, float64(42), sent[0]["chat_id"])
+	require.Equal(t, float64(7), sent[0]["reply_to_message_id"])
+	require.Equal(t, parseHTML, sent[0]["parse_mode"])
+	require.Contains(t, sent[0]["text"], `<a href="http://finviz.com/chart.ashx?t=AMD&amp;ty=c&amp;ta=1&amp;p=d&amp;s=l">AMD</a><pre>`)
+
+	// Commands for other bots in a group are ignored
+	require.Equal(t, http.StatusOK, webhook(b, messageUpdate(-100, "supergroup", "/list@someotherbot"), secret))
+	require.Empty(t, api.sent("sendMessage"))
+
+	// Public results are sent to the group
+	require.Equal(t, http.StatusOK, webhook(b, messageUpdate(-100, "supergroup", "/news amd"), secret))
+	sent = api.sent("sendMessage")
+	require.Len(t, sent, 1)
+	require.Equal(t, float64(-100), sent[0]["chat_id"])
+	require.Equal(t, "a &amp; b", sent[0]["text"])
+
+	// Private results are sent to the user
+	require.Equal(t, http.StatusOK, webhook(b, messageUpdate(-100, "supergroup", "/deposit 10"), secret))
+	sent = api.sent("sendMessage")
+	require.Len(t, sent, 1)
+	require.Equal(t, float64(42), sent[0]["chat_id"])
+	require.Equal(t, "New Balance: 10", sent[0]["text"])
+
+	// Unless they haven't started a chat with the bot
+	api.blocked = 42
+	require.Equal(t, http.StatusOK, webhook(b, messageUpdate(-100, "supergroup", "/buy amd ten"), secret))
+	sent = api.sent("sendMessage")
+	require.Len(t, sent, 2)
+	require.Equal(t, "\"ten\" is not a whole number of shares\nUsage: `buy [ticker] [shares]`", sent[0]["text"])
+	require.Equal(t, float64(-100), sent[1]["chat_id"])
+	require.Contains(t, sent[1]["text"], "Start a private chat")
+	api.blocked = 0
+
+	// Plain messages in groups aren't commands
+	require.Equal(t, http.StatusOK, webhook(b, messageUpdate(-100, "supergroup", "amd"), secret))
+	require.Empty(t, api.sent("sendMessage"))
+
+	// Accounts follow the user, team lists stay in their chat
+	require.Equal(t, http.StatusOK, webhook(b, messageUpdate(42, chatPrivate, "/deposit 5"), secret))
+	require.Equal(t, "New Balance: 15", api.sent("sendMessage")[0]["text"])
+	require.Equal(t, http.StatusOK, webhook(b, messageUpdate(-100, "supergroup", "/watch #fun amd"), secret))
+	found, err := store.Keys(context.Background(), "v2:telegram-")
+	require.NoError(t, err)
+	require.Equal(t, []string{"v2:telegram-users--100:list:fun", "v2:telegram-users:user:42:account", "v2:telegram-users:user:42:seen"}, found)
+}
+
+func TestInline(t *testing.T) {
+	b, api, store := newBot(t)
+
+	require.Equal(t, http.StatusOK, webhook(b, inlineUpdate("q1", "amd intc"), secret))
+
+	sent := api.sent("answerInlineQuery")
+	require.Len(t, sent, 1)
+	require.Equal(t, "q1", sent[0]["inline_query_id"])
+
+	results := sent[0]["results"].([]interface{})
+	require.Len(t, results, 2)
+	card := results[0].(map[string]interface{})
+	require.Equal(t, "article", card["type"])
+	require.Equal(t, "AMD 2.00", card["title"])
+	require.Equal(t, "+0.50 (+25.000%)", card["description"])
+	require.Contains(t, card["input_message_content"].(map[string]interface{})["message_text"], "<pre>")
+
+	// Anything but quotes has no results
+	require.Equal(t, http.StatusOK, webhook(b, inlineUpdate("q2", "--limit 5"), secret))
+	sent = api.sent("answerInlineQuery")
+	require.Empty(t, sent[0]["results"])
+
+	// Previews aren't user activity
+	found, err := store.Keys(context.Background(), "v2:telegram-")
+	require.NoError(t, err)
+	require.Empty(t, found)
+}
+
+func TestInlineCache(t *testing.T) {
+	b, api, _ := newBot(t)
+	l := lookup()
+	b.e = engine.New(storage.NewMemory(), l)
+
+	require.Equal(t, http.StatusOK, webhook(b, inlineUpdate("q1", "amd intc"), secret))
+	require.Equal(t, http.StatusOK, webhook(b, inlineUpdate("q2", " AMD  intc "), secret))
+	sent := api.sent("answerInlineQuery")
+	require.Len(t, sent, 2)
+	require.Equal(t, sent[0]["results"], sent[1]["results"])
+	require.Equal(t, 1, l.QuoteCalls(), "the same query is quoted once")
+
+	require.Equal(t, http.StatusOK, webhook(b, inlineUpdate("q3", "amd"), secret))
+	require.Equal(t, 2, l.QuoteCalls())
+}
+
+func TestInlineDebounce(t *testing.T) {
+	b, api, _ := newBot(t)
+	l := lookup()
+	b.e = engine.New(storage.NewMemory(), l)
+	b.debounce = 200 * time.Millisecond
+
+	// Each keystroke is a query, only the last one is answered
+	wg := &sync.WaitGroup{}
+	for i, query := range []string{"a", "am", "amd"} {
+		wg.Add(1)
+		go func(id, query string) {
+			defer wg.Done()
+			webhook(b, inlineUpdate(id, query), secret)
+		}(fmt.Sprintf("q%d", i), query)
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+
+	sent := api.sent("answerInlineQuery")
+	require.Len(t, sent, 1)
+	require.Equal(t, "q2", sent[0]["inline_query_id"])
+	require.Equal(t, 1, l.QuoteCalls())
+}
+
+func TestPoll(t *testing.T) {
+	b, api, _ := newBot(t)
+	api.results["getUpdates"] = []interface{}{
+		[]interface{}{json.RawMessage(messageUpdate(42, chatPrivate, "/list"))},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Poll(ctx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		return len(api.calls) >= 5 // deleteWebhook, getUpdates, getMe, sendMessage, getUpdates
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	require.Equal(t, "deleteWebhook", api.calls[0].method)
+	require.Equal(t, "getUpdates", api.calls[1].method)
+	require.Equal(t, float64(0), api.calls[1].params["offset"])
+	require.Equal(t, "getMe", api.calls[2].method)
+	require.Equal(t, "sendMessage", api.calls[3].method)
+	require.Equal(t, "getUpdates", api.calls[4].method)
+	require.Equal(t, float64(2), api.calls[4].params["offset"])
+}
+
+func TestSetWebhook(t *testing.T) {
+	b, api, _ := newBot(t)
+	require.NoError(t, b.SetWebhook(context.Background(), "https://example.com/telegram"))
+
+	sent := api.sent("setWebhook")
+	require.Len(t, sent, 1)
+	require.Equal(t, "https://example.com/telegram", sent[0]["url"])
+	require.Equal(t, secret, sent[0]["secret_token"])
+
+	// The token is never part of an error
+	b = New(nil, botToken, WithAPIURL("http://127.0.0.1:0"))
+	err := b.SetWebhook(context.Background(), "https://example.com/telegram")
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), botToken)
+}
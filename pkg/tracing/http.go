@@ -0,0 +1,114 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request id, one sent by the caller is kept
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestID is the longest request id accepted from a caller
+const maxRequestID = 64
+
+type requestIDKey struct{}
+
+// RequestID returns the id of the request ctx belongs to, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Log returns a logger with the request id and trace of ctx, so log lines can be found from a
+// trace and the other way around
+func Log(ctx context.Context) *logrus.Entry {
+	entry := logrus.NewEntry(logrus.StandardLogger())
+	if id := RequestID(ctx); id != "" {
+		entry = entry.WithField("request_id", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		entry = entry.WithField("trace_id", sc.TraceID().String()).WithField("span_id", sc.SpanID().String())
+	}
+	return entry
+}
+
+// Middleware assigns each request an id and traces it, continuing the caller's trace when the
+// request carries one
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		resp.Header().Set(RequestIDHeader, id)
+
+		// Name spans by route so ids in paths don't make every span name unique
+		name := req.URL.Path
+		if route := mux.CurrentRoute(req); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				name = tmpl
+			}
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx = context.WithValue(ctx, requestIDKey{}, id)
+		ctx, span := otel.Tracer(instrumentation).Start(ctx, req.Method+" "+name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("stocktopus", name, req)...),
+			trace.WithAttributes(requestIDAttr.String(id)),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: resp, status: http.StatusOK}
+		next.ServeHTTP(rec, req.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(rec.status))
+		if code, msg := semconv.SpanStatusFromHTTPStatusCode(rec.status); code == codes.Error {
+			span.SetStatus(code, msg)
+		}
+	})
+}
+
+// requestIDAttr records the request id on server spans
+const requestIDAttr = attribute.Key("http.request_id")
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// validRequestID reports whether a caller's request id is safe to log and echo back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
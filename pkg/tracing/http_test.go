@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMiddleware(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	logs := &bytes.Buffer{}
+	out, formatter := logrus.StandardLogger().Out, logrus.StandardLogger().Formatter
+	logrus.SetOutput(logs)
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+	defer func() {
+		logrus.SetOutput(out)
+		logrus.SetFormatter(formatter)
+	}()
+
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/teams/{team}", func(resp http.ResponseWriter, req *http.Request) {
+		ctx, span := Start(req.Context(), "child")
+		defer span.End()
+		Log(ctx).Info("handled")
+		resp.WriteHeader(http.StatusInternalServerError)
+	})
+
+	tests := map[string]struct {
+		header  http.Header
+		id      string
+		traceID string
+	}{
+		"new":       {header: http.Header{}},
+		"kept":      {header: http.Header{RequestIDHeader: {"abc-123"}}, id: "abc-123"},
+		"invalid":   {header: http.Header{RequestIDHeader: {"a b\nc"}}},
+		"continued": {header: http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}, traceID: "4bf92f3577b34da6a3ce929d0e0e4736"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			logs.Reset()
+			req := httptest.NewRequest("GET", "/teams/T1", nil)
+			req.Header = test.header
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			id := resp.Header().Get(RequestIDHeader)
+			if test.id != "" {
+				require.Equal(t, test.id, id)
+			} else {
+				require.Len(t, id, 32)
+			}
+
+			ended := spans.Ended()
+			child, server := ended[len(ended)-2], ended[len(ended)-1]
+			require.Equal(t, "child", child.Name())
+			require.Equal(t, "GET /teams/{team}", server.Name())
+			require.Equal(t, server.SpanContext().SpanID(), child.Parent().SpanID())
+			require.Contains(t, server.Attributes(), attribute.String("http.request_id", id))
+			require.Equal(t, codes.Error, server.Status().Code)
+			if test.traceID != "" {
+				require.Equal(t, test.traceID, server.SpanContext().TraceID().String())
+			}
+
+			// Log lines carry the request and the span they were written in
+			line := map[string]string{}
+			require.NoError(t, json.Unmarshal(logs.Bytes(), &line))
+			require.Equal(t, id, line["request_id"])
+			require.Equal(t, child.SpanContext().TraceID().String(), line["trace_id"])
+			require.Equal(t, child.SpanContext().SpanID().String(), line["span_id"])
+		})
+	}
+}
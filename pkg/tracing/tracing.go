@@ -0,0 +1,125 @@
+// Package tracing sets up OpenTelemetry tracing and correlates requests with their logs
+package tracing
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the tracer spans are recorded with
+const instrumentation = "github.com/thorfour/stocktopus"
+
+// Exporters spans can be sent to
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Config selects where spans are exported and how many requests are traced
+type Config struct {
+	// Exporter is none, stdout or otlp
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the host:port of an OTLP/HTTP collector, OTEL_EXPORTER_OTLP_ENDPOINT or
+	// localhost:4318 is used when it's empty
+	Endpoint string `yaml:"endpoint"`
+	// Insecure sends spans to the collector over plain HTTP
+	Insecure bool `yaml:"insecure"`
+	// SampleRatio is the fraction of requests traced, requests from a sampled caller always are
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// DefaultConfig returns the settings used when nothing is configured
+func DefaultConfig() Config {
+	return Config{
+		Exporter:    ExporterNone,
+		SampleRatio: 1,
+	}
+}
+
+// RegisterFlags adds flags for the tracing settings to fs, defaulting to the current settings
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Exporter, "tracing", c.Exporter, "where to export trace spans: none, stdout or otlp")
+	fs.StringVar(&c.Endpoint, "tracing-endpoint", c.Endpoint, "host:port of the OTLP/HTTP collector")
+	fs.BoolVar(&c.Insecure, "tracing-insecure", c.Insecure, "send spans to the collector without TLS")
+	fs.Float64Var(&c.SampleRatio, "tracing-sample-ratio", c.SampleRatio, "fraction of requests to trace")
+}
+
+// Validate checks the settings are usable
+func (c *Config) Validate() error {
+	switch c.Exporter {
+	case ExporterNone, ExporterStdout, ExporterOTLP:
+	default:
+		return fmt.Errorf("exporter %q is not one of %s, %s or %s", c.Exporter, ExporterNone, ExporterStdout, ExporterOTLP)
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return errors.New("sample ratio must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// Setup installs the global tracer provider for the configured exporter. Shutdown flushes any
+// spans that haven't been exported yet.
+func Setup(ctx context.Context, c *Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch c.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if c.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(c.Endpoint))
+		}
+		if c.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", c.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing exporter failed: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("stocktopus"))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span in ctx, if there is one
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End marks the span failed if err is set and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}